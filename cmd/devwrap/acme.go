@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/caddyserver/caddy/v2"
+
+	"devwrap/internal/devwrap"
+)
+
+func acmeDNSProviderNames() []string {
+	names := make([]string, 0, len(devwrap.ACMEDNSProviders))
+	for name := range devwrap.ACMEDNSProviders {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// validateACMEDNS checks --acme-dns against the route's host: DNS-01 only
+// makes sense for real domains the provider's zone controls.
+func validateACMEDNS(provider, host string) error {
+	if provider == "" {
+		return nil
+	}
+	if _, ok := devwrap.ACMEDNSProviders[provider]; !ok {
+		return fmt.Errorf("unknown --acme-dns provider %q (supported: %s)", provider, strings.Join(acmeDNSProviderNames(), ", "))
+	}
+	if host == "" {
+		return fmt.Errorf("--acme-dns requires --host with a real domain (e.g. myapp.dev.example.com)")
+	}
+	if h := strings.ToLower(host); h == "localhost" || strings.HasSuffix(h, ".localhost") || !strings.Contains(h, ".") {
+		return fmt.Errorf("--acme-dns needs a public domain, not %q", host)
+	}
+	return nil
+}
+
+// checkACMEDNSModule fails early when the devwrap-managed proxy (this
+// binary) lacks the provider's Caddy module. External Caddy builds are
+// left to report missing modules themselves.
+func checkACMEDNSModule(provider string) error {
+	if provider == "" {
+		return nil
+	}
+	if info, err := devwrap.InspectExternalCaddy(); err != nil || !info.Managed {
+		return nil
+	}
+	if _, err := caddy.GetModule("dns.providers." + provider); err == nil {
+		return nil
+	}
+	return fmt.Errorf("this devwrap build has no dns.providers.%s module; run an external Caddy built with `xcaddy build --with %s` and set %s in its environment", provider, devwrap.ACMEDNSProviders[provider].Module, strings.Join(devwrap.ACMEDNSProviders[provider].Env, ", "))
+}
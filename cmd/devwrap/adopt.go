@@ -0,0 +1,233 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"devwrap/internal/devwrap"
+)
+
+// adoptSkip is a devwrap route `proxy adopt` left alone, and why.
+type adoptSkip struct {
+	Name   string `json:"name"`
+	Reason string `json:"reason"`
+}
+
+// runProxyAdopt registers the devwrap-* routes Caddy serves that state
+// doesn't know about (state.json was deleted, or another machine shares the
+// Caddy) as `devwrap add` apps, so they can be listed and removed again.
+func runProxyAdopt() error {
+	if !devwrap.CheckSystemCaddyReachable() {
+		return errors.New("proxy is not running")
+	}
+	var adopted []string
+	var skipped []adoptSkip
+	err := devwrap.WithStateLock(func(tx *devwrap.StateTx) error {
+		servers, err := devwrap.FetchExternalServers()
+		if err != nil {
+			return err
+		}
+		_, _, httpName, httpsName, err := devwrap.ParseExternalServers(servers)
+		if err != nil {
+			return err
+		}
+		state, err := tx.Load()
+		if err != nil {
+			return err
+		}
+		devwrap.PruneDeadApps(&state)
+
+		found := adoptableRoutes(servers, httpName, httpsName)
+		names := make([]string, 0, len(found))
+		for name := range found {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if _, ok := state.Apps[name]; ok {
+				continue
+			}
+			req, err := leaseRequestFromRoute(name, found[name])
+			if err == nil {
+				_, err = devwrap.LeaseInState(&state, req)
+			}
+			if err != nil {
+				skipped = append(skipped, adoptSkip{Name: name, Reason: err.Error()})
+				continue
+			}
+			adopted = append(adopted, name)
+		}
+		if len(adopted) == 0 {
+			return nil
+		}
+		if _, _, err := devwrap.ApplyRoutesViaAdmin(state); err != nil {
+			return err
+		}
+		return tx.Save(state)
+	})
+	if err != nil {
+		return err
+	}
+	if adopted == nil {
+		adopted = []string{}
+	}
+	if skipped == nil {
+		skipped = []adoptSkip{}
+	}
+	if outputJSON {
+		return emitJSON(map[string]any{"ok": true, "action": "proxy_adopt", "adopted": adopted, "skipped": skipped})
+	}
+	for _, name := range adopted {
+		fmt.Printf("adopted %s\n", name)
+	}
+	for _, s := range skipped {
+		fmt.Printf("skipped %s: %s\n", s.Name, s.Reason)
+	}
+	if len(adopted) == 0 && len(skipped) == 0 {
+		fmt.Println("no untracked devwrap routes in caddy")
+	}
+	return nil
+}
+
+// adoptedRoute is what a devwrap route looks like on the HTTPS server and,
+// when it is a separate server, on the plain-HTTP one.
+type adoptedRoute struct {
+	HTTPS map[string]any
+	HTTP  map[string]any
+}
+
+// adoptableRoutes collects the devwrap-* routes by app name.
+func adoptableRoutes(servers map[string]map[string]any, httpName, httpsName string) map[string]adoptedRoute {
+	found := map[string]adoptedRoute{}
+	collect := func(serverName string, set func(r *adoptedRoute, route map[string]any)) {
+		routes, _ := servers[serverName]["routes"].([]any)
+		for _, route := range routes {
+			id := devwrap.DevwrapRouteID(route)
+			if id == "" {
+				continue
+			}
+			name := strings.TrimPrefix(id, "devwrap-")
+			r := found[name]
+			set(&r, route.(map[string]any))
+			found[name] = r
+		}
+	}
+	if httpsName != "" {
+		collect(httpsName, func(r *adoptedRoute, route map[string]any) { r.HTTPS = route })
+	}
+	if httpName != httpsName {
+		collect(httpName, func(r *adoptedRoute, route map[string]any) { r.HTTP = route })
+	}
+	return found
+}
+
+// leaseRequestFromRoute rebuilds an unowned registration from a live route:
+// its host, mount path, upstream, LAN matching, upstream TLS, streaming,
+// tracing, and HTTPS redirect. Other route options can't be told apart reliably and are
+// not recovered.
+func leaseRequestFromRoute(name string, r adoptedRoute) (devwrap.LeaseRequest, error) {
+	if err := validateAppRef(name); err != nil {
+		return devwrap.LeaseRequest{}, err
+	}
+	route := r.HTTPS
+	if route == nil {
+		route = r.HTTP
+	}
+	req := devwrap.LeaseRequest{Name: name, Unowned: true}
+	if _, project, ok := strings.Cut(name, "."); ok {
+		req.Project = project
+	}
+
+	matches, _ := route["match"].([]any)
+	if len(matches) == 0 {
+		return devwrap.LeaseRequest{}, errors.New("route has no host match")
+	}
+	match, _ := matches[0].(map[string]any)
+	hosts, _ := match["host"].([]any)
+	if len(hosts) == 0 {
+		return devwrap.LeaseRequest{}, errors.New("route has no host match")
+	}
+	req.Host, _ = hosts[0].(string)
+	req.Route.LAN = len(hosts) > 1
+	if paths, _ := match["path"].([]any); len(paths) > 0 {
+		req.Path, _ = paths[0].(string)
+	}
+
+	handle, _ := route["handle"].([]any)
+	if len(handle) > 0 {
+		first, _ := handle[0].(map[string]any)
+		req.Route.Trace = first["handler"] == "tracing"
+	}
+	proxy := findReverseProxy(handle)
+	if proxy == nil {
+		return devwrap.LeaseRequest{}, errors.New("route has no upstream (paused or redirect-only)")
+	}
+	upstreams, _ := proxy["upstreams"].([]any)
+	var dial string
+	if len(upstreams) > 0 {
+		u, _ := upstreams[0].(map[string]any)
+		dial, _ = u["dial"].(string)
+	}
+	if err := validateUpstream(dial); err != nil {
+		return devwrap.LeaseRequest{}, err
+	}
+	host, port, _ := net.SplitHostPort(dial)
+	n, err := strconv.Atoi(port)
+	switch {
+	case err == nil && host == devwrap.AppDialHost:
+		req.Port = n
+	case err == nil && devwrap.AppDialHost == devwrap.LoopbackDialHost && validateDialHost(host) == nil:
+		req.Port, req.Route.DialHost = n, host
+	default:
+		req.Upstream = dial
+	}
+	if transport, ok := proxy["transport"].(map[string]any); ok {
+		if tls, ok := transport["tls"].(map[string]any); ok {
+			req.Route.UpstreamTLS = true
+			req.Route.UpstreamInsecure, _ = tls["insecure_skip_verify"].(bool)
+		}
+	}
+	if flush, ok := proxy["flush_interval"].(float64); ok && flush < 0 {
+		req.Route.Stream = true
+	}
+	if r.HTTP != nil {
+		handle, _ := r.HTTP["handle"].([]any)
+		if len(handle) == 1 {
+			h, _ := handle[0].(map[string]any)
+			status, _ := h["status_code"].(float64)
+			req.Route.RedirectHTTPS = h["handler"] == "static_response" && int(status) == http.StatusPermanentRedirect
+		}
+	}
+	return req, nil
+}
+
+// findReverseProxy returns the first reverse_proxy handler in a handler
+// chain, looking inside subroutes.
+func findReverseProxy(handlers []any) map[string]any {
+	for _, h := range handlers {
+		m, ok := h.(map[string]any)
+		if !ok {
+			continue
+		}
+		if m["handler"] == "reverse_proxy" {
+			return m
+		}
+		if m["handler"] != "subroute" {
+			continue
+		}
+		routes, _ := m["routes"].([]any)
+		for _, route := range routes {
+			rm, _ := route.(map[string]any)
+			handle, _ := rm["handle"].([]any)
+			if proxy := findReverseProxy(handle); proxy != nil {
+				return proxy
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+
+	"devwrap/internal/devwrap"
+)
+
+// parseAuthFlags turns repeated `user:password` (or `user:<bcrypt hash>`)
+// flags into accounts, hashing plain passwords.
+func parseAuthFlags(specs []string) ([]devwrap.BasicAuthAccount, error) {
+	var accounts []devwrap.BasicAuthAccount
+	for _, spec := range specs {
+		user, pass, err := splitAuthSpec(spec)
+		if err != nil {
+			return nil, err
+		}
+		hash := pass
+		if _, err := bcrypt.Cost([]byte(pass)); err != nil {
+			h, err := bcrypt.GenerateFromPassword([]byte(pass), bcrypt.DefaultCost)
+			if err != nil {
+				if errors.Is(err, bcrypt.ErrPasswordTooLong) {
+					return nil, fmt.Errorf("--auth password for %q is longer than 72 bytes", user)
+				}
+				return nil, err
+			}
+			hash = string(h)
+		}
+		accounts = append(accounts, devwrap.BasicAuthAccount{Username: user, Hash: hash})
+	}
+	return accounts, nil
+}
+
+func splitAuthSpec(spec string) (user, pass string, err error) {
+	user, pass, ok := strings.Cut(spec, ":")
+	if !ok || user == "" || pass == "" {
+		return "", "", fmt.Errorf("invalid --auth %q (want user:password)", spec)
+	}
+	return user, pass, nil
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"devwrap/internal/devwrap"
+)
+
+// forgetPreviousBoot drops the apps of devwrap processes from an earlier
+// boot once the machine has rebooted, instead of trusting PID liveness: a
+// PID from an earlier boot may belong to an unrelated process by now.
+// `devwrap add` routes stay. --restore apps are queued for
+// restorePendingApps.
+func forgetPreviousBoot() {
+	id := devwrap.BootID()
+	if id == "" {
+		return
+	}
+	if state, err := devwrap.LoadLocalState(); err != nil || state.BootID == "" || state.BootID == id {
+		return
+	}
+	_ = devwrap.WithStateLock(func(tx *devwrap.StateTx) error {
+		state, err := tx.Load()
+		if err != nil || state.BootID == "" || state.BootID == id {
+			return err
+		}
+		for name, app := range state.Apps {
+			if app.Unowned {
+				continue
+			}
+			state.RecordExit(app, "reboot", nil)
+			delete(state.Apps, name)
+			if app.Restore != nil {
+				state.Restore = append(state.Restore, devwrap.PendingRestore{Name: name, Spec: *app.Restore})
+			}
+		}
+		state.BootID = id
+		if devwrap.CheckSystemCaddyReachable() {
+			_, _, _ = devwrap.ApplyRoutesViaAdmin(state)
+		}
+		return tx.Save(state)
+	})
+}
+
+// restorePendingApps starts each queued --restore app as a detached devwrap,
+// as its original run did, without waiting for it to come up.
+func restorePendingApps() {
+	if state, err := devwrap.LoadLocalState(); err != nil || len(state.Restore) == 0 {
+		return
+	}
+	var pending []devwrap.PendingRestore
+	_ = devwrap.WithStateLock(func(tx *devwrap.StateTx) error {
+		state, err := tx.Load()
+		if err != nil || len(state.Restore) == 0 {
+			return err
+		}
+		pending = state.Restore
+		state.Restore = nil
+		return tx.Save(state)
+	})
+	for _, p := range pending {
+		cmd, logPath, err := startDetached(p.Name, p.Spec.Args, p.Spec.Dir)
+		if err != nil {
+			if !outputJSON {
+				fmt.Fprintf(os.Stderr, "devwrap: could not restore %s after reboot: %v\n", p.Name, err)
+			}
+			continue
+		}
+		pid := cmd.Process.Pid
+		_ = cmd.Process.Release()
+		if !outputJSON {
+			fmt.Fprintf(os.Stderr, "devwrap: restoring %s after reboot (pid %d, logs: %s)\n", p.Name, pid, logPath)
+		}
+	}
+}
+
+// restoreSpec records how to start a --detach --restore app again. Only the
+// detached copy (which has a log file) records it, with the arguments it
+// was re-executed with.
+func restoreSpec(opts runOptions) *devwrap.RestoreSpec {
+	if !opts.Restore || opts.LogFile == "" {
+		return nil
+	}
+	dir, err := os.Getwd()
+	if err != nil {
+		return nil
+	}
+	return &devwrap.RestoreSpec{Args: os.Args[1:], Dir: dir}
+}
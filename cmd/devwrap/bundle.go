@@ -0,0 +1,299 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"runtime"
+	"runtime/debug"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"devwrap/internal/devwrap"
+)
+
+const bundleLogTailBytes = 1 << 20
+
+var sensitiveKeyPattern = regexp.MustCompile(`(?i)(pass|secret|token|key|auth|credential|cookie)`)
+
+type bundleOptions struct {
+	Output      string
+	RedactHosts bool
+}
+
+func runDoctorBundle(opts bundleOptions) error {
+	out := opts.Output
+	if out == "" {
+		out = "devwrap-bundle-" + time.Now().UTC().Format("20060102-150405") + ".tar.gz"
+	}
+
+	files := map[string][]byte{}
+	var notes []string
+
+	hosts := map[string]string{}
+	addHost := func(h string) {
+		h = strings.ToLower(strings.TrimSpace(h))
+		if h == "" {
+			return
+		}
+		if _, ok := hosts[h]; !ok {
+			hosts[h] = ""
+		}
+	}
+
+	var stateDoc any
+	if path, err := devwrap.StatePath(); err == nil {
+		if b, err := os.ReadFile(path); err == nil {
+			if err := json.Unmarshal(b, &stateDoc); err != nil {
+				notes = append(notes, "state.json is not valid JSON: "+err.Error())
+				stateDoc = string(b)
+			}
+		} else if !os.IsNotExist(err) {
+			notes = append(notes, "read state.json: "+err.Error())
+		}
+	}
+	if state, err := devwrap.LoadLocalState(); err == nil {
+		for _, app := range state.Apps {
+			addHost(app.Host)
+		}
+		// state.db isn't text; the bundle gets what it decodes to.
+		if store, err := devwrap.ActiveStateStore(); err == nil && isSQLiteStore(store) {
+			if b, err := json.Marshal(state); err == nil {
+				stateDoc = nil
+				_ = json.Unmarshal(b, &stateDoc)
+			}
+		}
+	}
+
+	var caddyDoc any
+	if devwrap.CheckSystemCaddyReachable() {
+		res, err := devwrap.AdminGet("/config/")
+		if err != nil {
+			notes = append(notes, "fetch caddy config: "+err.Error())
+		} else {
+			if err := json.NewDecoder(res.Body).Decode(&caddyDoc); err != nil {
+				notes = append(notes, "decode caddy config: "+err.Error())
+			}
+			res.Body.Close()
+		}
+		collectHostMatchers(caddyDoc, addHost)
+	} else {
+		notes = append(notes, "caddy admin not reachable")
+	}
+
+	if opts.RedactHosts {
+		names := make([]string, 0, len(hosts))
+		for h := range hosts {
+			names = append(names, h)
+		}
+		sort.Strings(names)
+		for i, h := range names {
+			hosts[h] = "host-" + strconv.Itoa(i+1) + ".redacted"
+		}
+	}
+	redact := func(v any) any { return redactValue(v, hosts, opts.RedactHosts) }
+
+	if stateDoc != nil {
+		files["state.json"] = mustIndent(redact(stateDoc))
+	}
+	if caddyDoc != nil {
+		files["caddy-config.json"] = mustIndent(redact(caddyDoc))
+	}
+	if report, err := doctorReport(); err == nil {
+		files["doctor.json"] = mustIndent(redact(any(report)))
+	} else {
+		notes = append(notes, "doctor: "+err.Error())
+	}
+	if path, err := daemonLogPath(); err == nil {
+		if b, err := readTail(path, bundleLogTailBytes); err == nil {
+			files["daemon.log"] = []byte(redactText(string(b), hosts, opts.RedactHosts))
+		} else if !os.IsNotExist(err) {
+			notes = append(notes, "read daemon.log: "+err.Error())
+		}
+	}
+	files["versions.json"] = mustIndent(buildVersions())
+	if len(notes) > 0 {
+		files["notes.txt"] = []byte(strings.Join(notes, "\n") + "\n")
+	}
+
+	if err := writeBundle(out, files); err != nil {
+		return err
+	}
+	if outputJSON {
+		names := make([]string, 0, len(files))
+		for name := range files {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return emitJSON(map[string]any{"ok": true, "action": "doctor_bundle", "path": out, "files": names, "hosts_redacted": opts.RedactHosts})
+	}
+	fmt.Printf("wrote %s\n", out)
+	if !opts.RedactHosts {
+		fmt.Println("note: hostnames are included; pass --redact-hosts to replace them")
+	}
+	return nil
+}
+
+func writeBundle(path string, files map[string][]byte) error {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	gz := gzip.NewWriter(f)
+	tw := tar.NewWriter(gz)
+
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	now := time.Now()
+	for _, name := range names {
+		b := files[name]
+		hdr := &tar.Header{Name: "devwrap-bundle/" + name, Mode: 0o600, Size: int64(len(b)), ModTime: now}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if _, err := tw.Write(b); err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+func buildVersions() map[string]any {
+	out := map[string]any{
+		"go":   runtime.Version(),
+		"os":   runtime.GOOS,
+		"arch": runtime.GOARCH,
+	}
+	if info, ok := debug.ReadBuildInfo(); ok {
+		out["devwrap"] = info.Main.Version
+		for _, dep := range info.Deps {
+			if dep.Path == "github.com/caddyserver/caddy/v2" {
+				out["caddy"] = dep.Version
+			}
+		}
+		for _, setting := range info.Settings {
+			if setting.Key == "vcs.revision" {
+				out["revision"] = setting.Value
+			}
+		}
+	}
+	return out
+}
+
+// collectHostMatchers walks a Caddy config and reports every string listed
+// under a "host" matcher.
+func collectHostMatchers(v any, add func(string)) {
+	switch t := v.(type) {
+	case map[string]any:
+		for k, child := range t {
+			if k == "host" {
+				if list, ok := child.([]any); ok {
+					for _, item := range list {
+						if s, ok := item.(string); ok {
+							add(s)
+						}
+					}
+					continue
+				}
+			}
+			collectHostMatchers(child, add)
+		}
+	case []any:
+		for _, child := range t {
+			collectHostMatchers(child, add)
+		}
+	}
+}
+
+// redactValue blanks values under secret-looking keys and, when requested,
+// swaps known hostnames for stable placeholders.
+func redactValue(v any, hosts map[string]string, redactHosts bool) any {
+	switch t := v.(type) {
+	case map[string]any:
+		out := make(map[string]any, len(t))
+		for k, child := range t {
+			if sensitiveKeyPattern.MatchString(k) && isScalar(child) {
+				out[k] = "[redacted]"
+				continue
+			}
+			out[k] = redactValue(child, hosts, redactHosts)
+		}
+		return out
+	case []any:
+		out := make([]any, len(t))
+		for i, child := range t {
+			out[i] = redactValue(child, hosts, redactHosts)
+		}
+		return out
+	case string:
+		return redactText(t, hosts, redactHosts)
+	default:
+		return v
+	}
+}
+
+func redactText(s string, hosts map[string]string, redactHosts bool) string {
+	if !redactHosts {
+		return s
+	}
+	names := make([]string, 0, len(hosts))
+	for h := range hosts {
+		names = append(names, h)
+	}
+	// Longest first so "a.b.localhost" is replaced before "b.localhost".
+	sort.Slice(names, func(i, j int) bool { return len(names[i]) > len(names[j]) })
+	for _, h := range names {
+		s = strings.ReplaceAll(s, h, hosts[h])
+	}
+	return s
+}
+
+func isScalar(v any) bool {
+	switch v.(type) {
+	case map[string]any, []any:
+		return false
+	}
+	return true
+}
+
+func mustIndent(v any) []byte {
+	b, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return []byte(fmt.Sprintf("marshal failed: %v\n", err))
+	}
+	return append(b, '\n')
+}
+
+func readTail(path string, max int64) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if info.Size() > max {
+		if _, err := f.Seek(-max, io.SeekEnd); err != nil {
+			return nil, err
+		}
+	}
+	return io.ReadAll(f)
+}
@@ -0,0 +1,347 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"time"
+
+	"devwrap/internal/devwrap"
+)
+
+const (
+	ownCARootLifetime = 20 * 365 * 24 * time.Hour
+)
+
+// activeCAID is the Caddy PKI authority devwrap issues certificates from.
+func activeCAID() string {
+	if devwrap.OwnCAApplied() {
+		return devwrap.DevwrapCAID
+	}
+	return "local"
+}
+
+// generateOwnCA writes a new long-lived ECDSA root to the state dir.
+func generateOwnCA(lifetime time.Duration) (*x509.Certificate, error) {
+	dir, err := devwrap.OwnCADir()
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	certPath, keyPath, err := devwrap.OwnCAPaths()
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	tmpl := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: "devwrap Local Root CA " + now.UTC().Format("2006-01-02"), Organization: []string{"devwrap"}},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(lifetime),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		return nil, err
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := writeFileAtomic(keyPath, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		return nil, err
+	}
+	if err := writeFileAtomic(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o644); err != nil {
+		return nil, err
+	}
+	return x509.ParseCertificate(der)
+}
+
+func writeFileAtomic(path string, b []byte, perm os.FileMode) error {
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, b, perm); err != nil {
+		return err
+	}
+	devwrap.ChownToInvoker(tmp)
+	return os.Rename(tmp, path)
+}
+
+func certFingerprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	return hex.EncodeToString(sum[:])
+}
+
+func runProxyCAInit(force bool) error {
+	if devwrap.OwnCAEnabled() && !force {
+		return errors.New("devwrap root CA already exists (pass --force to replace it)")
+	}
+	cert, err := generateOwnCA(ownCARootLifetime)
+	if err != nil {
+		return err
+	}
+	if devwrap.CheckSystemCaddyReachable() {
+		if err := devwrap.ReapplyAllDirect(); err != nil {
+			return fmt.Errorf("root created but applying it to caddy failed: %w", err)
+		}
+	}
+	certPath, _, _ := devwrap.OwnCAPaths()
+	if outputJSON {
+		return emitJSON(map[string]any{"ok": true, "action": "proxy_ca_init", "ca": devwrap.DevwrapCAID, "root_cert": certPath, "fingerprint": certFingerprint(cert), "not_after": cert.NotAfter.UTC().Format(time.RFC3339)})
+	}
+	fmt.Printf("created devwrap root CA: %s\n", certPath)
+	fmt.Printf("sha256: %s\n", certFingerprint(cert))
+	fmt.Println("run: devwrap proxy trust")
+	return nil
+}
+
+// importOwnCA validates a PEM root certificate and its private key (e.g. a
+// team-shared mkcert root) and copies both into the state dir, replacing
+// the devwrap root.
+func importOwnCA(certFile, keyFile string) (*x509.Certificate, error) {
+	certPEM, err := os.ReadFile(certFile)
+	if err != nil {
+		return nil, err
+	}
+	keyPEM, err := os.ReadFile(keyFile)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(certPEM)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, fmt.Errorf("%s: no PEM certificate found", certFile)
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", certFile, err)
+	}
+	if !cert.IsCA || cert.KeyUsage&x509.KeyUsageCertSign == 0 {
+		return nil, fmt.Errorf("%s is not a CA certificate", certFile)
+	}
+	if time.Now().After(cert.NotAfter) {
+		return nil, fmt.Errorf("%s expired on %s", certFile, cert.NotAfter.UTC().Format(time.RFC3339))
+	}
+	key, err := parsePrivateKeyPEM(keyPEM)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", keyFile, err)
+	}
+	pub, ok := key.Public().(interface{ Equal(crypto.PublicKey) bool })
+	if !ok || !pub.Equal(cert.PublicKey) {
+		return nil, fmt.Errorf("%s does not match %s", keyFile, certFile)
+	}
+
+	dir, err := devwrap.OwnCADir()
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	certPath, keyPath, err := devwrap.OwnCAPaths()
+	if err != nil {
+		return nil, err
+	}
+	if err := writeFileAtomic(keyPath, keyPEM, 0o600); err != nil {
+		return nil, err
+	}
+	if err := writeFileAtomic(certPath, pem.EncodeToMemory(block), 0o644); err != nil {
+		return nil, err
+	}
+	return cert, nil
+}
+
+// parsePrivateKeyPEM accepts the PKCS#8, EC, and PKCS#1 encodings Caddy's
+// pem_file loader understands.
+func parsePrivateKeyPEM(b []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, errors.New("no PEM private key found")
+	}
+	var key any
+	var err error
+	switch block.Type {
+	case "PRIVATE KEY":
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unsupported private key type %q", block.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, errors.New("private key cannot sign")
+	}
+	return signer, nil
+}
+
+func runProxyCAImport(certFile, keyFile string, force bool) error {
+	if devwrap.OwnCAEnabled() && !force {
+		return errors.New("devwrap root CA already exists (pass --force to replace it)")
+	}
+	cert, err := importOwnCA(certFile, keyFile)
+	if err != nil {
+		return err
+	}
+	if devwrap.CheckSystemCaddyReachable() {
+		if err := devwrap.ReapplyAllDirect(); err != nil {
+			return fmt.Errorf("root imported but applying it to caddy failed: %w", err)
+		}
+	}
+	certPath, _, _ := devwrap.OwnCAPaths()
+	if outputJSON {
+		return emitJSON(map[string]any{"ok": true, "action": "proxy_ca_import", "ca": devwrap.DevwrapCAID, "root_cert": certPath, "subject": cert.Subject.CommonName, "fingerprint": certFingerprint(cert), "not_after": cert.NotAfter.UTC().Format(time.RFC3339)})
+	}
+	fmt.Printf("imported root CA %q: %s\n", cert.Subject.CommonName, certPath)
+	fmt.Printf("sha256: %s\n", certFingerprint(cert))
+	fmt.Println("run: devwrap proxy trust")
+	return nil
+}
+
+// runProxyRotateCA replaces the active root with a new devwrap root:
+// the managed proxy is stopped, the new root generated, the intermediate
+// and leaf certificates issued from the old one are purged from Caddy's
+// storage, the proxy restarted (re-applying routes, so leaves are
+// re-issued), the new root trusted, and the old one untrusted.
+func runProxyRotateCA(lifetime time.Duration, keepOld bool) error {
+	if lifetime <= 0 {
+		return errors.New("--lifetime must be positive")
+	}
+	running := devwrap.CheckSystemCaddyReachable()
+	old, oldErr := devwrap.ActiveRootCert()
+
+	managed := false
+	if running {
+		if info, err := devwrap.InspectExternalCaddy(); err == nil && info.Managed {
+			managed = true
+		}
+	}
+	var privileged bool
+	if state, err := devwrap.LoadLocalState(); err == nil {
+		privileged = state.Root
+	}
+	if managed {
+		if err := stopManagedCaddy(); err != nil {
+			return err
+		}
+		deadline := time.Now().Add(5 * time.Second)
+		for devwrap.CheckSystemCaddyReachable() && time.Now().Before(deadline) {
+			time.Sleep(100 * time.Millisecond)
+		}
+	}
+
+	cert, err := generateOwnCA(lifetime)
+	if err != nil {
+		return err
+	}
+	var notes []string
+	if managed || !running {
+		storage := sharedCaddyStorageRoot()
+		for _, dir := range []string{
+			filepath.Join(storage, "pki", "authorities", devwrap.DevwrapCAID),
+			filepath.Join(storage, "certificates", devwrap.DevwrapCAID),
+			filepath.Join(storage, "certificates", "local"),
+		} {
+			if err := os.RemoveAll(dir); err != nil {
+				return fmt.Errorf("purge certificates issued by the old root: %w", err)
+			}
+		}
+	} else {
+		notes = append(notes, "external caddy keeps certificates issued by the old root until it restarts; restart it to re-issue them")
+	}
+	if managed {
+		if err := runProxyStart(privileged); err != nil {
+			return fmt.Errorf("new root created but restarting the proxy failed: %w", err)
+		}
+	} else if running {
+		if err := devwrap.ReapplyAllDirect(); err != nil {
+			return fmt.Errorf("new root created but applying it to caddy failed: %w", err)
+		}
+	}
+
+	trusted := true
+	if err := trustLocalCA(); err != nil {
+		trusted = false
+		notes = append(notes, "new root is not trusted yet: "+err.Error()+"; run: devwrap proxy trust")
+	}
+	untrusted := false
+	if !keepOld && oldErr == nil && old != nil && !old.Equal(cert) {
+		if err := untrustCert(old); err != nil {
+			notes = append(notes, "old root is still trusted: "+err.Error())
+		} else {
+			untrusted = true
+		}
+	}
+
+	certPath, _, _ := devwrap.OwnCAPaths()
+	if outputJSON {
+		out := map[string]any{"ok": true, "action": "proxy_rotate_ca", "ca": devwrap.DevwrapCAID, "root_cert": certPath, "fingerprint": certFingerprint(cert), "not_after": cert.NotAfter.UTC().Format(time.RFC3339), "trusted": trusted, "old_untrusted": untrusted}
+		if oldErr == nil && old != nil {
+			out["old_fingerprint"] = certFingerprint(old)
+		}
+		if len(notes) > 0 {
+			out["warnings"] = notes
+		}
+		return emitJSON(out)
+	}
+	fmt.Printf("rotated root CA: %s\n", certPath)
+	fmt.Printf("sha256: %s (expires %s)\n", certFingerprint(cert), cert.NotAfter.UTC().Format("2006-01-02"))
+	if untrusted {
+		fmt.Printf("untrusted old root %s\n", certFingerprint(old))
+	}
+	for _, note := range notes {
+		fmt.Printf("warning: %s\n", note)
+	}
+	return nil
+}
+
+func runProxyCAShow() error {
+	if !devwrap.OwnCAEnabled() {
+		if outputJSON {
+			return emitJSON(map[string]any{"ok": true, "action": "proxy_ca_show", "ca": activeCAID(), "own_ca": false})
+		}
+		fmt.Println("using caddy's local CA (run `devwrap proxy ca init` to create a devwrap-owned root)")
+		return nil
+	}
+	cert, err := devwrap.LoadOwnCARoot()
+	if err != nil {
+		return err
+	}
+	certPath, _, _ := devwrap.OwnCAPaths()
+	if outputJSON {
+		return emitJSON(map[string]any{"ok": true, "action": "proxy_ca_show", "ca": devwrap.DevwrapCAID, "own_ca": true, "root_cert": certPath, "subject": cert.Subject.CommonName, "fingerprint": certFingerprint(cert), "not_after": cert.NotAfter.UTC().Format(time.RFC3339)})
+	}
+	fmt.Printf("root cert:  %s\n", certPath)
+	fmt.Printf("subject:    %s\n", cert.Subject.CommonName)
+	fmt.Printf("sha256:     %s\n", certFingerprint(cert))
+	fmt.Printf("expires:    %s\n", cert.NotAfter.UTC().Format(time.RFC3339))
+	return nil
+}
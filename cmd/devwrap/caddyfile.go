@@ -0,0 +1,381 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"devwrap/internal/devwrap"
+)
+
+// runExportCaddyfile prints the live registrations as a Caddyfile, for
+// reviewing what devwrap configures or moving to a hand-maintained Caddy.
+func runExportCaddyfile(output string) error {
+	var state devwrap.DaemonState
+	err := devwrap.WithStateLock(func(tx *devwrap.StateTx) error {
+		var err error
+		state, err = tx.Load()
+		if err != nil {
+			return err
+		}
+		for name, app := range state.Apps {
+			if !devwrap.AppLive(app) {
+				delete(state.Apps, name)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	text := renderCaddyfile(state)
+	if outputJSON {
+		return emitJSON(map[string]any{"ok": true, "action": "export_caddyfile", "apps": len(state.Apps), "caddyfile": text})
+	}
+	if output == "" || output == "-" {
+		fmt.Print(text)
+		return nil
+	}
+	if err := os.WriteFile(output, []byte(text), 0o644); err != nil {
+		return err
+	}
+	fmt.Printf("wrote %d app(s) to %s\n", len(state.Apps), output)
+	return nil
+}
+
+// caddyfileWriter writes tab-indented Caddyfile lines.
+type caddyfileWriter struct {
+	b     strings.Builder
+	depth int
+}
+
+func (w *caddyfileWriter) line(tokens ...string) {
+	if len(tokens) == 0 {
+		w.b.WriteString("\n")
+		return
+	}
+	w.b.WriteString(strings.Repeat("\t", w.depth))
+	w.b.WriteString(strings.Join(tokens, " "))
+	w.b.WriteString("\n")
+}
+
+func (w *caddyfileWriter) open(tokens ...string) {
+	w.line(append(tokens, "{")...)
+	w.depth++
+}
+
+func (w *caddyfileWriter) close() {
+	w.depth--
+	w.line("}")
+}
+
+// caddyfileQuote quotes a token when the Caddyfile lexer would otherwise
+// split or misread it. Placeholders like {env.X} pass through bare.
+func caddyfileQuote(s string) string {
+	if s != "" && s != "{" && s != "}" && !strings.HasPrefix(s, "#") && !strings.ContainsAny(s, " \t\n\"") {
+		return s
+	}
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s) + `"`
+}
+
+// renderCaddyfile renders one site block per host. Apps mounted on a shared
+// host become `handle` blocks, longest path first as in the generated
+// routes; each app's directives sit in a `route` block so they run in the
+// same order as devwrap's handlers. Certificates come from Caddy's internal
+// CA (`tls internal`) unless the app uses --acme-dns.
+func renderCaddyfile(state devwrap.DaemonState) string {
+	apps := devwrap.RouteOrderedApps(state.Apps)
+	var w caddyfileWriter
+	w.line(fmt.Sprintf("# Exported by devwrap on %s from %d app(s).", time.Now().UTC().Format(time.RFC3339), len(apps)))
+	w.line("# Certificates come from Caddy's internal CA; run `caddy trust` to trust it.")
+	httpPort, httpsPort := state.HTTPPort, state.HTTPSPort
+	if (httpPort != 0 && httpPort != 80) || (httpsPort != 0 && httpsPort != 443) {
+		w.open()
+		if httpPort != 0 && httpPort != 80 {
+			w.line("http_port", strconv.Itoa(httpPort))
+		}
+		if httpsPort != 0 && httpsPort != 443 {
+			w.line("https_port", strconv.Itoa(httpsPort))
+		}
+		w.close()
+	}
+
+	var hosts []string
+	sites := map[string][]devwrap.App{}
+	for _, app := range apps {
+		if _, ok := sites[app.Host]; !ok {
+			hosts = append(hosts, app.Host)
+		}
+		sites[app.Host] = append(sites[app.Host], app)
+	}
+	for _, host := range hosts {
+		w.line()
+		renderCaddyfileSite(&w, host, sites[host], state.Paused)
+	}
+	return w.b.String()
+}
+
+func renderCaddyfileSite(w *caddyfileWriter, host string, apps []devwrap.App, paused bool) {
+	// Caddy redirects HTTP to HTTPS for a bare hostname; devwrap serves
+	// both unless every app asked for --redirect-https.
+	redirect := true
+	lan := map[string]bool{}
+	var clientCAs, addresses []string
+	for _, app := range apps {
+		redirect = redirect && app.RedirectHTTPS
+		if app.ClientCA != "" && !slices.Contains(clientCAs, app.ClientCA) {
+			clientCAs = append(clientCAs, app.ClientCA)
+		}
+		if app.LAN {
+			for _, ip := range devwrap.LANAddresses() {
+				lan[ip] = true
+			}
+		}
+	}
+	names := []string{host}
+	for ip := range lan {
+		names = append(names, ip)
+	}
+	sort.Strings(names[1:])
+	for _, name := range names {
+		if redirect {
+			addresses = append(addresses, name)
+		} else {
+			addresses = append(addresses, "http://"+name, "https://"+name)
+		}
+	}
+	w.open(strings.Join(addresses, ", "))
+	renderCaddyfileTLS(w, apps[0].ACMEDNS, clientCAs)
+
+	for _, app := range apps {
+		w.line()
+		target := "port " + strconv.Itoa(app.Port)
+		if app.Upstream != "" {
+			target = "upstream " + app.Upstream
+		}
+		w.line("#", app.Name+":", target)
+		// Only apps sharing the host need a handle block to keep them apart.
+		shared := app.Path != "" || len(apps) > 1
+		if app.Path != "" {
+			matcher := "@" + caddyfileMatcherName(app.Name)
+			w.line(matcher, "path", caddyfileQuote(app.Path), caddyfileQuote(app.Path+"/*"))
+			w.open("handle", matcher)
+		} else if shared {
+			w.open("handle")
+		}
+		renderCaddyfileApp(w, app, paused)
+		if shared {
+			w.close()
+		}
+	}
+	w.close()
+}
+
+func renderCaddyfileTLS(w *caddyfileWriter, acmeDNS string, clientCAs []string) {
+	if acmeDNS != "" {
+		w.open("tls")
+		if cfg := devwrap.ACMEDNSProviders[acmeDNS].Config; len(cfg) > 0 {
+			w.open("dns", acmeDNS)
+			keys := make([]string, 0, len(cfg))
+			for k := range cfg {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+			for _, k := range keys {
+				w.line(k, caddyfileQuote(fmt.Sprint(cfg[k])))
+			}
+			w.close()
+		} else {
+			w.line("dns", acmeDNS)
+		}
+	} else if len(clientCAs) > 0 {
+		w.open("tls", "internal")
+	} else {
+		w.line("tls", "internal")
+		return
+	}
+	if len(clientCAs) > 0 {
+		w.open("client_auth")
+		w.line("mode", "require_and_verify")
+		quoted := make([]string, 0, len(clientCAs))
+		for _, ca := range clientCAs {
+			quoted = append(quoted, caddyfileQuote(ca))
+		}
+		w.line(append([]string{"trust_pool", "file"}, quoted...)...)
+		w.close()
+	}
+	w.close()
+}
+
+// renderCaddyfileApp writes an app's directives in appHandlers order:
+// tracing, CORS, basic auth, file overrides, body limit, headers, SPA fallback,
+// rewrites, and the reverse proxy. devwrap's "starting" page is left out.
+func renderCaddyfileApp(w *caddyfileWriter, app devwrap.App, paused bool) {
+	w.open("route")
+	defer w.close()
+	if paused || app.Paused {
+		w.line("header", "Retry-After", "60")
+		w.line("respond", caddyfileQuote("devwrap: "+app.Name+" is paused"), "503")
+		return
+	}
+	if app.Trace {
+		w.open("tracing")
+		w.line("span", caddyfileQuote(app.Name))
+		w.close()
+	}
+	if len(app.CORS) > 0 {
+		renderCaddyfileCORS(w, app.RouteOptions)
+	}
+	if len(app.BasicAuth) > 0 {
+		w.open("basic_auth", "bcrypt", caddyfileQuote("devwrap "+app.Name))
+		for _, a := range app.BasicAuth {
+			w.line(caddyfileQuote(a.Username), caddyfileQuote(a.Hash))
+		}
+		w.close()
+	}
+	for _, o := range app.Overrides {
+		w.open("handle", caddyfileQuote(o.Path))
+		w.line("root", "*", caddyfileQuote(filepath.Dir(o.File)))
+		w.line("rewrite", "*", caddyfileQuote("/"+filepath.Base(o.File)))
+		w.line("file_server")
+		w.close()
+	}
+	if app.MaxBodySize > 0 {
+		w.open("request_body")
+		w.line("max_size", strconv.FormatInt(app.MaxBodySize, 10))
+		w.close()
+	}
+	response := map[string]string{}
+	for k, v := range app.ResponseHeaders {
+		response[k] = v
+	}
+	if _, set := response[devwrap.HSTSHeader]; app.HSTS && !set {
+		response[devwrap.HSTSHeader] = devwrap.HSTSValue
+	}
+	if len(response) > 0 {
+		w.open("header")
+		for _, k := range sortedKeys(response) {
+			w.line(caddyfileQuote(k), caddyfileQuote(response[k]))
+		}
+		w.line("defer")
+		w.close()
+	}
+	for _, k := range sortedKeys(app.RequestHeaders) {
+		w.line("request_header", caddyfileQuote(k), caddyfileQuote(app.RequestHeaders[k]))
+	}
+	if app.SPA {
+		w.open("@spa")
+		w.line("method", "GET", "HEAD")
+		w.line("header", "Accept", "*text/html*")
+		w.line("not", "path", "*.*")
+		w.close()
+		w.line("rewrite", "@spa", caddyfileQuote(app.Path+"/"))
+	}
+	if app.StripPrefix != "" {
+		w.line("uri", "strip_prefix", caddyfileQuote(app.StripPrefix))
+	}
+	for _, r := range app.Rewrites {
+		w.line("uri", "path_regexp", caddyfileQuote(r.Find), caddyfileQuote(r.Replace))
+	}
+	renderCaddyfileProxy(w, app)
+}
+
+func renderCaddyfileCORS(w *caddyfileWriter, opts devwrap.RouteOptions) {
+	origins := devwrap.CORSOrigins(opts.CORS)
+	for i, o := range origins {
+		origins[i] = caddyfileQuote(o)
+	}
+	response := map[string]string{
+		"Access-Control-Allow-Origin":      "{http.request.header.Origin}",
+		"Access-Control-Allow-Credentials": "true",
+		"Vary":                             "Origin",
+	}
+	w.open("@cors_preflight")
+	w.line("method", "OPTIONS")
+	w.line(append([]string{"header", "Origin"}, origins...)...)
+	w.line("header", "Access-Control-Request-Method", "*")
+	w.close()
+	w.open("handle", "@cors_preflight")
+	preflight := map[string]string{
+		"Access-Control-Allow-Methods": devwrap.CORSAllowMethods,
+		"Access-Control-Allow-Headers": "{http.request.header.Access-Control-Request-Headers}",
+		"Access-Control-Max-Age":       devwrap.CORSMaxAge,
+	}
+	for k, v := range response {
+		preflight[k] = v
+	}
+	// --set-header takes precedence, as in corsHandler.
+	for k, v := range opts.ResponseHeaders {
+		delete(response, k)
+		preflight[k] = v
+	}
+	w.open("header")
+	for _, k := range sortedKeys(preflight) {
+		w.line(caddyfileQuote(k), caddyfileQuote(preflight[k]))
+	}
+	w.close()
+	w.line("respond", "204")
+	w.close()
+	w.line(append([]string{"@cors", "header", "Origin"}, origins...)...)
+	w.open("header", "@cors")
+	for _, k := range sortedKeys(response) {
+		w.line(k, caddyfileQuote(response[k]))
+	}
+	w.line("defer")
+	w.close()
+}
+
+func renderCaddyfileProxy(w *caddyfileWriter, app devwrap.App) {
+	t := app.Timeouts
+	transport := app.UpstreamTLS || t.Read > 0 || t.Write > 0
+	if !transport && !app.Stream && t.StreamTimeout == 0 && t.StreamCloseDelay == 0 {
+		w.line("reverse_proxy", app.DialAddress())
+		return
+	}
+	w.open("reverse_proxy", app.DialAddress())
+	if app.Stream {
+		w.line("flush_interval", "-1")
+	}
+	if t.StreamTimeout > 0 {
+		w.line("stream_timeout", t.StreamTimeout.String())
+	}
+	if t.StreamCloseDelay > 0 {
+		w.line("stream_close_delay", t.StreamCloseDelay.String())
+	}
+	if transport {
+		w.open("transport", "http")
+		if app.UpstreamTLS {
+			w.line("tls")
+		}
+		if app.UpstreamInsecure {
+			w.line("tls_insecure_skip_verify")
+		}
+		if t.Read > 0 {
+			w.line("read_timeout", t.Read.String())
+		}
+		if t.Write > 0 {
+			w.line("write_timeout", t.Write.String())
+		}
+		w.close()
+	}
+	w.close()
+}
+
+// caddyfileMatcherName turns an app name ("web.shop") into a matcher name.
+func caddyfileMatcherName(name string) string {
+	return strings.NewReplacer(".", "_", "-", "_").Replace(name)
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
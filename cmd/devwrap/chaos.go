@@ -0,0 +1,130 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+
+	"devwrap/internal/devwrap"
+)
+
+func init() {
+	caddy.RegisterModule(delayHandler{})
+}
+
+// delayHandler holds each request for Duration before passing it on. It
+// exists only in devwrap's embedded Caddy; stock Caddy has no such handler.
+type delayHandler struct {
+	Duration caddy.Duration `json:"duration,omitempty"`
+}
+
+func (delayHandler) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "http.handlers.devwrap_delay",
+		New: func() caddy.Module { return new(delayHandler) },
+	}
+}
+
+func (h delayHandler) ServeHTTP(w http.ResponseWriter, r *http.Request, next caddyhttp.Handler) error {
+	t := time.NewTimer(time.Duration(h.Duration))
+	defer t.Stop()
+	select {
+	case <-t.C:
+	case <-r.Context().Done():
+		return r.Context().Err()
+	}
+	return next.ServeHTTP(w, r)
+}
+
+var _ caddyhttp.MiddlewareHandler = delayHandler{}
+
+// parseErrorRate accepts "5%" or a fraction like "0.05".
+func parseErrorRate(raw string) (float64, error) {
+	s := strings.TrimSpace(raw)
+	scale := 1.0
+	if strings.HasSuffix(s, "%") {
+		s, scale = strings.TrimSuffix(s, "%"), 100
+	}
+	v, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	if err != nil || v < 0 || v/scale > 1 {
+		return 0, fmt.Errorf("invalid error rate %q (e.g. 5%% or 0.05)", raw)
+	}
+	return v / scale, nil
+}
+
+// runChaos sets, clears (off), or shows an app's chaos options.
+func runChaos(name string, c devwrap.ChaosOptions, set, off bool) error {
+	if err := validateAppRef(name); err != nil {
+		return err
+	}
+	if !devwrap.CheckSystemCaddyReachable() {
+		return errors.New("proxy is not running")
+	}
+	if set && off {
+		return errors.New("--off cannot be combined with --latency or --error-rate")
+	}
+	if c.Latency < 0 {
+		return errors.New("--latency must be positive")
+	}
+	if c.ErrorStatus < 400 || c.ErrorStatus > 599 {
+		return fmt.Errorf("--error-status %d is not an HTTP error status (400-599)", c.ErrorStatus)
+	}
+	if c.Latency > 0 {
+		state, err := devwrap.LoadLocalState()
+		if err != nil {
+			return err
+		}
+		if state.CaddySource != "managed" {
+			return errors.New("--latency needs devwrap's managed proxy; an unmanaged caddy has no delay handler (--error-rate works with any caddy)")
+		}
+	}
+
+	var app devwrap.App
+	var err error
+	action := "chaos_show"
+	switch {
+	case off:
+		action = "chaos_off"
+		app, err = devwrap.UpdateAppDirect(name, func(a *devwrap.App) error {
+			a.Chaos = nil
+			return nil
+		})
+	case set:
+		action = "chaos_set"
+		app, err = devwrap.UpdateAppDirect(name, func(a *devwrap.App) error {
+			if c.Latency == 0 && c.ErrorRate == 0 {
+				a.Chaos = nil
+			} else {
+				a.Chaos = &c
+			}
+			return nil
+		})
+	default:
+		app, err = findApp(name)
+	}
+	if err != nil {
+		return err
+	}
+	if outputJSON {
+		return emitJSON(map[string]any{"ok": true, "action": action, "name": name, "chaos": app.Chaos})
+	}
+	if app.Chaos == nil {
+		fmt.Printf("%s: no chaos\n", name)
+		return nil
+	}
+	var parts []string
+	if app.Chaos.Latency > 0 {
+		parts = append(parts, "latency "+app.Chaos.Latency.String())
+	}
+	if app.Chaos.ErrorRate > 0 {
+		parts = append(parts, fmt.Sprintf("%s of requests fail with %d", strconv.FormatFloat(app.Chaos.ErrorRate*100, 'f', -1, 64)+"%", app.Chaos.ErrorStatus))
+	}
+	fmt.Printf("%s: %s; run `devwrap chaos %s --off` to restore\n", name, strings.Join(parts, ", "), name)
+	return nil
+}
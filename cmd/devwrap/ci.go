@@ -0,0 +1,194 @@
+package main
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"devwrap/internal/devwrap"
+)
+
+// Exit codes for `devwrap proxy trust` that CI scripts can branch on.
+const (
+	exitTrustUnavailable = 3
+)
+
+// nonInteractive is set by --ci or DEVWRAP_NONINTERACTIVE. In this mode
+// devwrap never waits on a terminal: sudo runs with -n, and browser/Java
+// trust stores (which may prompt) are skipped.
+var nonInteractive bool
+
+func nonInteractiveFromEnv() bool {
+	v := strings.TrimSpace(os.Getenv("DEVWRAP_NONINTERACTIVE"))
+	if v == "" {
+		return false
+	}
+	on, err := strconv.ParseBool(v)
+	return err != nil || on
+}
+
+// sudoAvailableNonInteractive reports whether system trust stores can be
+// written without prompting: either we are root or sudo has cached/NOPASSWD
+// credentials.
+func sudoAvailableNonInteractive() bool {
+	if os.Geteuid() == 0 {
+		return true
+	}
+	if _, err := exec.LookPath("sudo"); err != nil {
+		return false
+	}
+	return exec.Command("sudo", "-n", "true").Run() == nil
+}
+
+// trustUnavailableError ends the process with exitTrustUnavailable after
+// reporting why trust could not be installed.
+func trustUnavailableError(reason string) error {
+	if outputJSON {
+		_ = emitJSON(map[string]any{"ok": false, "action": "proxy_trust", "trusted": false, "error": reason})
+	} else {
+		fmt.Fprintln(os.Stderr, "error:", reason)
+	}
+	return exitStatusError{code: exitTrustUnavailable}
+}
+
+// installTrustBundle appends the root certificate to a PEM bundle file
+// instead of touching system stores. It is a no-op when the bundle already
+// contains the certificate.
+func installTrustBundle(path string, cert *x509.Certificate) (bool, error) {
+	block := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})
+	existing, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return false, err
+	}
+	if bundleContains(existing, cert) {
+		return false, nil
+	}
+	if dir := filepath.Dir(path); dir != "" {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return false, err
+		}
+	}
+	if len(existing) > 0 && !bytes.HasSuffix(existing, []byte("\n")) {
+		block = append([]byte("\n"), block...)
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return false, err
+	}
+	if _, err := f.Write(block); err != nil {
+		f.Close()
+		return false, err
+	}
+	return true, f.Close()
+}
+
+func bundleContains(b []byte, cert *x509.Certificate) bool {
+	for {
+		var block *pem.Block
+		block, b = pem.Decode(b)
+		if block == nil {
+			return false
+		}
+		if block.Type == "CERTIFICATE" && bytes.Equal(block.Bytes, cert.Raw) {
+			return true
+		}
+	}
+}
+
+func runProxyTrustBundle(path string) error {
+	if err := ensureCaddyOrDaemon(false); err != nil {
+		return err
+	}
+	cert, err := devwrap.ActiveRootCert()
+	if err != nil {
+		return trustUnavailableError(fmt.Sprintf("failed to load root CA: %v", err))
+	}
+	added, err := installTrustBundle(path, cert)
+	if err != nil {
+		return trustUnavailableError(fmt.Sprintf("write trust bundle: %v", err))
+	}
+	abs, _ := filepath.Abs(path)
+	if abs == "" {
+		abs = path
+	}
+	if outputJSON {
+		return emitJSON(map[string]any{"ok": true, "action": "proxy_trust", "trusted": true, "bundle": abs, "added": added})
+	}
+	if added {
+		fmt.Printf("added devwrap root CA to %s\n", abs)
+	} else {
+		fmt.Printf("%s already contains the devwrap root CA\n", abs)
+	}
+	fmt.Printf("e.g. export NODE_EXTRA_CA_CERTS=%q SSL_CERT_FILE=%q\n", abs, abs)
+	return nil
+}
+
+// runProxyTrustPrint exports the root CA as PEM to stdout, or to path, for
+// containers, NODE_EXTRA_CA_CERTS, or CI caches. Nothing is installed.
+func runProxyTrustPrint(path string) error {
+	// Starting the proxy reports on stdout; keep that out of the PEM.
+	stdout := os.Stdout
+	if path == "" && !outputJSON {
+		os.Stdout = os.Stderr
+	}
+	err := ensureCaddyOrDaemon(false)
+	os.Stdout = stdout
+	if err != nil {
+		return err
+	}
+	cert, err := devwrap.ActiveRootCert()
+	if err != nil {
+		return trustUnavailableError(fmt.Sprintf("failed to load root CA: %v", err))
+	}
+	block := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})
+	if path == "" {
+		if outputJSON {
+			return emitJSON(map[string]any{"ok": true, "action": "proxy_trust_print", "pem": string(block)})
+		}
+		_, err := os.Stdout.Write(block)
+		return err
+	}
+	if dir := filepath.Dir(path); dir != "" {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return err
+		}
+	}
+	if err := os.WriteFile(path, block, 0o644); err != nil {
+		return err
+	}
+	abs, _ := filepath.Abs(path)
+	if abs == "" {
+		abs = path
+	}
+	if outputJSON {
+		return emitJSON(map[string]any{"ok": true, "action": "proxy_trust_print", "path": abs})
+	}
+	fmt.Printf("wrote devwrap root CA to %s\n", abs)
+	return nil
+}
+
+func runProxyTrustCheck() error {
+	trusted := devwrap.CheckSystemCaddyReachable() && devwrap.IsCertTrusted()
+	if outputJSON {
+		if err := emitJSON(map[string]any{"ok": trusted, "action": "proxy_trust_check", "trusted": trusted}); err != nil {
+			return err
+		}
+	} else if trusted {
+		fmt.Println("trusted")
+	} else {
+		fmt.Println("not trusted")
+	}
+	if !trusted {
+		return exitStatusError{code: exitTrustUnavailable}
+	}
+	return nil
+}
+
+var errSudoNeedsPrompt = errors.New("installing system trust needs sudo, which would prompt for a password (non-interactive mode); use --bundle <file> or run as root")
@@ -3,9 +3,17 @@ package main
 import (
 	"errors"
 	"fmt"
+	"net/http"
 	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/spf13/cobra"
+
+	"devwrap/internal/devwrap"
 )
 
 func run(args []string) error {
@@ -18,24 +26,99 @@ func run(args []string) error {
 	return root.Execute()
 }
 
+type runOptions struct {
+	Name              string
+	Project           string
+	Host              string
+	Site              string
+	Mount             string
+	Path              string
+	Strip             bool
+	Cwd               string
+	Instance          bool
+	TTL               time.Duration
+	Route             devwrap.RouteOptions
+	Rewrites          []string
+	Profiles          []string
+	Watch             watchOptions
+	Restart           restartPolicy
+	StopTimeout       time.Duration
+	Hooks             hookOptions
+	MaxBodySize       string
+	SetHeaders        []string
+	SetRequestHeaders []string
+	Auth              []string
+	Detach            bool
+	Restore           bool
+	LogFile           string
+	Privileged        bool
+	// NameFrom is where a name derived without --name came from.
+	NameFrom string
+	// Port, when set, is used instead of allocating one.
+	Port         int
+	PortStrategy string
+	// Shell is a command string run with $SHELL -c instead of an argv.
+	Shell string
+	// Env holds KEY=VALUE entries added to the child's environment, after
+	// the variables from EnvFiles (loaded into FileEnv).
+	Env       []string
+	EnvFiles  []string
+	FileEnv   map[string]string
+	ReadyHTTP string
+	// FollowPort moves the route to the single other port the child
+	// listens on while its own is closed, instead of only warning.
+	FollowPort bool
+}
+
 func newRootCommand() *cobra.Command {
-	var name string
-	var host string
-	var privileged bool
+	var opts runOptions
+	var restart string
 
 	root := &cobra.Command{
-		Use:           "devwrap --name <name> -- <cmd...>",
+		Use:           "devwrap [--name <name>] -- <cmd...>",
 		Short:         "Local dev reverse proxy helper",
-		Long:          "Run local apps behind Caddy and map routes to local app ports. Use @PORT in your command arguments to inject the allocated app port.",
-		Example:       "  devwrap --name myapp -- pnpm dev\n  devwrap --name api -- uvicorn app:app --port @PORT\n  devwrap --name web --host web.dev.test -- pnpm dev\n  devwrap -p",
+		Long:          "Run local apps behind Caddy and map routes to local app ports. Use @PORT in your command arguments to inject the allocated app port; @HTTPS_PORT, @NAME, @HOST, @HTTPS_URL, and @HTTP_URL work the same way (@@PORT is a literal @PORT).",
+		Example:       "  devwrap -- pnpm dev\n  devwrap --name myapp -- pnpm dev\n  devwrap --name api -- uvicorn app:app --port @PORT\n  devwrap --name web --host web.dev.test -- pnpm dev\n  devwrap --name api --cwd services/api -- make dev\n  devwrap --name web -c \"npm run build && npm run serve -- --port @PORT\"\n  devwrap -p",
 		SilenceUsage:  true,
 		SilenceErrors: true,
 		Args:          cobra.ArbitraryArgs,
 		RunE: func(cmd *cobra.Command, args []string) error {
-			if privileged && name == "" && len(args) == 0 {
+			if opts.Privileged && opts.Name == "" && len(args) == 0 && opts.Shell == "" {
 				return runProxyStart(true)
 			}
-			if name == "" {
+			if cmd.Flags().Changed("command") {
+				if len(args) > 0 {
+					return errors.New("-c and a command after '--' cannot be combined")
+				}
+				if strings.TrimSpace(opts.Shell) == "" {
+					return errors.New("-c needs a command string")
+				}
+				args = shellCommand(opts.Shell)
+			}
+			path, err := findRunConfig()
+			if err != nil {
+				return err
+			}
+			if path != "" {
+				cfg, err := loadRunConfig(path)
+				if err != nil {
+					return err
+				}
+				args = cfg.apply(cmd, &opts, &restart, args)
+			}
+			if opts.Name == "" && len(args) > 0 {
+				cwd, err := resolveCwd(opts.Cwd)
+				if err != nil {
+					return err
+				}
+				if opts.Name, opts.NameFrom, err = deriveAppName(cwd); err != nil {
+					return err
+				}
+				if !outputJSON {
+					fmt.Fprintf(os.Stderr, "using app name %q from %s (set --name to change it)\n", opts.Name, opts.NameFrom)
+				}
+			}
+			if opts.Name == "" {
 				if !outputJSON {
 					_ = cmd.Help()
 				}
@@ -47,12 +130,53 @@ func newRootCommand() *cobra.Command {
 				}
 				return errors.New("missing command after '--'")
 			}
-			return runApp(name, host, args, privileged)
+			policy, err := parseRestartPolicy(restart)
+			if err != nil {
+				return err
+			}
+			opts.Restart = policy
+			if opts.Restore && !opts.Detach {
+				return errors.New("--restore requires --detach")
+			}
+			if logFile := detachedLogFile(); logFile != "" {
+				opts.LogFile = logFile
+				nonInteractive = true
+			} else if opts.Detach {
+				name, err := projectAppName(opts.Name, opts.Project)
+				if err != nil {
+					return err
+				}
+				return runDetached(name)
+			}
+			return runApp(opts, args)
 		},
 	}
 
-	root.PersistentPreRun = func(cmd *cobra.Command, args []string) {
+	root.PersistentPreRunE = func(cmd *cobra.Command, args []string) error {
 		outputJSON, _ = cmd.Flags().GetBool("json")
+		ci, _ := cmd.Flags().GetBool("ci")
+		nonInteractive = ci || nonInteractiveFromEnv()
+		ciRun = nonInteractive
+		// Exported so the daemon, detached apps, and `up` services inherit it.
+		if dir, _ := cmd.Flags().GetString("state-dir"); dir != "" {
+			if abs, err := filepath.Abs(dir); err == nil {
+				dir = abs
+			}
+			_ = os.Setenv(devwrap.StateDirEnv, dir)
+		}
+		if err := devwrap.ConfigureAdminEndpoint(); err != nil {
+			return err
+		}
+		switch cmd.Name() {
+		case "help", "completion", cobra.ShellCompRequestCmd, cobra.ShellCompNoDescRequestCmd:
+			return nil
+		}
+		forgetPreviousBoot()
+		// Proxy commands leave restoring to the daemon, once it is up.
+		if cmd.Parent() == nil || cmd.Parent().Name() != "proxy" {
+			restorePendingApps()
+		}
+		return nil
 	}
 
 	root.SetFlagErrorFunc(func(cmd *cobra.Command, err error) error {
@@ -62,15 +186,80 @@ func newRootCommand() *cobra.Command {
 		return err
 	})
 
-	root.Flags().StringVar(&name, "name", "", "App route name (e.g. myapp)")
-	root.Flags().StringVar(&host, "host", "", "Custom hostname (default: <name>.localhost)")
-	root.Flags().BoolVarP(&privileged, "privileged", "p", false, "Use sudo to spawn proxy if Caddy is not already running")
+	root.Flags().StringVar(&opts.Name, "name", "", "App route name (default: the git repository or directory name)")
+	root.Flags().StringVar(&opts.Project, "project", "", "Namespace the app as <name>.<project> (host <name>.<project>.localhost)")
+	root.Flags().StringVar(&opts.Host, "host", "", "Custom hostname (default: <name>.localhost)")
+	root.Flags().StringVar(&opts.Site, "site", "", "Share a host with other apps (name or hostname); combine with --mount")
+	root.Flags().StringVar(&opts.Mount, "mount", "", "Mount the app under this path on its host (e.g. /api)")
+	root.Flags().StringVar(&opts.Path, "path", "", "Serve the app under this path on the shared "+devwrap.DefaultPathSite+".localhost host (or --host/--site), e.g. /api")
+	root.Flags().BoolVar(&opts.Strip, "strip", false, "Strip the --path/--mount prefix before proxying")
+	root.Flags().StringVar(&opts.Cwd, "cwd", "", "Working directory for the command (default: current directory)")
+	root.Flags().IntVar(&opts.Port, "port", 0, "Register the app on this port instead of allocating one, for commands that ignore PORT (e.g. 3000)")
+	root.Flags().StringVar(&opts.PortStrategy, "port-strategy", "", "How to pick the app's port: sequential (default; reused per name) or hash (derived from the name, the same on every machine); also DEVWRAP_PORT_STRATEGY")
+	root.Flags().BoolVar(&opts.Instance, "instance", false, "Start another copy as <name>-N if <name> is already running")
+	root.Flags().DurationVar(&opts.TTL, "ttl", 0, "Stop the app and remove its route after this long (e.g. 2h)")
+	root.Flags().StringVar(&opts.Route.RouteOrder, "route-order", "", "Route placement among existing Caddy routes: first, last (default), or after:<@id>")
+	root.Flags().StringVar(&opts.Route.StripPrefix, "strip-prefix", "", "Strip this path prefix before proxying (e.g. /api)")
+	root.Flags().BoolVar(&opts.Route.UpstreamTLS, "upstream-tls", false, "The app serves HTTPS; proxy to it over TLS")
+	root.Flags().BoolVar(&opts.Route.UpstreamInsecure, "insecure", false, "With --upstream-tls, skip verifying the app's certificate")
+	root.Flags().StringVar(&opts.Route.DialHost, "dial-host", "", "Proxy to the app on 127.0.0.1, ::1, or localhost (default: 127.0.0.1, or ::1 when the app only listens there)")
+	addTimeoutFlags(root, &opts.Route.Timeouts)
+	root.Flags().StringArrayVar(&opts.SetHeaders, "set-header", nil, "Set a response header, as 'Name: value' (repeatable)")
+	root.Flags().StringArrayVar(&opts.SetRequestHeaders, "set-request-header", nil, "Set a header on requests to the app, as 'Name: value' (repeatable)")
+	addCORSFlag(root, &opts.Route.CORS)
+	root.Flags().BoolVar(&opts.Route.RedirectHTTPS, "redirect-https", false, "Redirect the app's plain-HTTP URL to HTTPS instead of serving it")
+	root.Flags().BoolVar(&opts.Route.HSTS, "hsts", false, "Send Strict-Transport-Security (max-age one year) like production")
+	root.Flags().BoolVar(&opts.Route.LAN, "lan", false, "Also serve the app on this machine's LAN IPs (https://<ip>:<port>) for phones and other devices")
+	root.Flags().StringVar(&opts.Route.ClientCA, "require-client-cert", "", "Only accept TLS connections with a client cert signed by this CA (PEM file)")
+	root.Flags().StringVar(&opts.Route.ACMEDNS, "acme-dns", "", "Get a publicly trusted cert for --host via ACME DNS-01 with this provider ("+strings.Join(acmeDNSProviderNames(), ", ")+")")
+	root.Flags().StringArrayVar(&opts.Auth, "auth", nil, "Require HTTP basic auth, as user:password or user:<bcrypt hash> (repeatable)")
+	root.Flags().StringVar(&opts.MaxBodySize, "max-body-size", "", "Reject request bodies larger than this with 413 (e.g. 100MB, 1GiB)")
+	root.Flags().BoolVar(&opts.Route.Stream, "stream", false, "Flush responses immediately (Server-Sent Events, chunked streaming)")
+	root.Flags().BoolVar(&opts.Route.Trace, "trace", false, "Export an OpenTelemetry span per request and propagate the trace context to the app (see DEVWRAP_OTLP_ENDPOINT)")
+	root.Flags().BoolVar(&opts.Route.SPA, "spa", false, "Single-page app: send HTML navigations to unknown paths to / so client-side routes reload")
+	root.Flags().StringArrayVar(&opts.Profiles, "profile", nil, "Tag the app with a profile so `devwrap ls --profile` can select it (repeatable)")
+	root.Flags().StringVar(&restart, "restart", "no", "Restart the command when it exits non-zero, with backoff: no, on-failure, or on-failure:<max>")
+	root.Flags().StringArrayVar(&opts.Watch.Patterns, "watch", nil, "Restart the command when files matching this glob change, e.g. '**/*.go' (repeatable)")
+	root.Flags().StringArrayVar(&opts.Watch.Ignore, "watch-ignore", nil, "Glob of files or directories to ignore while watching (repeatable)")
+	root.Flags().DurationVar(&opts.Watch.Debounce, "watch-debounce", defaultWatchDebounce, "Wait for changes to settle this long before restarting")
+	root.Flags().DurationVar(&opts.StopTimeout, "stop-timeout", defaultStopTimeout, "How long the command's process group gets to exit after SIGTERM before SIGKILL")
+	root.Flags().StringVarP(&opts.Shell, "command", "c", "", "Run this command string with $SHELL -c (pipelines, &&) instead of the command after --")
+	root.Flags().StringArrayVarP(&opts.Env, "env", "e", nil, "Set an environment variable for the command, as KEY=VALUE (${PORT} and other variables expand) or KEY to pass on devwrap's own value (repeatable)")
+	root.Flags().StringArrayVar(&opts.EnvFiles, "env-file", nil, "Load environment variables for the command from a dotenv file (repeatable; later files win)")
+	root.Flags().BoolVar(&opts.FollowPort, "follow-port", false, "When the command ignores PORT and listens on a single other port, route there instead of only warning")
+	root.Flags().StringVar(&opts.ReadyHTTP, "ready-http", "", "Treat the app as ready once this path answers 200, instead of once its port accepts connections (e.g. /healthz)")
+	root.Flags().StringVar(&opts.Hooks.PreStart, "hook-pre-start", "", "Shell command to run after the route is registered, before the command starts; failure aborts")
+	root.Flags().StringVar(&opts.Hooks.PostReady, "hook-post-ready", "", "Shell command to run once the app's port first accepts connections")
+	root.Flags().StringVar(&opts.Hooks.PostStop, "hook-post-stop", "", "Shell command to run after the command exits and the route is released")
+	root.Flags().BoolVarP(&opts.Detach, "detach", "d", false, "Run in the background with output in <runtime>/logs/<name>.log and return once the route is up")
+	root.Flags().BoolVar(&opts.Restore, "restore", false, "With --detach, start the app again after a reboot (on the first devwrap command)")
+	root.Flags().StringArrayVar(&opts.Rewrites, "rewrite", nil, "Regex path rewrite applied before proxying, as '<regex>=><replacement>' (repeatable)")
+	root.Flags().BoolVarP(&opts.Privileged, "privileged", "p", false, "Use sudo to spawn proxy if Caddy is not already running")
 	root.PersistentFlags().BoolVar(&outputJSON, "json", false, "Output JSON for scripting")
+	root.PersistentFlags().String("state-dir", "", "Keep all state and runtime files in this directory (also DEVWRAP_STATE_DIR)")
+	root.PersistentFlags().Bool("ci", false, "Never prompt (sudo -n, no browser trust stores); also set by DEVWRAP_NONINTERACTIVE=1")
 
 	root.AddCommand(newProxyCommand())
 	root.AddCommand(newListCommand())
+	root.AddCommand(newAddCommand())
 	root.AddCommand(newRemoveCommand())
+	root.AddCommand(newAttachCommand())
+	root.AddCommand(newLogsCommand())
+	root.AddCommand(newCurlCommand())
+	root.AddCommand(newEventsCommand())
+	root.AddCommand(newStateCommand())
+	root.AddCommand(newExportCommand())
+	root.AddCommand(newPsCommand())
 	root.AddCommand(newDoctorCommand())
+	root.AddCommand(newPauseCommand(true), newPauseCommand(false))
+	root.AddCommand(newOverrideCommand())
+	root.AddCommand(newRecordCommand())
+	root.AddCommand(newChaosCommand())
+	root.AddCommand(newDashboardCommand())
+	root.AddCommand(newTUICommand())
+	root.AddCommand(newSchemaCommand())
+	root.AddCommand(newUpCommand())
+	root.AddCommand(newDownCommand())
 
 	return root
 }
@@ -93,46 +282,652 @@ func newProxyCommand() *cobra.Command {
 	start.Flags().BoolVarP(&privileged, "privileged", "p", false, "Spawn proxy with sudo")
 
 	stop := &cobra.Command{Use: "stop", Short: "Stop devwrap-managed proxy", Args: helpOnArgValidationError(cobra.NoArgs), RunE: func(cmd *cobra.Command, args []string) error { return runProxyStop() }}
-	status := &cobra.Command{Use: "status", Short: "Show proxy status", Args: helpOnArgValidationError(cobra.NoArgs), RunE: func(cmd *cobra.Command, args []string) error { return runProxyStatus() }}
-	trust := &cobra.Command{Use: "trust", Short: "Trust Caddy local CA", Args: helpOnArgValidationError(cobra.NoArgs), RunE: func(cmd *cobra.Command, args []string) error { return runProxyTrust() }}
+	var statusOutput string
+	status := &cobra.Command{
+		Use:   "status",
+		Short: "Show proxy status",
+		Args:  helpOnArgValidationError(cobra.NoArgs),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			format, err := outputFlag(statusOutput)
+			if err != nil {
+				return err
+			}
+			return runProxyStatus(format)
+		},
+	}
+	addOutputFlag(status, &statusOutput)
+	var trustBundle string
+	var trustCheck, trustPrint bool
+	var trustOutput string
+	trust := &cobra.Command{
+		Use:   "trust",
+		Short: "Trust Caddy local CA",
+		Long:  "Install the root CA into system trust stores. With --bundle, append it to a PEM file instead (no sudo). With --print, write the PEM to stdout (or --output) without installing it. Exits 3 when trust is unavailable, e.g. in --ci mode when sudo would prompt.",
+		Args:  helpOnArgValidationError(cobra.NoArgs),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			switch {
+			case trustCheck:
+				return runProxyTrustCheck()
+			case trustPrint || trustOutput != "":
+				return runProxyTrustPrint(trustOutput)
+			case trustBundle != "":
+				return runProxyTrustBundle(trustBundle)
+			}
+			return runProxyTrust()
+		},
+	}
+	trust.Flags().StringVar(&trustBundle, "bundle", "", "Append the root CA to this PEM bundle file instead of system stores")
+	trust.Flags().BoolVar(&trustCheck, "check", false, "Only report whether the root CA is trusted (exit 3 if not)")
+	trust.Flags().BoolVar(&trustPrint, "print", false, "Write the root CA PEM to stdout instead of installing it")
+	trust.Flags().StringVarP(&trustOutput, "output", "o", "", "With --print, write the PEM to this file instead of stdout")
+	trust.MarkFlagsMutuallyExclusive("bundle", "check", "print")
+	trust.MarkFlagsMutuallyExclusive("bundle", "check", "output")
 	logs := &cobra.Command{Use: "logs", Short: "Show proxy logs", Args: helpOnArgValidationError(cobra.NoArgs), RunE: func(cmd *cobra.Command, args []string) error { return runProxyLogs() }}
+	var fix bool
+	verify := &cobra.Command{
+		Use:   "verify",
+		Short: "Check live Caddy config against devwrap state",
+		Long:  "Cross-check state.json against the live Caddy config: every app has a route with the right upstream and TLS subject, and there are no orphaned devwrap-* routes. Exits 2 when drift is found and not repaired.",
+		Args:  helpOnArgValidationError(cobra.NoArgs),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runProxyVerify(fix)
+		},
+	}
+	verify.Flags().BoolVar(&fix, "fix", false, "Re-apply routes and TLS policy from state when drift is found")
+	var watch bool
+	var interval time.Duration
+	sync := &cobra.Command{
+		Use:   "sync",
+		Short: "Converge Caddy routes with devwrap state",
+		Long:  "Prune exited apps and re-apply devwrap routes when they drift from state. With --watch, keep running as a lightweight reconciler, which is useful when sharing an unmanaged system Caddy.",
+		Args:  helpOnArgValidationError(cobra.NoArgs),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runProxySync(watch, interval)
+		},
+	}
+	sync.Flags().BoolVar(&watch, "watch", false, "Keep reconciling until interrupted")
+	sync.Flags().DurationVar(&interval, "interval", routeCheckInterval, "Reconcile interval for --watch")
+	reload := &cobra.Command{
+		Use:   "reload",
+		Short: "Re-apply all devwrap routes and TLS policy from state",
+		Long:  "Push every live app's route, the devwrap TLS policy, and CA config from state.json into Caddy, whether or not drift is detected. Use it after Caddy was reloaded with a config that dropped devwrap's routes.",
+		Args:  helpOnArgValidationError(cobra.NoArgs),
+		RunE:  func(cmd *cobra.Command, args []string) error { return runProxyReload() },
+	}
+	var configAll, configDiff bool
+	config := &cobra.Command{
+		Use:   "config",
+		Short: "Show the Caddy config devwrap maintains",
+		Long:  "Print the devwrap-owned parts of the live Caddy config (routes, TLS policies, and the devwrap CA), keyed by config path. --all prints Caddy's whole config; --diff shows how the live devwrap routes and TLS subjects differ from what state.json wants.",
+		Args:  helpOnArgValidationError(cobra.NoArgs),
+		RunE:  func(cmd *cobra.Command, args []string) error { return runProxyConfig(configAll, configDiff) },
+	}
+	config.Flags().BoolVar(&configAll, "all", false, "Print Caddy's whole config, not just devwrap's parts")
+	config.Flags().BoolVar(&configDiff, "diff", false, "Diff the live devwrap routes against the ones state wants")
+	config.MarkFlagsMutuallyExclusive("all", "diff")
+	var restoreForce bool
+	restoreOriginal := &cobra.Command{
+		Use:   "restore-original",
+		Short: "Put an unmanaged Caddy's config back to before devwrap changed it",
+		Long:  "Load the snapshot devwrap took of an unmanaged Caddy's config before its first change, removing every devwrap route, TLS policy, and the devwrap CA. Refuses while apps are registered unless --force, which also drops them from state.",
+		Args:  helpOnArgValidationError(cobra.NoArgs),
+		RunE:  func(cmd *cobra.Command, args []string) error { return runProxyRestoreOriginal(restoreForce) },
+	}
+	restoreOriginal.Flags().BoolVar(&restoreForce, "force", false, "Restore even while apps are registered, dropping them")
+	adopt := &cobra.Command{
+		Use:   "adopt",
+		Short: "Track devwrap routes in Caddy that state doesn't know about",
+		Long:  "Register every devwrap-* route in Caddy missing from state.json (for example after state.json was deleted, or when another machine shares the Caddy) as a `devwrap add` app, so `devwrap ls` shows it and `devwrap rm` removes it. Host, path, upstream, LAN, upstream TLS, streaming, and HTTPS redirect are recovered; other route options are not.",
+		Args:  helpOnArgValidationError(cobra.NoArgs),
+		RunE:  func(cmd *cobra.Command, args []string) error { return runProxyAdopt() },
+	}
+	install := &cobra.Command{
+		Use:   "install",
+		Short: "Run the proxy as a systemd user service (Linux)",
+		Long:  "Write and enable a systemd user unit running `devwrap proxy daemon`, so the proxy starts at login and outlives the terminal. A proxy started with `devwrap proxy start` is handed over to the service.",
+		Args:  helpOnArgValidationError(cobra.NoArgs),
+		RunE:  func(cmd *cobra.Command, args []string) error { return runProxyInstall() },
+	}
+	uninstall := &cobra.Command{
+		Use:   "uninstall",
+		Short: "Stop and remove the systemd user service",
+		Args:  helpOnArgValidationError(cobra.NoArgs),
+		RunE:  func(cmd *cobra.Command, args []string) error { return runProxyUninstall() },
+	}
 	daemon := &cobra.Command{Use: "daemon", Hidden: true, Args: helpOnArgValidationError(cobra.NoArgs), RunE: func(cmd *cobra.Command, args []string) error { return runProxyDaemon() }}
 
-	proxy.AddCommand(start, stop, status, trust, logs, daemon)
+	rotateCA := newRotateCACommand("rotate-ca")
+	rotateCA.Hidden = true
+	proxy.AddCommand(start, stop, status, trust, logs, verify, sync, reload, config, restoreOriginal, adopt, newProxyCACommand(), rotateCA, install, uninstall, daemon)
 	return proxy
 }
 
+func newProxyCACommand() *cobra.Command {
+	ca := &cobra.Command{
+		Use:   "ca",
+		Short: "Manage a devwrap-owned root CA",
+		Long:  "Manage a long-lived root CA stored in the devwrap state dir. When present, Caddy's internal issuer uses it instead of Caddy's local authority, so reinstalling Caddy or switching its storage doesn't invalidate trust.",
+	}
+	var force bool
+	initCmd := &cobra.Command{
+		Use:   "init",
+		Short: "Generate the devwrap root CA and start issuing from it",
+		Args:  helpOnArgValidationError(cobra.NoArgs),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runProxyCAInit(force)
+		},
+	}
+	initCmd.Flags().BoolVar(&force, "force", false, "Replace an existing devwrap root CA")
+	show := &cobra.Command{
+		Use:   "show",
+		Short: "Show the active root CA",
+		Args:  helpOnArgValidationError(cobra.NoArgs),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runProxyCAShow()
+		},
+	}
+	var importForce bool
+	importCmd := &cobra.Command{
+		Use:   "import <root.crt> <root.key>",
+		Short: "Use an existing root CA (e.g. a team-shared mkcert root)",
+		Long:  "Copy a PEM root certificate and its private key into the devwrap state dir and issue from them, so certificates chain to the same root for every teammate and container that trusts it.",
+		Args:  helpOnArgValidationError(cobra.ExactArgs(2)),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runProxyCAImport(args[0], args[1], importForce)
+		},
+	}
+	importCmd.Flags().BoolVar(&importForce, "force", false, "Replace an existing devwrap root CA")
+	ca.AddCommand(initCmd, importCmd, newRotateCACommand("rotate"), show)
+	return ca
+}
+
+// newRotateCACommand builds `proxy ca rotate`, also reachable as the
+// hidden `proxy rotate-ca`.
+func newRotateCACommand(use string) *cobra.Command {
+	var lifetime time.Duration
+	var keepOld bool
+	rotate := &cobra.Command{
+		Use:   use,
+		Short: "Replace the root CA with a new one and re-issue certificates",
+		Long:  "Generate a new devwrap root, purge the intermediate and leaf certificates issued from the old root, restart the managed proxy so every app gets a fresh certificate, trust the new root, and untrust the old one (unless --keep-old). Use it when a root may have leaked, or to move to a shorter lifetime.",
+		Args:  helpOnArgValidationError(cobra.NoArgs),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runProxyRotateCA(lifetime, keepOld)
+		},
+	}
+	rotate.Flags().DurationVar(&lifetime, "lifetime", ownCARootLifetime, "Validity of the new root (e.g. 8760h for one year)")
+	rotate.Flags().BoolVar(&keepOld, "keep-old", false, "Leave the old root in the trust stores")
+	return rotate
+}
+
 func newDoctorCommand() *cobra.Command {
-	return &cobra.Command{
+	var output string
+	doctor := &cobra.Command{
 		Use:   "doctor",
 		Short: "Show environment and health diagnostics",
 		Args:  helpOnArgValidationError(cobra.NoArgs),
 		RunE: func(cmd *cobra.Command, args []string) error {
-			return runDoctor()
+			format, err := outputFlag(output)
+			if err != nil {
+				return err
+			}
+			return runDoctor(format)
+		},
+	}
+	addOutputFlag(doctor, &output)
+
+	var opts bundleOptions
+	bundle := &cobra.Command{
+		Use:   "bundle",
+		Short: "Write a diagnostic tarball for bug reports",
+		Long:  "Collect sanitized state, daemon logs, the effective Caddy config, doctor output, and versions into a .tar.gz. Values under secret-looking keys are always redacted.",
+		Args:  helpOnArgValidationError(cobra.NoArgs),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runDoctorBundle(opts)
 		},
 	}
+	bundle.Flags().StringVarP(&opts.Output, "output", "o", "", "Output path (default: devwrap-bundle-<timestamp>.tar.gz)")
+	bundle.Flags().BoolVar(&opts.RedactHosts, "redact-hosts", false, "Replace hostnames with placeholders")
+	doctor.AddCommand(bundle)
+	return doctor
 }
 
-func newListCommand() *cobra.Command {
-	return &cobra.Command{
-		Use:   "ls",
-		Short: "List registered apps",
+func newUpCommand() *cobra.Command {
+	var opts upOptions
+	up := &cobra.Command{
+		Use:   "up",
+		Short: "Start every service in devwrap.yaml",
+		Long:  "Read devwrap.yaml (searched for from the current directory upward) and run each service behind the proxy as if started with `devwrap --name <service> -- <command>`. Output is prefixed with the service name; ctrl-c stops all services.",
+		Args:  helpOnArgValidationError(cobra.NoArgs),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runUp(opts)
+		},
+	}
+	up.Flags().StringVarP(&opts.File, "file", "f", "", "Path to the config file (default: nearest devwrap.yaml)")
+	up.Flags().StringArrayVar(&opts.Profiles, "profile", nil, "Also start services in this profile (repeatable)")
+	return up
+}
+
+func newDownCommand() *cobra.Command {
+	var profile string
+	var timeout time.Duration
+	down := &cobra.Command{
+		Use:   "down",
+		Short: "Stop all registered apps and remove their routes",
+		Long:  "Send SIGTERM to every tracked app, wait for it to exit (SIGKILL after --timeout), then release the leases and remove the routes in one pass.",
 		Args:  helpOnArgValidationError(cobra.NoArgs),
 		RunE: func(cmd *cobra.Command, args []string) error {
-			return runList()
+			return runDown(profile, timeout)
+		},
+	}
+	down.Flags().StringVar(&profile, "profile", "", "Only stop apps tagged with this profile")
+	down.Flags().DurationVar(&timeout, "timeout", defaultDownTimeout, "How long to wait before sending SIGKILL")
+	return down
+}
+
+func newListCommand() *cobra.Command {
+	var all bool
+	var profile, project, output string
+	ls := &cobra.Command{
+		Use:     "ls",
+		Short:   "List registered apps",
+		Example: "  devwrap ls -o go-template='{{range .apps}}{{.https_url}}{{\"\\n\"}}{{end}}'",
+		Args:    helpOnArgValidationError(cobra.NoArgs),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			format, err := outputFlag(output)
+			if err != nil {
+				return err
+			}
+			return runList(all, profile, project, format)
+		},
+	}
+	addOutputFlag(ls, &output)
+	ls.Flags().BoolVarP(&all, "all", "a", false, "Also show recently exited apps with their exit status")
+	ls.Flags().StringVar(&profile, "profile", "", "Only show apps tagged with this profile")
+	ls.Flags().StringVar(&project, "project", "", "Only show apps in this project")
+	return ls
+}
+
+func addOutputFlag(cmd *cobra.Command, output *string) {
+	cmd.Flags().StringVarP(output, "output", "o", "table", "Output format: table, json, yaml, or go-template='{{...}}' over the --json fields")
+}
+
+// outputFlag parses -o; -o json behaves exactly like --json, errors
+// included.
+func outputFlag(raw string) (outputFormat, error) {
+	format, err := parseOutputFormat(raw)
+	if format.kind == "json" {
+		outputJSON = true
+	}
+	return format, err
+}
+
+func newAddCommand() *cobra.Command {
+	var port int
+	var project, upstream, host, mount string
+	var profiles []string
+	var route devwrap.RouteOptions
+	var maxBodySize string
+	var setHeaders, setRequestHeaders, auth []string
+	add := &cobra.Command{
+		Use:   "add <name> --port <port> | --upstream <host:port>",
+		Short: "Route to an already-running server without running it",
+		Args:  helpOnArgValidationError(cobra.ExactArgs(1)),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var err error
+			if route.ResponseHeaders, err = parseHeaderFlags("--set-header", setHeaders); err != nil {
+				return err
+			}
+			if route.RequestHeaders, err = parseHeaderFlags("--set-request-header", setRequestHeaders); err != nil {
+				return err
+			}
+			if route.BasicAuth, err = parseAuthFlags(auth); err != nil {
+				return err
+			}
+			if err := validateCORSOrigins(route.CORS); err != nil {
+				return err
+			}
+			if route.ClientCA, err = resolveClientCA(route.ClientCA); err != nil {
+				return err
+			}
+			if maxBodySize != "" {
+				size, err := parseByteSize(maxBodySize)
+				if err != nil {
+					return fmt.Errorf("--max-body-size: %w", err)
+				}
+				route.MaxBodySize = size
+			}
+			return runAdd(args[0], project, port, upstream, host, mount, profiles, route)
 		},
 	}
+	add.Flags().IntVar(&port, "port", 0, "Local port the server listens on")
+	add.Flags().StringVar(&project, "project", "", "Namespace the route as <name>.<project> (host <name>.<project>.localhost)")
+	add.Flags().StringVar(&upstream, "upstream", "", "Proxy to this host:port instead of a local port (VM, LAN machine, container IP)")
+	add.Flags().StringVar(&host, "host", "", "Custom hostname (default: <name>.localhost)")
+	add.Flags().StringVar(&mount, "path", "", "Serve under this path on the shared "+devwrap.DefaultPathSite+".localhost host (or --host)")
+	add.Flags().StringArrayVar(&profiles, "profile", nil, "Tag the route with a profile (repeatable)")
+	addTimeoutFlags(add, &route.Timeouts)
+	add.Flags().StringArrayVar(&setHeaders, "set-header", nil, "Set a response header, as 'Name: value' (repeatable)")
+	add.Flags().StringArrayVar(&setRequestHeaders, "set-request-header", nil, "Set a header on requests to the server, as 'Name: value' (repeatable)")
+	addCORSFlag(add, &route.CORS)
+	add.Flags().BoolVar(&route.RedirectHTTPS, "redirect-https", false, "Redirect the server's plain-HTTP URL to HTTPS instead of serving it")
+	add.Flags().BoolVar(&route.HSTS, "hsts", false, "Send Strict-Transport-Security (max-age one year) like production")
+	add.Flags().BoolVar(&route.LAN, "lan", false, "Also serve the server on this machine's LAN IPs (https://<ip>:<port>) for phones and other devices")
+	add.Flags().StringVar(&route.ClientCA, "require-client-cert", "", "Only accept TLS connections with a client cert signed by this CA (PEM file)")
+	add.Flags().StringVar(&route.ACMEDNS, "acme-dns", "", "Get a publicly trusted cert for --host via ACME DNS-01 with this provider ("+strings.Join(acmeDNSProviderNames(), ", ")+")")
+	add.Flags().StringArrayVar(&auth, "auth", nil, "Require HTTP basic auth, as user:password or user:<bcrypt hash> (repeatable)")
+	add.Flags().StringVar(&maxBodySize, "max-body-size", "", "Reject request bodies larger than this with 413 (e.g. 100MB, 1GiB)")
+	add.Flags().BoolVar(&route.Stream, "stream", false, "Flush responses immediately (Server-Sent Events, chunked streaming)")
+	add.Flags().BoolVar(&route.Trace, "trace", false, "Export an OpenTelemetry span per request and propagate the trace context to the server (see DEVWRAP_OTLP_ENDPOINT)")
+	add.Flags().BoolVar(&route.UpstreamTLS, "upstream-tls", false, "The server speaks HTTPS; proxy to it over TLS")
+	add.Flags().BoolVar(&route.UpstreamInsecure, "insecure", false, "With --upstream-tls, skip verifying the server's certificate")
+	add.Flags().StringVar(&route.DialHost, "dial-host", "", "Proxy to --port on 127.0.0.1 (default), ::1, or localhost")
+	add.MarkFlagsOneRequired("port", "upstream")
+	return add
+}
+
+func addTimeoutFlags(cmd *cobra.Command, t *devwrap.RouteTimeouts) {
+	cmd.Flags().DurationVar(&t.Read, "read-timeout", 0, "Max time to wait for each read from the app (default: none)")
+	cmd.Flags().DurationVar(&t.Write, "write-timeout", 0, "Max time for each write to the app (default: none)")
+	cmd.Flags().DurationVar(&t.StreamTimeout, "stream-timeout", 0, "Close WebSockets and other upgraded connections after this long (default: never)")
+	cmd.Flags().DurationVar(&t.StreamCloseDelay, "stream-close-delay", 0, "Keep WebSockets open this long when the proxy config reloads, e.g. as other apps start (default: close at once)")
 }
 
 func newRemoveCommand() *cobra.Command {
+	var project string
+	rm := &cobra.Command{
+		Use:   "rm [name] [--project <project>]",
+		Short: "Remove app route, or every route in a project",
+		Args:  helpOnArgValidationError(cobra.MaximumNArgs(1)),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) == 0 {
+				if project == "" {
+					if !outputJSON {
+						_ = cmd.Help()
+					}
+					return errors.New("missing app name (or --project)")
+				}
+				return runRemove("", project)
+			}
+			return runRemove(args[0], project)
+		},
+	}
+	rm.Flags().StringVar(&project, "project", "", "Project of <name>; without <name>, remove every app in the project")
+	return rm
+}
+
+func newAttachCommand() *cobra.Command {
 	return &cobra.Command{
-		Use:   "rm <name>",
-		Short: "Remove app route",
+		Use:   "attach <name>",
+		Short: "Follow the output of an app started with --detach (Ctrl-C detaches)",
+		Args:  helpOnArgValidationError(cobra.ExactArgs(1)),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runAttach(args[0])
+		},
+	}
+}
+
+func newExportCommand() *cobra.Command {
+	export := &cobra.Command{
+		Use:   "export",
+		Short: "Render registrations in another tool's format",
+	}
+	var output string
+	caddyfile := &cobra.Command{
+		Use:   "caddyfile",
+		Short: "Print the live registrations as a Caddyfile",
+		Long:  "Render every live app as a human-editable Caddyfile: one site per host, path-mounted apps as handle blocks, and each app's headers, auth, rewrites, CORS, and proxy settings as directives. Use it to review what devwrap configures or to move to your own Caddy config.",
+		Args:  helpOnArgValidationError(cobra.NoArgs),
+		RunE:  func(cmd *cobra.Command, args []string) error { return runExportCaddyfile(output) },
+	}
+	caddyfile.Flags().StringVarP(&output, "output", "o", "", "Write the Caddyfile to this file instead of stdout")
+	export.AddCommand(caddyfile)
+	return export
+}
+
+func newStateCommand() *cobra.Command {
+	state := &cobra.Command{
+		Use:   "state",
+		Short: "Back up, restore, or migrate app registrations",
+	}
+	export := &cobra.Command{
+		Use:   "export",
+		Short: "Write all live registrations to stdout as JSON",
+		Args:  helpOnArgValidationError(cobra.NoArgs),
+		RunE:  func(cmd *cobra.Command, args []string) error { return runStateExport() },
+	}
+	var replace bool
+	importCmd := &cobra.Command{
+		Use:   "import [file]",
+		Short: "Register the apps of a state export and re-apply routes",
+		Long:  "Read a `devwrap state export` document (from file, or stdin when omitted or -) and register its apps. Apps owned by a devwrap process that is not running on this machine are skipped; `devwrap add` registrations are restored as they were.",
+		Args:  helpOnArgValidationError(cobra.MaximumNArgs(1)),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			path := ""
+			if len(args) == 1 {
+				path = args[0]
+			}
+			return runStateImport(path, replace)
+		},
+	}
+	importCmd.Flags().BoolVar(&replace, "replace", false, "Overwrite registrations that already exist under the same name")
+	migrate := &cobra.Command{
+		Use:       "migrate <sqlite|json>",
+		Short:     "Move state to the SQLite or JSON backend",
+		Long:      "Copy state into state.db (SQLite in WAL mode, which takes row-level writes and doesn't block readers, for many concurrent devwrap commands) or back into state.json, and switch to it. The old file is kept with a .migrated suffix.",
+		Args:      helpOnArgValidationError(cobra.ExactArgs(1)),
+		ValidArgs: []string{"sqlite", "json"},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if args[0] != "sqlite" && args[0] != "json" {
+				return fmt.Errorf("unknown state backend %q (expected sqlite or json)", args[0])
+			}
+			return runStateMigrate(args[0])
+		},
+	}
+	state.AddCommand(export, importCmd, migrate)
+	return state
+}
+
+func newEventsCommand() *cobra.Command {
+	var tail int
+	var sse bool
+	events := &cobra.Command{
+		Use:   "events",
+		Short: "Stream lifecycle events as NDJSON until Ctrl-C",
+		Long:  "Stream app_registered, app_ready, app_released, proxy_started, proxy_stopped, and trust_changed events, one JSON object per line, so editors and status bars can react to devwrap without polling `ls --json`.",
+		Args:  helpOnArgValidationError(cobra.NoArgs),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runEvents(tail, sse)
+		},
+	}
+	events.Flags().IntVarP(&tail, "tail", "n", 0, "Replay the last N events before streaming new ones")
+	events.Flags().BoolVar(&sse, "sse", false, "Frame events as server-sent events (event: <type>, data: <json>)")
+	return events
+}
+
+func newLogsCommand() *cobra.Command {
+	var follow bool
+	var tail int
+	logs := &cobra.Command{
+		Use:   "logs <name>",
+		Short: "Show captured output of an app",
 		Args:  helpOnArgValidationError(cobra.ExactArgs(1)),
 		RunE: func(cmd *cobra.Command, args []string) error {
-			return runRemove(args[0])
+			return runLogs(args[0], tail, follow)
+		},
+	}
+	logs.Flags().BoolVarP(&follow, "follow", "f", false, "Keep streaming new output until Ctrl-C")
+	logs.Flags().IntVarP(&tail, "tail", "n", 0, "Only show the last N lines (default: whole file)")
+	return logs
+}
+
+func newCurlCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "curl <name> [path] [-- curl-args...]",
+		Short: "Request an app through the proxy with curl",
+		Long:  "Run curl against the app's HTTPS URL through the proxy, verifying the certificate with the devwrap root CA so it works without trusting the CA system-wide. Arguments after -- go to curl unchanged (method, headers, body, -v), and devwrap exits with curl's status.",
+		Example: `  devwrap curl api /healthz
+  devwrap curl api /users -- -X POST -H 'Content-Type: application/json' -d '{"name":"ada"}'`,
+		Args: helpOnArgValidationError(func(cmd *cobra.Command, args []string) error {
+			if dash := cmd.ArgsLenAtDash(); dash >= 0 {
+				args = args[:dash]
+			}
+			return cobra.RangeArgs(1, 2)(cmd, args)
+		}),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var extra []string
+			if dash := cmd.ArgsLenAtDash(); dash >= 0 {
+				args, extra = args[:dash], args[dash:]
+			}
+			path := ""
+			if len(args) == 2 {
+				path = args[1]
+			}
+			return runCurl(args[0], path, extra)
+		},
+	}
+}
+
+func newDashboardCommand() *cobra.Command {
+	var detach bool
+	cmd := &cobra.Command{
+		Use:   "dashboard",
+		Short: "Serve a web dashboard of registered apps at https://devwrap.localhost",
+		Long:  "Register the devwrap app and serve a page listing every app with its status, URL, port, and uptime, plus the proxy's trust state, with buttons to open, pause, resume, and remove apps. It runs until Ctrl-C, or in the background with --detach.",
+		Args:  helpOnArgValidationError(cobra.NoArgs),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runDashboard(detach)
+		},
+	}
+	cmd.Flags().BoolVarP(&detach, "detach", "d", false, "Run in the background with output in <runtime>/logs/devwrap.log")
+	return cmd
+}
+
+func newTUICommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "tui",
+		Short: "Browse registered apps and their logs in the terminal",
+		Long:  "Show every registered app with its status, PID, uptime, and URL, and stream the selected app's log. Keys: ↑/↓ or j/k select, r restarts the app's command (the route stays up), p pauses or resumes, x removes, o opens in the browser, l toggles the log pane, q quits. The list follows the event log instead of polling.",
+		Args:  helpOnArgValidationError(cobra.NoArgs),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runTUI()
+		},
+	}
+}
+
+func newSchemaCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "schema",
+		Short: "Print the JSON Schema of --json output",
+		Long:  "Print the JSON Schema (draft 2020-12) that every --json payload follows. Each payload carries schema_version; within a version fields are only added, never renamed, removed, or retyped.",
+		Args:  helpOnArgValidationError(cobra.NoArgs),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			_, err := os.Stdout.Write(jsonSchema)
+			return err
+		},
+	}
+}
+
+func newPsCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "ps",
+		Short: "Show process tree, CPU, and memory of running apps",
+		Args:  helpOnArgValidationError(cobra.NoArgs),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runPs()
+		},
+	}
+}
+
+func newPauseCommand(paused bool) *cobra.Command {
+	use, short := "resume [name]", "Restore devwrap routes (or one app's route) after pause"
+	if paused {
+		use, short = "pause [name]", "Serve 503 on all devwrap routes, or one app's, without stopping apps or dropping registrations"
+	}
+	return &cobra.Command{
+		Use:   use,
+		Short: short,
+		Args:  helpOnArgValidationError(cobra.MaximumNArgs(1)),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			name := ""
+			if len(args) == 1 {
+				name = args[0]
+			}
+			return runPause(name, paused)
+		},
+	}
+}
+
+func newRecordCommand() *cobra.Command {
+	var opts recordOptions
+	var bodyLimit string
+	cmd := &cobra.Command{
+		Use:     "record <name>",
+		Short:   "Capture an app's requests and responses into a HAR file",
+		Long:    "Route an app through a recording hop until Ctrl-C (or --duration), then write every request and response as a HAR file for bug reports and replay. Bodies are cut at --body-limit. Without --output, the file goes to <state>/recordings, which keeps the newest 20.",
+		Example: "  devwrap record web\n  devwrap record api --duration 30s -o bug-1234.har\n  devwrap record api --body-limit 0",
+		Args:    helpOnArgValidationError(cobra.ExactArgs(1)),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			opts.BodyLimit = 0 // --body-limit 0 keeps no bodies
+			if strings.TrimSpace(bodyLimit) != "0" {
+				n, err := parseByteSize(bodyLimit)
+				if err != nil {
+					return fmt.Errorf("--body-limit: %w", err)
+				}
+				opts.BodyLimit = n
+			}
+			return runRecord(args[0], opts)
+		},
+	}
+	cmd.Flags().StringVarP(&opts.Output, "output", "o", "", "Write the HAR file here instead of <state>/recordings")
+	cmd.Flags().StringVar(&bodyLimit, "body-limit", "1MiB", "Keep at most this much of each request and response body; 0 keeps none")
+	cmd.Flags().DurationVar(&opts.Duration, "duration", 0, "Stop recording after this long instead of waiting for Ctrl-C")
+	return cmd
+}
+
+func newChaosCommand() *cobra.Command {
+	var c devwrap.ChaosOptions
+	var errorRate string
+	var off bool
+	cmd := &cobra.Command{
+		Use:     "chaos <name>",
+		Short:   "Slow down or fail a share of an app's requests at the proxy",
+		Long:    "Add latency to every request of an app's route and answer a share of them with an error instead of proxying, so frontends can be tested against a slow or flaky backend without touching its code. With only <name>, show the current settings. Settings last until --off or the end of the app's registration. --latency needs devwrap's managed proxy.",
+		Example: "  devwrap chaos api --latency 300ms --error-rate 5%\n  devwrap chaos api --error-rate 50% --error-status 502\n  devwrap chaos api --off",
+		Args:    helpOnArgValidationError(cobra.ExactArgs(1)),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			set := cmd.Flags().Changed("latency") || cmd.Flags().Changed("error-rate")
+			if cmd.Flags().Changed("error-rate") {
+				rate, err := parseErrorRate(errorRate)
+				if err != nil {
+					return err
+				}
+				c.ErrorRate = rate
+			}
+			return runChaos(args[0], c, set, off)
+		},
+	}
+	cmd.Flags().DurationVar(&c.Latency, "latency", 0, "Delay every request this long before it reaches the app (e.g. 300ms)")
+	cmd.Flags().StringVar(&errorRate, "error-rate", "", "Answer this share of requests with --error-status instead of proxying (e.g. 5% or 0.05)")
+	cmd.Flags().IntVar(&c.ErrorStatus, "error-status", http.StatusServiceUnavailable, "Status code for injected errors")
+	cmd.Flags().BoolVar(&off, "off", false, "Remove the app's latency and errors")
+	return cmd
+}
+
+func newOverrideCommand() *cobra.Command {
+	var remove bool
+	cmd := &cobra.Command{
+		Use:     "override <name> [<path> <file>]",
+		Short:   "Serve a local file for a path of an app",
+		Long:    "Serve a local file for a specific request path of a running app while everything else is still proxied. With only <name>, list the app's overrides. Overrides last as long as the app's registration.",
+		Example: "  devwrap override web /config.json ./local-config.json\n  devwrap override web /config.json --remove\n  devwrap override web",
+		Args:    helpOnArgValidationError(cobra.RangeArgs(1, 3)),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runOverride(args[0], args[1:], remove)
 		},
 	}
+	cmd.Flags().BoolVar(&remove, "remove", false, "Remove the override for <path>")
+	return cmd
 }
 
 func helpOnArgValidationError(next cobra.PositionalArgs) cobra.PositionalArgs {
@@ -145,22 +940,147 @@ func helpOnArgValidationError(next cobra.PositionalArgs) cobra.PositionalArgs {
 	}
 }
 
-func runApp(name, host string, cmdArgs []string, privileged bool) error {
-	if err := validateName(name); err != nil {
+func runApp(opts runOptions, cmdArgs []string) error {
+	name, err := projectAppName(opts.Name, opts.Project)
+	if err != nil {
+		return err
+	}
+
+	if opts.Path != "" {
+		if opts.Mount != "" {
+			return errors.New("--path and --mount cannot be combined")
+		}
+		opts.Mount = opts.Path
+		if opts.Site == "" && opts.Host == "" {
+			opts.Site = devwrap.DefaultPathSite
+		}
+	}
+	if opts.Site != "" {
+		if opts.Host != "" {
+			return errors.New("--site and --host cannot be combined")
+		}
+		site, err := siteHost(opts.Site)
+		if err != nil {
+			return err
+		}
+		opts.Host = site
+	}
+	resolvedHost, err := devwrap.HostForApp(name, opts.Host)
+	if err != nil {
+		return err
+	}
+	mount, err := devwrap.NormalizeMountPath(opts.Mount)
+	if err != nil {
+		return err
+	}
+	if opts.Strip {
+		if mount == "" {
+			return errors.New("--strip requires --path or --mount")
+		}
+		if opts.Route.StripPrefix != "" {
+			return errors.New("--strip and --strip-prefix cannot be combined")
+		}
+		opts.Route.StripPrefix = mount
+	}
+
+	if opts.TTL < 0 {
+		return errors.New("--ttl must be positive")
+	}
+	if opts.Route.UpstreamInsecure && !opts.Route.UpstreamTLS {
+		return errors.New("--insecure requires --upstream-tls")
+	}
+	if err := validateDialHost(opts.Route.DialHost); err != nil {
+		return err
+	}
+	if err := opts.Route.Timeouts.Validate(); err != nil {
+		return err
+	}
+	if opts.Route.ResponseHeaders, err = parseHeaderFlags("--set-header", opts.SetHeaders); err != nil {
+		return err
+	}
+	if opts.Route.RequestHeaders, err = parseHeaderFlags("--set-request-header", opts.SetRequestHeaders); err != nil {
+		return err
+	}
+	if opts.Route.BasicAuth, err = parseAuthFlags(opts.Auth); err != nil {
+		return err
+	}
+	if err := validateCORSOrigins(opts.Route.CORS); err != nil {
+		return err
+	}
+	if err := validateACMEDNS(opts.Route.ACMEDNS, opts.Host); err != nil {
+		return err
+	}
+	if opts.Route.ClientCA, err = resolveClientCA(opts.Route.ClientCA); err != nil {
+		return err
+	}
+	if opts.MaxBodySize != "" {
+		size, err := parseByteSize(opts.MaxBodySize)
+		if err != nil {
+			return fmt.Errorf("--max-body-size: %w", err)
+		}
+		opts.Route.MaxBodySize = size
+	}
+	if opts.StopTimeout <= 0 {
+		return errors.New("--stop-timeout must be positive")
+	}
+	if err := validateProfiles(opts.Profiles); err != nil {
+		return err
+	}
+	if err := opts.Watch.validate(); err != nil {
 		return err
 	}
+	if opts.Port != 0 {
+		if opts.Port < 1 || opts.Port > 65535 {
+			return fmt.Errorf("invalid --port %d", opts.Port)
+		}
+		if opts.Instance {
+			return errors.New("--port and --instance cannot be combined; copies need their own ports")
+		}
+	}
+	if opts.PortStrategy, err = devwrap.ResolvePortStrategy(opts.PortStrategy); err != nil {
+		return err
+	}
+	if opts.ReadyHTTP != "" && !strings.HasPrefix(opts.ReadyHTTP, "/") {
+		return errors.New("--ready-http must be a path starting with /")
+	}
+	if err := validateEnvEntries(opts.Env); err != nil {
+		return err
+	}
+	if opts.FileEnv, err = loadEnvFiles(opts.EnvFiles); err != nil {
+		return err
+	}
+	if err := validateRouteOrder(opts.Route.RouteOrder); err != nil {
+		return err
+	}
+	rewrites, err := parsePathRewrites(opts.Rewrites)
+	if err != nil {
+		return err
+	}
+	opts.Route.Rewrites = rewrites
+	if opts.Route.StripPrefix != "" && !strings.HasPrefix(opts.Route.StripPrefix, "/") {
+		return errors.New("--strip-prefix must start with /")
+	}
 
-	resolvedHost, err := hostForApp(name, host)
+	cwd, err := resolveCwd(opts.Cwd)
 	if err != nil {
 		return err
 	}
+	opts.Cwd = cwd
 
-	if err := ensureCaddyOrDaemon(privileged); err != nil {
+	if err := ensureCaddyOrDaemon(opts.Privileged); err != nil {
+		return err
+	}
+	if err := checkACMEDNSModule(opts.Route.ACMEDNS); err != nil {
 		return err
 	}
 
-	lease, err := acquireLease(name, resolvedHost, os.Getpid())
+	lease, err := acquireLease(devwrap.LeaseRequest{Name: name, Host: resolvedHost, Path: mount, Project: opts.Project, PID: os.Getpid(), Port: opts.Port, PortStrategy: opts.PortStrategy, Instance: opts.Instance, TTL: opts.TTL, Route: opts.Route, Profiles: opts.Profiles, LogFile: opts.LogFile, Restore: restoreSpec(opts)})
 	if err != nil {
+		if opts.NameFrom != "" {
+			if taken := derivedNameTaken(name, opts, cmdArgs); taken != nil {
+				return taken
+			}
+		}
 		if checkDaemonReachable() {
 			if path, logErr := daemonLogPath(); logErr == nil {
 				return fmt.Errorf("%w (logs: %s)", err, path)
@@ -168,72 +1088,155 @@ func runApp(name, host string, cmdArgs []string, privileged bool) error {
 		}
 		return err
 	}
+	name = lease.Name
+	opts.Name = name
 
-	if !lease.Trusted {
-		if outputJSON {
-			_ = emitJSON(map[string]any{
-				"ok":        true,
-				"action":    "run",
-				"name":      name,
-				"port":      lease.Port,
-				"https_url": lease.HTTPSURL,
-				"http_url":  lease.HTTPURL,
-				"trusted":   lease.Trusted,
-				"warnings": []string{
-					"HTTPS cert is issued by Caddy Local Authority and is not trusted yet",
-					"run: devwrap proxy trust",
-					"or: sudo devwrap proxy trust",
-				},
-			})
-		} else {
-			fmt.Println("warning: HTTPS cert is issued by Caddy Local Authority and is not trusted yet")
-			fmt.Println("run: devwrap proxy trust")
-			fmt.Println("or:  sudo devwrap proxy trust")
-		}
-	} else if outputJSON {
-		_ = emitJSON(map[string]any{
-			"ok":        true,
-			"action":    "run",
-			"name":      name,
+	// ACME certificates are publicly trusted; the local CA doesn't matter.
+	untrusted := !lease.Trusted && opts.Route.ACMEDNS == ""
+	if outputJSON {
+		acquired := map[string]any{
 			"port":      lease.Port,
 			"https_url": lease.HTTPSURL,
 			"http_url":  lease.HTTPURL,
 			"trusted":   lease.Trusted,
-		})
+			"lan_urls":  lease.LANURLs,
+		}
+		if untrusted {
+			acquired["warnings"] = []string{
+				"HTTPS cert is issued by Caddy Local Authority and is not trusted yet",
+				"run: devwrap proxy trust",
+				"or: sudo devwrap proxy trust",
+			}
+		}
+		emitRunEvent(name, "lease_acquired", acquired)
+		// The lease request applies the route before it returns.
+		emitRunEvent(name, "route_applied", map[string]any{"host": lease.Host, "path": lease.Path, "https_url": lease.HTTPSURL})
+	} else if untrusted {
+		fmt.Println("warning: HTTPS cert is issued by Caddy Local Authority and is not trusted yet")
+		fmt.Println("run: devwrap proxy trust")
+		fmt.Println("or:  sudo devwrap proxy trust")
 	}
 
 	if !outputJSON {
 		fmt.Printf("%s -> %s\n", name, lease.HTTPSURL)
 		fmt.Printf("http fallback: %s\n", lease.HTTPURL)
+		for _, u := range lease.LANURLs {
+			fmt.Printf("lan: %s\n", u)
+		}
 	}
 
-	release := func() {
-		releaseLeaseSelected(name, os.Getpid())
+	stopWatch := watchRoute(name, os.Getpid())
+	release := func(exitCode *int) {
+		stopWatch()
+		releaseLeaseSelected(name, os.Getpid(), exitCode)
 	}
-	return runChild(name, cmdArgs, lease.Port, normalizeDevwrapHostURL(lease.HTTPSURL), release)
+	return runChild(opts, cmdArgs, leaseTemplates(lease), release)
 }
 
-func wantsJSONArgs(args []string) bool {
-	for _, a := range args {
-		if a == "--json" {
-			return true
+func parsePathRewrites(specs []string) ([]devwrap.PathRewrite, error) {
+	var out []devwrap.PathRewrite
+	for _, spec := range specs {
+		find, replace, ok := strings.Cut(spec, "=>")
+		if !ok || find == "" {
+			return nil, fmt.Errorf("invalid --rewrite %q (want '<regex>=><replacement>')", spec)
 		}
+		if _, err := regexp.Compile(find); err != nil {
+			return nil, fmt.Errorf("invalid --rewrite regex %q: %w", find, err)
+		}
+		out = append(out, devwrap.PathRewrite{Find: find, Replace: replace})
 	}
-	return false
+	return out, nil
+}
+
+// parseHeaderFlags turns repeated 'Name: value' flags into a header map;
+// a later flag for the same name wins.
+func parseHeaderFlags(flag string, specs []string) (map[string]string, error) {
+	if len(specs) == 0 {
+		return nil, nil
+	}
+	out := make(map[string]string, len(specs))
+	for _, spec := range specs {
+		name, value, ok := strings.Cut(spec, ":")
+		name = strings.TrimSpace(name)
+		if !ok || name == "" || strings.ContainsAny(name, " \t") {
+			return nil, fmt.Errorf("invalid %s %q (want 'Name: value')", flag, spec)
+		}
+		out[http.CanonicalHeaderKey(name)] = strings.TrimSpace(value)
+	}
+	return out, nil
+}
+
+// derivedNameTaken explains a lease refused because another app already
+// has the name derived for this one, suggesting a free name to pass as
+// --name. It returns nil for any other failure. It only reads state: an
+// error path must not prune apps or touch the proxy.
+func derivedNameTaken(name string, opts runOptions, cmdArgs []string) error {
+	state, err := devwrap.LoadLocalState()
+	if err != nil {
+		return nil
+	}
+	taken := map[string]bool{}
+	for _, app := range state.Apps {
+		if devwrap.AppLive(app) {
+			taken[app.Name] = true
+		}
+	}
+	holder, ok := state.Apps[name]
+	if !ok || !taken[name] {
+		return nil
+	}
+	suggestion := "<name>"
+	for n := 2; n <= 99; n++ {
+		candidate := opts.Name + "-" + strconv.Itoa(n)
+		full, err := projectAppName(candidate, opts.Project)
+		if err == nil && !taken[full] {
+			suggestion = candidate
+			break
+		}
+	}
+	owner, instance := "a route from `devwrap add`", ""
+	if !holder.Unowned {
+		owner, instance = fmt.Sprintf("pid %d", holder.PID), "\nor pass --instance to start another copy"
+	}
+	return fmt.Errorf("the name %q (from %s) is already used by %s; name this app explicitly:\n  devwrap --name %s -- %s%s",
+		name, opts.NameFrom, owner, suggestion, strings.Join(cmdArgs, " "), instance)
 }
 
-func validateName(name string) error {
-	if name == "" {
-		return errors.New("app name cannot be empty")
+// shellCommand runs s with the user's $SHELL, or /bin/sh without one.
+func shellCommand(s string) []string {
+	shell := os.Getenv("SHELL")
+	if shell == "" {
+		shell = "/bin/sh"
+	}
+	return []string{shell, "-c", s}
+}
+
+func resolveCwd(raw string) (string, error) {
+	if raw == "" {
+		return "", nil
+	}
+	dir, err := filepath.Abs(raw)
+	if err != nil {
+		return "", err
 	}
-	for _, r := range name {
-		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') || r == '-' {
-			continue
+	info, err := os.Stat(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", fmt.Errorf("cwd %q does not exist", raw)
 		}
-		return errors.New("app name must use lowercase letters, numbers, or dashes")
+		return "", err
+	}
+	if !info.IsDir() {
+		return "", fmt.Errorf("cwd %q is not a directory", raw)
 	}
-	if name[0] == '-' || name[len(name)-1] == '-' {
-		return errors.New("app name cannot start or end with a dash")
+	return dir, nil
+}
+
+func wantsJSONArgs(args []string) bool {
+	for _, a := range args {
+		if a == "--json" {
+			return true
+		}
 	}
-	return nil
+	return false
 }
@@ -3,15 +3,23 @@ package main
 import (
 	"errors"
 	"fmt"
+	"maps"
+	"net"
 	"net/http"
 	"net/url"
 	"os"
 	"os/exec"
 	"os/signal"
+	"path/filepath"
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"syscall"
+	"time"
+
+	"devwrap/internal/devwrap"
 )
 
 func runProxyStart(privileged bool) error {
@@ -26,14 +34,31 @@ func runProxyStart(privileged bool) error {
 		fmt.Println("proxy is already running")
 		return nil
 	}
-	if checkSystemCaddyReachable() {
+	if devwrap.CheckSystemCaddyReachable() {
 		if outputJSON {
-			return emitJSON(map[string]any{"ok": true, "action": "proxy_start", "result": "using_unmanaged", "admin": caddyAdminBase})
+			return emitJSON(map[string]any{"ok": true, "action": "proxy_start", "result": "using_unmanaged", "admin": devwrap.CaddyAdmin.Listen})
 		}
-		fmt.Println("unmanaged caddy is already running at 127.0.0.1:2019")
+		fmt.Printf("unmanaged caddy is already running at %s\n", devwrap.CaddyAdmin.Listen)
 		fmt.Println("devwrap will use it directly with file-based state")
 		return nil
 	}
+	if devwrap.CaddyAdmin.Remote {
+		return fmt.Errorf("the remote caddy admin at %s (%s) is not reachable; devwrap does not start remote proxies", devwrap.CaddyAdmin.Base, devwrap.CaddyAdminEnv)
+	}
+
+	if devwrap.SystemdServiceInstalled() && !privileged {
+		if err := systemctlUser("start", devwrap.SystemdUnitName); err != nil {
+			return err
+		}
+		if err := waitForDaemon(); err != nil {
+			return fmt.Errorf("proxy failed to start (see `journalctl --user -u %s`): %w", devwrap.SystemdUnitName, err)
+		}
+		if outputJSON {
+			return emitJSON(map[string]any{"ok": true, "action": "proxy_start", "result": "started", "managed_by": "systemd"})
+		}
+		fmt.Println("proxy started (systemd)")
+		return nil
+	}
 
 	bin, err := os.Executable()
 	if err != nil {
@@ -43,7 +68,7 @@ func runProxyStart(privileged bool) error {
 	if err != nil {
 		return err
 	}
-	logFile, err := os.OpenFile(logPath, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	logFile, err := os.OpenFile(logPath, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
 	if err != nil {
 		return err
 	}
@@ -53,12 +78,15 @@ func runProxyStart(privileged bool) error {
 	cmdArgs := []string{"proxy", "daemon"}
 	if privileged {
 		cmdName = "sudo"
-		cmdArgs = append([]string{"--preserve-env=XDG_STATE_HOME,DEVWRAP_CADDY_DATA_DIR,CADDY_DATA_DIR", bin}, cmdArgs...)
+		cmdArgs = append([]string{"--preserve-env=XDG_STATE_HOME,XDG_RUNTIME_DIR,DEVWRAP_STATE_DIR,DEVWRAP_CADDY_DATA_DIR,CADDY_DATA_DIR," + otlpEndpointEnv, bin}, cmdArgs...)
+		if nonInteractive {
+			cmdArgs = append([]string{"-n"}, cmdArgs...)
+		}
 	}
 	cmd := exec.Command(cmdName, cmdArgs...)
 	cmd.Stdout = logFile
 	cmd.Stderr = logFile
-	if privileged {
+	if privileged && !nonInteractive {
 		cmd.Stdin = os.Stdin
 	} else {
 		cmd.Stdin = nil
@@ -83,9 +111,13 @@ func runProxyStart(privileged bool) error {
 }
 
 func runProxyStop() error {
-	if checkSystemCaddyReachable() {
-		if info, err := inspectExternalCaddy(); err == nil && info.Managed {
-			if err := stopManagedCaddy(); err != nil {
+	if devwrap.CheckSystemCaddyReachable() {
+		if info, err := devwrap.InspectExternalCaddy(); err == nil && info.Managed {
+			if devwrap.SystemdServiceActive() {
+				if err := systemctlUser("stop", devwrap.SystemdUnitName); err != nil {
+					return err
+				}
+			} else if err := stopManagedCaddy(); err != nil {
 				return err
 			}
 			if outputJSON {
@@ -96,9 +128,9 @@ func runProxyStop() error {
 		}
 	}
 
-	pid, err := readDaemonPID()
-	if err != nil || !processAlive(pid) {
-		if checkSystemCaddyReachable() {
+	pid, err := devwrap.ReadDaemonPID()
+	if err != nil || !devwrap.ProcessAlive(pid) {
+		if devwrap.CheckSystemCaddyReachable() {
 			if outputJSON {
 				return emitJSON(map[string]any{"ok": true, "action": "proxy_stop", "result": "using_unmanaged"})
 			}
@@ -121,24 +153,36 @@ func runProxyStop() error {
 	return nil
 }
 
-func runProxyStatus() error {
-	if !checkSystemCaddyReachable() {
-		if outputJSON {
-			return emitJSON(map[string]any{"ok": true, "running": false})
+func runProxyStatus(format outputFormat) error {
+	if !devwrap.CheckSystemCaddyReachable() {
+		installed := devwrap.SystemdServiceInstalled()
+		if format.structured() {
+			out := map[string]any{"ok": true, "action": "proxy_status", "running": false}
+			if installed {
+				out["managed_by"] = "systemd"
+			}
+			return format.emit(out)
+		}
+		if installed {
+			fmt.Printf("proxy is not running (systemd unit installed; see `systemctl --user status %s`)\n", devwrap.SystemdUnitName)
+			return nil
 		}
 		fmt.Println("proxy is not running")
 		return nil
 	}
-	s, err := localStatusFromFiles()
+	s, err := devwrap.LocalStatusFromFiles()
 	if err != nil {
 		return err
 	}
 	owner := "unmanaged caddy"
 	if s.CaddySource == "managed" {
 		owner = "managed caddy"
+		if s.ManagedBy != "" {
+			owner += " via " + s.ManagedBy
+		}
 	}
-	if outputJSON {
-		return emitJSON(map[string]any{"ok": true, "running": true, "status": s, "owner": owner})
+	if format.structured() {
+		return format.emit(map[string]any{"ok": true, "action": "proxy_status", "running": true, "status": s, "owner": owner})
 	}
 	mode := modeFromStatus(s)
 	if s.CaddySource == "managed" {
@@ -152,13 +196,16 @@ func runProxyStatus() error {
 	}
 	fmt.Printf("http: %d, https: %d\n", s.HTTPPort, s.HTTPSPort)
 	fmt.Printf("ca trusted: %v\n", s.Trusted)
+	if s.Paused {
+		fmt.Println("routes: paused (run `devwrap resume`)")
+	}
 	if len(s.Apps) == 0 {
 		fmt.Println("apps: none")
 		return nil
 	}
 	fmt.Println("apps:")
 	for _, app := range s.Apps {
-		fmt.Printf("- %s -> https://%s%s (port %d, pid %d)\n", app.Name, app.Host, portSuffix(s.HTTPSPort), app.Port, app.PID)
+		fmt.Printf("- %s -> %s (%s, %s)\n", app.Name, app.HTTPSURL(s.HTTPSPort), targetLabel(app), ownerLabel(app))
 	}
 	return nil
 }
@@ -168,6 +215,9 @@ func runProxyTrust() error {
 		return err
 	}
 	if err := trustLocalCA(); err != nil {
+		if nonInteractive {
+			return trustUnavailableError(err.Error())
+		}
 		return err
 	}
 	if outputJSON {
@@ -179,14 +229,14 @@ func runProxyTrust() error {
 
 func runProxyLogs() error {
 	managed := false
-	if checkSystemCaddyReachable() {
-		if info, err := inspectExternalCaddy(); err == nil {
+	if devwrap.CheckSystemCaddyReachable() {
+		if info, err := devwrap.InspectExternalCaddy(); err == nil {
 			managed = info.Managed
 		}
 	}
 	if !managed {
 		if outputJSON {
-			return emitJSON(map[string]any{"ok": true, "managed": false, "log_file": "", "content": ""})
+			return emitJSON(map[string]any{"ok": true, "action": "proxy_logs", "managed": false, "log_file": "", "content": ""})
 		}
 		fmt.Println("no managed caddy logs (currently using unmanaged caddy)")
 		return nil
@@ -200,7 +250,7 @@ func runProxyLogs() error {
 	if err != nil {
 		if os.IsNotExist(err) {
 			if outputJSON {
-				return emitJSON(map[string]any{"ok": true, "log_file": path, "content": ""})
+				return emitJSON(map[string]any{"ok": true, "action": "proxy_logs", "managed": true, "log_file": path, "content": ""})
 			}
 			fmt.Printf("no daemon logs yet (%s)\n", path)
 			return nil
@@ -208,7 +258,7 @@ func runProxyLogs() error {
 		return err
 	}
 	if outputJSON {
-		return emitJSON(map[string]any{"ok": true, "log_file": path, "content": string(b)})
+		return emitJSON(map[string]any{"ok": true, "action": "proxy_logs", "managed": true, "log_file": path, "content": string(b)})
 	}
 	fmt.Printf("log file: %s\n", path)
 	if len(b) == 0 {
@@ -223,59 +273,37 @@ func runProxyDaemon() error {
 	return startDaemon()
 }
 
-func runDoctor() error {
-	runtimePath, err := runtimeDir()
+func runDoctor(format outputFormat) error {
+	runtimePath, err := devwrap.RuntimeDir()
+	if err != nil {
+		return err
+	}
+	stateDirPath, err := devwrap.StateDir()
 	if err != nil {
 		return err
 	}
-	stateP, _ := statePath()
-	lockP, _ := stateLockPath()
-	pidP, _ := pidPath()
+	stateP, _ := activeStatePath()
+	lockP, _ := devwrap.StateLockPath()
+	pidP, _ := devwrap.PidPath()
 	logP, _ := daemonLogPath()
 	managed := false
-	if checkSystemCaddyReachable() {
-		if info, err := inspectExternalCaddy(); err == nil {
+	if devwrap.CheckSystemCaddyReachable() {
+		if info, err := devwrap.InspectExternalCaddy(); err == nil {
 			managed = info.Managed
 		}
 	}
 
-	if outputJSON {
-		payload := map[string]any{
-			"ok":          true,
-			"runtime_dir": runtimePath,
-			"state_file":  stateP,
-			"state_lock":  lockP,
-			"storage_dir": sharedCaddyStorageRoot(),
-			"caddy_admin": checkSystemCaddyReachable(),
-			"trusted":     isCertTrusted(),
-		}
-		if managed {
-			payload["pid_file"] = pidP
-			payload["log_file"] = logP
-		}
-		if checkSystemCaddyReachable() {
-			if info, err := inspectExternalCaddy(); err == nil {
-				source := "unmanaged"
-				if info.Managed {
-					source = "managed"
-				}
-				payload["caddy_source"] = source
-				payload["http_port"] = info.HTTPPort
-				payload["https_port"] = info.HTTPSPort
-			} else {
-				payload["caddy_inspect_error"] = err.Error()
-			}
+	if format.structured() {
+		payload, err := doctorReport()
+		if err != nil {
+			return err
 		}
-		if s, err := localStatusFromFiles(); err == nil {
-			payload["tracked_apps"] = len(s.Apps)
-		} else {
-			payload["tracked_apps_error"] = err.Error()
-		}
-		return emitJSON(payload)
+		return format.emit(payload)
 	}
 
 	fmt.Println("devwrap doctor")
 	fmt.Printf("runtime dir: %s\n", runtimePath)
+	fmt.Printf("state dir:   %s\n", stateDirPath)
 	fmt.Printf("state file: %s\n", stateP)
 	fmt.Printf("state lock: %s\n", lockP)
 	if managed {
@@ -283,10 +311,21 @@ func runDoctor() error {
 		fmt.Printf("log file:   %s\n", logP)
 	}
 	fmt.Printf("storage dir: %s\n", sharedCaddyStorageRoot())
+	if problems := runtimeOwnershipProblems(); len(problems) > 0 {
+		fmt.Println("runtime file problems:")
+		for _, p := range problems {
+			fmt.Printf("- %s\n", p)
+		}
+		dirs := stateDirPath
+		if runtimePath != stateDirPath {
+			dirs += " " + runtimePath
+		}
+		fmt.Printf("fix: sudo chown -R \"$USER\" %s && chmod -R go-rwx %s\n", dirs, dirs)
+	}
 
-	fmt.Printf("caddy admin: %v\n", checkSystemCaddyReachable())
-	if checkSystemCaddyReachable() {
-		if info, err := inspectExternalCaddy(); err == nil {
+	fmt.Printf("caddy admin: %v\n", devwrap.CheckSystemCaddyReachable())
+	if devwrap.CheckSystemCaddyReachable() {
+		if info, err := devwrap.InspectExternalCaddy(); err == nil {
 			source := "unmanaged"
 			if info.Managed {
 				source = "managed"
@@ -298,8 +337,8 @@ func runDoctor() error {
 		}
 	}
 
-	fmt.Printf("trust (local CA): %v\n", isCertTrusted())
-	if s, err := localStatusFromFiles(); err == nil {
+	fmt.Printf("trust (local CA): %v\n", devwrap.IsCertTrusted())
+	if s, err := devwrap.LocalStatusFromFiles(); err == nil {
 		fmt.Printf("tracked apps: %d\n", len(s.Apps))
 	} else {
 		fmt.Printf("tracked apps: unknown (%v)\n", err)
@@ -308,39 +347,344 @@ func runDoctor() error {
 	return nil
 }
 
-func runList() error {
-	if !checkSystemCaddyReachable() {
-		if outputJSON {
-			return emitJSON(map[string]any{"ok": true, "apps": []any{}})
+// doctorReport collects the machine-readable doctor payload shared by
+// `doctor --json` and `doctor bundle`.
+func doctorReport() (map[string]any, error) {
+	runtimePath, err := devwrap.RuntimeDir()
+	if err != nil {
+		return nil, err
+	}
+	stateDirPath, err := devwrap.StateDir()
+	if err != nil {
+		return nil, err
+	}
+	stateP, _ := activeStatePath()
+	lockP, _ := devwrap.StateLockPath()
+	pidP, _ := devwrap.PidPath()
+	logP, _ := daemonLogPath()
+	managed := false
+	if devwrap.CheckSystemCaddyReachable() {
+		if info, err := devwrap.InspectExternalCaddy(); err == nil {
+			managed = info.Managed
+		}
+	}
+
+	payload := map[string]any{
+		"ok":          true,
+		"action":      "doctor",
+		"runtime_dir": runtimePath,
+		"state_dir":   stateDirPath,
+		"state_file":  stateP,
+		"state_lock":  lockP,
+		"storage_dir": sharedCaddyStorageRoot(),
+		"caddy_admin": devwrap.CheckSystemCaddyReachable(),
+		"trusted":     devwrap.IsCertTrusted(),
+	}
+	if managed {
+		payload["pid_file"] = pidP
+		payload["log_file"] = logP
+	}
+	if devwrap.CheckSystemCaddyReachable() {
+		if info, err := devwrap.InspectExternalCaddy(); err == nil {
+			source := "unmanaged"
+			if info.Managed {
+				source = "managed"
+			}
+			payload["caddy_source"] = source
+			payload["http_port"] = info.HTTPPort
+			payload["https_port"] = info.HTTPSPort
+		} else {
+			payload["caddy_inspect_error"] = err.Error()
+		}
+	}
+	if problems := runtimeOwnershipProblems(); len(problems) > 0 {
+		payload["runtime_file_problems"] = problems
+	}
+	if s, err := devwrap.LocalStatusFromFiles(); err == nil {
+		payload["tracked_apps"] = len(s.Apps)
+	} else {
+		payload["tracked_apps_error"] = err.Error()
+	}
+	return payload, nil
+}
+
+func runList(all bool, profile, project string, format outputFormat) error {
+	if project != "" {
+		if err := validateProject(project); err != nil {
+			return err
+		}
+	}
+	if !devwrap.CheckSystemCaddyReachable() {
+		var history []devwrap.ExitedApp
+		if all {
+			if state, err := devwrap.LoadLocalState(); err == nil {
+				history = state.History
+			}
+		}
+		if format.structured() {
+			out := map[string]any{"ok": true, "action": "list", "apps": []any{}, "paused": false}
+			if all {
+				out["history"] = nonNilHistory(history)
+			}
+			return format.emit(out)
 		}
 		fmt.Println("no apps registered (proxy not running)")
+		printHistory(history)
 		return nil
 	}
-	s, err := localStatusFromFiles()
+	s, err := devwrap.LocalStatusFromFiles()
 	if err != nil {
 		return err
 	}
-	if outputJSON {
-		return emitJSON(map[string]any{"ok": true, "apps": sortedApps(s.Apps), "https_port": s.HTTPSPort})
+	if profile != "" {
+		s.Apps = filterAppsByProfile(s.Apps, profile)
+		s.History = filterHistoryByProfile(s.History, profile)
+	}
+	if project != "" {
+		s.Apps = filterAppsByProject(s.Apps, project)
+		s.History = filterHistoryByProject(s.History, project)
+	}
+	if format.structured() {
+		apps := []listedApp{}
+		for _, app := range sortedApps(s.Apps) {
+			apps = append(apps, listedApp{App: app, HTTPSURL: app.HTTPSURL(s.HTTPSPort)})
+		}
+		out := map[string]any{"ok": true, "action": "list", "apps": apps, "https_port": s.HTTPSPort, "paused": s.Paused}
+		if all {
+			out["history"] = nonNilHistory(s.History)
+		}
+		return format.emit(out)
 	}
 	if len(s.Apps) == 0 {
 		fmt.Println("no apps registered")
-		return nil
+	} else {
+		if s.Paused {
+			fmt.Println("(all routes paused; run `devwrap resume`)")
+		}
+		for _, app := range s.Apps {
+			fmt.Printf("%s -> %s (%s, %s%s)%s%s\n", app.Name, app.HTTPSURL(s.HTTPSPort), targetLabel(app), ownerLabel(app), expirySuffix(app), profileSuffix(app.Profiles), pausedSuffix(app))
+		}
 	}
-	for _, app := range s.Apps {
-		fmt.Printf("%s -> %s (port %d, pid %d)\n", app.Name, app.HTTPSURL(s.HTTPSPort), app.Port, app.PID)
+	if all {
+		printHistory(s.History)
 	}
 	return nil
 }
 
-func runRemove(name string) error {
-	if err := validateName(name); err != nil {
+// listedApp is an app as `ls -o` reports it, with its URL resolved.
+type listedApp struct {
+	devwrap.App
+	HTTPSURL string `json:"https_url"`
+}
+
+func filterAppsByProfile(apps []devwrap.App, profile string) []devwrap.App {
+	out := []devwrap.App{}
+	for _, app := range apps {
+		if hasProfile(app.Profiles, profile) {
+			out = append(out, app)
+		}
+	}
+	return out
+}
+
+func filterHistoryByProfile(history []devwrap.ExitedApp, profile string) []devwrap.ExitedApp {
+	var out []devwrap.ExitedApp
+	for _, h := range history {
+		if hasProfile(h.Profiles, profile) {
+			out = append(out, h)
+		}
+	}
+	return out
+}
+
+func targetLabel(app devwrap.App) string {
+	if app.Upstream != "" {
+		return "upstream " + app.Upstream
+	}
+	return fmt.Sprintf("port %d", app.Port)
+}
+
+func ownerLabel(app devwrap.App) string {
+	if app.Unowned {
+		return "added"
+	}
+	return fmt.Sprintf("pid %d", app.PID)
+}
+
+func pausedSuffix(app devwrap.App) string {
+	if app.Paused {
+		return " (paused)"
+	}
+	return ""
+}
+
+func profileSuffix(profiles []string) string {
+	if len(profiles) == 0 {
+		return ""
+	}
+	return " [" + strings.Join(profiles, ",") + "]"
+}
+
+func nonNilHistory(h []devwrap.ExitedApp) []devwrap.ExitedApp {
+	if h == nil {
+		return []devwrap.ExitedApp{}
+	}
+	return h
+}
+
+func printHistory(history []devwrap.ExitedApp) {
+	if len(history) == 0 {
+		return
+	}
+	fmt.Println("recently exited:")
+	for _, h := range history {
+		fmt.Printf("- %s (%s, %s)\n", h.Name, exitDescription(h), agoString(h.ExitedAt))
+	}
+}
+
+func exitDescription(h devwrap.ExitedApp) string {
+	switch {
+	case h.ExitCode != nil:
+		return "exit " + strconv.Itoa(*h.ExitCode)
+	case h.Reason == "expired":
+		return "ttl expired"
+	case h.Reason == "removed":
+		return "removed"
+	case h.Reason == "reboot":
+		return "machine rebooted"
+	}
+	return "process gone, exit status unknown"
+}
+
+func agoString(ts string) string {
+	t, err := time.Parse(time.RFC3339, ts)
+	if err != nil {
+		return ts
+	}
+	d := time.Since(t)
+	if d < time.Minute {
+		return "just now"
+	}
+	return d.Round(time.Minute).String() + " ago"
+}
+
+// runAdd registers a route to a server devwrap does not run, e.g. one
+// started by an IDE. The lease stays until `devwrap rm` or `devwrap down`.
+func runAdd(name, project string, port int, upstream, host, mountPath string, profiles []string, route devwrap.RouteOptions) error {
+	name, err := projectAppName(name, project)
+	if err != nil {
+		return err
+	}
+	switch {
+	case port != 0 && upstream != "":
+		return errors.New("--port and --upstream cannot be combined")
+	case upstream != "":
+		if err := validateUpstream(upstream); err != nil {
+			return err
+		}
+	case port <= 0 || port > 65535:
+		return errors.New("--port must be between 1 and 65535 (or pass --upstream host:port)")
+	}
+	if err := validateProfiles(profiles); err != nil {
+		return err
+	}
+	if route.UpstreamInsecure && !route.UpstreamTLS {
+		return errors.New("--insecure requires --upstream-tls")
+	}
+	if err := validateDialHost(route.DialHost); err != nil {
+		return err
+	}
+	if route.DialHost != "" && upstream != "" {
+		return errors.New("--dial-host and --upstream cannot be combined")
+	}
+	if err := route.Timeouts.Validate(); err != nil {
+		return err
+	}
+	mount, err := devwrap.NormalizeMountPath(mountPath)
+	if err != nil {
 		return err
 	}
-	if !checkSystemCaddyReachable() {
+	if mount != "" && host == "" {
+		host = devwrap.DefaultPathSite + ".localhost"
+	}
+	resolvedHost, err := devwrap.HostForApp(name, host)
+	if err != nil {
+		return err
+	}
+	if err := validateACMEDNS(route.ACMEDNS, host); err != nil {
+		return err
+	}
+	if err := ensureCaddyOrDaemon(false); err != nil {
+		return err
+	}
+	if err := checkACMEDNSModule(route.ACMEDNS); err != nil {
+		return err
+	}
+	lease, err := acquireLease(devwrap.LeaseRequest{Name: name, Host: resolvedHost, Path: mount, Project: project, Port: port, Upstream: upstream, Unowned: true, Profiles: profiles, Route: route})
+	if err != nil {
+		return err
+	}
+	if outputJSON {
+		return emitJSON(map[string]any{
+			"ok":        true,
+			"action":    "add",
+			"name":      lease.Name,
+			"port":      lease.Port,
+			"upstream":  upstream,
+			"https_url": lease.HTTPSURL,
+			"http_url":  lease.HTTPURL,
+			"trusted":   lease.Trusted,
+		})
+	}
+	target := fmt.Sprintf("port %d", lease.Port)
+	if upstream != "" {
+		target = "upstream " + upstream
+	}
+	fmt.Printf("%s -> %s (%s)\n", lease.Name, lease.HTTPSURL, target)
+	fmt.Printf("remove with: devwrap rm %s\n", lease.Name)
+	return nil
+}
+
+// validateUpstream checks a host:port dial address for --upstream.
+func validateUpstream(addr string) error {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil || host == "" {
+		return fmt.Errorf("invalid --upstream %q (want host:port)", addr)
+	}
+	if n, err := strconv.Atoi(port); err != nil || n <= 0 || n > 65535 {
+		return fmt.Errorf("invalid --upstream port %q", port)
+	}
+	return nil
+}
+
+// validateDialHost accepts the loopback hosts --dial-host can name.
+func validateDialHost(host string) error {
+	switch host {
+	case "", "127.0.0.1", "::1", "localhost":
+		return nil
+	}
+	return fmt.Errorf("invalid --dial-host %q (want 127.0.0.1, ::1, or localhost)", host)
+}
+
+// runRemove removes one app's route, or with only project set, the routes
+// of every app in the project.
+func runRemove(name, project string) error {
+	if name == "" {
+		return runRemoveProject(project)
+	}
+	var err error
+	if project != "" {
+		name, err = projectAppName(name, project)
+	} else {
+		err = validateAppRef(name)
+	}
+	if err != nil {
+		return err
+	}
+	if !devwrap.CheckSystemCaddyReachable() {
 		return errors.New("proxy is not running")
 	}
-	if err := removeDirect(name); err != nil {
+	if err := devwrap.RemoveDirect(name); err != nil {
 		return err
 	}
 	if outputJSON {
@@ -350,54 +694,500 @@ func runRemove(name string) error {
 	return nil
 }
 
-func runChild(name string, cmdArgs []string, port int, hostURL string, release func()) error {
-	templated := applyTemplates(cmdArgs, port)
-	cmd := exec.Command(templated[0], templated[1:]...)
-	cmd.Stdin = os.Stdin
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
+func runRemoveProject(project string) error {
+	if err := validateProject(project); err != nil {
+		return err
+	}
+	if !devwrap.CheckSystemCaddyReachable() {
+		return errors.New("proxy is not running")
+	}
+	removed, err := devwrap.RemoveProjectDirect(project)
+	if err != nil {
+		return err
+	}
+	if outputJSON {
+		return emitJSON(map[string]any{"ok": true, "action": "remove", "project": project, "removed": removed})
+	}
+	if len(removed) == 0 {
+		fmt.Printf("no apps registered in project %q\n", project)
+		return nil
+	}
+	for _, name := range removed {
+		fmt.Printf("removed route for %q\n", name)
+	}
+	return nil
+}
+
+func runPause(name string, paused bool) error {
+	if name != "" {
+		if err := validateAppRef(name); err != nil {
+			return err
+		}
+	}
+	if !devwrap.CheckSystemCaddyReachable() {
+		return errors.New("proxy is not running")
+	}
+	if err := devwrap.SetPausedDirect(name, paused); err != nil {
+		return err
+	}
+	action := "resume"
+	if paused {
+		action = "pause"
+	}
+	if outputJSON {
+		out := map[string]any{"ok": true, "action": action, "paused": paused}
+		if name != "" {
+			out["name"] = name
+		}
+		return emitJSON(out)
+	}
+	switch {
+	case name != "" && paused:
+		fmt.Printf("%s paused (503); the app keeps running; run `devwrap resume %s` to restore\n", name, name)
+	case name != "":
+		fmt.Printf("%s resumed\n", name)
+	case paused:
+		fmt.Println("all devwrap routes paused (503); run `devwrap resume` to restore")
+	default:
+		fmt.Println("devwrap routes resumed")
+	}
+	return nil
+}
+
+func runOverride(name string, args []string, remove bool) error {
+	if err := validateAppRef(name); err != nil {
+		return err
+	}
+	if !devwrap.CheckSystemCaddyReachable() {
+		return errors.New("proxy is not running")
+	}
+
+	var app devwrap.App
+	var err error
+	action := "override_list"
+	switch {
+	case len(args) == 0:
+		if remove {
+			return errors.New("--remove needs a path")
+		}
+		app, err = findApp(name)
+	case remove:
+		if len(args) != 1 {
+			return errors.New("--remove takes only a path")
+		}
+		action = "override_remove"
+		app, err = devwrap.UpdateAppDirect(name, func(a *devwrap.App) error {
+			kept := a.Overrides[:0]
+			found := false
+			for _, o := range a.Overrides {
+				if o.Path == args[0] {
+					found = true
+					continue
+				}
+				kept = append(kept, o)
+			}
+			if !found {
+				return fmt.Errorf("no override for %s on %q", args[0], name)
+			}
+			a.Overrides = kept
+			return nil
+		})
+	default:
+		if len(args) != 2 {
+			return errors.New("usage: devwrap override <name> <path> <file>")
+		}
+		override, verr := newFileOverride(args[0], args[1])
+		if verr != nil {
+			return verr
+		}
+		action = "override_set"
+		app, err = devwrap.UpdateAppDirect(name, func(a *devwrap.App) error {
+			for i, o := range a.Overrides {
+				if o.Path == override.Path {
+					a.Overrides[i] = override
+					return nil
+				}
+			}
+			a.Overrides = append(a.Overrides, override)
+			return nil
+		})
+	}
+	if err != nil {
+		return err
+	}
+
+	overrides := app.Overrides
+	if overrides == nil {
+		overrides = []devwrap.FileOverride{}
+	}
+	if outputJSON {
+		return emitJSON(map[string]any{"ok": true, "action": action, "name": name, "overrides": overrides})
+	}
+	if len(overrides) == 0 {
+		fmt.Printf("no overrides for %q\n", name)
+		return nil
+	}
+	for _, o := range overrides {
+		fmt.Printf("%s -> %s\n", o.Path, o.File)
+	}
+	return nil
+}
+
+func findApp(name string) (devwrap.App, error) {
+	s, err := devwrap.LocalStatusFromFiles()
+	if err != nil {
+		return devwrap.App{}, err
+	}
+	for _, app := range s.Apps {
+		if app.Name == name {
+			return app, nil
+		}
+	}
+	return devwrap.App{}, fmt.Errorf("app %q is not registered", name)
+}
+
+func newFileOverride(path, file string) (devwrap.FileOverride, error) {
+	if !strings.HasPrefix(path, "/") {
+		return devwrap.FileOverride{}, fmt.Errorf("override path %q must start with /", path)
+	}
+	abs, err := filepath.Abs(file)
+	if err != nil {
+		return devwrap.FileOverride{}, err
+	}
+	info, err := os.Stat(abs)
+	if err != nil {
+		return devwrap.FileOverride{}, err
+	}
+	if !info.Mode().IsRegular() {
+		return devwrap.FileOverride{}, fmt.Errorf("override file %q is not a regular file", file)
+	}
+	return devwrap.FileOverride{Path: path, File: abs}, nil
+}
+
+func runChild(opts runOptions, cmdArgs []string, vars templateVars, release func(exitCode *int)) error {
+	port := vars.Port
+	hostURL := normalizeDevwrapHostURL(vars.HTTPSURL)
+	templated := applyTemplates(cmdArgs, vars)
 
 	env := os.Environ()
+	if opts.Cwd != "" {
+		env = append(env, "PWD="+opts.Cwd)
+	}
 	env = append(env, "PORT="+strconv.Itoa(port))
-	env = append(env, "DEVWRAP_APP="+name)
+	env = append(env, "DEVWRAP_APP="+opts.Name)
 	if hostURL != "" {
 		env = append(env, "DEVWRAP_HOST="+hostURL)
 	}
-	cmd.Env = env
+	env = append(env, applyTemplates(expandRunEnv(env, opts.FileEnv, opts.Env), vars)...)
 
-	if err := cmd.Start(); err != nil {
-		return err
+	// The child may be restarted (--watch, --restart), so signals and the TTL go to
+	// whichever process is current, always to its whole process group. Once
+	// the user or the TTL asks it to stop, no further restarts happen and
+	// anything still alive after --stop-timeout is killed.
+	var mu sync.Mutex
+	var current *exec.Cmd
+	stopping := false
+	stopCh := make(chan struct{})
+	markStopping := func() {
+		if stopping {
+			return
+		}
+		stopping = true
+		close(stopCh)
+		time.AfterFunc(opts.StopTimeout, func() {
+			mu.Lock()
+			defer mu.Unlock()
+			if current != nil {
+				signalGroup(current.Process.Pid, syscall.SIGKILL)
+			}
+		})
+	}
+	signalCurrent := func(sig syscall.Signal, stop bool) {
+		mu.Lock()
+		defer mu.Unlock()
+		if stop {
+			markStopping()
+		}
+		if current != nil {
+			signalGroup(current.Process.Pid, sig)
+		}
 	}
 
 	sigCh := make(chan os.Signal, 8)
-	signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP, syscall.SIGQUIT)
+	signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP, syscall.SIGQUIT, syscall.SIGUSR1)
 	defer signal.Stop(sigCh)
 
+	// SIGUSR1 asks for a restart (`devwrap tui` sends it) rather than a stop.
+	restartReq := make(chan struct{}, 1)
 	go func() {
 		for sig := range sigCh {
-			if cmd.Process != nil {
-				_ = cmd.Process.Signal(sig)
+			if sig == syscall.SIGUSR1 {
+				select {
+				case restartReq <- struct{}{}:
+				default:
+				}
+				continue
 			}
+			signalCurrent(sig.(syscall.Signal), true)
 		}
 	}()
 
-	err := cmd.Wait()
+	if opts.TTL > 0 {
+		timer := time.AfterFunc(opts.TTL, func() {
+			if !outputJSON {
+				fmt.Fprintf(os.Stderr, "devwrap: ttl of %s elapsed; stopping %s\n", opts.TTL, opts.Name)
+			}
+			signalCurrent(syscall.SIGTERM, true)
+		})
+		defer timer.Stop()
+	}
+
+	var changes <-chan []string
+	if opts.Watch.enabled() {
+		root := opts.Cwd
+		if root == "" {
+			root, _ = os.Getwd()
+		}
+		stopWatching := make(chan struct{})
+		defer close(stopWatching)
+		changes = watchFiles(root, opts.Watch, stopWatching)
+	}
+
+	stdout, stderr, closeLog := childOutput(opts)
+	defer closeLog()
+
+	if err := runHook("pre-start", opts.Hooks.PreStart, opts.Cwd, env); err != nil {
+		if release != nil {
+			release(nil)
+		}
+		return err
+	}
+	// Routes hold requests while the app boots; release them once the port
+	// is up, then run the post-ready hook.
+	pid := os.Getpid()
+	// With --follow-port, a child that ignores PORT moves the route to
+	// where it listens.
+	var routedPort atomic.Int64
+	routedPort.Store(int64(port))
+	currentPort := func() int { return int(routedPort.Load()) }
+	childPID := func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		if current == nil || current.Process == nil {
+			return 0
+		}
+		return current.Process.Pid
+	}
+	var movePort func(int)
+	if opts.FollowPort {
+		movePort = func(real int) {
+			if err := moveAppPort(opts.Name, pid, real); err != nil {
+				warnListenPorts(opts.Name, port, []int{real})
+				return
+			}
+			routedPort.Store(int64(real))
+			emitRunEvent(opts.Name, "port_moved", map[string]any{"leased_port": port, "port": real})
+			if !outputJSON {
+				fmt.Fprintf(os.Stderr, "warning: %s ignored PORT=%d and listens on %d; routing to %d instead (use @PORT or --port %d to avoid this)\n", opts.Name, port, real, real, real)
+			}
+		}
+	}
+	watchListenPort(opts.Name, currentPort, childPID, stopCh, movePort)
+	whenReady(currentPort, opts.ReadyHTTP, stopCh, func(host string) {
+		// Only ::1 answered: the app bound "localhost" to IPv6, where the
+		// default 127.0.0.1 dial can't reach it. --dial-host opts out.
+		var dialHost string
+		if host != devwrap.LoopbackDialHost && opts.Route.DialHost == "" && devwrap.AppDialHost == devwrap.LoopbackDialHost {
+			dialHost = host
+			if !outputJSON {
+				fmt.Fprintf(os.Stderr, "devwrap: %s listens on %s only; routing to %s\n", opts.Name, host, net.JoinHostPort(host, strconv.Itoa(currentPort())))
+			}
+		}
+		markAppReady(opts.Name, pid, dialHost)
+		emitRunEvent(opts.Name, "child_ready", map[string]any{"port": currentPort()})
+		if err := runHook("post-ready", opts.Hooks.PostReady, opts.Cwd, env); err != nil {
+			fmt.Fprintf(os.Stderr, "devwrap: %v\n", err)
+		}
+	})
+
+	bo := newRestartBackOff()
+	restarts := 0
+	var err error
+	for {
+		cmd := exec.Command(templated[0], templated[1:]...)
+		cmd.Stdin = os.Stdin
+		cmd.Stdout = stdout
+		cmd.Stderr = stderr
+		// Background grandchildren may hold the output pipe open after the
+		// child exits; don't let them stall Wait.
+		cmd.WaitDelay = time.Second
+		cmd.Dir = opts.Cwd
+		cmd.Env = env
+		var foreground bool
+		cmd.SysProcAttr, foreground = childSysProcAttr()
+		mu.Lock()
+		if stopping {
+			mu.Unlock()
+			break
+		}
+		if err := cmd.Start(); err != nil {
+			mu.Unlock()
+			if foreground {
+				reclaimTerminal()
+			}
+			if release != nil {
+				release(nil)
+			}
+			return err
+		}
+		current = cmd
+		mu.Unlock()
+		emitRunEvent(opts.Name, "child_started", map[string]any{"pid": cmd.Process.Pid, "restarts": restarts})
+
+		startedAt := time.Now()
+		done := make(chan error, 1)
+		go func() { done <- cmd.Wait() }()
+		restartChild := func(why string) (bool, error) {
+			mu.Lock()
+			restart := !stopping
+			mu.Unlock()
+			if restart && !outputJSON {
+				fmt.Fprintf(os.Stderr, "devwrap: %s; restarting %s\n", why, opts.Name)
+			}
+			err := stopChild(cmd, done, opts.StopTimeout)
+			reapGroup(cmd.Process.Pid, opts.StopTimeout)
+			if foreground {
+				reclaimTerminal()
+			}
+			emitChildExited(opts.Name, err, restart, why)
+			return restart, err
+		}
+
+		restart := false
+		select {
+		case err = <-done:
+			mu.Lock()
+			current = nil
+			if foreground && interruptedFromTerminal(err) {
+				markStopping()
+			}
+			stopped := stopping
+			mu.Unlock()
+			// Grandchildren left behind would hold the port or the terminal.
+			reapGroup(cmd.Process.Pid, opts.StopTimeout)
+			if foreground {
+				reclaimTerminal()
+			}
+			if stopped || !opts.Restart.shouldRestart(err, restarts) {
+				emitChildExited(opts.Name, err, false, "")
+				if !stopped && err != nil {
+					notifyAppExited(opts.Name, err)
+				}
+				break
+			}
+			emitChildExited(opts.Name, err, true, "")
+			if time.Since(startedAt) >= restartStableAfter {
+				bo.Reset()
+			}
+			restarts++
+			delay := bo.NextBackOff()
+			if !outputJSON {
+				code, _ := childExitCode(err)
+				fmt.Fprintf(os.Stderr, "devwrap: %s exited with status %d; restarting in %s (attempt %s)\n", opts.Name, code, delay.Round(100*time.Millisecond), opts.Restart.attempts(restarts))
+			}
+			select {
+			case <-time.After(delay):
+				restart = true
+			case <-changes:
+				restart = true
+			case <-restartReq:
+				restart = true
+			case <-stopCh:
+			}
+		case files := <-changes:
+			restart, err = restartChild(describeChanges(files) + " changed")
+		case <-restartReq:
+			restart, err = restartChild("restart requested")
+		}
+		mu.Lock()
+		current = nil
+		restart = restart && !stopping
+		mu.Unlock()
+		if !restart {
+			break
+		}
+	}
+
+	code, known := childExitCode(err)
+	var exitCode *int
+	if known {
+		exitCode = &code
+	}
 	if release != nil {
-		release()
+		release(exitCode)
 	}
-	if err == nil {
+	if opts.Hooks.PostStop != "" {
+		runPostStopHook(opts.Hooks.PostStop, opts.Cwd, env, exitCode)
+	}
+	switch {
+	case err == nil:
 		return nil
+	case known:
+		return childExitError{code: code}
+	}
+	return err
+}
+
+// emitRunEvent writes one line of the NDJSON stream that
+// `devwrap --name ... --json` prints as the app moves through its lifecycle.
+func emitRunEvent(name, event string, fields map[string]any) {
+	if !outputJSON {
+		return
+	}
+	out := map[string]any{"ok": true, "action": "run", "event": event, "name": name}
+	maps.Copy(out, fields)
+	_ = emitJSON(out)
+}
+
+// emitChildExited reports a child's exit; ok is false when it failed on its
+// own rather than being stopped for a restart devwrap asked for (why).
+func emitChildExited(name string, err error, restarting bool, why string) {
+	fields := map[string]any{"restarting": restarting}
+	code, known := childExitCode(err)
+	if known {
+		fields["exit_code"] = code
+	}
+	if why != "" {
+		fields["reason"] = why
+	} else {
+		fields["ok"] = known && code == 0
+	}
+	emitRunEvent(name, "child_exited", fields)
+}
+
+func describeChanges(files []string) string {
+	if len(files) == 1 {
+		return files[0]
+	}
+	return fmt.Sprintf("%s and %d more", files[0], len(files)-1)
+}
+
+// childExitCode maps a cmd.Wait result to a shell-style exit status
+// (128+signal for signaled children).
+func childExitCode(err error) (int, bool) {
+	if err == nil {
+		return 0, true
 	}
 	var exitErr *exec.ExitError
 	if errors.As(err, &exitErr) {
 		if status, ok := exitErr.Sys().(syscall.WaitStatus); ok {
 			if status.Signaled() {
-				return childExitError{code: 128 + int(status.Signal())}
+				return 128 + int(status.Signal()), true
 			}
-			return childExitError{code: status.ExitStatus()}
+			return status.ExitStatus(), true
 		}
 	}
-	return err
+	return 0, false
 }
 
 func normalizeDevwrapHostURL(raw string) string {
@@ -425,15 +1215,29 @@ func (e childExitError) ExitCode() int {
 	return e.code
 }
 
-func modeFromStatus(s ProxyStatus) string {
+// exitStatusError ends the process with a specific exit code after the
+// command has already reported its result.
+type exitStatusError struct {
+	code int
+}
+
+func (e exitStatusError) Error() string {
+	return fmt.Sprintf("exit status %d", e.code)
+}
+
+func (e exitStatusError) ExitCode() int {
+	return e.code
+}
+
+func modeFromStatus(s devwrap.ProxyStatus) string {
 	if s.Root {
 		return "sudo"
 	}
 	return "unprivileged"
 }
 
-func sortedApps(apps []App) []App {
-	out := make([]App, len(apps))
+func sortedApps(apps []devwrap.App) []devwrap.App {
+	out := make([]devwrap.App, len(apps))
 	copy(out, apps)
 	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
 	return out
@@ -451,18 +1255,90 @@ func stopManagedCaddy() error {
 	return nil
 }
 
-func applyTemplates(args []string, port int) []string {
+// templateVars are the values of the @ tokens in a command and its env.
+type templateVars struct {
+	Port      int
+	HTTPSPort int
+	Name      string
+	Host      string
+	HTTPSURL  string
+	HTTPURL   string
+}
+
+// leaseTemplates returns the token values for an acquired lease.
+func leaseTemplates(lease devwrap.Lease) templateVars {
+	vars := templateVars{Port: lease.Port, HTTPSPort: 443, Name: lease.Name, Host: lease.Host, HTTPSURL: lease.HTTPSURL, HTTPURL: lease.HTTPURL}
+	if u, err := url.Parse(lease.HTTPSURL); err == nil && u.Port() != "" {
+		vars.HTTPSPort, _ = strconv.Atoi(u.Port())
+	}
+	return vars
+}
+
+// applyTemplates replaces @PORT, @HTTPS_PORT, @NAME, @HOST, @HTTPS_URL,
+// and @HTTP_URL. A token must not run into further letters, digits, or
+// underscores (@PORTAL is left alone), and a doubled @ escapes it: @@PORT
+// is a literal @PORT. Only argv and the env entries devwrap adds go through
+// it; the inherited environment is never rewritten.
+func applyTemplates(args []string, vars templateVars) []string {
+	values := []struct{ token, value string }{
+		{"HTTPS_PORT", strconv.Itoa(vars.HTTPSPort)},
+		{"HTTPS_URL", vars.HTTPSURL},
+		{"HTTP_URL", vars.HTTPURL},
+		{"HOST", vars.Host},
+		{"NAME", vars.Name},
+		{"PORT", strconv.Itoa(vars.Port)},
+	}
+	match := func(s string) (string, string, bool) {
+		for _, v := range values {
+			rest, ok := strings.CutPrefix(s, v.token)
+			if ok && (rest == "" || !isTemplateNameByte(rest[0])) {
+				return v.token, v.value, true
+			}
+		}
+		return "", "", false
+	}
 	out := make([]string, 0, len(args))
-	portValue := strconv.Itoa(port)
 	for _, arg := range args {
-		out = append(out, strings.ReplaceAll(arg, "@PORT", portValue))
+		if !strings.Contains(arg, "@") {
+			out = append(out, arg)
+			continue
+		}
+		var b strings.Builder
+		for i := 0; i < len(arg); i++ {
+			if arg[i] != '@' {
+				b.WriteByte(arg[i])
+				continue
+			}
+			if strings.HasPrefix(arg[i+1:], "@") {
+				if token, _, ok := match(arg[i+2:]); ok {
+					b.WriteString("@" + token)
+					i += 1 + len(token)
+					continue
+				}
+			}
+			if token, value, ok := match(arg[i+1:]); ok {
+				b.WriteString(value)
+				i += len(token)
+				continue
+			}
+			b.WriteByte('@')
+		}
+		out = append(out, b.String())
 	}
 	return out
 }
 
-func portSuffix(port int) string {
-	if port == 443 {
+func isTemplateNameByte(c byte) bool {
+	return c == '_' || (c >= 'A' && c <= 'Z') || (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9')
+}
+
+func expirySuffix(app devwrap.App) string {
+	if app.ExpiresAt == "" {
+		return ""
+	}
+	t, err := time.Parse(time.RFC3339, app.ExpiresAt)
+	if err != nil {
 		return ""
 	}
-	return ":" + strconv.Itoa(port)
+	return ", expires in " + time.Until(t).Round(time.Minute).String()
 }
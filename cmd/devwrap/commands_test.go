@@ -0,0 +1,87 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestApplyTemplates(t *testing.T) {
+	vars := templateVars{
+		Port:      4100,
+		HTTPSPort: 8443,
+		Name:      "api",
+		Host:      "api.localhost",
+		HTTPSURL:  "https://api.localhost:8443",
+		HTTPURL:   "http://api.localhost",
+	}
+	tests := []struct {
+		name string
+		arg  string
+		want string
+	}{
+		{"no template", "serve", "serve"},
+		{"port", "--port=@PORT", "--port=4100"},
+		{"https port", "@HTTPS_PORT", "8443"},
+		{"name and host", "@NAME:@HOST", "api:api.localhost"},
+		{"urls", "@HTTPS_URL,@HTTP_URL", "https://api.localhost:8443,http://api.localhost"},
+		{"token followed by punctuation", "@PORT/health", "4100/health"},
+		{"longer name left alone", "@PORTAL", "@PORTAL"},
+		{"underscore suffix left alone", "@PORT_X", "@PORT_X"},
+		{"lowercase left alone", "@port", "@port"},
+		{"escaped", "@@PORT", "@PORT"},
+		{"escaped then expanded", "@@PORT=@PORT", "@PORT=4100"},
+		{"escape needs a token", "a@@b", "a@@b"},
+		{"tripled", "@@@PORT", "@@PORT"},
+		{"lone at", "user@", "user@"},
+		{"email", "me@example.com", "me@example.com"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := applyTemplates([]string{tt.arg}, vars)
+			if len(got) != 1 || got[0] != tt.want {
+				t.Errorf("applyTemplates(%q) = %q, want %q", tt.arg, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestApplyTemplatesEnvScope(t *testing.T) {
+	vars := templateVars{Port: 4100, Host: "api.localhost"}
+	base := []string{"INHERITED=@PORT", "PORT=4100"}
+	tests := []struct {
+		name    string
+		fileEnv map[string]string
+		entries []string
+		want    []string
+	}{
+		{
+			name:    "env file value",
+			fileEnv: map[string]string{"API": "http://@HOST"},
+			want:    []string{"API=http://api.localhost"},
+		},
+		{
+			name:    "flag entry",
+			entries: []string{"LISTEN=:@PORT"},
+			want:    []string{"LISTEN=:4100"},
+		},
+		{
+			name:    "escaped flag entry",
+			entries: []string{"LITERAL=@@PORT"},
+			want:    []string{"LITERAL=@PORT"},
+		},
+		{
+			name: "inherited environment untouched",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := applyTemplates(expandRunEnv(base, tt.fileEnv, tt.entries), vars)
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+			if base[0] != "INHERITED=@PORT" {
+				t.Errorf("inherited entry rewritten to %q", base[0])
+			}
+		})
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"devwrap/internal/devwrap"
+)
+
+// composeUp runs `docker compose up -d <service>`, streaming its output to w.
+func composeUp(c devwrap.ComposeService, w io.Writer) error {
+	cmd := c.Command("up", "-d", c.Service)
+	cmd.Stdout = w
+	cmd.Stderr = w
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("docker compose up -d %s: %w", c.Service, err)
+	}
+	return nil
+}
+
+// composeDown stops and removes the service's containers.
+func composeDown(c devwrap.ComposeService) error {
+	cmd := c.Command("rm", "--stop", "--force", c.Service)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("docker compose rm %s: %v: %s", c.Service, err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+type composePsEntry struct {
+	Service string `json:"Service"`
+	State   string `json:"State"`
+	Health  string `json:"Health"`
+}
+
+// composeStatus reads `docker compose ps --format json`, which newer Compose
+// versions print as one object per line and older ones as a single array.
+func composeStatus(c devwrap.ComposeService) ([]composePsEntry, error) {
+	out, err := c.Command("ps", "--all", "--format", "json", c.Service).Output()
+	if err != nil {
+		return nil, fmt.Errorf("docker compose ps %s: %w", c.Service, err)
+	}
+	out = bytes.TrimSpace(out)
+	if len(out) == 0 {
+		return nil, nil
+	}
+	if out[0] == '[' {
+		var entries []composePsEntry
+		if err := json.Unmarshal(out, &entries); err != nil {
+			return nil, err
+		}
+		return entries, nil
+	}
+	var entries []composePsEntry
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		var e composePsEntry
+		if err := json.Unmarshal(line, &e); err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+	return entries, scanner.Err()
+}
+
+// waitComposeHealthy waits until every container of the service is running
+// and, when it defines a healthcheck, reports healthy.
+func waitComposeHealthy(c devwrap.ComposeService, timeout time.Duration, stop <-chan struct{}) error {
+	if timeout == 0 {
+		timeout = defaultReadyTimeout
+	}
+	deadline := time.Now().Add(timeout)
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	last := "no containers"
+	for {
+		entries, err := composeStatus(c)
+		if err != nil {
+			return err
+		}
+		ready := len(entries) > 0
+		for _, e := range entries {
+			switch {
+			case e.Health == "unhealthy":
+				return fmt.Errorf("%s is unhealthy", c.Service)
+			case e.State == "exited" || e.State == "dead":
+				return fmt.Errorf("%s container %s", c.Service, e.State)
+			case e.State != "running" || (e.Health != "" && e.Health != "healthy"):
+				ready = false
+				last = strings.TrimSpace(e.State + " " + e.Health)
+			}
+		}
+		if ready {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("%s not healthy after %s (%s)", c.Service, timeout, last)
+		}
+		select {
+		case <-stop:
+			return errors.New("stopped while waiting for compose service")
+		case <-ticker.C:
+		}
+	}
+}
+
+// recordComposeService remembers a started compose service in state.json.
+func recordComposeService(c devwrap.ComposeService) error {
+	return devwrap.WithStateLock(func(tx *devwrap.StateTx) error {
+		state, err := tx.Load()
+		if err != nil {
+			return err
+		}
+		kept := state.Compose[:0]
+		for _, existing := range state.Compose {
+			if existing.Dir != c.Dir || existing.File != c.File || existing.Service != c.Service {
+				kept = append(kept, existing)
+			}
+		}
+		state.Compose = append(kept, c)
+		return tx.Save(state)
+	})
+}
@@ -0,0 +1,522 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"devwrap/internal/devwrap"
+)
+
+const projectConfigFile = "devwrap.yaml"
+
+// projectConfig is the multi-service file read by `devwrap up`.
+type projectConfig struct {
+	// Project namespaces every service as <service>.<project>, as --project.
+	Project  string                   `yaml:"project"`
+	Services map[string]serviceConfig `yaml:"services"`
+	// ComposeFile is passed to `docker compose -f` for compose services.
+	ComposeFile string `yaml:"compose_file"`
+	// PortStrategy is passed to every service as --port-strategy.
+	PortStrategy string `yaml:"port_strategy"`
+
+	// dir is the directory containing the config file; relative paths in
+	// the config resolve against it.
+	dir string
+}
+
+type serviceConfig struct {
+	Command     commandSpec           `yaml:"command"`
+	Compose     string                `yaml:"compose"`
+	Host        string                `yaml:"host"`
+	Path        string                `yaml:"path"`
+	SPA         bool                  `yaml:"spa"`
+	Stream      bool                  `yaml:"stream"`
+	Trace       bool                  `yaml:"trace"`
+	Timeouts    devwrap.RouteTimeouts `yaml:"timeouts"`
+	MaxBodySize string                `yaml:"max_body_size"`
+	// Headers and RequestHeaders mirror --set-header and --set-request-header.
+	Headers        map[string]string `yaml:"headers"`
+	RequestHeaders map[string]string `yaml:"request_headers"`
+	Auth           stringList        `yaml:"auth"`
+	CORS           stringList        `yaml:"cors"`
+	ACMEDNS        string            `yaml:"acme_dns"`
+	LAN            bool              `yaml:"lan"`
+	RedirectHTTPS  bool              `yaml:"redirect_https"`
+	HSTS           bool              `yaml:"hsts"`
+	// RequireClientCert is a CA PEM path, relative to devwrap.yaml.
+	RequireClientCert string            `yaml:"require_client_cert"`
+	UpstreamTLS       bool              `yaml:"upstream_tls"`
+	Insecure          bool              `yaml:"insecure"`
+	DialHost          string            `yaml:"dial_host"`
+	Cwd               string            `yaml:"cwd"`
+	Env               map[string]string `yaml:"env"`
+	EnvFile           stringList        `yaml:"env_file"`
+	DependsOn         []string          `yaml:"depends_on"`
+	Ready             readyConfig       `yaml:"ready"`
+	Profiles          []string          `yaml:"profiles"`
+	Restart           string            `yaml:"restart"`
+	Watch             stringList        `yaml:"watch"`
+	WatchIgnore       stringList        `yaml:"watch_ignore"`
+	StopTimeout       time.Duration     `yaml:"stop_timeout"`
+	Hooks             hookOptions       `yaml:"hooks"`
+}
+
+// readyConfig is the health gate dependents wait on. Without HTTP the
+// service is ready once its port accepts TCP connections.
+type readyConfig struct {
+	HTTP    string        `yaml:"http"`
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+const defaultReadyTimeout = 60 * time.Second
+
+// commandSpec accepts either a list of argv entries or a single string,
+// which is run through `sh -c`.
+type commandSpec []string
+
+func (c *commandSpec) UnmarshalYAML(node *yaml.Node) error {
+	switch node.Kind {
+	case yaml.ScalarNode:
+		var s string
+		if err := node.Decode(&s); err != nil {
+			return err
+		}
+		if s == "" {
+			*c = nil
+			return nil
+		}
+		*c = commandSpec{"sh", "-c", s}
+		return nil
+	case yaml.SequenceNode:
+		var list []string
+		if err := node.Decode(&list); err != nil {
+			return err
+		}
+		*c = list
+		return nil
+	}
+	return fmt.Errorf("line %d: command must be a string or a list", node.Line)
+}
+
+// stringList accepts a single string or a list of strings.
+type stringList []string
+
+func (l *stringList) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		var s string
+		if err := node.Decode(&s); err != nil {
+			return err
+		}
+		*l = stringList{s}
+		return nil
+	}
+	var list []string
+	if err := node.Decode(&list); err != nil {
+		return err
+	}
+	*l = list
+	return nil
+}
+
+// findProjectConfig returns the explicit path, or searches for devwrap.yaml
+// from the working directory up to the filesystem root.
+func findProjectConfig(explicit string) (string, error) {
+	if explicit != "" {
+		return filepath.Abs(explicit)
+	}
+	dir, err := os.Getwd()
+	if err != nil {
+		return "", err
+	}
+	for {
+		candidate := filepath.Join(dir, projectConfigFile)
+		if _, err := os.Stat(candidate); err == nil {
+			return candidate, nil
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", fmt.Errorf("no %s found in this directory or any parent (use --file)", projectConfigFile)
+		}
+		dir = parent
+	}
+}
+
+func loadProjectConfig(path string) (projectConfig, error) {
+	var cfg projectConfig
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return cfg, err
+	}
+	if err := yaml.Unmarshal(b, &cfg); err != nil {
+		return cfg, fmt.Errorf("parse %s: %w", path, err)
+	}
+	cfg.dir = filepath.Dir(path)
+	if len(cfg.Services) == 0 {
+		return cfg, fmt.Errorf("%s defines no services", path)
+	}
+	if cfg.Project != "" {
+		if err := validateProject(cfg.Project); err != nil {
+			return cfg, err
+		}
+	}
+	if cfg.PortStrategy != "" {
+		if _, err := devwrap.ResolvePortStrategy(cfg.PortStrategy); err != nil {
+			return cfg, err
+		}
+	}
+	for name, svc := range cfg.Services {
+		if err := devwrap.ValidateName(name); err != nil {
+			return cfg, fmt.Errorf("service %q: %w", name, err)
+		}
+		switch {
+		case svc.Compose != "" && len(svc.Command) > 0:
+			return cfg, fmt.Errorf("service %q: command and compose cannot be combined", name)
+		case svc.Compose != "" && (svc.Host != "" || svc.Path != "" || svc.SPA || svc.Stream || svc.Trace || svc.UpstreamTLS || len(svc.Auth) > 0 || len(svc.CORS) > 0 || svc.ACMEDNS != "" || svc.LAN || svc.RedirectHTTPS || svc.HSTS || svc.RequireClientCert != "" || svc.DialHost != "" || svc.Ready.HTTP != ""):
+			return cfg, fmt.Errorf("service %q: compose services are not proxied; host, path, spa, stream, trace, upstream_tls, auth, cors, acme_dns, lan, redirect_https, hsts, require_client_cert, dial_host, and ready.http do not apply", name)
+		case svc.Compose != "" && svc.Hooks != (hookOptions{}):
+			return cfg, fmt.Errorf("service %q: hooks do not apply to compose services", name)
+		case svc.Compose == "" && len(svc.Command) == 0:
+			return cfg, fmt.Errorf("service %q: command (or compose) is required", name)
+		}
+		for _, dep := range svc.DependsOn {
+			if _, ok := cfg.Services[dep]; !ok {
+				return cfg, fmt.Errorf("service %q depends on unknown service %q", name, dep)
+			}
+		}
+		if err := validateDialHost(svc.DialHost); err != nil {
+			return cfg, fmt.Errorf("service %q: %w", name, err)
+		}
+		if svc.Ready.HTTP != "" && !strings.HasPrefix(svc.Ready.HTTP, "/") {
+			return cfg, fmt.Errorf("service %q: ready.http must be a path starting with /", name)
+		}
+		if _, err := parseRestartPolicy(svc.Restart); err != nil {
+			return cfg, fmt.Errorf("service %q: %w", name, err)
+		}
+		if err := validateProfiles(svc.Profiles); err != nil {
+			return cfg, fmt.Errorf("service %q: %w", name, err)
+		}
+		if svc.Ready.Timeout < 0 {
+			return cfg, fmt.Errorf("service %q: ready.timeout must be positive", name)
+		}
+		if err := svc.Timeouts.Validate(); err != nil {
+			return cfg, fmt.Errorf("service %q: %w", name, err)
+		}
+		if svc.MaxBodySize != "" {
+			if _, err := parseByteSize(svc.MaxBodySize); err != nil {
+				return cfg, fmt.Errorf("service %q: max_body_size: %w", name, err)
+			}
+		}
+		for _, spec := range svc.Auth {
+			if _, _, err := splitAuthSpec(spec); err != nil {
+				return cfg, fmt.Errorf("service %q: %w", name, err)
+			}
+		}
+		if err := validateCORSOrigins(svc.CORS); err != nil {
+			return cfg, fmt.Errorf("service %q: %w", name, err)
+		}
+		if err := validateACMEDNS(svc.ACMEDNS, svc.Host); err != nil {
+			return cfg, fmt.Errorf("service %q: %w", name, err)
+		}
+		if svc.StopTimeout < 0 {
+			return cfg, fmt.Errorf("service %q: stop_timeout must be positive", name)
+		}
+	}
+	if cycle := cfg.dependencyCycle(); cycle != nil {
+		return cfg, fmt.Errorf("dependency cycle: %s", strings.Join(cycle, " -> "))
+	}
+	return cfg, nil
+}
+
+// dependencyCycle returns one depends_on cycle (first name repeated at the
+// end), or nil when the graph is acyclic.
+func (c projectConfig) dependencyCycle() []string {
+	const (
+		visiting = iota + 1
+		done
+	)
+	mark := map[string]int{}
+	var stack []string
+	var visit func(name string) []string
+	visit = func(name string) []string {
+		switch mark[name] {
+		case visiting:
+			for i, n := range stack {
+				if n == name {
+					return append(append([]string{}, stack[i:]...), name)
+				}
+			}
+		case done:
+			return nil
+		}
+		mark[name] = visiting
+		stack = append(stack, name)
+		deps := append([]string{}, c.Services[name].DependsOn...)
+		sort.Strings(deps)
+		for _, dep := range deps {
+			if cycle := visit(dep); cycle != nil {
+				return cycle
+			}
+		}
+		stack = stack[:len(stack)-1]
+		mark[name] = done
+		return nil
+	}
+	for _, name := range c.serviceNames() {
+		if cycle := visit(name); cycle != nil {
+			return cycle
+		}
+	}
+	return nil
+}
+
+// selectServices picks the services to start for the given profiles.
+// Services without profiles always start; profiled services start only when
+// one of their profiles is requested. Dependencies of selected services are
+// pulled in regardless of profile.
+func (c projectConfig) selectServices(profiles []string) ([]string, error) {
+	known := map[string]bool{}
+	for _, svc := range c.Services {
+		for _, p := range svc.Profiles {
+			known[p] = true
+		}
+	}
+	wanted := map[string]bool{}
+	for _, p := range profiles {
+		if !known[p] {
+			return nil, fmt.Errorf("no service uses profile %q", p)
+		}
+		wanted[p] = true
+	}
+
+	selected := map[string]bool{}
+	var add func(name string)
+	add = func(name string) {
+		if selected[name] {
+			return
+		}
+		selected[name] = true
+		for _, dep := range c.Services[name].DependsOn {
+			add(dep)
+		}
+	}
+	for _, name := range c.serviceNames() {
+		svc := c.Services[name]
+		if len(svc.Profiles) == 0 {
+			add(name)
+			continue
+		}
+		for _, p := range svc.Profiles {
+			if wanted[p] {
+				add(name)
+				break
+			}
+		}
+	}
+	names := make([]string, 0, len(selected))
+	for _, name := range c.serviceNames() {
+		if selected[name] {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return nil, errNoServices
+	}
+	return names, nil
+}
+
+func validateProfiles(profiles []string) error {
+	for _, p := range profiles {
+		if err := devwrap.ValidateName(p); err != nil {
+			return fmt.Errorf("invalid profile %q: %w", p, err)
+		}
+	}
+	return nil
+}
+
+func hasProfile(profiles []string, profile string) bool {
+	for _, p := range profiles {
+		if p == profile {
+			return true
+		}
+	}
+	return false
+}
+
+// resolvePath resolves p relative to the config file's directory.
+func (c projectConfig) resolvePath(p string) string {
+	if p == "" {
+		return c.dir
+	}
+	if filepath.IsAbs(p) {
+		return p
+	}
+	return filepath.Join(c.dir, p)
+}
+
+// resolvedService is a service with ${...} references expanded, ready to
+// hand to a child devwrap process.
+type resolvedService struct {
+	Host    string
+	Cwd     string
+	Command []string
+	Env     []string
+}
+
+// resolveService expands ${VAR} references in the service's host, cwd,
+// command, and env values at launch time. Built-ins are ${NAME}, ${HOST}
+// (the resolved host), and ${PORT}, which becomes the @PORT token the child
+// devwrap fills in once the port is leased. Other names resolve from env,
+// then env_file, then the inherited environment; unknown names are left as
+// written so a shell command can still expand them.
+func (c projectConfig) resolveService(name string) (resolvedService, error) {
+	svc := c.Services[name]
+	fileEnv := map[string]string{}
+	for _, file := range svc.EnvFile {
+		vars, err := parseEnvFile(c.resolvePath(file))
+		if err != nil {
+			return resolvedService{}, fmt.Errorf("service %q: env_file: %w", name, err)
+		}
+		for k, v := range vars {
+			fileEnv[k] = v
+		}
+	}
+	builtins := map[string]string{"NAME": name}
+	lookupIn := func(layers ...map[string]string) func(string) (string, bool) {
+		return func(key string) (string, bool) {
+			for _, layer := range layers {
+				if v, ok := layer[key]; ok {
+					return v, true
+				}
+			}
+			return os.LookupEnv(key)
+		}
+	}
+
+	// env values may use built-ins and env_file/inherited variables, but
+	// not each other, so the result does not depend on map order.
+	host := ""
+	if svc.Host != "" {
+		host = interpolate(svc.Host, lookupIn(builtins, fileEnv))
+	}
+	resolvedHost, err := devwrap.HostForApp(c.appName(name), host)
+	if err != nil {
+		return resolvedService{}, fmt.Errorf("service %q: %w", name, err)
+	}
+	builtins["HOST"] = resolvedHost
+	builtins["PORT"] = "@PORT"
+
+	env := map[string]string{}
+	for k, v := range fileEnv {
+		env[k] = v
+	}
+	valueLookup := lookupIn(builtins, fileEnv)
+	for k, v := range svc.Env {
+		env[k] = interpolate(v, valueLookup)
+	}
+
+	lookup := lookupIn(builtins, env)
+	out := resolvedService{
+		Host:    host,
+		Cwd:     c.resolvePath(interpolate(svc.Cwd, lookup)),
+		Command: make([]string, len(svc.Command)),
+		Env:     envList(env),
+	}
+	for i, arg := range svc.Command {
+		out.Command[i] = interpolate(arg, lookup)
+	}
+	return out, nil
+}
+
+// interpolate replaces ${NAME} references using lookup. "$$" is an escaped
+// dollar; bare $VAR and $(...) are left alone for the shell.
+func interpolate(s string, lookup func(string) (string, bool)) string {
+	if !strings.Contains(s, "$") {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '$' || i+1 >= len(s) {
+			b.WriteByte(s[i])
+			continue
+		}
+		switch s[i+1] {
+		case '$':
+			b.WriteByte('$')
+			i++
+			continue
+		case '{':
+			end := strings.IndexByte(s[i+2:], '}')
+			if end < 0 {
+				break
+			}
+			key := s[i+2 : i+2+end]
+			if v, ok := lookup(key); ok {
+				b.WriteString(v)
+			} else {
+				b.WriteString(s[i : i+3+end])
+			}
+			i += 2 + end
+			continue
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+// appName is the name service name registers under: <name>.<project> when
+// the config sets a project.
+func (c projectConfig) appName(name string) string {
+	if c.Project == "" {
+		return name
+	}
+	return name + "." + c.Project
+}
+
+// composeService describes the docker compose service behind name.
+func (c projectConfig) composeService(name string) devwrap.ComposeService {
+	svc := c.Services[name]
+	file := ""
+	if c.ComposeFile != "" {
+		file = c.resolvePath(c.ComposeFile)
+	}
+	return devwrap.ComposeService{
+		Name:     name,
+		Service:  svc.Compose,
+		Dir:      c.dir,
+		File:     file,
+		Profiles: svc.Profiles,
+	}
+}
+
+// dependents returns the services that list name in depends_on.
+func (c projectConfig) dependents(name string) []string {
+	var out []string
+	for _, other := range c.serviceNames() {
+		for _, dep := range c.Services[other].DependsOn {
+			if dep == name {
+				out = append(out, other)
+				break
+			}
+		}
+	}
+	return out
+}
+
+// serviceNames returns the configured services in a stable order.
+func (c projectConfig) serviceNames() []string {
+	names := make([]string, 0, len(c.Services))
+	for name := range c.Services {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+var errNoServices = errors.New("no services selected (services with profiles need --profile)")
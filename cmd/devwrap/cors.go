@@ -0,0 +1,34 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+
+	"github.com/spf13/cobra"
+)
+
+// corsAnyOrigin is the --cors value (and the bare flag's default) that allows
+// every origin.
+const corsAnyOrigin = "*"
+
+func addCORSFlag(cmd *cobra.Command, origins *[]string) {
+	cmd.Flags().StringSliceVar(origins, "cors", nil, "Answer CORS preflights and allow cross-origin requests (bare: any origin; or --cors=https://a.localhost,...)")
+	cmd.Flags().Lookup("cors").NoOptDefVal = corsAnyOrigin
+}
+
+// validateCORSOrigins accepts "*" or scheme://host[:port] origins.
+func validateCORSOrigins(origins []string) error {
+	for _, o := range origins {
+		if o == corsAnyOrigin {
+			if len(origins) > 1 {
+				return fmt.Errorf("--cors %q cannot be combined with other origins", corsAnyOrigin)
+			}
+			continue
+		}
+		u, err := url.Parse(o)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || (u.Path != "" && u.Path != "/") || u.RawQuery != "" {
+			return fmt.Errorf("invalid --cors origin %q (want scheme://host[:port])", o)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"devwrap/internal/devwrap"
+)
+
+// runCurl requests an app's HTTPS URL through the proxy with the system
+// curl, verifying the proxy's certificate against the devwrap root CA so
+// it works whether or not that CA is trusted. Extra args go to curl as-is.
+func runCurl(ref, path string, extra []string) error {
+	if err := validateAppRef(ref); err != nil {
+		return err
+	}
+	if outputJSON {
+		return errors.New("curl does not support --json; pass curl's own flags after --")
+	}
+	curl, err := exec.LookPath("curl")
+	if err != nil {
+		return errors.New("curl is not installed")
+	}
+	status, err := devwrap.LocalStatusFromFiles()
+	if err != nil {
+		return err
+	}
+	if !status.Running {
+		return errors.New("proxy is not running")
+	}
+	var app devwrap.App
+	found := false
+	for _, a := range status.Apps {
+		if a.Name == ref {
+			app, found = a, true
+			break
+		}
+	}
+	if !found {
+		return fmt.Errorf("app %q is not registered", ref)
+	}
+	target, err := curlURL(app.HTTPSURL(status.HTTPSPort), path)
+	if err != nil {
+		return err
+	}
+
+	cert, err := devwrap.ActiveRootCert()
+	if err != nil {
+		return trustUnavailableError(fmt.Sprintf("failed to load root CA: %v", err))
+	}
+	ca, err := os.CreateTemp("", "devwrap-ca-*.pem")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(ca.Name())
+	if _, err := ca.Write(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})); err != nil {
+		ca.Close()
+		return err
+	}
+	if err := ca.Close(); err != nil {
+		return err
+	}
+
+	args := []string{"--cacert", ca.Name()}
+	// A local proxy listens on loopback whatever the host resolves to; a
+	// remote one is reached through the host's own DNS.
+	if !devwrap.CaddyAdmin.Remote {
+		args = append(args, "--resolve", net.JoinHostPort(app.Host, strconv.Itoa(status.HTTPSPort))+":127.0.0.1")
+	}
+	args = append(args, extra...)
+	args = append(args, target)
+
+	cmd := exec.Command(curl, args...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	if err := cmd.Run(); err != nil {
+		if code, ok := childExitCode(err); ok {
+			return exitStatusError{code: code}
+		}
+		return err
+	}
+	return nil
+}
+
+// curlURL appends path, which may carry a query, to the app's base URL.
+func curlURL(base, path string) (string, error) {
+	if path == "" {
+		return base, nil
+	}
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+	u, err := url.Parse(strings.TrimSuffix(base, "/") + path)
+	if err != nil {
+		return "", fmt.Errorf("invalid path %q: %w", path, err)
+	}
+	return u.String(), nil
+}
@@ -2,8 +2,6 @@ package main
 
 import (
 	"crypto/x509"
-	"encoding/json"
-	"encoding/pem"
 	"errors"
 	"fmt"
 	"net"
@@ -13,34 +11,12 @@ import (
 	"syscall"
 
 	"github.com/smallstep/truststore"
-)
-
-type App struct {
-	Name      string `json:"name"`
-	Host      string `json:"host"`
-	Port      int    `json:"port"`
-	PID       int    `json:"pid"`
-	StartedAt string `json:"started_at"`
-}
 
-func (a App) HTTPSURL(httpsPort int) string {
-	if httpsPort == 443 {
-		return "https://" + a.Host
-	}
-	return "https://" + a.Host + ":" + strconv.Itoa(httpsPort)
-}
-
-type daemonState struct {
-	Version     int            `json:"version"`
-	CaddySource string         `json:"caddy_source"`
-	Root        bool           `json:"root"`
-	HTTPPort    int            `json:"http_port"`
-	HTTPSPort   int            `json:"https_port"`
-	Apps        map[string]App `json:"apps"`
-}
+	"devwrap/internal/devwrap"
+)
 
 func startDaemon() error {
-	if checkSystemCaddyReachable() {
+	if devwrap.CheckSystemCaddyReachable() {
 		return errors.New("caddy admin already running; daemon not needed")
 	}
 
@@ -51,60 +27,70 @@ func startDaemon() error {
 	if err := startEmbeddedCaddy(httpPort, httpsPort); err != nil {
 		return err
 	}
+	if err := adoptEmbeddedCaddy(httpPort, httpsPort); err != nil {
+		return err
+	}
 
-	if err := withStateLock(func() error {
-		state, err := loadLocalState()
+	pid, err := devwrap.PidPath()
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(pid, []byte(strconv.Itoa(os.Getpid())), 0o600); err != nil {
+		return err
+	}
+	devwrap.ChownToInvoker(pid)
+	defer os.Remove(pid)
+	devwrap.RecordEvents(devwrap.Event{Type: eventProxyStarted, PID: os.Getpid(), HTTPPort: httpPort, HTTPSPort: httpsPort})
+	// Started at login by the systemd unit, this is the first command after
+	// a reboot; restored apps need the proxy, so they start now.
+	restorePendingApps()
+
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(quit)
+	stopSweep := sweepDeadApps()
+	watchEmbeddedCaddy(httpPort, httpsPort, quit)
+	stopSweep()
+	err = stopSpawnedCaddy()
+	devwrap.RecordEvents(devwrap.Event{Type: eventProxyStopped, PID: os.Getpid()})
+	return err
+}
+
+// adoptEmbeddedCaddy records the embedded Caddy as the managed proxy and
+// pushes every live app's route into it.
+func adoptEmbeddedCaddy(httpPort, httpsPort int) error {
+	return devwrap.WithStateLock(func(tx *devwrap.StateTx) error {
+		state, err := tx.Load()
 		if err != nil {
 			return err
 		}
-		for name, app := range state.Apps {
-			if !processAlive(app.PID) {
-				delete(state.Apps, name)
-			}
-		}
+		devwrap.PruneDeadApps(&state)
 		state.Version = 1
 		state.CaddySource = "managed"
 		state.HTTPPort = httpPort
 		state.HTTPSPort = httpsPort
 		state.Root = httpPort == 80 && httpsPort == 443
-		if err := saveLocalState(state); err != nil {
+		if err := tx.Save(state); err != nil {
 			return err
 		}
-		if _, _, err := applyRoutesViaAdmin(state.Apps); err != nil {
+		if _, _, err := devwrap.ApplyRoutesViaAdmin(state); err != nil {
 			return err
 		}
 		return nil
-	}); err != nil {
-		return err
-	}
-
-	pid, err := pidPath()
-	if err != nil {
-		return err
-	}
-	if err := os.WriteFile(pid, []byte(strconv.Itoa(os.Getpid())), 0o644); err != nil {
-		return err
-	}
-	defer os.Remove(pid)
-
-	quit := make(chan os.Signal, 1)
-	signal.Notify(quit, os.Interrupt, syscall.SIGTERM)
-	defer signal.Stop(quit)
-	<-quit
-	return stopSpawnedCaddy()
+	})
 }
 
 func stopSpawnedCaddy() error {
 	if err := stopEmbeddedCaddy(); err != nil {
 		return err
 	}
-	return withStateLock(func() error {
-		state, err := loadLocalState()
+	return devwrap.WithStateLock(func(tx *devwrap.StateTx) error {
+		state, err := tx.Load()
 		if err != nil {
 			return err
 		}
 		state.CaddySource = "unmanaged"
-		return saveLocalState(state)
+		return tx.Save(state)
 	})
 }
 
@@ -140,58 +126,42 @@ func isPortAvailable(port int) bool {
 	return true
 }
 
-func isCertTrusted() bool {
-	cert, err := rootCertFromAdmin("local")
-	if err != nil {
-		return false
-	}
-	chains, err := cert.Verify(x509.VerifyOptions{})
-	return err == nil && len(chains) > 0
-}
-
 func trustLocalCA() error {
-	cert, err := rootCertFromAdmin("local")
+	cert, err := devwrap.ActiveRootCert()
 	if err != nil {
-		return fmt.Errorf("failed to fetch caddy local CA from admin API: %w", err)
+		return fmt.Errorf("failed to load root CA: %w", err)
 	}
-	if isCertTrusted() {
+	if devwrap.IsCertTrusted() {
 		return nil
 	}
-	if err := truststore.Install(cert,
-		truststore.WithDebug(),
-		truststore.WithFirefox(),
-		truststore.WithJava(),
-	); err != nil {
+	opts := []truststore.Option{truststore.WithDebug()}
+	if nonInteractive {
+		if !sudoAvailableNonInteractive() {
+			return errSudoNeedsPrompt
+		}
+	} else {
+		opts = append(opts, truststore.WithFirefox(), truststore.WithJava())
+	}
+	if err := truststore.Install(cert, opts...); err != nil {
 		return fmt.Errorf("trust install failed: %w", err)
 	}
+	devwrap.RecordEvents(trustEvent(true, certFingerprint(cert)))
 	return nil
 }
 
-func rootCertFromAdmin(caID string) (*x509.Certificate, error) {
-	if caID == "" {
-		caID = "local"
-	}
-	res, err := adminGet("/pki/ca/" + caID)
-	if err != nil {
-		return nil, err
-	}
-	defer res.Body.Close()
-	if res.StatusCode >= 300 {
-		return nil, fmt.Errorf("admin API returned %d", res.StatusCode)
-	}
-	var payload struct {
-		RootCert string `json:"root_certificate"`
-	}
-	if err := json.NewDecoder(res.Body).Decode(&payload); err != nil {
-		return nil, err
-	}
-	block, _ := pem.Decode([]byte(payload.RootCert))
-	if block == nil {
-		return nil, errors.New("failed to decode root certificate PEM")
+// untrustCert removes cert from the stores trustLocalCA installs into.
+func untrustCert(cert *x509.Certificate) error {
+	opts := []truststore.Option{truststore.WithDebug()}
+	if nonInteractive {
+		if !sudoAvailableNonInteractive() {
+			return errSudoNeedsPrompt
+		}
+	} else {
+		opts = append(opts, truststore.WithFirefox(), truststore.WithJava())
 	}
-	cert, err := x509.ParseCertificate(block.Bytes)
-	if err != nil {
-		return nil, err
+	if err := truststore.Uninstall(cert, opts...); err != nil {
+		return fmt.Errorf("trust uninstall failed: %w", err)
 	}
-	return cert, nil
+	devwrap.RecordEvents(trustEvent(false, certFingerprint(cert)))
+	return nil
 }
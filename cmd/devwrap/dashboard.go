@@ -0,0 +1,220 @@
+package main
+
+import (
+	"context"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"devwrap/internal/devwrap"
+)
+
+// dashboardAppName is the app the dashboard registers as, served at
+// https://devwrap.localhost.
+const dashboardAppName = "devwrap"
+
+// dashboardRefresh is how often the dashboard page polls for status.
+const dashboardRefresh = 2 * time.Second
+
+//go:embed assets/dashboard.html
+var dashboardPageHTML string
+
+var dashboardPage = template.Must(template.New("dashboard").Parse(dashboardPageHTML))
+
+// dashboardApp is one row of the dashboard: an app with its status, URL,
+// and uptime.
+type dashboardApp struct {
+	Name     string `json:"name"`
+	Status   string `json:"status"`
+	URL      string `json:"url"`
+	Port     int    `json:"port,omitempty"`
+	Upstream string `json:"upstream,omitempty"`
+	PID      int    `json:"pid,omitempty"`
+	Project  string `json:"project,omitempty"`
+	Paused   bool   `json:"paused"`
+	Unowned  bool   `json:"unowned"`
+	// UptimeSeconds is -1 when the start time is unknown.
+	UptimeSeconds int64 `json:"uptime_seconds"`
+}
+
+// runDashboard registers the dashboard as an app and serves it until
+// Ctrl-C, like any app run under devwrap.
+func runDashboard(detach bool) error {
+	if detachedLogFile() == "" && detach {
+		return runDetached(dashboardAppName)
+	}
+	if err := ensureCaddyOrDaemon(false); err != nil {
+		return err
+	}
+	lease, err := acquireLease(devwrap.LeaseRequest{Name: dashboardAppName, PID: os.Getpid()})
+	if err != nil {
+		return err
+	}
+	ln, err := net.Listen("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(lease.Port)))
+	if err != nil {
+		releaseLeaseSelected(dashboardAppName, os.Getpid(), nil)
+		return err
+	}
+	stopWatch := watchRoute(dashboardAppName, os.Getpid())
+	defer func() {
+		stopWatch()
+		releaseLeaseSelected(dashboardAppName, os.Getpid(), nil)
+	}()
+
+	srv := &http.Server{Handler: dashboardHandler(lease.Host), ReadHeaderTimeout: 10 * time.Second}
+	go func() { _ = srv.Serve(ln) }()
+	// The listener is already accepting connections.
+	markAppReady(dashboardAppName, os.Getpid(), "")
+	if outputJSON {
+		_ = emitJSON(map[string]any{"ok": true, "action": "dashboard", "https_url": lease.HTTPSURL, "port": lease.Port})
+	} else {
+		fmt.Printf("dashboard -> %s\n", lease.HTTPSURL)
+	}
+
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(quit)
+	<-quit
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return srv.Shutdown(ctx)
+}
+
+// dashboardHandler serves the dashboard for requests addressed to host,
+// its lease host, or to a loopback address.
+func dashboardHandler(host string) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /{$}", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		_ = dashboardPage.Execute(w, map[string]any{
+			"Self":          dashboardAppName,
+			"RefreshMillis": dashboardRefresh.Milliseconds(),
+		})
+	})
+	mux.HandleFunc("GET /api/status", func(w http.ResponseWriter, r *http.Request) {
+		status, err := devwrap.LocalStatusFromFiles()
+		if err != nil {
+			writeDashboardJSON(w, http.StatusServiceUnavailable, map[string]any{"error": err.Error()})
+			return
+		}
+		writeDashboardJSON(w, http.StatusOK, map[string]any{
+			"proxy": map[string]any{
+				"caddy_source": status.CaddySource,
+				"http_port":    status.HTTPPort,
+				"https_port":   status.HTTPSPort,
+				"trusted":      status.Trusted,
+				"paused":       status.Paused,
+				"managed_by":   status.ManagedBy,
+			},
+			"apps": dashboardApps(status),
+		})
+	})
+	mux.HandleFunc("POST /api/apps/{name}/{action}", func(w http.ResponseWriter, r *http.Request) {
+		if !sameOriginPost(r) {
+			writeDashboardJSON(w, http.StatusForbidden, map[string]any{"error": "cross-origin request refused"})
+			return
+		}
+		name := r.PathValue("name")
+		if err := validateAppRef(name); err != nil {
+			writeDashboardJSON(w, http.StatusBadRequest, map[string]any{"error": err.Error()})
+			return
+		}
+		if name == dashboardAppName {
+			writeDashboardJSON(w, http.StatusBadRequest, map[string]any{"error": "the dashboard can't act on itself"})
+			return
+		}
+		var err error
+		switch r.PathValue("action") {
+		case "pause":
+			err = devwrap.SetPausedDirect(name, true)
+		case "resume":
+			err = devwrap.SetPausedDirect(name, false)
+		case "remove":
+			err = devwrap.RemoveDirect(name)
+		default:
+			writeDashboardJSON(w, http.StatusNotFound, map[string]any{"error": "unknown action"})
+			return
+		}
+		if err != nil {
+			writeDashboardJSON(w, http.StatusInternalServerError, map[string]any{"error": err.Error()})
+			return
+		}
+		writeDashboardJSON(w, http.StatusOK, map[string]any{"ok": true})
+	})
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !dashboardHostAllowed(r, host) {
+			writeDashboardJSON(w, http.StatusForbidden, map[string]any{"error": "unknown host refused"})
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+func dashboardApps(status devwrap.ProxyStatus) []dashboardApp {
+	now := time.Now()
+	apps := make([]dashboardApp, 0, len(status.Apps))
+	for _, app := range status.Apps {
+		row := dashboardApp{
+			Name:          app.Name,
+			Status:        "running",
+			URL:           app.HTTPSURL(status.HTTPSPort),
+			Port:          app.Port,
+			Upstream:      app.Upstream,
+			PID:           app.PID,
+			Project:       app.Project,
+			Paused:        app.Paused,
+			Unowned:       app.Unowned,
+			UptimeSeconds: -1,
+		}
+		switch {
+		case app.Paused || status.Paused:
+			row.Status = "paused"
+		case app.Starting:
+			row.Status = "starting"
+		}
+		if t, err := time.Parse(time.RFC3339, app.StartedAt); err == nil {
+			row.UptimeSeconds = int64(now.Sub(t).Seconds())
+		}
+		apps = append(apps, row)
+	}
+	return apps
+}
+
+// dashboardHostAllowed refuses requests whose Host is another name, as a
+// DNS-rebinding page's would be: only the dashboard's own host and
+// loopback addresses are served.
+func dashboardHostAllowed(r *http.Request, host string) bool {
+	h := r.Host
+	if hostname, _, err := net.SplitHostPort(h); err == nil {
+		h = hostname
+	}
+	h = strings.TrimSuffix(strings.ToLower(h), ".")
+	if h == strings.ToLower(host) || h == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(h)
+	return ip != nil && ip.IsLoopback()
+}
+
+// sameOriginPost refuses actions posted from other sites: the browser's
+// Origin must be the dashboard's own host.
+func sameOriginPost(r *http.Request) bool {
+	u, err := url.Parse(r.Header.Get("Origin"))
+	return err == nil && u.Host != "" && u.Host == r.Host
+}
+
+func writeDashboardJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
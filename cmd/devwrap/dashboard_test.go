@@ -0,0 +1,32 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestDashboardHostAllowed(t *testing.T) {
+	tests := []struct {
+		host string
+		want bool
+	}{
+		{"devwrap.localhost", true},
+		{"devwrap.localhost:8443", true},
+		{"DEVWRAP.localhost.", true},
+		{"localhost:11000", true},
+		{"127.0.0.1:11000", true},
+		{"[::1]:11000", true},
+		{"evil.example", false},
+		{"evil.example:11000", false},
+		{"api.localhost", false},
+		{"devwrap.localhost.evil.example", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/api/status", nil)
+		r.Host = tt.host
+		if got := dashboardHostAllowed(r, "devwrap.localhost"); got != tt.want {
+			t.Errorf("dashboardHostAllowed(%q) = %v, want %v", tt.host, got, tt.want)
+		}
+	}
+}
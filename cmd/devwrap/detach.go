@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"syscall"
+	"time"
+
+	"devwrap/internal/devwrap"
+)
+
+// detachLogEnv is set on the devwrap process re-executed by --detach. It
+// carries the log file path and stops the copy from detaching again.
+const detachLogEnv = "DEVWRAP_DETACHED_LOG"
+
+const detachStartTimeout = 30 * time.Second
+
+// detachedLogFile reports the log file of a devwrap started by --detach, and
+// clears the marker so the app's own processes do not inherit it.
+func detachedLogFile() string {
+	path := os.Getenv(detachLogEnv)
+	if path != "" {
+		_ = os.Unsetenv(detachLogEnv)
+	}
+	return path
+}
+
+// runDetached re-executes devwrap with the same arguments in a new session,
+// with output going to the app's log file, and returns once the copy holds
+// its lease.
+func runDetached(name string) error {
+	if err := validateAppRef(name); err != nil {
+		return err
+	}
+	cmd, logPath, err := startDetached(name, os.Args[1:], "")
+	if err != nil {
+		return err
+	}
+	pid := cmd.Process.Pid
+	exited := make(chan struct{})
+	go func() {
+		_ = cmd.Wait()
+		close(exited)
+	}()
+
+	deadline := time.Now().Add(detachStartTimeout)
+	for {
+		if lease, ok := leaseForPID(pid); ok {
+			return printDetached(lease, pid, logPath)
+		}
+		select {
+		case <-exited:
+			return fmt.Errorf("%s exited during startup (logs: %s)", name, logPath)
+		case <-time.After(100 * time.Millisecond):
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("%s did not start within %s (pid %d, logs: %s)", name, detachStartTimeout, pid, logPath)
+		}
+	}
+}
+
+// startDetached starts devwrap with args in dir (the current directory when
+// empty) as a detached copy in its own session, with output appended to
+// name's log file.
+func startDetached(name string, args []string, dir string) (*exec.Cmd, string, error) {
+	logPath, err := appLogPath(name)
+	if err != nil {
+		return nil, "", err
+	}
+	logOut, err := os.OpenFile(logPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, "", err
+	}
+	defer logOut.Close()
+	devwrap.ChownToInvoker(logPath)
+	devNull, err := os.Open(os.DevNull)
+	if err != nil {
+		return nil, "", err
+	}
+	defer devNull.Close()
+
+	exe, err := os.Executable()
+	if err != nil {
+		return nil, "", err
+	}
+	cmd := exec.Command(exe, args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), detachLogEnv+"="+logPath)
+	cmd.Stdin = devNull
+	cmd.Stdout = logOut
+	cmd.Stderr = logOut
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	if err := cmd.Start(); err != nil {
+		return nil, "", err
+	}
+	return cmd, logPath, nil
+}
+
+// leaseForPID finds the lease held by the devwrap process pid, which may have
+// been renamed by --instance.
+func leaseForPID(pid int) (devwrap.Lease, bool) {
+	state, err := devwrap.LoadLocalState()
+	if err != nil {
+		return devwrap.Lease{}, false
+	}
+	for _, app := range state.Apps {
+		if app.PID == pid {
+			return devwrap.LeaseFromAppAndPorts(app, state.HTTPPort, state.HTTPSPort), true
+		}
+	}
+	return devwrap.Lease{}, false
+}
+
+func printDetached(lease devwrap.Lease, pid int, logPath string) error {
+	if outputJSON {
+		return emitJSON(map[string]any{
+			"ok":        true,
+			"action":    "detach",
+			"name":      lease.Name,
+			"pid":       pid,
+			"port":      lease.Port,
+			"https_url": lease.HTTPSURL,
+			"http_url":  lease.HTTPURL,
+			"log_file":  logPath,
+		})
+	}
+	fmt.Printf("%s -> %s (detached, pid %d)\n", lease.Name, lease.HTTPSURL, pid)
+	fmt.Printf("logs: %s\n", logPath)
+	fmt.Printf("stop with: kill %d\n", pid)
+	return nil
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"devwrap/internal/devwrap"
+)
+
+const defaultDownTimeout = 10 * time.Second
+
+// runDown terminates every tracked app (optionally only one profile), waits
+// for the devwrap processes to exit, and then drops whatever leases are left
+// in a single state update and route apply.
+func runDown(profile string, timeout time.Duration) error {
+	if timeout <= 0 {
+		return errors.New("--timeout must be positive")
+	}
+	// Apps are stopped even when Caddy has died; only the route update
+	// needs the proxy.
+	state, err := devwrap.LoadLocalState()
+	if err != nil {
+		return err
+	}
+	targets := map[string]int{}
+	for name, app := range state.Apps {
+		if profile != "" && !hasProfile(app.Profiles, profile) {
+			continue
+		}
+		targets[name] = app.PID
+	}
+	names := make([]string, 0, len(targets))
+	for name := range targets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if pid := targets[name]; devwrap.ProcessAlive(pid) {
+			_ = syscall.Kill(pid, syscall.SIGTERM)
+		}
+	}
+	killed := waitForExit(names, targets, timeout)
+
+	var composeStopped []string
+	var composeErrs []string
+	for _, c := range state.Compose {
+		if profile != "" && !hasProfile(c.Profiles, profile) {
+			continue
+		}
+		if err := composeDown(c); err != nil {
+			composeErrs = append(composeErrs, err.Error())
+			continue
+		}
+		composeStopped = append(composeStopped, c.Name)
+	}
+
+	proxyRunning := devwrap.CheckSystemCaddyReachable()
+	err = devwrap.WithStateLock(func(tx *devwrap.StateTx) error {
+		state, err := tx.Load()
+		if err != nil {
+			return err
+		}
+		for _, name := range names {
+			app, ok := state.Apps[name]
+			if !ok || app.PID != targets[name] {
+				continue
+			}
+			state.RecordExit(app, "stopped", nil)
+			delete(state.Apps, name)
+		}
+		devwrap.PruneDeadApps(&state)
+		kept := state.Compose[:0]
+		for _, c := range state.Compose {
+			if !slices.Contains(composeStopped, c.Name) {
+				kept = append(kept, c)
+			}
+		}
+		state.Compose = kept
+		if proxyRunning {
+			if _, _, err := devwrap.ApplyRoutesViaAdmin(state); err != nil {
+				return err
+			}
+		}
+		return tx.Save(state)
+	})
+	if err != nil {
+		return err
+	}
+
+	if outputJSON {
+		if killed == nil {
+			killed = []string{}
+		}
+		if composeStopped == nil {
+			composeStopped = []string{}
+		}
+		out := map[string]any{"ok": len(composeErrs) == 0, "action": "down", "profile": profile, "stopped": names, "killed": killed, "compose_stopped": composeStopped, "proxy_running": proxyRunning}
+		if len(composeErrs) > 0 {
+			out["errors"] = composeErrs
+		}
+		if err := emitJSON(out); err != nil {
+			return err
+		}
+		if len(composeErrs) > 0 {
+			return exitStatusError{code: 1}
+		}
+		return nil
+	}
+	if len(names) == 0 && len(composeStopped) == 0 && len(composeErrs) == 0 {
+		fmt.Println("no apps to stop")
+		return nil
+	}
+	if len(names) > 0 {
+		fmt.Printf("stopped %d app(s): %s\n", len(names), strings.Join(names, ", "))
+	}
+	if len(killed) > 0 {
+		fmt.Printf("killed after %s: %s\n", timeout, strings.Join(killed, ", "))
+	}
+	if len(composeStopped) > 0 {
+		fmt.Printf("removed compose service(s): %s\n", strings.Join(composeStopped, ", "))
+	}
+	if !proxyRunning {
+		fmt.Fprintln(os.Stderr, "warning: proxy is not running, so its routes were not updated; run `devwrap proxy reload` once it is back")
+	}
+	if len(composeErrs) > 0 {
+		return errors.New(strings.Join(composeErrs, "; "))
+	}
+	return nil
+}
+
+// waitForExit polls until every target pid is gone, sending SIGKILL to the
+// stragglers once timeout elapses. It returns the names that were killed.
+func waitForExit(names []string, pids map[string]int, timeout time.Duration) []string {
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		alive := false
+		for _, name := range names {
+			if devwrap.ProcessAlive(pids[name]) {
+				alive = true
+				break
+			}
+		}
+		if !alive {
+			return nil
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	var killed []string
+	for _, name := range names {
+		if pid := pids[name]; devwrap.ProcessAlive(pid) {
+			_ = syscall.Kill(pid, syscall.SIGKILL)
+			killed = append(killed, name)
+		}
+	}
+	return killed
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"maps"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// parseEnvFile reads a dotenv-style file: KEY=VALUE lines, optional `export `
+// prefix, `#` comments, and single- or double-quoted values.
+func parseEnvFile(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	env := map[string]string{}
+	scanner := bufio.NewScanner(f)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimPrefix(line, "export ")
+		key, value, ok := strings.Cut(line, "=")
+		key = strings.TrimSpace(key)
+		if !ok || key == "" || strings.ContainsAny(key, " \t") {
+			return nil, fmt.Errorf("%s:%d: expected KEY=VALUE", path, lineNo)
+		}
+		value = strings.TrimSpace(value)
+		switch {
+		case len(value) >= 2 && value[0] == '"' && value[len(value)-1] == '"':
+			unquoted, err := strconv.Unquote(value)
+			if err != nil {
+				return nil, fmt.Errorf("%s:%d: %w", path, lineNo, err)
+			}
+			value = unquoted
+		case len(value) >= 2 && value[0] == '\'' && value[len(value)-1] == '\'':
+			value = value[1 : len(value)-1]
+		default:
+			if i := strings.Index(value, " #"); i >= 0 {
+				value = strings.TrimSpace(value[:i])
+			}
+		}
+		env[key] = value
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return env, nil
+}
+
+// loadEnvFiles merges --env-file files in order, later files winning.
+func loadEnvFiles(paths []string) (map[string]string, error) {
+	merged := map[string]string{}
+	for _, path := range paths {
+		vars, err := parseEnvFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("--env-file: %w", err)
+		}
+		for k, v := range vars {
+			merged[k] = v
+		}
+	}
+	return merged, nil
+}
+
+func validateEnvEntries(entries []string) error {
+	for _, e := range entries {
+		key, _, _ := strings.Cut(e, "=")
+		if key == "" || strings.ContainsAny(key, " \t\x00") {
+			return fmt.Errorf("--env %q: expected KEY=VALUE or KEY", e)
+		}
+	}
+	return nil
+}
+
+// expandRunEnv resolves env file values and then KEY=VALUE entries against
+// the child's environment, which already holds PORT and DEVWRAP_*. As in
+// devwrap.yaml, entries may use ${...} references to file values but not to
+// each other, so the result does not depend on order within a layer. A bare
+// KEY entry takes its value from the environment as-is, or is skipped when
+// unset.
+func expandRunEnv(base []string, fileEnv map[string]string, entries []string) []string {
+	known := map[string]string{}
+	for _, kv := range base {
+		if k, v, ok := strings.Cut(kv, "="); ok {
+			known[k] = v
+		}
+	}
+	lookup := func(key string) (string, bool) {
+		v, ok := known[key]
+		return v, ok
+	}
+	resolved := map[string]string{}
+	for k, v := range fileEnv {
+		resolved[k] = interpolate(v, lookup)
+	}
+	out := envList(resolved)
+	maps.Copy(known, resolved)
+	for _, e := range entries {
+		k, v, ok := strings.Cut(e, "=")
+		if !ok {
+			if v, set := os.LookupEnv(k); set {
+				out = append(out, k+"="+v)
+			}
+			continue
+		}
+		out = append(out, k+"="+interpolate(v, lookup))
+	}
+	return out
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"devwrap/internal/devwrap"
+)
+
+// Lifecycle event types written to <state>/events.ndjson.
+const (
+	eventProxyStarted = "proxy_started"
+	eventProxyStopped = "proxy_stopped"
+	eventTrustChanged = "trust_changed"
+)
+
+func trustEvent(trusted bool, fingerprint string) devwrap.Event {
+	return devwrap.Event{Type: eventTrustChanged, Trusted: &trusted, Fingerprint: fingerprint}
+}
+
+// runEvents streams the event log from its end (after replaying the last
+// tail events) until Ctrl-C, as NDJSON or as server-sent events.
+func runEvents(tail int, sse bool) error {
+	path, err := devwrap.EventsPath()
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return err
+	}
+	f.Close()
+	devwrap.ChownToInvoker(path)
+
+	var offset int64
+	if tail > 0 {
+		offset, err = tailOffset(path, tail)
+	} else {
+		var info os.FileInfo
+		if info, err = os.Stat(path); err == nil {
+			offset = info.Size()
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	stop := make(chan struct{})
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
+	defer signal.Stop(sigCh)
+	go func() {
+		<-sigCh
+		close(stop)
+	}()
+
+	var w io.Writer = os.Stdout
+	if sse {
+		w = &sseWriter{w: os.Stdout}
+	}
+	return followLog(path, offset, w, stop)
+}
+
+// sseWriter reframes NDJSON lines as server-sent events named by their
+// type, holding back a partial line until its newline arrives.
+type sseWriter struct {
+	w       io.Writer
+	pending []byte
+}
+
+func (s *sseWriter) Write(p []byte) (int, error) {
+	s.pending = append(s.pending, p...)
+	for {
+		i := bytes.IndexByte(s.pending, '\n')
+		if i < 0 {
+			return len(p), nil
+		}
+		line := s.pending[:i]
+		var ev struct {
+			Type string `json:"type"`
+		}
+		if err := json.Unmarshal(line, &ev); err == nil && ev.Type != "" {
+			if _, err := fmt.Fprintf(s.w, "event: %s\ndata: %s\n\n", ev.Type, line); err != nil {
+				return len(p), err
+			}
+		}
+		s.pending = s.pending[i+1:]
+	}
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"slices"
+	"strconv"
+)
+
+// hookOptions are shell commands run at points of an app's lifecycle:
+// PreStart once the route is registered and before the command starts,
+// PostReady once the app's port first accepts connections, and PostStop
+// after the command has exited and the route is released.
+type hookOptions struct {
+	PreStart  string `yaml:"pre_start"`
+	PostReady string `yaml:"post_ready"`
+	PostStop  string `yaml:"post_stop"`
+}
+
+// runHook runs command with sh -c in dir, with the app's DEVWRAP_* env and
+// DEVWRAP_HOOK set to the hook name. Its output goes to stderr so it never
+// mixes with --json output.
+func runHook(hook, command, dir string, env []string) error {
+	if command == "" {
+		return nil
+	}
+	cmd := exec.Command("sh", "-c", command)
+	cmd.Dir = dir
+	// env is shared with the child and other hooks; never append in place.
+	cmd.Env = append(slices.Clip(env), "DEVWRAP_HOOK="+hook)
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%s hook %q: %w", hook, command, err)
+	}
+	return nil
+}
+
+// runPostStopHook runs the post-stop hook with DEVWRAP_EXIT_CODE set when
+// the exit status is known. Failures are only reported.
+func runPostStopHook(command, dir string, env []string, exitCode *int) {
+	if exitCode != nil {
+		env = append(slices.Clip(env), "DEVWRAP_EXIT_CODE="+strconv.Itoa(*exitCode))
+	}
+	if err := runHook("post-stop", command, dir, env); err != nil {
+		fmt.Fprintf(os.Stderr, "devwrap: %v\n", err)
+	}
+}
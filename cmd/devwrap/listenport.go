@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"devwrap/internal/devwrap"
+)
+
+// listenPortPollInterval is how often a starting child's process tree is
+// checked for listeners on a port other than its own.
+const listenPortPollInterval = time.Second
+
+// watchListenPort catches dev servers that ignore PORT and pick their own.
+// Until the app is ready on its leased port it looks at the ports the
+// child's process tree listens on. When the same single other port shows up
+// twice in a row, the route moves there via moved, or with a nil moved
+// (no --follow-port) only a warning is printed: the port may be a debugger,
+// HMR socket, or metrics port opened before PORT. Several ports always only
+// get a warning, since devwrap can't tell which one serves the app.
+func watchListenPort(name string, port func() int, childPID func() int, stop <-chan struct{}, moved func(int)) {
+	go func() {
+		ticker := time.NewTicker(listenPortPollInterval)
+		defer ticker.Stop()
+		var last []int
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+			}
+			leased := port()
+			if _, ok := probeReady(leased, ""); ok {
+				return
+			}
+			pid := childPID()
+			if pid == 0 {
+				continue
+			}
+			ports := childListenPorts(pid, leased)
+			if len(ports) == 0 || !slices.Equal(ports, last) {
+				last = ports
+				continue
+			}
+			if len(ports) > 1 || moved == nil {
+				warnListenPorts(name, leased, ports)
+				return
+			}
+			moved(ports[0])
+			return
+		}
+	}()
+}
+
+// childListenPorts lists the ports pid and its descendants listen on,
+// other than leased, in ascending order.
+func childListenPorts(pid, leased int) []int {
+	procs, err := devwrap.ReadProcesses()
+	if err != nil {
+		return nil
+	}
+	var pids []int
+	for _, node := range devwrap.ProcessTree(procs, pid) {
+		pids = append(pids, node.PID)
+	}
+	var out []int
+	for _, p := range devwrap.ListeningPorts(pids) {
+		if p != leased {
+			out = append(out, p)
+		}
+	}
+	slices.Sort(out)
+	return out
+}
+
+// moveAppPort points name's route at port, if pid still owns the lease and
+// no other app uses the port.
+func moveAppPort(name string, pid, port int) error {
+	return devwrap.WithStateLock(func(tx *devwrap.StateTx) error {
+		state, err := tx.Load()
+		if err != nil {
+			return err
+		}
+		app, ok := state.Apps[name]
+		if !ok || app.PID != pid {
+			return fmt.Errorf("app %q is no longer registered by this process", name)
+		}
+		for other, a := range state.Apps {
+			if other != name && a.Port == port {
+				return fmt.Errorf("port %d is already used by app %q", port, other)
+			}
+		}
+		app.Port = port
+		state.Apps[name] = app
+		if _, _, err := devwrap.ApplyRoutesViaAdmin(state); err != nil {
+			return err
+		}
+		return tx.Save(state)
+	})
+}
+
+func warnListenPorts(name string, leased int, ports []int) {
+	list := make([]string, len(ports))
+	for i, p := range ports {
+		list[i] = strconv.Itoa(p)
+	}
+	msg := fmt.Sprintf("%s is not listening on its port %d but on %s; its route gets no traffic. Pass the port to the command with @PORT, or register the right one with --port", name, leased, strings.Join(list, ", "))
+	if len(ports) == 1 {
+		msg += " (or --follow-port to route there)"
+	}
+	emitRunEvent(name, "port_mismatch", map[string]any{"leased_port": leased, "listen_ports": ports})
+	if !outputJSON {
+		fmt.Fprintf(os.Stderr, "warning: %s\n", msg)
+	}
+	notifyDesktop("devwrap: "+name+" is on the wrong port", msg)
+}
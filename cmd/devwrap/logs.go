@@ -0,0 +1,333 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"devwrap/internal/devwrap"
+)
+
+const (
+	logFollowInterval = 200 * time.Millisecond
+	defaultTailLines  = 20
+	maxAppLogSize     = 10 << 20
+	appLogBackups     = 3
+)
+
+// rotatingLog appends to an app log file and, once it would grow past
+// maxAppLogSize, shifts it to <name>.log.1 (keeping appLogBackups files).
+// Write never fails so a full disk cannot take the child's terminal output
+// down with it; logging simply stops.
+type rotatingLog struct {
+	mu     sync.Mutex
+	path   string
+	f      *os.File
+	size   int64
+	failed bool
+}
+
+func openRotatingLog(path string) (*rotatingLog, error) {
+	l := &rotatingLog{path: path}
+	if err := l.open(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+func (l *rotatingLog) open() error {
+	f, err := os.OpenFile(l.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	devwrap.ChownToInvoker(l.path)
+	l.f = f
+	l.size = info.Size()
+	return nil
+}
+
+func (l *rotatingLog) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.failed {
+		return len(p), nil
+	}
+	if l.size > 0 && l.size+int64(len(p)) > maxAppLogSize {
+		if err := l.rotate(); err != nil {
+			l.failed = true
+			return len(p), nil
+		}
+	}
+	n, err := l.f.Write(p)
+	l.size += int64(n)
+	if err != nil {
+		l.failed = true
+	}
+	return len(p), nil
+}
+
+func (l *rotatingLog) rotate() error {
+	l.f.Close()
+	for i := appLogBackups - 1; i >= 1; i-- {
+		_ = os.Rename(l.path+"."+strconv.Itoa(i), l.path+"."+strconv.Itoa(i+1))
+	}
+	if err := os.Rename(l.path, l.path+".1"); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return l.open()
+}
+
+func (l *rotatingLog) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.f.Close()
+}
+
+// childOutput returns the child's stdout and stderr: only the log for a
+// detached app, the inherited terminal when both are one (so prompts,
+// raw-mode input, and isatty checks keep working), else the redirected
+// output plus the app log. Without a usable log file the child just gets
+// stdout and stderr.
+func childOutput(opts runOptions) (stdout, stderr io.Writer, closeLog func()) {
+	if opts.LogFile == "" && isTerminal(os.Stdout) && isTerminal(os.Stderr) {
+		return os.Stdout, os.Stderr, func() {}
+	}
+	path := opts.LogFile
+	if path == "" {
+		var err error
+		if path, err = appLogPath(opts.Name); err != nil {
+			fmt.Fprintf(os.Stderr, "devwrap: not capturing output: %v\n", err)
+			return os.Stdout, os.Stderr, func() {}
+		}
+	}
+	log, err := openRotatingLog(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "devwrap: not capturing output: %v\n", err)
+		return os.Stdout, os.Stderr, func() {}
+	}
+	closeLog = func() { _ = log.Close() }
+	if opts.LogFile != "" {
+		return log, log, closeLog
+	}
+	return io.MultiWriter(os.Stdout, log), io.MultiWriter(os.Stderr, log), closeLog
+}
+
+// tailOffset returns the offset of the start of the last n lines of path, so
+// following can begin with a little context.
+func tailOffset(path string, n int) (int64, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	end := len(data)
+	if end > 0 && data[end-1] == '\n' {
+		end--
+	}
+	for i := 0; i < n; i++ {
+		idx := bytes.LastIndexByte(data[:end], '\n')
+		if idx < 0 {
+			return 0, nil
+		}
+		end = idx
+	}
+	return int64(end + 1), nil
+}
+
+// followLog copies path to w starting at offset and keeps polling for
+// appended data until stop is closed. A truncated or replaced file is read
+// again from the start.
+func followLog(path string, offset int64, w io.Writer, stop <-chan struct{}) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer func() { f.Close() }()
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+	ticker := time.NewTicker(logFollowInterval)
+	defer ticker.Stop()
+	for {
+		if _, err := io.Copy(w, f); err != nil {
+			return err
+		}
+		select {
+		case <-stop:
+			return nil
+		case <-ticker.C:
+		}
+		cur, err := f.Stat()
+		if err != nil {
+			return err
+		}
+		pos, err := f.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return err
+		}
+		onDisk, statErr := os.Stat(path)
+		switch {
+		case statErr == nil && !os.SameFile(cur, onDisk):
+			next, err := os.Open(path)
+			if err != nil {
+				continue
+			}
+			// Drain what was written before the file was replaced.
+			_, _ = io.Copy(w, f)
+			f.Close()
+			f = next
+		case cur.Size() < pos:
+			if _, err := f.Seek(0, io.SeekStart); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// runAttach streams the log of an app started with --detach until Ctrl-C,
+// which only detaches the viewer, or until the app exits.
+func runAttach(name string) error {
+	if outputJSON {
+		return errors.New("attach does not support --json")
+	}
+	state, err := devwrap.LoadLocalState()
+	if err != nil {
+		return err
+	}
+	app, ok := state.Apps[name]
+	if !ok {
+		return fmt.Errorf("app %q is not running", name)
+	}
+	if app.LogFile == "" {
+		return fmt.Errorf("app %q was not started with --detach; use `devwrap logs -f %s`", name, name)
+	}
+	offset, err := tailOffset(app.LogFile, defaultTailLines)
+	if err != nil {
+		return err
+	}
+
+	stop := make(chan struct{})
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
+	defer signal.Stop(sigCh)
+	exited := make(chan struct{})
+	go func() {
+		for devwrap.ProcessAlive(app.PID) {
+			time.Sleep(time.Second)
+		}
+		close(exited)
+	}()
+	detached := false
+	go func() {
+		select {
+		case <-sigCh:
+			detached = true
+		case <-exited:
+			// Give the final output a poll interval to land.
+			time.Sleep(2 * logFollowInterval)
+		}
+		close(stop)
+	}()
+
+	fmt.Fprintf(os.Stderr, "attached to %s (pid %d); Ctrl-C detaches\n", name, app.PID)
+	if err := followLog(app.LogFile, offset, os.Stdout, stop); err != nil {
+		return err
+	}
+	if detached {
+		fmt.Fprintf(os.Stderr, "detached from %s; it keeps running\n", name)
+	} else {
+		fmt.Fprintf(os.Stderr, "%s exited\n", name)
+	}
+	return nil
+}
+
+// appLogFile returns the log of name: the file recorded on its lease, or
+// <runtime>/logs/<name>.log for apps that are not running anymore.
+func appLogFile(name string) (string, error) {
+	if state, err := devwrap.LoadLocalState(); err == nil {
+		if app, ok := state.Apps[name]; ok && app.LogFile != "" {
+			return app.LogFile, nil
+		}
+	}
+	path, err := appLogPath(name)
+	if err != nil {
+		return "", err
+	}
+	if _, err := os.Stat(path); err != nil {
+		if os.IsNotExist(err) {
+			return "", fmt.Errorf("no logs for %q", name)
+		}
+		return "", err
+	}
+	return path, nil
+}
+
+// runLogs prints the captured output of an app, the last tail lines only
+// when tail > 0, and with follow keeps streaming until Ctrl-C.
+func runLogs(name string, tail int, follow bool) error {
+	if err := validateAppRef(name); err != nil {
+		return err
+	}
+	if tail < 0 {
+		return errors.New("--tail must be positive")
+	}
+	if follow && outputJSON {
+		return errors.New("--follow does not support --json")
+	}
+	path, err := appLogFile(name)
+	if err != nil {
+		return err
+	}
+	var offset int64
+	if tail > 0 {
+		if offset, err = tailOffset(path, tail); err != nil {
+			return err
+		}
+	}
+
+	if outputJSON {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		lines := strings.Split(strings.TrimSuffix(string(data[min(offset, int64(len(data))):]), "\n"), "\n")
+		if len(lines) == 1 && lines[0] == "" {
+			lines = []string{}
+		}
+		return emitJSON(map[string]any{"ok": true, "action": "logs", "name": name, "path": path, "lines": lines})
+	}
+	if !follow {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		if _, err := f.Seek(offset, io.SeekStart); err != nil {
+			return err
+		}
+		_, err = io.Copy(os.Stdout, f)
+		return err
+	}
+
+	stop := make(chan struct{})
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
+	defer signal.Stop(sigCh)
+	go func() {
+		<-sigCh
+		close(stop)
+	}()
+	return followLog(path, offset, os.Stdout, stop)
+}
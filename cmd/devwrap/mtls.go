@@ -0,0 +1,39 @@
+package main
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// resolveClientCA returns the absolute path of a PEM bundle holding at
+// least one CA certificate, for --require-client-cert.
+func resolveClientCA(path string) (string, error) {
+	if path == "" {
+		return "", nil
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	b, err := os.ReadFile(abs)
+	if err != nil {
+		return "", fmt.Errorf("--require-client-cert: %w", err)
+	}
+	for {
+		var block *pem.Block
+		block, b = pem.Decode(b)
+		if block == nil {
+			return "", fmt.Errorf("--require-client-cert: %s holds no PEM certificate", path)
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		if _, err := x509.ParseCertificate(block.Bytes); err != nil {
+			return "", fmt.Errorf("--require-client-cert: %s: %w", path, err)
+		}
+		return abs, nil
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+)
+
+// prefixColors cycles through bright ANSI colors, one per app.
+var prefixColors = []string{"36", "33", "32", "35", "34", "31", "96", "93", "92", "95"}
+
+// prefixWriterGroup multiplexes line-prefixed output from several apps onto
+// one stream, foreman-style: "<name> | <line>" with a per-app color when the
+// stream is a terminal. In --json mode app output goes to stderr so stdout
+// stays parseable.
+type prefixWriterGroup struct {
+	mu     sync.Mutex
+	dst    io.Writer
+	width  int
+	colors map[string]string
+}
+
+func newPrefixWriterGroup(names []string) *prefixWriterGroup {
+	dst := os.Stdout
+	if outputJSON {
+		dst = os.Stderr
+	}
+	g := &prefixWriterGroup{dst: dst, colors: map[string]string{}}
+	color := useColor(dst)
+	for i, name := range names {
+		g.width = max(g.width, len(name))
+		if color {
+			g.colors[name] = prefixColors[i%len(prefixColors)]
+		}
+	}
+	return g
+}
+
+// useColor reports whether f is a terminal and NO_COLOR is unset.
+func useColor(f *os.File) bool {
+	if os.Getenv("NO_COLOR") != "" || os.Getenv("TERM") == "dumb" {
+		return false
+	}
+	return isTerminal(f)
+}
+
+// isTerminal reports whether f is a terminal.
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+func (g *prefixWriterGroup) writer(name string) *prefixLineWriter {
+	return &prefixLineWriter{group: g, name: name}
+}
+
+func (g *prefixWriterGroup) line(name, text string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	prefix := fmt.Sprintf("%-*s |", g.width, name)
+	if c := g.colors[name]; c != "" {
+		prefix = "\x1b[" + c + "m" + prefix + "\x1b[0m"
+	}
+	fmt.Fprintf(g.dst, "%s %s\n", prefix, text)
+}
+
+func (g *prefixWriterGroup) linef(name, format string, args ...any) {
+	g.line(name, fmt.Sprintf(format, args...))
+}
+
+// prefixLineWriter buffers an app's output and emits it one prefixed line
+// at a time. Stdout and stderr share one writer, so exec copies both
+// through a single pipe.
+type prefixLineWriter struct {
+	group *prefixWriterGroup
+	name  string
+	mu    sync.Mutex
+	buf   []byte
+}
+
+func (w *prefixLineWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.buf = append(w.buf, p...)
+	for {
+		i := bytes.IndexByte(w.buf, '\n')
+		if i < 0 {
+			break
+		}
+		w.group.line(w.name, strings.TrimSuffix(string(w.buf[:i]), "\r"))
+		w.buf = w.buf[i+1:]
+	}
+	return len(p), nil
+}
+
+func (w *prefixLineWriter) flush() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if len(w.buf) > 0 {
+		w.group.line(w.name, string(w.buf))
+		w.buf = nil
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"devwrap/internal/devwrap"
+)
+
+// notifyEnv turns desktop notifications off when set to a false value
+// (DEVWRAP_NOTIFY=0).
+const notifyEnv = "DEVWRAP_NOTIFY"
+
+// ciRun is set by --ci or DEVWRAP_NONINTERACTIVE, which also silence
+// notifications. Unlike nonInteractive it stays false in detached apps,
+// which are the ones that most need to report a crash.
+var ciRun bool
+
+// proxyDownNotifyEvery keeps every app watching the same dead proxy from
+// raising its own notification.
+const proxyDownNotifyEvery = time.Minute
+
+func notificationsEnabled() bool {
+	if ciRun {
+		return false
+	}
+	v := strings.TrimSpace(os.Getenv(notifyEnv))
+	if v == "" {
+		return true
+	}
+	on, err := strconv.ParseBool(v)
+	return err != nil || on
+}
+
+// notifyAppExited reports a child that exited on its own with a failure
+// and is not being restarted.
+func notifyAppExited(name string, err error) {
+	body := err.Error()
+	if code, ok := childExitCode(err); ok {
+		body = fmt.Sprintf("exited with status %d", code)
+	}
+	notifyDesktop("devwrap: "+name+" stopped", body)
+}
+
+// notifyProxyDown reports that the Caddy admin API stopped answering, at
+// most once per proxyDownNotifyEvery across all devwrap processes.
+func notifyProxyDown(detail string) {
+	if !notificationsEnabled() {
+		return
+	}
+	if dir, err := devwrap.RuntimeDir(); err == nil {
+		stamp := filepath.Join(dir, devwrap.ProxyDownNotified)
+		if info, err := os.Stat(stamp); err == nil && time.Since(info.ModTime()) < proxyDownNotifyEvery {
+			return
+		}
+		if f, err := os.OpenFile(stamp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o600); err == nil {
+			f.Close()
+			devwrap.ChownToInvoker(stamp)
+		}
+	}
+	notifyDesktop("devwrap: proxy unreachable", detail)
+}
+
+// notifyDesktop shows a native notification: osascript on macOS,
+// notify-send elsewhere. It is best effort; without a notifier or a
+// desktop session nothing happens.
+func notifyDesktop(title, body string) {
+	if !notificationsEnabled() {
+		return
+	}
+	var name string
+	var args []string
+	switch runtime.GOOS {
+	case "darwin":
+		name = "osascript"
+		args = []string{"-e", "display notification " + appleScriptString(body) + " with title " + appleScriptString(title)}
+	default:
+		name = "notify-send"
+		args = []string{"--app-name=devwrap", title, body}
+	}
+	if _, err := exec.LookPath(name); err != nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	_ = exec.CommandContext(ctx, name, args...).Run()
+}
+
+func appleScriptString(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
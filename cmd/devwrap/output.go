@@ -1,14 +1,113 @@
 package main
 
 import (
+	"bytes"
+	_ "embed"
 	"encoding/json"
+	"fmt"
 	"os"
+	"strings"
+	"text/template"
+
+	"gopkg.in/yaml.v3"
 )
 
 var outputJSON bool
 
-func emitJSON(v any) error {
+// jsonSchemaVersion is stamped on every --json payload as schema_version.
+// Within a version fields are only ever added; renaming, removing, or
+// retyping one bumps it. `devwrap schema` prints the matching schema.
+const jsonSchemaVersion = 1
+
+//go:embed assets/schema.json
+var jsonSchema []byte
+
+// emitJSON writes one --json payload. Every payload is an object with ok,
+// schema_version, and (except for errors) action naming its shape.
+func emitJSON(v map[string]any) error {
+	v["schema_version"] = jsonSchemaVersion
 	enc := json.NewEncoder(os.Stdout)
 	enc.SetEscapeHTML(false)
 	return enc.Encode(v)
 }
+
+// outputFormat is the -o of ls, proxy status, and doctor: the default
+// table, or the --json payload as JSON, YAML, or through a Go template.
+type outputFormat struct {
+	kind string // "table", "json", "yaml", or "go-template"
+	tmpl *template.Template
+}
+
+func parseOutputFormat(raw string) (outputFormat, error) {
+	switch raw {
+	case "", "table":
+		return outputFormat{kind: "table"}, nil
+	case "json", "yaml":
+		return outputFormat{kind: raw}, nil
+	}
+	if text, ok := strings.CutPrefix(raw, "go-template="); ok {
+		tmpl, err := template.New("output").Option("missingkey=error").Parse(text)
+		if err != nil {
+			return outputFormat{}, fmt.Errorf("invalid -o go-template: %w", err)
+		}
+		return outputFormat{kind: "go-template", tmpl: tmpl}, nil
+	}
+	return outputFormat{}, fmt.Errorf("invalid -o %q (use table, json, yaml, or go-template='{{...}}')", raw)
+}
+
+// structured reports whether the payload is printed instead of the table;
+// --json means -o json.
+func (f outputFormat) structured() bool {
+	return f.kind != "table" || outputJSON
+}
+
+// emit prints payload in f. YAML and templates see the payload as its JSON
+// decodes, so keys are the --json field names (.apps, .https_url).
+func (f outputFormat) emit(payload map[string]any) error {
+	if f.kind == "table" || f.kind == "json" {
+		return emitJSON(payload)
+	}
+	payload["schema_version"] = jsonSchemaVersion
+	b, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	var data any
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	if err := dec.Decode(&data); err != nil {
+		return err
+	}
+	data = plainNumbers(data)
+	if f.kind == "yaml" {
+		out, err := yaml.Marshal(data)
+		if err != nil {
+			return err
+		}
+		_, err = os.Stdout.Write(out)
+		return err
+	}
+	return f.tmpl.Execute(os.Stdout, data)
+}
+
+// plainNumbers turns the json.Numbers of a decoded payload into int64 or
+// float64, so ports and byte counts don't print in exponent form.
+func plainNumbers(v any) any {
+	switch v := v.(type) {
+	case map[string]any:
+		for k, e := range v {
+			v[k] = plainNumbers(e)
+		}
+	case []any:
+		for i, e := range v {
+			v[i] = plainNumbers(e)
+		}
+	case json.Number:
+		if n, err := v.Int64(); err == nil {
+			return n
+		}
+		f, _ := v.Float64()
+		return f
+	}
+	return v
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+const defaultStopTimeout = 10 * time.Second
+
+// childSysProcAttr starts the child in its own process group so the whole
+// tree (npm/pnpm grandchildren included) can be signalled at once. When
+// devwrap is the terminal's foreground job, the child's group takes over the
+// terminal so it can still read input and receives Ctrl-C directly; the
+// second result reports that case.
+func childSysProcAttr() (*syscall.SysProcAttr, bool) {
+	fd := int(os.Stdin.Fd())
+	if pgrp, err := unix.IoctlGetInt(fd, unix.TIOCGPGRP); err == nil && pgrp == syscall.Getpgrp() {
+		return &syscall.SysProcAttr{Setpgid: true, Foreground: true, Ctty: fd}, true
+	}
+	return &syscall.SysProcAttr{Setpgid: true}, false
+}
+
+// reclaimTerminal makes devwrap's process group the terminal's foreground
+// group again after a foreground child is gone.
+func reclaimTerminal() {
+	signal.Ignore(syscall.SIGTTOU)
+	defer signal.Reset(syscall.SIGTTOU)
+	_ = unix.IoctlSetPointerInt(int(os.Stdin.Fd()), unix.TIOCSPGRP, syscall.Getpgrp())
+}
+
+func signalGroup(pgid int, sig syscall.Signal) {
+	_ = syscall.Kill(-pgid, sig)
+}
+
+func groupAlive(pgid int) bool {
+	err := syscall.Kill(-pgid, 0)
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
+
+// stopChild asks the child's process group to exit with SIGTERM, escalating
+// to SIGKILL after timeout, and returns the child's Wait result.
+func stopChild(cmd *exec.Cmd, done <-chan error, timeout time.Duration) error {
+	signalGroup(cmd.Process.Pid, syscall.SIGTERM)
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(timeout):
+		signalGroup(cmd.Process.Pid, syscall.SIGKILL)
+		return <-done
+	}
+}
+
+// reapGroup terminates whatever is left in the process group once the child
+// itself has exited: SIGTERM, then SIGKILL after timeout.
+func reapGroup(pgid int, timeout time.Duration) {
+	if !groupAlive(pgid) {
+		return
+	}
+	signalGroup(pgid, syscall.SIGTERM)
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		time.Sleep(100 * time.Millisecond)
+		if !groupAlive(pgid) {
+			return
+		}
+	}
+	signalGroup(pgid, syscall.SIGKILL)
+}
+
+// interruptedFromTerminal reports whether a foreground child ended because
+// of Ctrl-C or Ctrl-\, which devwrap does not see itself.
+func interruptedFromTerminal(err error) bool {
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) {
+		return false
+	}
+	status, ok := exitErr.Sys().(syscall.WaitStatus)
+	if !ok {
+		return false
+	}
+	if status.Signaled() {
+		return status.Signal() == syscall.SIGINT || status.Signal() == syscall.SIGQUIT
+	}
+	return status.ExitStatus() == 130
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"devwrap/internal/devwrap"
+)
+
+// projectAppName returns the registration name for name in project:
+// "<name>.<project>", so "web" from two repos registers as web.shop and
+// web.blog with hosts web.shop.localhost and web.blog.localhost.
+func projectAppName(name, project string) (string, error) {
+	if err := devwrap.ValidateName(name); err != nil {
+		return "", err
+	}
+	if project == "" {
+		return name, nil
+	}
+	if err := validateProject(project); err != nil {
+		return "", err
+	}
+	return name + "." + project, nil
+}
+
+// deriveAppName names an app run without --name after the git repository
+// dir belongs to, or dir itself outside a repository: "My_Shop" becomes
+// "my-shop". from describes the source for messages.
+func deriveAppName(dir string) (name, from string, err error) {
+	if dir == "" {
+		if dir, err = os.Getwd(); err != nil {
+			return "", "", err
+		}
+	}
+	from = "directory " + dir
+	base := filepath.Base(dir)
+	for d := dir; ; d = filepath.Dir(d) {
+		// .git is a file in worktrees and submodules.
+		if _, err := os.Stat(filepath.Join(d, ".git")); err == nil {
+			from, base = "git repository "+d, filepath.Base(d)
+			break
+		}
+		if filepath.Dir(d) == d {
+			break
+		}
+	}
+	name = sanitizeName(base)
+	if devwrap.ValidateName(name) != nil {
+		return "", "", fmt.Errorf("cannot derive an app name from %s; pass --name", from)
+	}
+	return name, from, nil
+}
+
+// sanitizeName lowercases s and turns every run of characters validateName
+// rejects into one dash.
+func sanitizeName(s string) string {
+	var b strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(s) {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') {
+			if dash && b.Len() > 0 {
+				b.WriteByte('-')
+			}
+			b.WriteRune(r)
+			dash = false
+			continue
+		}
+		dash = true
+	}
+	return b.String()
+}
+
+func validateProject(project string) error {
+	if err := devwrap.ValidateName(project); err != nil {
+		return fmt.Errorf("invalid project %q: %w", project, err)
+	}
+	return nil
+}
+
+// validateAppRef accepts the name of a registered app: a plain name or a
+// project-qualified "<name>.<project>".
+func validateAppRef(ref string) error {
+	name, project, ok := strings.Cut(ref, ".")
+	if err := devwrap.ValidateName(name); err != nil {
+		return err
+	}
+	if ok {
+		return validateProject(project)
+	}
+	return nil
+}
+
+func filterAppsByProject(apps []devwrap.App, project string) []devwrap.App {
+	out := []devwrap.App{}
+	for _, app := range apps {
+		if app.Project == project {
+			out = append(out, app)
+		}
+	}
+	return out
+}
+
+func filterHistoryByProject(history []devwrap.ExitedApp, project string) []devwrap.ExitedApp {
+	var out []devwrap.ExitedApp
+	for _, h := range history {
+		if h.Project == project {
+			out = append(out, h)
+		}
+	}
+	return out
+}
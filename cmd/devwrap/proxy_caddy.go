@@ -7,16 +7,47 @@ import (
 	"os/user"
 	"path/filepath"
 	"runtime"
+	"strings"
 	"time"
 
 	"github.com/caddyserver/caddy/v2"
 	_ "github.com/caddyserver/caddy/v2/modules/standard"
+
+	"devwrap/internal/devwrap"
 )
 
+// otlpEndpointEnv points the embedded Caddy's tracing handlers (routes
+// registered with --trace) at an OTLP/gRPC collector, e.g.
+// http://localhost:4317 for a local Jaeger or Tempo.
+const otlpEndpointEnv = "DEVWRAP_OTLP_ENDPOINT"
+
+// configureTraceExporter hands DEVWRAP_OTLP_ENDPOINT to the OpenTelemetry
+// exporter Caddy's tracing handler builds from the OTEL_* environment. A
+// bare host:port is taken as plaintext, as local collectors usually are.
+func configureTraceExporter() error {
+	endpoint := strings.TrimSpace(os.Getenv(otlpEndpointEnv))
+	if endpoint == "" {
+		return nil
+	}
+	if !strings.Contains(endpoint, "://") {
+		endpoint = "http://" + endpoint
+	}
+	if err := os.Setenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT", endpoint); err != nil {
+		return err
+	}
+	if os.Getenv("OTEL_SERVICE_NAME") == "" {
+		return os.Setenv("OTEL_SERVICE_NAME", "devwrap")
+	}
+	return nil
+}
+
 func startEmbeddedCaddy(httpPort, httpsPort int) error {
+	if err := configureTraceExporter(); err != nil {
+		return err
+	}
 	storageRoot := sharedCaddyStorageRoot()
 	cfg := map[string]any{
-		"admin": map[string]any{"listen": "127.0.0.1:2019"},
+		"admin": map[string]any{"listen": devwrap.CaddyAdmin.Listen},
 		"storage": map[string]any{
 			"module": "file_system",
 			"root":   storageRoot,
@@ -38,12 +69,21 @@ func startEmbeddedCaddy(httpPort, httpsPort int) error {
 			"tls": map[string]any{
 				"automation": map[string]any{
 					"policies": []map[string]any{{
-						"issuers": []map[string]any{{"module": "internal"}},
+						"issuers": []map[string]any{devwrap.InternalIssuer()},
 					}},
 				},
 			},
 		},
 	}
+	if devwrap.OwnCAEnabled() {
+		caCfg, err := devwrap.OwnCAConfig()
+		if err != nil {
+			return err
+		}
+		cfg["apps"].(map[string]any)["pki"] = map[string]any{
+			"certificate_authorities": map[string]any{devwrap.DevwrapCAID: caCfg},
+		}
+	}
 	b, err := json.Marshal(cfg)
 	if err != nil {
 		return err
@@ -0,0 +1,297 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"devwrap/internal/devwrap"
+)
+
+// routeDiff is a devwrap route whose live config differs from the one state
+// wants. Live is nil when the route is missing, Desired when it is orphaned.
+type routeDiff struct {
+	Server  string `json:"server"`
+	ID      string `json:"id"`
+	Live    any    `json:"live"`
+	Desired any    `json:"desired"`
+}
+
+// runProxyConfig prints the config devwrap maintains in Caddy: every object
+// it owns by default, Caddy's whole config with all, or how the live routes
+// differ from what state wants with diff.
+func runProxyConfig(all, diff bool) error {
+	if !devwrap.CheckSystemCaddyReachable() {
+		return errors.New("proxy is not running")
+	}
+	if diff {
+		return runProxyConfigDiff()
+	}
+	cfg, err := devwrap.FetchCaddyConfig()
+	if err != nil {
+		return err
+	}
+	if all {
+		if outputJSON {
+			return emitJSON(map[string]any{"ok": true, "action": "proxy_config", "config": cfg})
+		}
+		return printIndentedJSON(cfg)
+	}
+	owned := map[string]any{}
+	collectDevwrapObjects(cfg, "/config", owned)
+	if outputJSON {
+		return emitJSON(map[string]any{"ok": true, "action": "proxy_config", "objects": owned})
+	}
+	if len(owned) == 0 {
+		fmt.Println("caddy has no devwrap config")
+		return nil
+	}
+	paths := make([]string, 0, len(owned))
+	for p := range owned {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	for _, p := range paths {
+		fmt.Printf("# %s\n", p)
+		if err := printIndentedJSON(owned[p]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// collectDevwrapObjects records, by config path, every object devwrap owns
+// in cfg: those with a devwrap-* @id (routes and TLS policies) and the
+// devwrap CA. Owned objects aren't searched further.
+func collectDevwrapObjects(v any, at string, out map[string]any) {
+	switch val := v.(type) {
+	case map[string]any:
+		if id, _ := val["@id"].(string); strings.HasPrefix(id, "devwrap-") {
+			out[at] = val
+			return
+		}
+		for k, child := range val {
+			p := path.Join(at, k)
+			if p == "/config/apps/pki/certificate_authorities/"+devwrap.DevwrapCAID {
+				out[p] = child
+				continue
+			}
+			collectDevwrapObjects(child, p, out)
+		}
+	case []any:
+		for i, child := range val {
+			collectDevwrapObjects(child, path.Join(at, strconv.Itoa(i)), out)
+		}
+	}
+}
+
+func runProxyConfigDiff() error {
+	state, err := devwrap.LoadLocalState()
+	if err != nil {
+		return err
+	}
+	devwrap.PruneDeadApps(&state)
+	routes, err := diffDevwrapRoutes(state)
+	if err != nil {
+		return err
+	}
+	problems, err := devwrap.VerifyProxyState(state)
+	if err != nil {
+		return err
+	}
+	var tls []devwrap.DriftProblem
+	seen := map[string]bool{}
+	for _, p := range problems {
+		// Apps sharing a wildcard subject each report it; show it once.
+		if p.Kind == "missing_tls_subject" && !seen[p.Detail] {
+			seen[p.Detail] = true
+			tls = append(tls, p)
+		}
+	}
+	inSync := len(routes) == 0 && len(tls) == 0
+	if outputJSON {
+		if routes == nil {
+			routes = []routeDiff{}
+		}
+		if tls == nil {
+			tls = []devwrap.DriftProblem{}
+		}
+		return emitJSON(map[string]any{"ok": true, "action": "proxy_config_diff", "in_sync": inSync, "routes": routes, "tls": tls})
+	}
+	if inSync {
+		fmt.Println("caddy matches devwrap state")
+		return nil
+	}
+	for _, d := range routes {
+		fmt.Printf("--- live %s %s\n+++ desired %s %s\n", d.Server, d.ID, d.Server, d.ID)
+		for _, line := range diffHunks(diffLines(indentedJSONLines(d.Live), indentedJSONLines(d.Desired))) {
+			fmt.Println(line)
+		}
+	}
+	for _, p := range tls {
+		fmt.Println(p.Detail)
+	}
+	return nil
+}
+
+// diffDevwrapRoutes compares each server's live devwrap routes with the
+// ones state wants, as VerifyProxyState does, keeping both sides of every
+// difference.
+func diffDevwrapRoutes(state devwrap.DaemonState) ([]routeDiff, error) {
+	servers, err := devwrap.FetchExternalServers()
+	if err != nil {
+		return nil, err
+	}
+	_, httpsPort, httpName, httpsName, err := devwrap.ParseExternalServers(servers)
+	if err != nil {
+		return nil, err
+	}
+	serverNames := []string{httpName}
+	if httpsName != "" && httpsName != httpName {
+		serverNames = append(serverNames, httpsName)
+	}
+
+	var diffs []routeDiff
+	for _, serverName := range serverNames {
+		redirectPort := 0
+		if serverName != httpsName {
+			redirectPort = httpsPort
+		}
+		desired := map[string]any{}
+		for _, route := range devwrap.MakeDevwrapRoutes(state, redirectPort) {
+			id, _ := route["@id"].(string)
+			desired[id] = route
+		}
+		live := map[string]any{}
+		routes, _ := servers[serverName]["routes"].([]any)
+		for _, route := range routes {
+			if id := devwrap.DevwrapRouteID(route); id != "" {
+				live[id] = route
+			}
+		}
+		ids := make([]string, 0, len(desired)+len(live))
+		for id := range desired {
+			ids = append(ids, id)
+		}
+		for id := range live {
+			if _, ok := desired[id]; !ok {
+				ids = append(ids, id)
+			}
+		}
+		sort.Strings(ids)
+		for _, id := range ids {
+			if !devwrap.SameJSON(live[id], desired[id]) {
+				diffs = append(diffs, routeDiff{Server: serverName, ID: id, Live: live[id], Desired: desired[id]})
+			}
+		}
+	}
+	return diffs, nil
+}
+
+// indentedJSON renders v for reading: indented, and without escaping the
+// HTML in devwrap's starting page.
+func indentedJSON(v any) (string, error) {
+	var b strings.Builder
+	enc := json.NewEncoder(&b)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(b.String(), "\n"), nil
+}
+
+func printIndentedJSON(v any) error {
+	s, err := indentedJSON(v)
+	if err != nil {
+		return err
+	}
+	fmt.Println(s)
+	return nil
+}
+
+// indentedJSONLines renders v as indented JSON lines; nil has none.
+func indentedJSONLines(v any) []string {
+	if v == nil {
+		return nil
+	}
+	s, err := indentedJSON(v)
+	if err != nil {
+		return nil
+	}
+	return strings.Split(s, "\n")
+}
+
+// diffContext is how many unchanged lines diffHunks keeps around a change.
+const diffContext = 3
+
+// diffHunks trims a diffLines result to the changed lines and their
+// context, separating hunks with "@@".
+func diffHunks(lines []string) []string {
+	keep := make([]bool, len(lines))
+	for i, line := range lines {
+		if strings.HasPrefix(line, " ") {
+			continue
+		}
+		for j := max(0, i-diffContext); j <= min(len(lines)-1, i+diffContext); j++ {
+			keep[j] = true
+		}
+	}
+	var out []string
+	for i, line := range lines {
+		if !keep[i] {
+			continue
+		}
+		if i > 0 && !keep[i-1] {
+			out = append(out, "@@")
+		}
+		out = append(out, line)
+	}
+	return out
+}
+
+// diffLines is a line diff of a and b: lines only in a are prefixed "-",
+// lines only in b "+", and shared lines " ".
+func diffLines(a, b []string) []string {
+	// lcs[i][j] is the longest common subsequence of a[i:] and b[j:].
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+	var out []string
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] == b[j]:
+			out = append(out, " "+a[i])
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			out = append(out, "-"+a[i])
+			i++
+		default:
+			out = append(out, "+"+b[j])
+			j++
+		}
+	}
+	for ; i < len(a); i++ {
+		out = append(out, "-"+a[i])
+	}
+	for ; j < len(b); j++ {
+		out = append(out, "+"+b[j])
+	}
+	return out
+}
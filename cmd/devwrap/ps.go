@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"devwrap/internal/devwrap"
+)
+
+const psSampleInterval = 500 * time.Millisecond
+
+func runPs() error {
+	state, err := devwrap.LoadLocalState()
+	if err != nil {
+		return err
+	}
+	var apps []devwrap.App
+	for _, app := range state.Apps {
+		if devwrap.ProcessAlive(app.PID) {
+			apps = append(apps, app)
+		}
+	}
+	apps = sortedApps(apps)
+
+	results := []devwrap.AppResources{}
+	if len(apps) > 0 {
+		before, err := devwrap.ReadProcesses()
+		if err != nil {
+			return err
+		}
+		time.Sleep(psSampleInterval)
+		after, err := devwrap.ReadProcesses()
+		if err != nil {
+			return err
+		}
+		for _, app := range apps {
+			results = append(results, app.SampleResources(before, after, psSampleInterval))
+		}
+	}
+
+	if outputJSON {
+		return emitJSON(map[string]any{"ok": true, "action": "ps", "apps": results})
+	}
+	if len(results) == 0 {
+		fmt.Println("no apps running")
+		return nil
+	}
+	for i, r := range results {
+		if i > 0 {
+			fmt.Println()
+		}
+		uptime := time.Duration(r.UptimeSeconds) * time.Second
+		fmt.Printf("%s (port %d, up %s)  cpu %.1f%%  rss %s\n", r.Name, r.Port, uptime, r.CPUPercent, formatBytes(r.RSSBytes))
+		for _, p := range r.Processes {
+			fmt.Printf("  %s%-7d %-20s %5.1f%% %10s\n", strings.Repeat("  ", p.Depth), p.PID, p.Command, p.CPUPercent, formatBytes(p.RSSBytes))
+		}
+	}
+	return nil
+}
+
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"devwrap/internal/devwrap"
+)
+
+// whenReady calls fn in the background once port first accepts TCP
+// connections, or answers 200 on path when set, unless stop is closed first.
+// port is read on every poll, since the route may move to the port the app
+// really listens on. fn gets the loopback host that answered.
+func whenReady(port func() int, path string, stop <-chan struct{}, fn func(host string)) {
+	go func() {
+		ticker := time.NewTicker(devwrap.ReadyPollInterval)
+		defer ticker.Stop()
+		for {
+			if host, ok := probeReady(port(), path); ok {
+				fn(host)
+				return
+			}
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// markAppReady clears the app's Starting flag so its route stops holding
+// requests and a later crash shows the starting page right away. A
+// non-empty dialHost also points the route at that loopback host.
+func markAppReady(name string, pid int, dialHost string) {
+	_, err := devwrap.UpdateAppDirect(name, func(a *devwrap.App) error {
+		if a.PID != pid {
+			return fmt.Errorf("app %q is now owned by pid %d", name, a.PID)
+		}
+		a.Starting = false
+		if dialHost != "" {
+			a.DialHost = dialHost
+		}
+		return nil
+	})
+	if err != nil && !outputJSON {
+		fmt.Fprintf(os.Stderr, "devwrap: %v\n", err)
+	}
+}
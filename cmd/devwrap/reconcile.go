@@ -0,0 +1,283 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"devwrap/internal/devwrap"
+)
+
+const (
+	routeCheckInterval = 5 * time.Second
+	// deadAppSweepInterval is how often the managed daemon prunes apps
+	// whose owners died without releasing their routes.
+	deadAppSweepInterval = 15 * time.Second
+)
+
+// reapplyIfRouteMissing re-publishes all live routes when the app's own route
+// has disappeared from either Caddy server (e.g. after `caddy reload` with
+// another config).
+// It is a no-op unless the app is still leased to pid.
+func reapplyIfRouteMissing(name string, pid int) (bool, error) {
+	ok, err := devwrap.AppRoutePresent(name)
+	if err != nil || ok {
+		return false, err
+	}
+	reapplied := false
+	err = devwrap.WithStateLock(func(tx *devwrap.StateTx) error {
+		state, err := tx.Load()
+		if err != nil {
+			return err
+		}
+		app, ok := state.Apps[name]
+		if !ok || app.PID != pid {
+			return nil
+		}
+		devwrap.PruneDeadApps(&state)
+		if _, _, err := devwrap.ApplyRoutesViaAdmin(state); err != nil {
+			return err
+		}
+		reapplied = true
+		return tx.Save(state)
+	})
+	return reapplied, err
+}
+
+// watchRoute periodically refreshes the app's lease heartbeat, checks that
+// its route is still present, and re-applies it when Caddy's config was
+// reset underneath a running app.
+func watchRoute(name string, pid int) (stop func()) {
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(routeCheckInterval)
+		defer ticker.Stop()
+		lastErr := ""
+		lastBeatErr := ""
+		reachable := true
+		var last devwrap.App
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				restored, err := devwrap.HeartbeatLease(name, pid, &last)
+				if !outputJSON {
+					switch {
+					case err != nil && err.Error() != lastBeatErr:
+						fmt.Fprintf(os.Stderr, "devwrap: %v\n", err)
+					case restored:
+						fmt.Fprintf(os.Stderr, "devwrap: lease for %s had gone stale; re-registered\n", name)
+					}
+				}
+				lastBeatErr = ""
+				if err != nil {
+					lastBeatErr = err.Error()
+				}
+				if !devwrap.CheckSystemCaddyReachable() {
+					if reachable {
+						notifyProxyDown(fmt.Sprintf("the caddy admin API stopped answering; %s is unreachable until it is back", name))
+					}
+					reachable = false
+					continue
+				}
+				reachable = true
+				reapplied, err := reapplyIfRouteMissing(name, pid)
+				if outputJSON {
+					continue
+				}
+				if err != nil {
+					if err.Error() != lastErr {
+						fmt.Fprintf(os.Stderr, "devwrap: route check for %s failed: %v\n", name, err)
+					}
+					lastErr = err.Error()
+					continue
+				}
+				lastErr = ""
+				if reapplied {
+					fmt.Fprintf(os.Stderr, "devwrap: route for %s was missing from caddy; re-applied\n", name)
+				}
+			}
+		}
+	}()
+	return func() { close(done) }
+}
+
+// sweepDeadApps periodically prunes dead apps and drops their routes, so a
+// crashed app's route goes away even when no devwrap command runs.
+func sweepDeadApps() (stop func()) {
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(deadAppSweepInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+			pruned, err := devwrap.PruneDeadAppsDirect()
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "devwrap: dead app sweep failed: %v\n", err)
+				continue
+			}
+			if len(pruned) > 0 {
+				fmt.Fprintf(os.Stderr, "devwrap: pruned dead apps: %s\n", strings.Join(pruned, ", "))
+			}
+		}
+	}()
+	return func() { close(done) }
+}
+
+type syncResult struct {
+	Pruned   []string               `json:"pruned"`
+	Problems []devwrap.DriftProblem `json:"problems"`
+	Applied  bool                   `json:"applied"`
+}
+
+// syncOnce prunes dead apps from state and re-applies routes if state and
+// Caddy have drifted apart.
+func syncOnce() (syncResult, error) {
+	result := syncResult{Pruned: []string{}, Problems: []devwrap.DriftProblem{}}
+	err := devwrap.WithStateLock(func(tx *devwrap.StateTx) error {
+		state, err := tx.Load()
+		if err != nil {
+			return err
+		}
+		if pruned := devwrap.PruneDeadApps(&state); pruned != nil {
+			result.Pruned = pruned
+		}
+		problems, err := devwrap.VerifyProxyState(state)
+		if err != nil {
+			return err
+		}
+		if problems != nil {
+			result.Problems = problems
+		}
+		if len(result.Pruned) == 0 && len(problems) == 0 {
+			return nil
+		}
+		if _, _, err := devwrap.ApplyRoutesViaAdmin(state); err != nil {
+			return err
+		}
+		result.Applied = true
+		return tx.Save(state)
+	})
+	return result, err
+}
+
+func runProxySync(watch bool, interval time.Duration) error {
+	if interval <= 0 {
+		return errors.New("--interval must be positive")
+	}
+	if !watch {
+		if !devwrap.CheckSystemCaddyReachable() {
+			return errors.New("proxy is not running")
+		}
+		result, err := syncOnce()
+		if err != nil {
+			return err
+		}
+		if outputJSON {
+			return emitJSON(map[string]any{"ok": true, "action": "proxy_sync", "result": result})
+		}
+		printSyncResult(result)
+		if !result.Applied {
+			fmt.Println("proxy config already in sync")
+		}
+		return nil
+	}
+
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(quit)
+
+	if !outputJSON {
+		fmt.Printf("watching devwrap routes every %s (ctrl-c to stop)\n", interval)
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	lastErr := ""
+	for {
+		if devwrap.CheckSystemCaddyReachable() {
+			result, err := syncOnce()
+			switch {
+			case err != nil:
+				if err.Error() != lastErr {
+					if outputJSON {
+						_ = emitJSON(map[string]any{"ok": false, "action": "proxy_sync", "error": err.Error()})
+					} else {
+						fmt.Fprintf(os.Stderr, "sync failed: %v\n", err)
+					}
+				}
+				lastErr = err.Error()
+			case result.Applied:
+				lastErr = ""
+				if outputJSON {
+					_ = emitJSON(map[string]any{"ok": true, "action": "proxy_sync", "time": time.Now().UTC().Format(time.RFC3339), "result": result})
+				} else {
+					printSyncResult(result)
+				}
+			default:
+				lastErr = ""
+			}
+		}
+		select {
+		case <-quit:
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// runProxyReload unconditionally re-publishes routes, TLS policy, and CA
+// config from state.json, e.g. after Caddy was reloaded with a config that
+// wiped devwrap's routes. Unlike sync it does not wait for verify to find
+// drift first.
+func runProxyReload() error {
+	if !devwrap.CheckSystemCaddyReachable() {
+		return errors.New("proxy is not running")
+	}
+	var pruned []string
+	apps := 0
+	err := devwrap.WithStateLock(func(tx *devwrap.StateTx) error {
+		state, err := tx.Load()
+		if err != nil {
+			return err
+		}
+		pruned = devwrap.PruneDeadApps(&state)
+		if _, _, err := devwrap.ApplyRoutesViaAdmin(state); err != nil {
+			return err
+		}
+		apps = len(state.Apps)
+		return tx.Save(state)
+	})
+	if err != nil {
+		return err
+	}
+	if pruned == nil {
+		pruned = []string{}
+	}
+	if outputJSON {
+		return emitJSON(map[string]any{"ok": true, "action": "proxy_reload", "apps": apps, "pruned": pruned})
+	}
+	for _, name := range pruned {
+		fmt.Printf("pruned exited app %s\n", name)
+	}
+	fmt.Printf("re-applied routes and TLS policy for %d app(s)\n", apps)
+	return nil
+}
+
+func printSyncResult(result syncResult) {
+	ts := time.Now().Format("15:04:05")
+	for _, name := range result.Pruned {
+		fmt.Printf("%s pruned exited app %s\n", ts, name)
+	}
+	for _, p := range result.Problems {
+		fmt.Printf("%s fixed %s: %s\n", ts, p.Kind, p.Detail)
+	}
+}
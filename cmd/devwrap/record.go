@@ -0,0 +1,470 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+	"unicode/utf8"
+
+	"devwrap/internal/devwrap"
+)
+
+// recordingsKept is how many HAR files the recordings directory keeps;
+// older ones are removed when a new recording is saved there.
+const recordingsKept = 20
+
+type recordOptions struct {
+	Output    string
+	BodyLimit int64
+	Duration  time.Duration
+}
+
+// runRecord routes an app through a recording hop until Ctrl-C (or
+// Duration), then restores the route and writes the exchanges as a HAR file.
+func runRecord(name string, opts recordOptions) error {
+	if err := validateAppRef(name); err != nil {
+		return err
+	}
+	if !devwrap.CheckSystemCaddyReachable() {
+		return errors.New("proxy is not running")
+	}
+	if devwrap.CaddyAdmin.Remote {
+		return errors.New("record needs caddy on this machine; a remote caddy can't reach the recording hop")
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return err
+	}
+	defer ln.Close()
+
+	var upstream devwrap.App
+	_, err = devwrap.UpdateAppDirect(name, func(a *devwrap.App) error {
+		if a.Recorder != nil && devwrap.ProcessAlive(a.Recorder.PID) {
+			return fmt.Errorf("app %q is already being recorded (pid %d)", name, a.Recorder.PID)
+		}
+		if a.UpstreamTLS {
+			return fmt.Errorf("app %q uses --upstream-tls; record only proxies plain HTTP", name)
+		}
+		upstream = *a
+		a.Recorder = &devwrap.RecorderHop{PID: os.Getpid(), Dial: ln.Addr().String()}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	rec := &harRecorder{bodyLimit: opts.BodyLimit}
+	srv := &http.Server{Handler: rec.proxy(upstream.UpstreamAddress()), ReadHeaderTimeout: 10 * time.Second}
+	go func() { _ = srv.Serve(ln) }()
+	if !outputJSON {
+		fmt.Fprintf(os.Stderr, "recording %s (%s); press Ctrl-C to stop\n", name, upstream.HTTPSURL(localHTTPSPort()))
+	}
+
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(quit)
+	var timeout <-chan time.Time
+	if opts.Duration > 0 {
+		timeout = time.After(opts.Duration)
+	}
+	select {
+	case <-quit:
+	case <-timeout:
+	}
+
+	// Put the route back before the hop goes away so no request hits a
+	// closed port; an app released meanwhile has no route to restore.
+	_, restoreErr := devwrap.UpdateAppDirect(name, func(a *devwrap.App) error {
+		a.Recorder = nil
+		return nil
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_ = srv.Shutdown(ctx)
+
+	path, err := rec.save(name, opts.Output)
+	if err != nil {
+		return err
+	}
+	entries := rec.len()
+	if outputJSON {
+		return emitJSON(map[string]any{"ok": true, "action": "record", "name": name, "file": path, "entries": entries})
+	}
+	fmt.Printf("recorded %d request(s) to %s\n", entries, path)
+	if restoreErr != nil && !strings.Contains(restoreErr.Error(), "is not registered") {
+		fmt.Fprintf(os.Stderr, "warning: restoring %s's route failed: %v; run `devwrap proxy reload`\n", name, restoreErr)
+	}
+	return nil
+}
+
+// localHTTPSPort is the HTTPS port in state, for printing URLs.
+func localHTTPSPort() int {
+	state, err := devwrap.LoadLocalState()
+	if err != nil {
+		return 443
+	}
+	return state.HTTPSPort
+}
+
+// harRecorder collects exchanges proxied through the recording hop.
+type harRecorder struct {
+	bodyLimit int64
+
+	mu      sync.Mutex
+	entries []harEntry
+}
+
+func (r *harRecorder) len() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.entries)
+}
+
+func (r *harRecorder) add(e harEntry) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.entries = append(r.entries, e)
+}
+
+// proxy forwards to the app unchanged (Caddy already set the forwarding
+// headers) and records each exchange once its response body is done.
+func (r *harRecorder) proxy(dial string) http.Handler {
+	target := &url.URL{Scheme: "http", Host: dial}
+	rp := &httputil.ReverseProxy{
+		Rewrite: func(pr *httputil.ProxyRequest) {
+			pr.SetURL(target)
+			pr.Out.Host = pr.In.Host
+			ex := exchangeFrom(pr.In)
+			if pr.Out.Body != nil && pr.Out.Body != http.NoBody {
+				ex.reqBody = &capturedBody{ReadCloser: pr.Out.Body, limit: r.bodyLimit}
+				pr.Out.Body = ex.reqBody
+			}
+		},
+		// Flush at once so SSE and other streams aren't held back.
+		FlushInterval: -1,
+		ModifyResponse: func(res *http.Response) error {
+			ex := exchangeFrom(res.Request)
+			ex.waited = time.Since(ex.started)
+			ex.res = res
+			if res.StatusCode == http.StatusSwitchingProtocols {
+				// The upgraded connection must stay writable; record the
+				// handshake only.
+				r.add(ex.entry())
+				return nil
+			}
+			ex.resBody = &capturedBody{ReadCloser: res.Body, limit: r.bodyLimit, done: func() { r.add(ex.entry()) }}
+			res.Body = ex.resBody
+			return nil
+		},
+		ErrorHandler: func(w http.ResponseWriter, req *http.Request, err error) {
+			ex := exchangeFrom(req)
+			ex.waited = time.Since(ex.started)
+			ex.err = err
+			r.add(ex.entry())
+			http.Error(w, "devwrap record: "+err.Error(), http.StatusBadGateway)
+		},
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		ex := &exchange{req: req, started: time.Now()}
+		rp.ServeHTTP(w, req.WithContext(context.WithValue(req.Context(), exchangeKey{}, ex)))
+	})
+}
+
+type exchangeKey struct{}
+
+// exchange is one request in flight through the hop.
+type exchange struct {
+	req     *http.Request
+	started time.Time
+	waited  time.Duration
+	reqBody *capturedBody
+	resBody *capturedBody
+	res     *http.Response
+	err     error
+}
+
+func exchangeFrom(req *http.Request) *exchange {
+	return req.Context().Value(exchangeKey{}).(*exchange)
+}
+
+// capturedBody keeps up to limit bytes of a body as it is read, counts the
+// rest, and calls done once, when the body is closed.
+type capturedBody struct {
+	io.ReadCloser
+	limit int64
+	buf   bytes.Buffer
+	size  int64
+	done  func()
+	once  sync.Once
+}
+
+func (b *capturedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if room := b.limit - int64(b.buf.Len()); room > 0 {
+		b.buf.Write(p[:min(int64(n), room)])
+	}
+	b.size += int64(n)
+	return n, err
+}
+
+func (b *capturedBody) Close() error {
+	err := b.ReadCloser.Close()
+	if b.done != nil {
+		b.once.Do(b.done)
+	}
+	return err
+}
+
+// HAR 1.2 (http://www.softwareishard.com/blog/har-12-spec/), limited to the
+// fields devwrap can fill in. Sizes of -1 mean unknown.
+type harFile struct {
+	Log harLog `json:"log"`
+}
+
+type harLog struct {
+	Version string     `json:"version"`
+	Creator harCreator `json:"creator"`
+	Entries []harEntry `json:"entries"`
+}
+
+type harCreator struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+type harEntry struct {
+	StartedDateTime string      `json:"startedDateTime"`
+	Time            float64     `json:"time"`
+	Request         harRequest  `json:"request"`
+	Response        harResponse `json:"response"`
+	Cache           struct{}    `json:"cache"`
+	Timings         harTimings  `json:"timings"`
+	Comment         string      `json:"comment,omitempty"`
+
+	started time.Time
+}
+
+type harRequest struct {
+	Method      string         `json:"method"`
+	URL         string         `json:"url"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []harNameValue `json:"cookies"`
+	Headers     []harNameValue `json:"headers"`
+	QueryString []harNameValue `json:"queryString"`
+	PostData    *harPostData   `json:"postData,omitempty"`
+	HeadersSize int64          `json:"headersSize"`
+	BodySize    int64          `json:"bodySize"`
+}
+
+type harResponse struct {
+	Status      int            `json:"status"`
+	StatusText  string         `json:"statusText"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []harNameValue `json:"cookies"`
+	Headers     []harNameValue `json:"headers"`
+	Content     harContent     `json:"content"`
+	RedirectURL string         `json:"redirectURL"`
+	HeadersSize int64          `json:"headersSize"`
+	BodySize    int64          `json:"bodySize"`
+}
+
+type harNameValue struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type harPostData struct {
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text"`
+	Comment  string `json:"comment,omitempty"`
+}
+
+type harContent struct {
+	Size     int64  `json:"size"`
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text,omitempty"`
+	Encoding string `json:"encoding,omitempty"`
+	Comment  string `json:"comment,omitempty"`
+}
+
+type harTimings struct {
+	Send    float64 `json:"send"`
+	Wait    float64 `json:"wait"`
+	Receive float64 `json:"receive"`
+}
+
+// entry converts a finished exchange to HAR. The URL is the one the
+// browser used: Caddy passes the scheme in X-Forwarded-Proto.
+func (ex *exchange) entry() harEntry {
+	req := ex.req
+	scheme := req.Header.Get("X-Forwarded-Proto")
+	if scheme == "" {
+		scheme = "http"
+	}
+	u := url.URL{Scheme: scheme, Host: req.Host, Path: req.URL.Path, RawPath: req.URL.RawPath, RawQuery: req.URL.RawQuery}
+	total := time.Since(ex.started)
+	e := harEntry{
+		started:         ex.started,
+		StartedDateTime: ex.started.Format(time.RFC3339Nano),
+		Time:            millis(total),
+		Request: harRequest{
+			Method:      req.Method,
+			URL:         u.String(),
+			HTTPVersion: req.Proto,
+			Cookies:     harCookies(req.Cookies()),
+			Headers:     harHeaders(req.Header),
+			QueryString: harQuery(req.URL.Query()),
+			HeadersSize: -1,
+			BodySize:    0,
+		},
+		Response: harResponse{
+			Cookies:     []harNameValue{},
+			Headers:     []harNameValue{},
+			HeadersSize: -1,
+			BodySize:    -1,
+		},
+		Timings: harTimings{Wait: millis(ex.waited), Receive: millis(total - ex.waited)},
+	}
+	if ex.reqBody != nil {
+		text, _, comment := harBodyText(ex.reqBody)
+		e.Request.BodySize = ex.reqBody.size
+		e.Request.PostData = &harPostData{MimeType: req.Header.Get("Content-Type"), Text: text, Comment: comment}
+	}
+	if ex.err != nil {
+		e.Response.Status = http.StatusBadGateway
+		e.Response.StatusText = http.StatusText(http.StatusBadGateway)
+		e.Response.HTTPVersion = req.Proto
+		e.Response.Content = harContent{Size: -1, MimeType: "text/plain"}
+		e.Comment = "devwrap record: " + ex.err.Error()
+		return e
+	}
+	res := ex.res
+	e.Response.Status = res.StatusCode
+	e.Response.StatusText = http.StatusText(res.StatusCode)
+	e.Response.HTTPVersion = res.Proto
+	e.Response.Cookies = harCookies(res.Cookies())
+	e.Response.Headers = harHeaders(res.Header)
+	e.Response.RedirectURL = res.Header.Get("Location")
+	e.Response.Content = harContent{MimeType: res.Header.Get("Content-Type")}
+	if ex.resBody != nil {
+		e.Response.BodySize = ex.resBody.size
+		e.Response.Content.Size = ex.resBody.size
+		e.Response.Content.Text, e.Response.Content.Encoding, e.Response.Content.Comment = harBodyText(ex.resBody)
+	}
+	return e
+}
+
+// harBodyText returns the kept part of a body: as is when it's text,
+// base64 otherwise, noting when it was cut short.
+func harBodyText(b *capturedBody) (text, encoding, comment string) {
+	kept := b.buf.Bytes()
+	if int64(len(kept)) < b.size {
+		comment = fmt.Sprintf("truncated to %d of %d bytes", len(kept), b.size)
+	}
+	if utf8.Valid(kept) {
+		return string(kept), "", comment
+	}
+	return base64.StdEncoding.EncodeToString(kept), "base64", comment
+}
+
+func harHeaders(h http.Header) []harNameValue {
+	out := []harNameValue{}
+	for _, name := range slices.Sorted(maps.Keys(h)) {
+		for _, v := range h[name] {
+			out = append(out, harNameValue{Name: name, Value: v})
+		}
+	}
+	return out
+}
+
+func harQuery(q url.Values) []harNameValue {
+	out := []harNameValue{}
+	for _, name := range slices.Sorted(maps.Keys(q)) {
+		for _, v := range q[name] {
+			out = append(out, harNameValue{Name: name, Value: v})
+		}
+	}
+	return out
+}
+
+func harCookies(cookies []*http.Cookie) []harNameValue {
+	out := []harNameValue{}
+	for _, c := range cookies {
+		out = append(out, harNameValue{Name: c.Name, Value: c.Value})
+	}
+	return out
+}
+
+func millis(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
+
+// save writes the recording to output, or to a new file in the recordings
+// directory, which then keeps only the newest recordingsKept files.
+func (r *harRecorder) save(name, output string) (string, error) {
+	r.mu.Lock()
+	entries := append([]harEntry{}, r.entries...)
+	r.mu.Unlock()
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].started.Before(entries[j].started) })
+	b, err := json.MarshalIndent(harFile{Log: harLog{
+		Version: "1.2",
+		Creator: harCreator{Name: "devwrap", Version: fmt.Sprint(buildVersions()["devwrap"])},
+		Entries: entries,
+	}}, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	path := output
+	var dir string
+	if path == "" {
+		if dir, err = recordingsPath(); err != nil {
+			return "", err
+		}
+		path = filepath.Join(dir, name+"-"+time.Now().Format("20060102-150405")+".har")
+	}
+	if err := os.WriteFile(path, append(b, '\n'), 0o600); err != nil {
+		return "", err
+	}
+	devwrap.ChownToInvoker(path)
+	if dir != "" {
+		pruneRecordings(dir)
+	}
+	return path, nil
+}
+
+// pruneRecordings removes all but the newest recordingsKept HAR files.
+func pruneRecordings(dir string) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.har"))
+	if err != nil || len(files) <= recordingsKept {
+		return
+	}
+	modTime := func(path string) time.Time {
+		info, err := os.Stat(path)
+		if err != nil {
+			return time.Time{}
+		}
+		return info.ModTime()
+	}
+	sort.Slice(files, func(i, j int) bool { return modTime(files[i]).After(modTime(files[j])) })
+	for _, path := range files[recordingsKept:] {
+		_ = os.Remove(path)
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cenkalti/backoff/v5"
+)
+
+// restartPolicy decides whether runChild restarts a child that exited on its
+// own. The lease and route stay registered while it is restarted.
+type restartPolicy struct {
+	OnFailure bool
+	// Max caps restarts; 0 means unlimited.
+	Max int
+}
+
+// restartStableAfter resets the backoff once a child has stayed up this long.
+const restartStableAfter = time.Minute
+
+func parseRestartPolicy(raw string) (restartPolicy, error) {
+	switch {
+	case raw == "" || raw == "no":
+		return restartPolicy{}, nil
+	case raw == "on-failure":
+		return restartPolicy{OnFailure: true}, nil
+	case strings.HasPrefix(raw, "on-failure:"):
+		n, err := strconv.Atoi(strings.TrimPrefix(raw, "on-failure:"))
+		if err != nil || n <= 0 {
+			return restartPolicy{}, fmt.Errorf("invalid --restart %q: max restarts must be a positive number", raw)
+		}
+		return restartPolicy{OnFailure: true, Max: n}, nil
+	}
+	return restartPolicy{}, fmt.Errorf("invalid --restart %q (use no, on-failure, or on-failure:<max>)", raw)
+}
+
+// shouldRestart reports whether a child that exited with err after
+// `restarts` previous restarts should be started again.
+func (p restartPolicy) shouldRestart(err error, restarts int) bool {
+	if !p.OnFailure || err == nil {
+		return false
+	}
+	return p.Max == 0 || restarts < p.Max
+}
+
+func (p restartPolicy) attempts(n int) string {
+	if p.Max == 0 {
+		return strconv.Itoa(n)
+	}
+	return strconv.Itoa(n) + "/" + strconv.Itoa(p.Max)
+}
+
+func newRestartBackOff() *backoff.ExponentialBackOff {
+	bo := backoff.NewExponentialBackOff()
+	bo.InitialInterval = time.Second
+	bo.MaxInterval = 30 * time.Second
+	return bo
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/spf13/cobra"
+
+	"devwrap/internal/devwrap"
+)
+
+const runConfigFile = ".devwrap.toml"
+
+// runConfig is the single-app file committed to a repository, so that
+// `devwrap` with no flags runs the same app on the same route for everyone.
+// Explicit flags and a command after -- override it.
+type runConfig struct {
+	Name    string `toml:"name"`
+	Project string `toml:"project"`
+	Host    string `toml:"host"`
+	Path    string `toml:"path"`
+	Port    int    `toml:"port"`
+	// DialHost is --dial-host.
+	DialHost string `toml:"dial_host"`
+	// PortStrategy is --port-strategy; commit "hash" so the team agrees on
+	// ports.
+	PortStrategy string `toml:"port_strategy"`
+	// Cwd is relative to the config file; the command runs in the config
+	// file's directory by default.
+	Cwd      string            `toml:"cwd"`
+	Command  commandSpec       `toml:"command"`
+	Env      map[string]string `toml:"env"`
+	EnvFile  []string          `toml:"env_file"`
+	Ready    runReadyConfig    `toml:"ready"`
+	Restart  string            `toml:"restart"`
+	Watch    []string          `toml:"watch"`
+	Profiles []string          `toml:"profiles"`
+
+	dir string
+}
+
+// runReadyConfig holds the check that marks the app ready. Without HTTP the
+// app is ready once its port accepts TCP connections.
+type runReadyConfig struct {
+	HTTP string `toml:"http"`
+}
+
+// UnmarshalTOML accepts a string, run through `sh -c`, or an argv array.
+func (c *commandSpec) UnmarshalTOML(v any) error {
+	switch v := v.(type) {
+	case string:
+		if v == "" {
+			*c = nil
+			return nil
+		}
+		*c = commandSpec{"sh", "-c", v}
+		return nil
+	case []any:
+		list := make(commandSpec, 0, len(v))
+		for _, item := range v {
+			s, ok := item.(string)
+			if !ok {
+				return errors.New("command must be a string or an array of strings")
+			}
+			list = append(list, s)
+		}
+		*c = list
+		return nil
+	}
+	return errors.New("command must be a string or an array of strings")
+}
+
+// findRunConfig searches for .devwrap.toml from the working directory up
+// to the filesystem root. It returns "" when there is none.
+func findRunConfig() (string, error) {
+	dir, err := os.Getwd()
+	if err != nil {
+		return "", err
+	}
+	for {
+		candidate := filepath.Join(dir, runConfigFile)
+		if _, err := os.Stat(candidate); err == nil {
+			return candidate, nil
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", nil
+		}
+		dir = parent
+	}
+}
+
+func loadRunConfig(path string) (runConfig, error) {
+	var cfg runConfig
+	md, err := toml.DecodeFile(path, &cfg)
+	if err != nil {
+		return cfg, fmt.Errorf("parse %s: %w", path, err)
+	}
+	if undecoded := md.Undecoded(); len(undecoded) > 0 {
+		keys := make([]string, len(undecoded))
+		for i, k := range undecoded {
+			keys[i] = k.String()
+		}
+		sort.Strings(keys)
+		return cfg, fmt.Errorf("%s: unknown keys: %s", path, strings.Join(keys, ", "))
+	}
+	cfg.dir = filepath.Dir(path)
+	if cfg.Name != "" {
+		if err := devwrap.ValidateName(cfg.Name); err != nil {
+			return cfg, fmt.Errorf("%s: name: %w", path, err)
+		}
+	}
+	if cfg.Project != "" {
+		if err := validateProject(cfg.Project); err != nil {
+			return cfg, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	if cfg.PortStrategy != "" {
+		if _, err := devwrap.ResolvePortStrategy(cfg.PortStrategy); err != nil {
+			return cfg, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	if err := validateDialHost(cfg.DialHost); err != nil {
+		return cfg, fmt.Errorf("%s: %w", path, err)
+	}
+	if cfg.Ready.HTTP != "" && !strings.HasPrefix(cfg.Ready.HTTP, "/") {
+		return cfg, fmt.Errorf("%s: ready.http must be a path starting with /", path)
+	}
+	if _, err := parseRestartPolicy(cfg.Restart); err != nil {
+		return cfg, fmt.Errorf("%s: %w", path, err)
+	}
+	if err := validateProfiles(cfg.Profiles); err != nil {
+		return cfg, fmt.Errorf("%s: %w", path, err)
+	}
+	for k := range cfg.Env {
+		if k == "" || strings.ContainsAny(k, "=\x00") {
+			return cfg, fmt.Errorf("%s: invalid env name %q", path, k)
+		}
+	}
+	return cfg, nil
+}
+
+// apply fills the run options the command line left unset. args is the
+// command after --; the config's command is used only when it is empty.
+func (c runConfig) apply(cmd *cobra.Command, opts *runOptions, restart *string, args []string) []string {
+	flags := cmd.Flags()
+	setString := func(flag string, dst *string, v string) {
+		if v != "" && !flags.Changed(flag) {
+			*dst = v
+		}
+	}
+	setString("name", &opts.Name, c.Name)
+	setString("project", &opts.Project, c.Project)
+	setString("host", &opts.Host, c.Host)
+	setString("path", &opts.Path, c.Path)
+	setString("port-strategy", &opts.PortStrategy, c.PortStrategy)
+	setString("dial-host", &opts.Route.DialHost, c.DialHost)
+	setString("ready-http", &opts.ReadyHTTP, c.Ready.HTTP)
+	setString("restart", restart, c.Restart)
+	if c.Port != 0 && !flags.Changed("port") {
+		opts.Port = c.Port
+	}
+	if len(c.Watch) > 0 && !flags.Changed("watch") {
+		opts.Watch.Patterns = c.Watch
+	}
+	if len(c.Profiles) > 0 && !flags.Changed("profile") {
+		opts.Profiles = c.Profiles
+	}
+	if len(args) == 0 && len(c.Command) > 0 {
+		args = c.Command
+		if !flags.Changed("cwd") {
+			opts.Cwd = c.dir
+		}
+	}
+	if c.Cwd != "" && !flags.Changed("cwd") {
+		opts.Cwd = c.Cwd
+		if !filepath.IsAbs(c.Cwd) {
+			opts.Cwd = filepath.Join(c.dir, c.Cwd)
+		}
+	}
+	if len(c.EnvFile) > 0 && !flags.Changed("env-file") {
+		for _, file := range c.EnvFile {
+			if !filepath.IsAbs(file) {
+				file = filepath.Join(c.dir, file)
+			}
+			opts.EnvFiles = append(opts.EnvFiles, file)
+		}
+	}
+	// --env entries come last so they override [env].
+	opts.Env = append(envList(c.Env), opts.Env...)
+	return args
+}
@@ -3,109 +3,129 @@ package main
 import (
 	"fmt"
 	"os"
-	"os/user"
 	"path/filepath"
-	"strconv"
-	"syscall"
 	"time"
 
-	"github.com/gofrs/flock"
+	"devwrap/internal/devwrap"
 )
 
 const (
-	stateFile = "state.json"
-	pidFile   = "daemon.pid"
-	logFile   = "daemon.log"
-	lockFile  = "state.lock"
+	logsDir    = "logs"
+	recordings = "recordings"
 )
 
-func runtimeDir() (string, error) {
-	base := os.Getenv("XDG_STATE_HOME")
-	if base == "" {
-		home, err := runtimeHomeDir()
-		if err != nil {
-			return "", err
+// runtimeOwnershipProblems lists devwrap directories and files the current
+// user does not own or that are readable by other users.
+func runtimeOwnershipProblems() []string {
+	var problems []string
+	sdir, err := devwrap.StateDir()
+	if err == nil {
+		problems = append(problems, ownershipProblems(sdir, devwrap.StateDirFiles)...)
+	}
+	rdir, err := devwrap.RuntimeDir()
+	if err == nil {
+		if rdir != sdir {
+			problems = append(problems, ownershipProblems(rdir, devwrap.RuntimeDirFiles)...)
+		} else {
+			for _, name := range devwrap.RuntimeDirFiles {
+				problems = append(problems, fileOwnershipProblem(filepath.Join(rdir, name))...)
+			}
 		}
-		base = filepath.Join(home, ".local", "state")
-	}
-	dir := filepath.Join(base, "devwrap")
-	if err := os.MkdirAll(dir, 0o755); err != nil {
-		return "", err
 	}
-	return dir, nil
+	return problems
 }
 
-func runtimeHomeDir() (string, error) {
-	if os.Geteuid() == 0 {
-		sudoUser := os.Getenv("SUDO_USER")
-		if sudoUser != "" {
-			u, err := user.Lookup(sudoUser)
-			if err == nil && u.HomeDir != "" {
-				return u.HomeDir, nil
-			}
+func ownershipProblems(dir string, names []string) []string {
+	var problems []string
+	if info, err := os.Stat(dir); err == nil {
+		if owner := devwrap.FileOwner(info); owner != os.Geteuid() {
+			problems = append(problems, fmt.Sprintf("%s is owned by uid %d", dir, owner))
+		} else if info.Mode().Perm() != 0o700 {
+			problems = append(problems, fmt.Sprintf("%s has mode %o (want 700)", dir, info.Mode().Perm()))
 		}
 	}
-	return os.UserHomeDir()
+	for _, name := range names {
+		problems = append(problems, fileOwnershipProblem(filepath.Join(dir, name))...)
+	}
+	return problems
 }
 
-func pidPath() (string, error) {
-	dir, err := runtimeDir()
+func fileOwnershipProblem(path string) []string {
+	info, err := os.Lstat(path)
 	if err != nil {
-		return "", err
+		return nil
+	}
+	if owner := devwrap.FileOwner(info); owner != os.Geteuid() {
+		return []string{fmt.Sprintf("%s is owned by uid %d", path, owner)}
 	}
-	return filepath.Join(dir, pidFile), nil
+	if info.Mode().Perm()&0o077 != 0 {
+		return []string{fmt.Sprintf("%s has mode %o (want 600)", path, info.Mode().Perm())}
+	}
+	return nil
 }
 
-func statePath() (string, error) {
-	dir, err := runtimeDir()
+// activeStatePath is the file the current state backend keeps state in.
+func activeStatePath() (string, error) {
+	path, err := devwrap.StateDBPath()
 	if err != nil {
 		return "", err
 	}
-	return filepath.Join(dir, stateFile), nil
+	if _, err := os.Stat(path); err == nil {
+		return path, nil
+	}
+	return devwrap.StatePath()
 }
 
 func daemonLogPath() (string, error) {
-	dir, err := runtimeDir()
+	dir, err := devwrap.StateDir()
 	if err != nil {
 		return "", err
 	}
-	return filepath.Join(dir, logFile), nil
+	return filepath.Join(dir, devwrap.LogFile), nil
 }
 
-func stateLockPath() (string, error) {
-	dir, err := runtimeDir()
+// appLogPath returns <runtime>/logs/<name>.log, creating the logs directory.
+func appLogPath(name string) (string, error) {
+	dir, err := devwrap.RuntimeDir()
 	if err != nil {
 		return "", err
 	}
-	return filepath.Join(dir, lockFile), nil
+	dir = filepath.Join(dir, logsDir)
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return "", err
+	}
+	devwrap.ChownToInvoker(dir)
+	return filepath.Join(dir, name+".log"), nil
 }
 
-func withStateLock(fn func() error) error {
-	path, err := stateLockPath()
+// recordingsPath returns <state>/recordings, where `devwrap record` keeps
+// HAR files, creating it.
+func recordingsPath() (string, error) {
+	dir, err := devwrap.StateDir()
 	if err != nil {
-		return err
+		return "", err
 	}
-	fileLock := flock.New(path)
-	if err := fileLock.Lock(); err != nil {
-		return fmt.Errorf("acquire state lock: %w", err)
+	dir = filepath.Join(dir, recordings)
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return "", err
 	}
-	defer func() { _ = fileLock.Unlock() }()
-	return fn()
+	devwrap.ChownToInvoker(dir)
+	return dir, nil
 }
 
 func checkDaemonReachable() bool {
-	pid, err := readDaemonPID()
+	pid, err := devwrap.ReadDaemonPID()
 	if err != nil {
 		return false
 	}
-	if !processAlive(pid) {
+	if !devwrap.ProcessAlive(pid) {
 		_ = clearDaemonPIDFile()
 		return false
 	}
-	if !checkSystemCaddyReachable() {
+	if !devwrap.CheckSystemCaddyReachable() {
 		return false
 	}
-	info, err := inspectExternalCaddy()
+	info, err := devwrap.InspectExternalCaddy()
 	if err != nil {
 		return false
 	}
@@ -117,7 +137,7 @@ func checkDaemonReachable() bool {
 }
 
 func clearDaemonPIDFile() error {
-	path, err := pidPath()
+	path, err := devwrap.PidPath()
 	if err != nil {
 		return err
 	}
@@ -127,34 +147,6 @@ func clearDaemonPIDFile() error {
 	return nil
 }
 
-func checkSystemCaddyReachable() bool {
-	return adminHealthy()
-}
-
-func readDaemonPID() (int, error) {
-	path, err := pidPath()
-	if err != nil {
-		return 0, err
-	}
-	b, err := os.ReadFile(path)
-	if err != nil {
-		return 0, err
-	}
-	p, err := strconv.Atoi(string(b))
-	if err != nil {
-		return 0, err
-	}
-	return p, nil
-}
-
-func processAlive(pid int) bool {
-	if pid <= 0 {
-		return false
-	}
-	err := syscall.Kill(pid, 0)
-	return err == nil
-}
-
 func waitForDaemon() error {
 	return waitForAdminReady(5 * time.Second)
 }
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	"devwrap/internal/devwrap"
+)
+
+// systemdUnit runs the managed proxy daemon for the login session. A
+// daemon that exits cleanly (`devwrap proxy stop`) stays stopped; a crash
+// is restarted. The unit does not see the shell's environment, so the
+// state dir, admin endpoint, and OTLP endpoint overrides in effect at
+// install are baked in.
+func systemdUnit(bin string) string {
+	var env strings.Builder
+	for _, key := range []string{devwrap.StateDirEnv, devwrap.CaddyAdminEnv, otlpEndpointEnv, notifyEnv} {
+		if v := os.Getenv(key); v != "" {
+			fmt.Fprintf(&env, "Environment=%s\n", systemdQuote(key+"="+v))
+		}
+	}
+	return fmt.Sprintf(`[Unit]
+Description=devwrap local HTTPS proxy
+After=network.target
+
+[Service]
+Type=simple
+%sExecStart=%s proxy daemon
+Restart=on-failure
+RestartSec=2
+
+[Install]
+WantedBy=default.target
+`, env.String(), systemdQuote(bin))
+}
+
+func systemdQuote(s string) string {
+	if !strings.ContainsAny(s, " \t\"'\\") {
+		return s
+	}
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
+
+func systemctlUser(args ...string) error {
+	out, err := exec.Command("systemctl", append([]string{"--user"}, args...)...).CombinedOutput()
+	if err != nil {
+		if msg := strings.TrimSpace(string(out)); msg != "" {
+			return fmt.Errorf("systemctl --user %s: %s", strings.Join(args, " "), msg)
+		}
+		return fmt.Errorf("systemctl --user %s: %w", strings.Join(args, " "), err)
+	}
+	return nil
+}
+
+func checkSystemdUserAvailable() error {
+	if runtime.GOOS != "linux" {
+		return errors.New("proxy install uses a systemd user service and is only supported on Linux")
+	}
+	if os.Geteuid() == 0 {
+		return errors.New("run `devwrap proxy install` as your normal user, not under sudo; the unit belongs to your login session")
+	}
+	if _, err := exec.LookPath("systemctl"); err != nil {
+		return errors.New("systemctl not found; proxy install needs systemd")
+	}
+	return nil
+}
+
+// runProxyInstall writes and enables the systemd user unit and starts it,
+// handing over from a proxy started by `devwrap proxy start`.
+func runProxyInstall() error {
+	if err := checkSystemdUserAvailable(); err != nil {
+		return err
+	}
+	if devwrap.CheckSystemCaddyReachable() && !devwrap.SystemdServiceActive() {
+		info, err := devwrap.InspectExternalCaddy()
+		if err != nil || !info.Managed {
+			return fmt.Errorf("an unmanaged Caddy is serving %s; devwrap uses it directly and needs no service", devwrap.CaddyAdmin.Listen)
+		}
+		if err := stopManagedCaddy(); err != nil {
+			return err
+		}
+		deadline := time.Now().Add(5 * time.Second)
+		for devwrap.CheckSystemCaddyReachable() && time.Now().Before(deadline) {
+			time.Sleep(100 * time.Millisecond)
+		}
+	}
+
+	bin, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	if resolved, err := filepath.EvalSymlinks(bin); err == nil {
+		bin = resolved
+	}
+	path, err := devwrap.SystemdUnitPath()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, []byte(systemdUnit(bin)), 0o644); err != nil {
+		return err
+	}
+	if err := systemctlUser("daemon-reload"); err != nil {
+		return err
+	}
+	if err := systemctlUser("enable", "--now", devwrap.SystemdUnitName); err != nil {
+		return err
+	}
+	if err := waitForDaemon(); err != nil {
+		return fmt.Errorf("proxy failed to start (see `journalctl --user -u %s`): %w", devwrap.SystemdUnitName, err)
+	}
+	if outputJSON {
+		return emitJSON(map[string]any{"ok": true, "action": "proxy_install", "result": "installed", "unit": path})
+	}
+	fmt.Printf("installed %s\n", path)
+	fmt.Println("proxy started (systemd); it now starts at login")
+	return nil
+}
+
+func runProxyUninstall() error {
+	if err := checkSystemdUserAvailable(); err != nil {
+		return err
+	}
+	path, err := devwrap.SystemdUnitPath()
+	if err != nil {
+		return err
+	}
+	if !devwrap.SystemdServiceInstalled() {
+		if outputJSON {
+			return emitJSON(map[string]any{"ok": true, "action": "proxy_uninstall", "result": "not_installed"})
+		}
+		fmt.Println("no devwrap systemd unit installed")
+		return nil
+	}
+	if err := systemctlUser("disable", "--now", devwrap.SystemdUnitName); err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := systemctlUser("daemon-reload"); err != nil {
+		return err
+	}
+	if outputJSON {
+		return emitJSON(map[string]any{"ok": true, "action": "proxy_uninstall", "result": "uninstalled", "unit": path})
+	}
+	fmt.Printf("removed %s; proxy stopped\n", path)
+	return nil
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+var byteSizeUnits = []struct {
+	suffix string
+	mult   int64
+}{
+	{"kib", 1 << 10}, {"mib", 1 << 20}, {"gib", 1 << 30},
+	{"kb", 1e3}, {"mb", 1e6}, {"gb", 1e9},
+	{"b", 1},
+}
+
+// parseByteSize parses sizes like "512", "100MB", or "1GiB": KB/MB/GB are
+// decimal and KiB/MiB/GiB binary, matching Caddy's own size syntax.
+func parseByteSize(raw string) (int64, error) {
+	s := strings.ToLower(strings.TrimSpace(raw))
+	mult := int64(1)
+	for _, u := range byteSizeUnits {
+		if strings.HasSuffix(s, u.suffix) {
+			s, mult = strings.TrimSpace(strings.TrimSuffix(s, u.suffix)), u.mult
+			break
+		}
+	}
+	n, err := strconv.ParseFloat(s, 64)
+	if err != nil || math.IsNaN(n) || math.IsInf(n, 0) || n <= 0 {
+		return 0, fmt.Errorf("invalid size %q (e.g. 100MB, 1GiB)", raw)
+	}
+	// float64(math.MaxInt64) rounds up to 2^63, which no int64 holds.
+	size := n * float64(mult)
+	if size >= float64(math.MaxInt64) {
+		return 0, fmt.Errorf("size %q is too large", raw)
+	}
+	if size < 1 {
+		return 0, fmt.Errorf("size %q is less than a byte", raw)
+	}
+	return int64(size), nil
+}
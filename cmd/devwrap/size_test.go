@@ -0,0 +1,41 @@
+package main
+
+import "testing"
+
+func TestParseByteSize(t *testing.T) {
+	tests := []struct {
+		raw     string
+		want    int64
+		wantErr bool
+	}{
+		{raw: "512", want: 512},
+		{raw: "100MB", want: 100e6},
+		{raw: "1GiB", want: 1 << 30},
+		{raw: " 1.5 kib ", want: 1536},
+		{raw: "10b", want: 10},
+		{raw: "0", wantErr: true},
+		{raw: "-1MB", wantErr: true},
+		{raw: "0.5", wantErr: true},
+		{raw: "MB", wantErr: true},
+		{raw: "ten", wantErr: true},
+		{raw: "nan", wantErr: true},
+		{raw: "NaNMB", wantErr: true},
+		{raw: "inf", wantErr: true},
+		{raw: "+Inf", wantErr: true},
+		{raw: "1e400", wantErr: true},
+		{raw: "9223372036854775807", wantErr: true},
+		{raw: "10000000000GB", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseByteSize(tt.raw)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("parseByteSize(%q) = %d, want an error", tt.raw, got)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("parseByteSize(%q) = %d, %v; want %d", tt.raw, got, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"devwrap/internal/devwrap"
+)
+
+// runProxyRestoreOriginal loads the snapshot back into the unmanaged Caddy,
+// removing every devwrap route and policy, and forgets the snapshot.
+func runProxyRestoreOriginal(force bool) error {
+	if !devwrap.CheckSystemCaddyReachable() {
+		return errors.New("proxy is not running")
+	}
+	if info, err := devwrap.InspectExternalCaddy(); err == nil && info.Managed {
+		return errors.New("the managed proxy has no original config; stop it with `devwrap proxy stop`")
+	}
+	path, err := devwrap.OriginalConfigPath()
+	if err != nil {
+		return err
+	}
+	b, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return errors.New("no snapshot of the original caddy config; devwrap has not changed this caddy")
+	}
+	if err != nil {
+		return err
+	}
+
+	var dropped []string
+	err = devwrap.WithStateLock(func(tx *devwrap.StateTx) error {
+		state, err := tx.Load()
+		if err != nil {
+			return err
+		}
+		devwrap.PruneDeadApps(&state)
+		if len(state.Apps) > 0 && !force {
+			names := make([]string, 0, len(state.Apps))
+			for name := range state.Apps {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			return fmt.Errorf("apps are still registered (%s); stop them with `devwrap down` or pass --force", strings.Join(names, ", "))
+		}
+		if bytes.Equal(bytes.TrimSpace(b), []byte("null")) {
+			b = []byte("{}")
+		}
+		res, err := devwrap.APIClient().Post(devwrap.AdminURL("/load"), "application/json", bytes.NewReader(b))
+		if err != nil {
+			return err
+		}
+		defer res.Body.Close()
+		if res.StatusCode >= 300 {
+			return fmt.Errorf("caddy rejected the original config: %s", devwrap.AdminReadBody(res))
+		}
+		for name, app := range state.Apps {
+			state.RecordExit(app, "removed", nil)
+			delete(state.Apps, name)
+			dropped = append(dropped, name)
+		}
+		sort.Strings(dropped)
+		return tx.Save(state)
+	})
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if dropped == nil {
+		dropped = []string{}
+	}
+	if outputJSON {
+		return emitJSON(map[string]any{"ok": true, "action": "proxy_restore_original", "removed": dropped})
+	}
+	for _, name := range dropped {
+		fmt.Printf("removed app %s\n", name)
+	}
+	fmt.Println("restored the original caddy config")
+	return nil
+}
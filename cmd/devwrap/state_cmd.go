@@ -0,0 +1,290 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"devwrap/internal/devwrap"
+)
+
+// stateExport is the `devwrap state export` document.
+type stateExport struct {
+	Version    int           `json:"version"`
+	ExportedAt string        `json:"exported_at"`
+	Apps       []devwrap.App `json:"apps"`
+}
+
+// runStateExport writes every live registration to stdout as JSON.
+func runStateExport() error {
+	var apps []devwrap.App
+	err := devwrap.WithStateLock(func(tx *devwrap.StateTx) error {
+		state, err := tx.Load()
+		if err != nil {
+			return err
+		}
+		for _, app := range state.Apps {
+			if devwrap.AppLive(app) {
+				apps = append(apps, app)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if apps == nil {
+		apps = []devwrap.App{}
+	}
+	sort.Slice(apps, func(i, j int) bool { return apps[i].Name < apps[j].Name })
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(stateExport{Version: 1, ExportedAt: time.Now().UTC().Format(time.RFC3339), Apps: apps})
+}
+
+// runStateImport registers the apps of an export and re-applies routes.
+// Apps owned by a process that is not running here (always the case on
+// another machine) are skipped; `devwrap add` registrations come back as
+// they were. Existing registrations win unless replace is set.
+func runStateImport(path string, replace bool) error {
+	var r io.Reader = os.Stdin
+	if path != "" && path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+	var doc stateExport
+	if err := json.NewDecoder(r).Decode(&doc); err != nil {
+		return fmt.Errorf("invalid state export: %w", err)
+	}
+	if doc.Version != 1 {
+		return fmt.Errorf("unsupported state export version %d", doc.Version)
+	}
+	if err := ensureCaddyOrDaemon(false); err != nil {
+		return err
+	}
+
+	imported := []string{}
+	skipped := []string{}
+	err := devwrap.WithStateLock(func(tx *devwrap.StateTx) error {
+		state, err := tx.Load()
+		if err != nil {
+			return err
+		}
+		devwrap.PruneDeadApps(&state)
+		var conflicts []string
+		for _, app := range doc.Apps {
+			if app.Name == "" {
+				return errors.New("invalid state export: app without a name")
+			}
+			if !devwrap.AppLive(app) {
+				skipped = append(skipped, app.Name)
+				continue
+			}
+			if existing, ok := state.Apps[app.Name]; ok && !replace {
+				if existing.PID != app.PID || existing.Host != app.Host || existing.Path != app.Path || existing.Port != app.Port {
+					conflicts = append(conflicts, app.Name)
+				}
+				continue
+			}
+			state.Apps[app.Name] = app
+			imported = append(imported, app.Name)
+		}
+		if len(conflicts) > 0 {
+			return fmt.Errorf("already registered with different settings: %s (pass --replace to overwrite)", strings.Join(conflicts, ", "))
+		}
+		if err := checkImportedApps(state.Apps); err != nil {
+			return err
+		}
+		httpPort, httpsPort, err := devwrap.ApplyRoutesViaAdmin(state)
+		if err != nil {
+			return err
+		}
+		state.HTTPPort = httpPort
+		state.HTTPSPort = httpsPort
+		return tx.Save(state)
+	})
+	if err != nil {
+		return err
+	}
+	if outputJSON {
+		return emitJSON(map[string]any{"ok": true, "action": "state_import", "imported": imported, "skipped": skipped})
+	}
+	fmt.Printf("imported %d app(s)\n", len(imported))
+	for _, name := range imported {
+		fmt.Printf("- %s\n", name)
+	}
+	if len(skipped) > 0 {
+		fmt.Printf("skipped (not running here, or expired): %s\n", strings.Join(skipped, ", "))
+	}
+	return nil
+}
+
+// checkImportedApps rejects a merged registry in which two apps claim the
+// same host and path or the same port.
+func checkImportedApps(apps map[string]devwrap.App) error {
+	names := make([]string, 0, len(apps))
+	for name := range apps {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	routes := map[string]string{}
+	ports := map[int]string{}
+	for _, name := range names {
+		app := apps[name]
+		route := strings.ToLower(app.Host) + app.Path
+		if other, ok := routes[route]; ok {
+			return fmt.Errorf("apps %q and %q both claim %s", other, name, route)
+		}
+		routes[route] = name
+		if app.Port > 0 {
+			if other, ok := ports[app.Port]; ok {
+				return fmt.Errorf("apps %q and %q both use port %d", other, name, app.Port)
+			}
+			ports[app.Port] = name
+		}
+	}
+	return nil
+}
+
+// runStateMigrate moves state between state.json and state.db under the
+// current backend's lock. The old file is kept with a .migrated suffix;
+// commands waiting for the lock notice the switch and retry on the new
+// backend.
+func runStateMigrate(to string) error {
+	dbPath, err := devwrap.StateDBPath()
+	if err != nil {
+		return err
+	}
+	jsonPath, err := devwrap.StatePath()
+	if err != nil {
+		return err
+	}
+	var from string
+	var written devwrap.DaemonState
+	err = devwrap.WithStateLock(func(tx *devwrap.StateTx) error {
+		store, err := devwrap.ActiveStateStore()
+		if err != nil {
+			return err
+		}
+		from = "json"
+		if isSQLiteStore(store) {
+			from = "sqlite"
+		}
+		if from == to {
+			return nil
+		}
+		state, err := tx.Load()
+		if err != nil {
+			return err
+		}
+		if to == "json" {
+			// state.db is retired once this transaction is over: renamed
+			// now, the unlock would commit into the moved files.
+			written = state
+			return (devwrap.FileStateStore{}).Save(state)
+		}
+		tmp := dbPath + ".tmp"
+		for _, suffix := range []string{"", "-wal", "-shm"} {
+			_ = os.Remove(tmp + suffix)
+		}
+		db, err := devwrap.OpenSQLiteStateStore(tmp)
+		if err != nil {
+			return err
+		}
+		locked, unlock, err := db.Lock()
+		if err != nil {
+			db.Close()
+			return err
+		}
+		err = locked.Save(state)
+		unlock()
+		if err != nil {
+			db.Close()
+			return err
+		}
+		if err := db.Close(); err != nil {
+			return err
+		}
+		if err := os.Rename(tmp, dbPath); err != nil {
+			return err
+		}
+		if err := os.Rename(jsonPath, jsonPath+".migrated"); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		devwrap.SyncDir(filepath.Dir(dbPath))
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if from != to && to == "json" {
+		if err := retireStateDB(dbPath, written); err != nil {
+			return err
+		}
+	}
+	if outputJSON {
+		return emitJSON(map[string]any{"ok": true, "action": "state_migrate", "from": from, "to": to})
+	}
+	if from == to {
+		fmt.Printf("state is already kept in %s\n", map[string]string{"json": devwrap.StateFile, "sqlite": devwrap.StateDB}[to])
+		return nil
+	}
+	fmt.Printf("migrated state from %s to %s\n", from, to)
+	return nil
+}
+
+// retireStateDB renames state.db, its WAL, and its index to .migrated after
+// their contents were written to state.json as written. It closes this
+// process's SQLite store first and renames under state.json's lock, which
+// every command takes once state.db is gone. A save another process
+// committed to state.db in between is carried over to state.json.
+func retireStateDB(dbPath string, written devwrap.DaemonState) error {
+	if err := devwrap.CloseStateStore(); err != nil {
+		return err
+	}
+	_, unlock, err := (devwrap.FileStateStore{}).Lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	// The WAL and its index belong with the database; keep the three
+	// together so the .migrated copy stays consistent.
+	for _, suffix := range []string{"", "-wal", "-shm"} {
+		if err := os.Rename(dbPath+suffix, dbPath+suffix+".migrated"); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	devwrap.SyncDir(filepath.Dir(dbPath))
+
+	// A process that took state.db's lock before the rename commits into
+	// the renamed files; wait for it with a lock of our own.
+	migrated, err := devwrap.OpenSQLiteStateStore(dbPath + ".migrated")
+	if err != nil {
+		return err
+	}
+	defer migrated.Close()
+	locked, unlockDB, err := migrated.Lock()
+	if err != nil {
+		return err
+	}
+	latest := written
+	err = locked.Load(&latest)
+	unlockDB()
+	if err != nil {
+		return err
+	}
+	if devwrap.SameJSON(latest, written) {
+		return nil
+	}
+	return (devwrap.FileStateStore{}).Save(latest)
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"devwrap/internal/devwrap"
+)
+
+func TestStateMigrateRoundTrip(t *testing.T) {
+	tests := []struct {
+		name  string
+		state devwrap.DaemonState
+	}{
+		{
+			name:  "empty",
+			state: devwrap.DaemonState{BootID: "boot"},
+		},
+		{
+			name: "apps and history",
+			state: devwrap.DaemonState{
+				HTTPPort:  80,
+				HTTPSPort: 443,
+				BootID:    "boot",
+				Apps: map[string]devwrap.App{
+					"api": {
+						Name: "api", Host: "api.localhost", Port: 11000, PID: 42,
+						StartedAt: "2026-01-02T03:04:05Z",
+						Profiles:  []string{"backend"},
+						Restore:   &devwrap.RestoreSpec{Args: []string{"npm", "start"}, Dir: "/src/api"},
+						RouteOptions: devwrap.RouteOptions{
+							RouteOrder:      "first",
+							ResponseHeaders: map[string]string{"X-Env": "dev"},
+							CORS:            []string{"*"},
+						},
+					},
+					"docs": {Name: "docs", Host: "dev.localhost", Path: "/docs", Upstream: "10.0.0.2:8080", Unowned: true, Paused: true},
+				},
+				History: []devwrap.ExitedApp{{Name: "web", Host: "web.localhost", PID: 7, Reason: "exited"}},
+				Ports:   map[string]int{"api": 11000, "web": 11001},
+				Compose: []devwrap.ComposeService{{Name: "db", Service: "postgres", Dir: "/src"}},
+				Restore: []devwrap.PendingRestore{{Name: "worker", Spec: devwrap.RestoreSpec{Args: []string{"./worker"}, Dir: "/src"}}},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			t.Setenv(devwrap.StateDirEnv, dir)
+			t.Cleanup(func() { _ = devwrap.CloseStateStore() })
+
+			if err := devwrap.WithStateLock(func(tx *devwrap.StateTx) error {
+				return tx.Save(tt.state)
+			}); err != nil {
+				t.Fatal(err)
+			}
+			want, err := devwrap.LoadLocalState()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			steps := []struct {
+				to      string
+				present string
+				retired string
+			}{
+				{"sqlite", devwrap.StateDB, devwrap.StateFile},
+				{"sqlite", devwrap.StateDB, devwrap.StateFile},
+				{"json", devwrap.StateFile, devwrap.StateDB},
+			}
+			for _, step := range steps {
+				if err := runStateMigrate(step.to); err != nil {
+					t.Fatalf("migrate to %s: %v", step.to, err)
+				}
+				if _, err := os.Stat(filepath.Join(dir, step.present)); err != nil {
+					t.Fatalf("after migrating to %s: %v", step.to, err)
+				}
+				if _, err := os.Stat(filepath.Join(dir, step.retired)); !os.IsNotExist(err) {
+					t.Fatalf("after migrating to %s, %s still in use: %v", step.to, step.retired, err)
+				}
+				if _, err := os.Stat(filepath.Join(dir, step.retired+".migrated")); err != nil {
+					t.Fatalf("after migrating to %s: %v", step.to, err)
+				}
+				got, err := devwrap.LoadLocalState()
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !reflect.DeepEqual(got, want) {
+					t.Fatalf("after migrating to %s:\ngot  %+v\nwant %+v", step.to, got, want)
+				}
+			}
+		})
+	}
+}
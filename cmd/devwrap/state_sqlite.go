@@ -0,0 +1,10 @@
+package main
+
+import (
+	"devwrap/internal/devwrap"
+)
+
+func isSQLiteStore(store devwrap.StateStore) bool {
+	_, ok := store.(*devwrap.SQLiteStateStore)
+	return ok
+}
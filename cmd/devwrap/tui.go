@@ -0,0 +1,469 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+	"unicode/utf8"
+
+	"golang.org/x/term"
+
+	"devwrap/internal/devwrap"
+)
+
+// tuiLogLines is how much of the selected app's log the TUI keeps.
+const tuiLogLines = 500
+
+// tuiKeys is the help line at the bottom of the TUI.
+const tuiKeys = "↑/↓ select  r restart  p pause/resume  x remove  o open  l logs  q quit"
+
+// tui is the state behind `devwrap tui`. Everything but the log buffer is
+// only touched by the loop in runTUI.
+type tui struct {
+	status   devwrap.ProxyStatus
+	apps     []dashboardApp
+	selected string
+	showLogs bool
+	confirm  string // app awaiting a y/n to be removed
+	message  string
+	color    bool
+
+	logs     *tuiLog
+	logApp   string
+	stopLogs chan struct{}
+}
+
+// runTUI shows the registered apps and the selected app's log until q or
+// Ctrl-C. The app list is reloaded whenever the event log moves, not on a
+// timer.
+func runTUI() error {
+	if outputJSON {
+		return errors.New("tui does not support --json")
+	}
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) || !term.IsTerminal(int(os.Stdout.Fd())) {
+		return errors.New("tui needs a terminal")
+	}
+	path, err := devwrap.EventsPath()
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return err
+	}
+	f.Close()
+	devwrap.ChownToInvoker(path)
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+
+	saved, err := term.MakeRaw(fd)
+	if err != nil {
+		return err
+	}
+	// Alternate screen, cursor hidden; both undone on the way out.
+	fmt.Print("\x1b[?1049h\x1b[?25l")
+	defer func() {
+		fmt.Print("\x1b[?25h\x1b[?1049l")
+		_ = term.Restore(fd, saved)
+	}()
+
+	t := &tui{showLogs: true, color: useColor(os.Stdout), logs: &tuiLog{changed: make(chan struct{}, 1)}}
+	defer t.followLogs("")
+
+	stop := make(chan struct{})
+	defer close(stop)
+	moved := make(chan struct{}, 1)
+	go func() { _ = followLog(path, info.Size(), notifyWriter(moved), stop) }()
+
+	keys := make(chan []byte)
+	go func() {
+		buf := make([]byte, 64)
+		for {
+			n, err := os.Stdin.Read(buf)
+			if err != nil {
+				close(keys)
+				return
+			}
+			keys <- bytes.Clone(buf[:n])
+		}
+	}()
+
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP, syscall.SIGWINCH)
+	defer signal.Stop(sigCh)
+	// Uptimes tick; the state is not reloaded for it.
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	t.reload()
+	for {
+		t.draw()
+		select {
+		case k, ok := <-keys:
+			if !ok || !t.key(k) {
+				return nil
+			}
+		case <-moved:
+			t.reload()
+		case <-t.logs.changed:
+		case <-ticker.C:
+		case sig := <-sigCh:
+			if sig != syscall.SIGWINCH {
+				return nil
+			}
+		}
+	}
+}
+
+// reload reads the apps again, keeping the selection on the same app, and
+// points the log pane at it.
+func (t *tui) reload() {
+	status, err := devwrap.LocalStatusFromFiles()
+	if err != nil {
+		t.message = err.Error()
+		return
+	}
+	t.status = status
+	t.apps = dashboardApps(status)
+	if t.index() < 0 {
+		t.selected = ""
+		if len(t.apps) > 0 {
+			t.selected = t.apps[0].Name
+		}
+	}
+	t.syncLogs()
+}
+
+func (t *tui) index() int {
+	for i, app := range t.apps {
+		if app.Name == t.selected {
+			return i
+		}
+	}
+	return -1
+}
+
+func (t *tui) current() (dashboardApp, bool) {
+	if i := t.index(); i >= 0 {
+		return t.apps[i], true
+	}
+	return dashboardApp{}, false
+}
+
+// key handles one read from the terminal and reports whether to keep going.
+func (t *tui) key(k []byte) bool {
+	if t.confirm != "" {
+		name := t.confirm
+		t.confirm = ""
+		if string(k) == "y" || string(k) == "Y" {
+			t.act(fmt.Sprintf("removed %s", name), devwrap.RemoveDirect(name))
+		} else {
+			t.message = ""
+		}
+		return true
+	}
+	switch string(k) {
+	case "q", "\x03", "\x04":
+		return false
+	case "k", "\x1b[A", "\x1bOA":
+		t.move(-1)
+	case "j", "\x1b[B", "\x1bOB":
+		t.move(1)
+	case "l":
+		t.showLogs = !t.showLogs
+		t.syncLogs()
+	}
+	app, ok := t.current()
+	if !ok {
+		return true
+	}
+	switch string(k) {
+	case "r":
+		t.act(fmt.Sprintf("restarting %s", app.Name), restartApp(app))
+	case "p":
+		if app.Paused {
+			t.act(fmt.Sprintf("resumed %s", app.Name), devwrap.SetPausedDirect(app.Name, false))
+		} else {
+			t.act(fmt.Sprintf("paused %s", app.Name), devwrap.SetPausedDirect(app.Name, true))
+		}
+	case "x":
+		t.confirm = app.Name
+		t.message = fmt.Sprintf("remove the route for %s? (y/N)", app.Name)
+	case "o":
+		t.act(fmt.Sprintf("opened %s", app.URL), openBrowser(app.URL))
+	}
+	return true
+}
+
+func (t *tui) move(by int) {
+	if len(t.apps) == 0 {
+		return
+	}
+	i := min(max(t.index()+by, 0), len(t.apps)-1)
+	t.selected = t.apps[i].Name
+	t.syncLogs()
+}
+
+// act reports how an action went and reloads, since pausing and
+// removing change state without an event of their own.
+func (t *tui) act(done string, err error) {
+	t.message = done
+	if err != nil {
+		t.message = err.Error()
+	}
+	t.reload()
+}
+
+// syncLogs follows the selected app's log, or nothing with the pane hidden.
+func (t *tui) syncLogs() {
+	name := t.selected
+	if !t.showLogs {
+		name = ""
+	}
+	if name != t.logApp {
+		t.followLogs(name)
+	}
+}
+
+func (t *tui) followLogs(name string) {
+	if t.stopLogs != nil {
+		close(t.stopLogs)
+		t.stopLogs = nil
+	}
+	t.logApp = name
+	t.logs.reset("")
+	if name == "" {
+		return
+	}
+	path, err := appLogFile(name)
+	if err != nil {
+		t.logs.reset(err.Error())
+		return
+	}
+	offset, err := tailOffset(path, tuiLogLines)
+	if err != nil {
+		t.logs.reset(err.Error())
+		return
+	}
+	stop := make(chan struct{})
+	t.stopLogs = stop
+	go func() { _ = followLog(path, offset, t.logs.writer(stop), stop) }()
+}
+
+// restartApp has the devwrap process running app restart its command; the
+// route stays up meanwhile.
+func restartApp(app dashboardApp) error {
+	if app.Unowned || app.PID == 0 || app.Name == dashboardAppName {
+		return fmt.Errorf("%s is not a command run by devwrap; nothing to restart", app.Name)
+	}
+	return syscall.Kill(app.PID, syscall.SIGUSR1)
+}
+
+func openBrowser(url string) error {
+	opener := "xdg-open"
+	if runtime.GOOS == "darwin" {
+		opener = "open"
+	}
+	cmd := exec.Command(opener, url)
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("open %s: %w", url, err)
+	}
+	go func() { _ = cmd.Wait() }()
+	return nil
+}
+
+func (t *tui) draw() {
+	width, height, err := term.GetSize(int(os.Stdout.Fd()))
+	if err != nil || width <= 0 || height <= 0 {
+		width, height = 80, 24
+	}
+	var lines []string
+	proxy := fmt.Sprintf("devwrap · %s caddy on :%d/:%d", t.status.CaddySource, t.status.HTTPPort, t.status.HTTPSPort)
+	if !t.status.Trusted {
+		proxy += " · CA not trusted"
+	}
+	if t.status.Paused {
+		proxy += " · all routes paused"
+	}
+	lines = append(lines, t.style("1", proxy), "")
+
+	nameWidth := len("APP")
+	for _, app := range t.apps {
+		nameWidth = max(nameWidth, len(app.Name))
+	}
+	row := func(name, status, pid, uptime, url string) string {
+		return fmt.Sprintf("  %-*s  %-8s  %-7s  %-9s  %s", nameWidth, name, status, pid, uptime, url)
+	}
+	lines = append(lines, t.style("2", row("APP", "STATUS", "PID", "UPTIME", "URL")))
+	if len(t.apps) == 0 {
+		lines = append(lines, "  no apps registered")
+	}
+	for _, app := range t.apps {
+		pid, uptime := "-", "-"
+		if app.PID != 0 {
+			pid = fmt.Sprint(app.PID)
+		}
+		if app.UptimeSeconds >= 0 {
+			uptime = (time.Duration(app.UptimeSeconds) * time.Second).String()
+		}
+		line := row(app.Name, app.Status, pid, uptime, app.URL)
+		if app.Name == t.selected {
+			line = t.style("7", ">"+line[1:])
+		}
+		lines = append(lines, line)
+	}
+
+	footer := []string{"", t.style("2", tuiKeys)}
+	if t.message != "" {
+		footer[0] = t.message
+	}
+	if t.showLogs && t.logApp != "" {
+		lines = append(lines, "", t.style("1", "── "+t.logApp+" "))
+		room := height - len(lines) - len(footer)
+		if room > 0 {
+			lines = append(lines, t.logs.last(room)...)
+		}
+	}
+	for len(lines) < height-len(footer) {
+		lines = append(lines, "")
+	}
+	lines = append(lines[:max(0, height-len(footer))], footer...)
+
+	var b strings.Builder
+	b.WriteString("\x1b[H")
+	for i, line := range lines {
+		if i > 0 {
+			b.WriteString("\r\n")
+		}
+		b.WriteString(truncateToWidth(line, width))
+		b.WriteString("\x1b[K")
+	}
+	b.WriteString("\x1b[J")
+	fmt.Print(b.String())
+}
+
+func (t *tui) style(sgr, s string) string {
+	if !t.color {
+		return s
+	}
+	return "\x1b[" + sgr + "m" + s + "\x1b[0m"
+}
+
+// truncateToWidth cuts s to width runes, ignoring SGR escapes in the
+// count. Log lines keep their colors but lose other control sequences.
+func truncateToWidth(s string, width int) string {
+	var b strings.Builder
+	n := 0
+	for i := 0; i < len(s); {
+		if s[i] == '\x1b' {
+			end := i + 1
+			if end < len(s) && s[end] == '[' {
+				end++
+				for end < len(s) && (s[end] < 0x40 || s[end] > 0x7e) {
+					end++
+				}
+			}
+			if end < len(s) && s[end] == 'm' {
+				b.WriteString(s[i : end+1])
+			}
+			i = end + 1
+			continue
+		}
+		r, size := utf8.DecodeRuneInString(s[i:])
+		i += size
+		if r < 0x20 || r == 0x7f || n == width {
+			continue
+		}
+		b.WriteRune(r)
+		n++
+	}
+	if b.Len() > 0 && strings.Contains(s, "\x1b[") {
+		b.WriteString("\x1b[0m")
+	}
+	return b.String()
+}
+
+// tuiLog keeps the last tuiLogLines lines written to it.
+type tuiLog struct {
+	mu      sync.Mutex
+	lines   []string
+	partial string
+	changed chan struct{}
+}
+
+func (l *tuiLog) reset(line string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.lines, l.partial = nil, ""
+	if line != "" {
+		l.lines = []string{line}
+	}
+	notify(l.changed)
+}
+
+func (l *tuiLog) last(n int) []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	lines := l.lines[max(0, len(l.lines)-n):]
+	if l.partial != "" && len(lines) == n {
+		lines = lines[1:]
+	}
+	if l.partial != "" {
+		lines = append(slices.Clone(lines), l.partial)
+	}
+	return lines
+}
+
+// writer appends to the log until stop is closed, so a follower that is
+// being replaced cannot write into the next app's pane.
+func (l *tuiLog) writer(stop <-chan struct{}) writerFunc {
+	return func(p []byte) (int, error) {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		select {
+		case <-stop:
+			return len(p), nil
+		default:
+		}
+		text := l.partial + strings.ReplaceAll(string(p), "\r\n", "\n")
+		parts := strings.Split(text, "\n")
+		l.partial = parts[len(parts)-1]
+		l.lines = append(l.lines, parts[:len(parts)-1]...)
+		if over := len(l.lines) - tuiLogLines; over > 0 {
+			l.lines = slices.Clone(l.lines[over:])
+		}
+		notify(l.changed)
+		return len(p), nil
+	}
+}
+
+type writerFunc func(p []byte) (int, error)
+
+func (f writerFunc) Write(p []byte) (int, error) { return f(p) }
+
+// notifyWriter discards what it is given and signals ch instead.
+func notifyWriter(ch chan struct{}) writerFunc {
+	return func(p []byte) (int, error) {
+		notify(ch)
+		return len(p), nil
+	}
+}
+
+func notify(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
@@ -0,0 +1,505 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"os/signal"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"devwrap/internal/devwrap"
+)
+
+type upOptions struct {
+	File     string
+	Profiles []string
+}
+
+// upService is one `devwrap --name <svc> -- <cmd>` child supervised by up.
+type upService struct {
+	Name string
+	cmd  *exec.Cmd
+	out  *prefixLineWriter
+
+	// ready is closed once the service passes its health gate; failed is
+	// closed when it exits, misses the gate, or is skipped. Dependents wait
+	// on whichever comes first.
+	ready      chan struct{}
+	failed     chan struct{}
+	failedOnce sync.Once
+}
+
+func (s *upService) fail() {
+	s.failedOnce.Do(func() { close(s.failed) })
+}
+
+type upEvent struct {
+	name   string
+	event  string
+	code   int
+	detail string
+}
+
+// upScheduler starts services once their depends_on services are ready.
+type upScheduler struct {
+	bin      string
+	cfg      projectConfig
+	out      *prefixWriterGroup
+	services map[string]*upService
+	events   chan upEvent
+
+	mu       sync.Mutex
+	stopping chan struct{}
+	stopOnce sync.Once
+}
+
+// runUp starts every service in devwrap.yaml as its own devwrap process, so
+// each gets the normal lease, route, and release handling, and multiplexes
+// their output with a name prefix until all of them exit. Services with
+// depends_on start only after those services pass their readiness check.
+func runUp(opts upOptions) error {
+	path, err := findProjectConfig(opts.File)
+	if err != nil {
+		return err
+	}
+	cfg, err := loadProjectConfig(path)
+	if err != nil {
+		return err
+	}
+	names, err := cfg.selectServices(opts.Profiles)
+	if err != nil {
+		return err
+	}
+	selected := make(map[string]serviceConfig, len(names))
+	for _, name := range names {
+		selected[name] = cfg.Services[name]
+	}
+	cfg.Services = selected
+	if err := ensureCaddyOrDaemon(false); err != nil {
+		return err
+	}
+	bin, err := os.Executable()
+	if err != nil {
+		return err
+	}
+
+	sched := &upScheduler{
+		bin:      bin,
+		cfg:      cfg,
+		out:      newPrefixWriterGroup(names),
+		services: map[string]*upService{},
+		events:   make(chan upEvent, 4*len(names)),
+		stopping: make(chan struct{}),
+	}
+	for _, name := range names {
+		sched.services[name] = &upService{Name: name, ready: make(chan struct{}), failed: make(chan struct{})}
+	}
+
+	sigCh := make(chan os.Signal, 8)
+	signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
+	defer signal.Stop(sigCh)
+
+	for _, name := range names {
+		go sched.run(name)
+	}
+
+	failed := map[string]int{}
+	for remaining := len(names); remaining > 0; {
+		select {
+		case sig := <-sigCh:
+			sched.stop(sig)
+		case ev := <-sched.events:
+			sched.report(ev)
+			switch ev.event {
+			case "exited", "skipped", "detached":
+				remaining--
+				if ev.code != 0 {
+					failed[ev.name] = ev.code
+				}
+			}
+		}
+	}
+	if len(failed) == 0 {
+		return nil
+	}
+	names = names[:0]
+	for name := range failed {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	if outputJSON {
+		return exitStatusError{code: 1}
+	}
+	return fmt.Errorf("services exited with errors: %s", strings.Join(names, ", "))
+}
+
+func (s *upScheduler) run(name string) {
+	svc := s.services[name]
+	for _, dep := range s.cfg.Services[name].DependsOn {
+		select {
+		case <-s.services[dep].ready:
+		case <-s.services[dep].failed:
+			svc.fail()
+			s.events <- upEvent{name: name, event: "skipped", code: 1, detail: "dependency " + dep + " failed"}
+			return
+		case <-s.stopping:
+			svc.fail()
+			s.events <- upEvent{name: name, event: "skipped", detail: "stopped before start"}
+			return
+		}
+	}
+
+	if s.cfg.Services[name].Compose != "" {
+		s.runCompose(svc)
+		return
+	}
+
+	s.mu.Lock()
+	select {
+	case <-s.stopping:
+		s.mu.Unlock()
+		svc.fail()
+		s.events <- upEvent{name: name, event: "skipped", detail: "stopped before start"}
+		return
+	default:
+	}
+	err := startUpService(s.bin, s.cfg, svc, s.out)
+	s.mu.Unlock()
+	if err != nil {
+		svc.fail()
+		s.events <- upEvent{name: name, event: "exited", code: 1, detail: err.Error()}
+		return
+	}
+	s.events <- upEvent{name: name, event: "started"}
+
+	exited := make(chan struct{})
+	go func() {
+		err := svc.cmd.Wait()
+		svc.out.flush()
+		code, _ := childExitCode(err)
+		close(exited)
+		svc.fail()
+		s.events <- upEvent{name: name, event: "exited", code: code}
+	}()
+
+	rc := s.cfg.Services[name].Ready
+	if rc == (readyConfig{}) && len(s.cfg.dependents(name)) == 0 {
+		// Nothing waits on it and no check was asked for.
+		close(svc.ready)
+		return
+	}
+	if err := waitServiceReady(s.cfg.appName(name), svc.cmd.Process.Pid, rc, exited); err != nil {
+		select {
+		case <-exited:
+		default:
+			svc.fail()
+			s.events <- upEvent{name: name, event: "not_ready", detail: err.Error()}
+		}
+		return
+	}
+	close(svc.ready)
+	s.events <- upEvent{name: name, event: "ready"}
+}
+
+// runCompose starts a docker compose service in the background, waits for its
+// healthcheck, and records it for `devwrap down`. The service is then
+// "detached": up no longer waits on it.
+func (s *upScheduler) runCompose(svc *upService) {
+	c := s.cfg.composeService(svc.Name)
+	w := s.out.writer(svc.Name)
+	err := composeUp(c, w)
+	w.flush()
+	if err == nil {
+		s.events <- upEvent{name: svc.Name, event: "started"}
+		err = waitComposeHealthy(c, s.cfg.Services[svc.Name].Ready.Timeout, s.stopping)
+	}
+	if err == nil {
+		c.StartedAt = time.Now().UTC().Format(time.RFC3339)
+		err = recordComposeService(c)
+	}
+	if err != nil {
+		svc.fail()
+		s.events <- upEvent{name: svc.Name, event: "exited", code: 1, detail: err.Error()}
+		return
+	}
+	close(svc.ready)
+	s.events <- upEvent{name: svc.Name, event: "ready"}
+	s.events <- upEvent{name: svc.Name, event: "detached"}
+}
+
+// stop forwards sig to every started service and prevents pending ones from
+// starting.
+func (s *upScheduler) stop(sig os.Signal) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stopOnce.Do(func() { close(s.stopping) })
+	for _, svc := range s.services {
+		if svc.cmd != nil && svc.cmd.Process != nil {
+			_ = svc.cmd.Process.Signal(sig)
+		}
+	}
+}
+
+func (s *upScheduler) report(ev upEvent) {
+	if outputJSON {
+		out := map[string]any{"ok": ev.code == 0 && ev.event != "not_ready", "action": "up", "event": ev.event, "service": ev.name}
+		if ev.event == "exited" {
+			out["exit_code"] = ev.code
+		}
+		if ev.detail != "" {
+			out["detail"] = ev.detail
+		}
+		_ = emitJSON(out)
+		return
+	}
+	switch ev.event {
+	case "exited":
+		if ev.detail != "" {
+			s.out.linef(ev.name, "failed to start: %s", ev.detail)
+			return
+		}
+		s.out.linef(ev.name, "exited with status %d", ev.code)
+	case "skipped":
+		s.out.linef(ev.name, "not started: %s", ev.detail)
+	case "not_ready":
+		s.out.linef(ev.name, "not ready: %s", ev.detail)
+	case "ready":
+		s.out.linef(ev.name, "ready")
+	case "detached":
+		s.out.linef(ev.name, "running in docker compose (stop with `devwrap down`)")
+	}
+}
+
+// waitServiceReady polls until the service's leased port passes its readiness
+// check, the process exits, or the timeout elapses.
+func waitServiceReady(name string, pid int, rc readyConfig, exited <-chan struct{}) error {
+	timeout := rc.Timeout
+	if timeout == 0 {
+		timeout = defaultReadyTimeout
+	}
+	deadline := time.Now().Add(timeout)
+	ticker := time.NewTicker(250 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		if port := leasedPort(name, pid); port > 0 {
+			if _, ok := probeReady(port, rc.HTTP); ok {
+				return nil
+			}
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("no response after %s", timeout)
+		}
+		select {
+		case <-exited:
+			return errors.New("exited before becoming ready")
+		case <-ticker.C:
+		}
+	}
+}
+
+// leasedPort returns the port leased to the devwrap process pid for name, or 0
+// while the lease does not exist yet.
+func leasedPort(name string, pid int) int {
+	state, err := devwrap.LoadLocalState()
+	if err != nil {
+		return 0
+	}
+	app, ok := state.Apps[name]
+	if !ok || app.PID != pid {
+		return 0
+	}
+	return app.Port
+}
+
+// probeReady checks for an open TCP port, or an HTTP 200 on path when set,
+// on 127.0.0.1 and then ::1, and returns the host that answered. Servers
+// that bind "localhost" may only listen on ::1 (Node does since v17).
+func probeReady(port int, path string) (string, bool) {
+	for _, host := range []string{"127.0.0.1", "::1"} {
+		if probeReadyAt(host, port, path) {
+			return host, true
+		}
+	}
+	return "", false
+}
+
+func probeReadyAt(host string, port int, path string) bool {
+	addr := net.JoinHostPort(host, strconv.Itoa(port))
+	if path == "" {
+		conn, err := net.DialTimeout("tcp", addr, time.Second)
+		if err != nil {
+			return false
+		}
+		conn.Close()
+		return true
+	}
+	client := &http.Client{Timeout: 2 * time.Second}
+	res, err := client.Get("http://" + addr + path)
+	if err != nil {
+		return false
+	}
+	res.Body.Close()
+	return res.StatusCode == http.StatusOK
+}
+
+func startUpService(bin string, cfg projectConfig, svc *upService, out *prefixWriterGroup) error {
+	conf := cfg.Services[svc.Name]
+	resolved, err := cfg.resolveService(svc.Name)
+	if err != nil {
+		return err
+	}
+	args := []string{"--name", svc.Name, "--cwd", resolved.Cwd}
+	if cfg.Project != "" {
+		args = append(args, "--project", cfg.Project)
+	}
+	if cfg.PortStrategy != "" {
+		args = append(args, "--port-strategy", cfg.PortStrategy)
+	}
+	if resolved.Host != "" {
+		args = append(args, "--host", resolved.Host)
+	}
+	if conf.Path != "" {
+		args = append(args, "--path", conf.Path)
+	}
+	if conf.SPA {
+		args = append(args, "--spa")
+	}
+	if conf.Stream {
+		args = append(args, "--stream")
+	}
+	if conf.Trace {
+		args = append(args, "--trace")
+	}
+	if conf.MaxBodySize != "" {
+		args = append(args, "--max-body-size", conf.MaxBodySize)
+	}
+	for _, name := range slices.Sorted(maps.Keys(conf.Headers)) {
+		args = append(args, "--set-header", name+": "+conf.Headers[name])
+	}
+	for _, name := range slices.Sorted(maps.Keys(conf.RequestHeaders)) {
+		args = append(args, "--set-request-header", name+": "+conf.RequestHeaders[name])
+	}
+	for _, a := range conf.Auth {
+		args = append(args, "--auth", a)
+	}
+	if len(conf.CORS) > 0 {
+		args = append(args, "--cors="+strings.Join(conf.CORS, ","))
+	}
+	if conf.ACMEDNS != "" {
+		args = append(args, "--acme-dns", conf.ACMEDNS)
+	}
+	if conf.LAN {
+		args = append(args, "--lan")
+	}
+	if conf.RedirectHTTPS {
+		args = append(args, "--redirect-https")
+	}
+	if conf.HSTS {
+		args = append(args, "--hsts")
+	}
+	if conf.RequireClientCert != "" {
+		args = append(args, "--require-client-cert", cfg.resolvePath(conf.RequireClientCert))
+	}
+	for _, t := range []struct {
+		flag string
+		d    time.Duration
+	}{
+		{"--read-timeout", conf.Timeouts.Read},
+		{"--write-timeout", conf.Timeouts.Write},
+		{"--stream-timeout", conf.Timeouts.StreamTimeout},
+		{"--stream-close-delay", conf.Timeouts.StreamCloseDelay},
+	} {
+		if t.d > 0 {
+			args = append(args, t.flag, t.d.String())
+		}
+	}
+	if conf.UpstreamTLS {
+		args = append(args, "--upstream-tls")
+	}
+	if conf.Insecure {
+		args = append(args, "--insecure")
+	}
+	if conf.DialHost != "" {
+		args = append(args, "--dial-host", conf.DialHost)
+	}
+	for _, p := range conf.Profiles {
+		args = append(args, "--profile", p)
+	}
+	if conf.Restart != "" {
+		args = append(args, "--restart", conf.Restart)
+	}
+	for _, w := range conf.Watch {
+		args = append(args, "--watch", w)
+	}
+	for _, w := range conf.WatchIgnore {
+		args = append(args, "--watch-ignore", w)
+	}
+	if conf.StopTimeout > 0 {
+		args = append(args, "--stop-timeout", conf.StopTimeout.String())
+	}
+	if conf.Hooks.PreStart != "" {
+		args = append(args, "--hook-pre-start", conf.Hooks.PreStart)
+	}
+	if conf.Hooks.PostReady != "" {
+		args = append(args, "--hook-post-ready", conf.Hooks.PostReady)
+	}
+	if conf.Hooks.PostStop != "" {
+		args = append(args, "--hook-post-stop", conf.Hooks.PostStop)
+	}
+	if nonInteractive {
+		args = append(args, "--ci")
+	}
+	// Values travel in the environment, not argv; naming them with --env
+	// makes the child fill in @PORT and the other tokens.
+	for _, kv := range resolved.Env {
+		k, _, _ := strings.Cut(kv, "=")
+		args = append(args, "--env", k)
+	}
+	args = append(args, "--")
+	args = append(args, resolved.Command...)
+
+	cmd := exec.Command(bin, args...)
+	cmd.Stdin = nil
+	cmd.Env = append(os.Environ(), resolved.Env...)
+	if out.colors[svc.Name] != "" && os.Getenv("FORCE_COLOR") == "" {
+		// Output is piped through the multiplexer; keep tools that check
+		// for a TTY colorful when the user's terminal is.
+		cmd.Env = append(cmd.Env, "FORCE_COLOR=1")
+	}
+	// Own process group: terminal ctrl-c reaches up only, which then
+	// forwards a single signal to each service.
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	w := out.writer(svc.Name)
+	cmd.Stdout = w
+	cmd.Stderr = w
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	svc.cmd = cmd
+	svc.out = w
+	return nil
+}
+
+// envList renders an env map as KEY=value entries in a stable order.
+func envList(env map[string]string) []string {
+	keys := make([]string, 0, len(env))
+	for k := range env {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	out := make([]string, 0, len(keys))
+	for _, k := range keys {
+		out = append(out, k+"="+env[k])
+	}
+	return out
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"devwrap/internal/devwrap"
+)
+
+func runProxyVerify(fix bool) error {
+	if !devwrap.CheckSystemCaddyReachable() {
+		return errors.New("proxy is not running")
+	}
+	var problems []devwrap.DriftProblem
+	repaired := false
+	err := devwrap.WithStateLock(func(tx *devwrap.StateTx) error {
+		state, err := tx.Load()
+		if err != nil {
+			return err
+		}
+		devwrap.PruneDeadApps(&state)
+		problems, err = devwrap.VerifyProxyState(state)
+		if err != nil {
+			return err
+		}
+		if !fix || len(problems) == 0 {
+			return nil
+		}
+		if _, _, err := devwrap.ApplyRoutesViaAdmin(state); err != nil {
+			return err
+		}
+		if err := tx.Save(state); err != nil {
+			return err
+		}
+		repaired = true
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if problems == nil {
+		problems = []devwrap.DriftProblem{}
+	}
+
+	if outputJSON {
+		if err := emitJSON(map[string]any{"ok": len(problems) == 0 || repaired, "action": "proxy_verify", "problems": problems, "repaired": repaired}); err != nil {
+			return err
+		}
+	} else {
+		if len(problems) == 0 {
+			fmt.Println("proxy config matches state")
+			return nil
+		}
+		for _, p := range problems {
+			fmt.Printf("- %s: %s\n", p.Kind, p.Detail)
+		}
+		if repaired {
+			fmt.Printf("repaired %d problem(s)\n", len(problems))
+		} else {
+			fmt.Println("run `devwrap proxy verify --fix` to repair")
+		}
+	}
+	if len(problems) > 0 && !repaired {
+		return exitStatusError{code: 2}
+	}
+	return nil
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	defaultWatchDebounce = 300 * time.Millisecond
+	watchPollInterval    = 500 * time.Millisecond
+)
+
+// defaultWatchIgnoreDirs are never descended into while watching.
+var defaultWatchIgnoreDirs = map[string]bool{".git": true, "node_modules": true, ".hg": true, ".svn": true}
+
+// watchOptions configures restart-on-change for a child command.
+type watchOptions struct {
+	Patterns []string
+	Ignore   []string
+	Debounce time.Duration
+}
+
+func (w watchOptions) enabled() bool {
+	return len(w.Patterns) > 0
+}
+
+func (w watchOptions) validate() error {
+	for _, p := range append(append([]string{}, w.Patterns...), w.Ignore...) {
+		if _, err := path.Match(strings.ReplaceAll(p, "**", "*"), ""); err != nil {
+			return fmt.Errorf("invalid watch pattern %q: %w", p, err)
+		}
+	}
+	if w.Debounce < 0 {
+		return errors.New("--watch-debounce must be positive")
+	}
+	return nil
+}
+
+type fileStamp struct {
+	mod  time.Time
+	size int64
+}
+
+// watchFiles polls root for files matching the watch patterns and sends the
+// changed paths once changes have settled for the debounce period. It stops
+// when stop is closed.
+func watchFiles(root string, opts watchOptions, stop <-chan struct{}) <-chan []string {
+	out := make(chan []string)
+	debounce := opts.Debounce
+	if debounce <= 0 {
+		debounce = defaultWatchDebounce
+	}
+	go func() {
+		prev := scanWatched(root, opts)
+		pending := map[string]bool{}
+		var settleAt time.Time
+		ticker := time.NewTicker(min(watchPollInterval, debounce))
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+			}
+			cur := scanWatched(root, opts)
+			changed := diffStamps(prev, cur)
+			prev = cur
+			if len(changed) > 0 {
+				for _, p := range changed {
+					pending[p] = true
+				}
+				settleAt = time.Now().Add(debounce)
+				continue
+			}
+			if len(pending) == 0 || time.Now().Before(settleAt) {
+				continue
+			}
+			paths := make([]string, 0, len(pending))
+			for p := range pending {
+				paths = append(paths, p)
+			}
+			sort.Strings(paths)
+			pending = map[string]bool{}
+			select {
+			case out <- paths:
+			case <-stop:
+				return
+			}
+		}
+	}()
+	return out
+}
+
+func scanWatched(root string, opts watchOptions) map[string]fileStamp {
+	stamps := map[string]fileStamp{}
+	_ = filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		rel, relErr := filepath.Rel(root, p)
+		if relErr != nil || rel == "." {
+			return nil
+		}
+		rel = filepath.ToSlash(rel)
+		if d.IsDir() {
+			if defaultWatchIgnoreDirs[d.Name()] || matchesAnyGlob(opts.Ignore, rel) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !matchesAnyGlob(opts.Patterns, rel) || matchesAnyGlob(opts.Ignore, rel) {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		stamps[rel] = fileStamp{mod: info.ModTime(), size: info.Size()}
+		return nil
+	})
+	return stamps
+}
+
+func diffStamps(prev, cur map[string]fileStamp) []string {
+	var changed []string
+	for p, s := range cur {
+		if old, ok := prev[p]; !ok || !old.mod.Equal(s.mod) || old.size != s.size {
+			changed = append(changed, p)
+		}
+	}
+	for p := range prev {
+		if _, ok := cur[p]; !ok {
+			changed = append(changed, p)
+		}
+	}
+	return changed
+}
+
+func matchesAnyGlob(patterns []string, rel string) bool {
+	for _, p := range patterns {
+		if matchGlob(p, rel) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchGlob matches a slash-separated relative path against a glob where
+// `**` spans any number of directories. A pattern without a slash matches
+// the file name at any depth, e.g. `*.go`.
+func matchGlob(pattern, rel string) bool {
+	pattern = strings.TrimPrefix(filepath.ToSlash(pattern), "./")
+	if !strings.Contains(pattern, "/") {
+		ok, _ := path.Match(pattern, path.Base(rel))
+		return ok
+	}
+	return matchSegments(strings.Split(pattern, "/"), strings.Split(rel, "/"))
+}
+
+func matchSegments(pat, parts []string) bool {
+	for len(pat) > 0 {
+		if pat[0] == "**" {
+			for i := 0; i <= len(parts); i++ {
+				if matchSegments(pat[1:], parts[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(parts) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pat[0], parts[0]); !ok {
+			return false
+		}
+		pat, parts = pat[1:], parts[1:]
+	}
+	return len(parts) == 0
+}
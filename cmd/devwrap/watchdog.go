@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"devwrap/internal/devwrap"
+)
+
+const (
+	// watchdogInterval is how often the managed daemon probes its Caddy.
+	watchdogInterval = 5 * time.Second
+	// watchdogFailures is how many probes in a row must fail before the
+	// daemon restarts Caddy, so one slow admin response is not a crash.
+	watchdogFailures = 3
+)
+
+// watchEmbeddedCaddy blocks until quit, restarting the embedded Caddy with
+// devwrap's config and re-applying routes from state whenever its admin API
+// stops answering or the devwrap servers disappear from its config.
+func watchEmbeddedCaddy(httpPort, httpsPort int, quit <-chan os.Signal) {
+	ticker := time.NewTicker(watchdogInterval)
+	defer ticker.Stop()
+	failures := 0
+	for {
+		select {
+		case <-quit:
+			return
+		case <-ticker.C:
+		}
+		if embeddedCaddyHealthy() {
+			failures = 0
+			continue
+		}
+		failures++
+		if failures < watchdogFailures {
+			continue
+		}
+		failures = 0
+		fmt.Fprintf(os.Stderr, "devwrap: caddy unresponsive after %d checks; restarting\n", watchdogFailures)
+		devwrap.RecordEvents(devwrap.Event{Type: eventProxyStopped, PID: os.Getpid(), Reason: "unresponsive"})
+		if err := restartEmbeddedCaddy(httpPort, httpsPort); err != nil {
+			fmt.Fprintf(os.Stderr, "devwrap: caddy restart failed: %v\n", err)
+			notifyProxyDown(fmt.Sprintf("caddy stopped answering and could not be restarted: %v", err))
+			continue
+		}
+		fmt.Fprintln(os.Stderr, "devwrap: caddy restarted; routes re-applied")
+		devwrap.RecordEvents(devwrap.Event{Type: eventProxyStarted, PID: os.Getpid(), HTTPPort: httpPort, HTTPSPort: httpsPort, Reason: "restarted"})
+	}
+}
+
+// embeddedCaddyHealthy reports whether the admin API answers and still
+// carries the devwrap servers (a `caddy load` from elsewhere can drop them).
+func embeddedCaddyHealthy() bool {
+	if !devwrap.AdminHealthy() {
+		return false
+	}
+	info, err := devwrap.InspectExternalCaddy()
+	return err == nil && info.Managed
+}
+
+func restartEmbeddedCaddy(httpPort, httpsPort int) error {
+	// Stopping an instance that already died only reports that; carry on.
+	_ = stopEmbeddedCaddy()
+	if err := startEmbeddedCaddy(httpPort, httpsPort); err != nil {
+		return err
+	}
+	return adoptEmbeddedCaddy(httpPort, httpsPort)
+}
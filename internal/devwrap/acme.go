@@ -0,0 +1,54 @@
+package devwrap
+
+import (
+	"strings"
+)
+
+// devwrapACMETLSPolicyPrefix prefixes the @id of the TLS policy devwrap
+// keeps per --acme-dns provider, next to the internal-issuer policy.
+const devwrapACMETLSPolicyPrefix = "devwrap-acme-"
+
+// ACMEDNSProviders are the DNS providers --acme-dns knows how to configure.
+// Credentials are Caddy {env.*} placeholders, so they are read from the
+// Caddy process's environment and never written to devwrap state.
+var ACMEDNSProviders = map[string]struct {
+	Module string
+	Env    []string
+	Config map[string]any
+}{
+	"cloudflare":   {"github.com/caddy-dns/cloudflare", []string{"CLOUDFLARE_API_TOKEN"}, map[string]any{"api_token": "{env.CLOUDFLARE_API_TOKEN}"}},
+	"digitalocean": {"github.com/caddy-dns/digitalocean", []string{"DO_AUTH_TOKEN"}, map[string]any{"auth_token": "{env.DO_AUTH_TOKEN}"}},
+	"duckdns":      {"github.com/caddy-dns/duckdns", []string{"DUCKDNS_API_TOKEN"}, map[string]any{"api_token": "{env.DUCKDNS_API_TOKEN}"}},
+	"route53":      {"github.com/caddy-dns/route53", []string{"AWS_ACCESS_KEY_ID", "AWS_SECRET_ACCESS_KEY"}, map[string]any{}},
+}
+
+// tlsPolicyForApp is the devwrap TLS policy @id and subject covering app:
+// the shared internal-issuer policy with a wildcard subject, or the
+// provider's ACME policy with the exact host.
+func tlsPolicyForApp(app App) (string, string) {
+	if app.ACMEDNS != "" {
+		return devwrapACMETLSPolicyPrefix + app.ACMEDNS, strings.ToLower(strings.TrimSpace(app.Host))
+	}
+	return devwrapInternalTLSPolicyID, tlsSubjectForHost(app.Host)
+}
+
+func isDevwrapTLSPolicyID(id string) bool {
+	return id == devwrapInternalTLSPolicyID || strings.HasPrefix(id, devwrapACMETLSPolicyPrefix)
+}
+
+// acmeDNSPolicy issues publicly trusted certificates for subjects through
+// ACME (Let's Encrypt by default) with the DNS-01 challenge.
+func acmeDNSPolicy(provider string, subjects []string) map[string]any {
+	dnsProvider := map[string]any{"name": provider}
+	for k, v := range ACMEDNSProviders[provider].Config {
+		dnsProvider[k] = v
+	}
+	return map[string]any{
+		"@id":      devwrapACMETLSPolicyPrefix + provider,
+		"subjects": subjects,
+		"issuers": []map[string]any{{
+			"module":     "acme",
+			"challenges": map[string]any{"dns": map[string]any{"provider": dnsProvider}},
+		}},
+	}
+}
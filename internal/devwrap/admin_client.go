@@ -0,0 +1,284 @@
+package devwrap
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// CaddyAdminEnv points devwrap at a Caddy admin endpoint other than
+// 127.0.0.1:2019, in Caddy's address syntax: host:port, http://host:port,
+// or unix//run/caddy/admin.sock as many distro packages configure it. The
+// managed proxy serves its admin API there too. An https:// endpoint or a
+// non-loopback host is a remote Caddy, e.g. a team's shared dev proxy.
+const CaddyAdminEnv = "DEVWRAP_CADDY_ADMIN"
+
+// Credentials for a remote admin endpoint, and the address it reaches
+// this machine's apps at (a tunnel or VPN address) instead of 127.0.0.1.
+const (
+	caddyAdminHeaderEnv = "DEVWRAP_CADDY_ADMIN_HEADER"
+	caddyAdminCertEnv   = "DEVWRAP_CADDY_ADMIN_CERT"
+	caddyAdminKeyEnv    = "DEVWRAP_CADDY_ADMIN_KEY"
+	caddyAdminCAEnv     = "DEVWRAP_CADDY_ADMIN_CA"
+	upstreamHostEnv     = "DEVWRAP_UPSTREAM_HOST"
+)
+
+// AdminEndpoint is where the Caddy admin API is served.
+type AdminEndpoint struct {
+	// Listen is the address in Caddy's syntax, as in admin.listen.
+	Listen string
+	// Base prefixes every admin request URL.
+	Base string
+	// Socket is the socket path of a unix address.
+	Socket string
+	// Remote is set for a Caddy on another machine, which devwrap can
+	// use but never starts.
+	Remote bool
+}
+
+var CaddyAdmin = AdminEndpoint{Listen: caddyAdminListen, Base: "http://" + caddyAdminListen}
+
+// LoopbackDialHost is where a local Caddy dials app ports unless the app
+// sets RouteOptions.DialHost.
+const LoopbackDialHost = "127.0.0.1"
+
+// AppDialHost is the host routes dial app ports on.
+var AppDialHost = LoopbackDialHost
+
+// ConfigureAdminEndpoint applies $DEVWRAP_CADDY_ADMIN and its credentials
+// before the first admin request.
+func ConfigureAdminEndpoint() error {
+	raw := os.Getenv(CaddyAdminEnv)
+	if raw == "" {
+		return nil
+	}
+	ep, err := parseAdminEndpoint(raw)
+	if err != nil {
+		return fmt.Errorf("invalid %s: %w", CaddyAdminEnv, err)
+	}
+	CaddyAdmin = ep
+	if host := os.Getenv(upstreamHostEnv); host != "" {
+		AppDialHost = host
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if ep.Socket != "" {
+		var dialer net.Dialer
+		transport.Proxy = nil
+		transport.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return dialer.DialContext(ctx, "unix", ep.Socket)
+		}
+	}
+	if transport.TLSClientConfig, err = adminTLSConfig(); err != nil {
+		return err
+	}
+	var rt http.RoundTripper = transport
+	if spec := os.Getenv(caddyAdminHeaderEnv); spec != "" {
+		name, value, ok := strings.Cut(spec, ":")
+		name = strings.TrimSpace(name)
+		if !ok || name == "" {
+			return fmt.Errorf("invalid %s: want 'Name: value'", caddyAdminHeaderEnv)
+		}
+		rt = headerTransport{base: transport, name: name, value: strings.TrimSpace(value)}
+	}
+	adminHTTPClient = &http.Client{Timeout: adminHTTPClient.Timeout, Transport: rt}
+	return nil
+}
+
+// adminTLSConfig verifies an https admin endpoint against
+// $DEVWRAP_CADDY_ADMIN_CA when set, and presents the client certificate
+// Caddy's remote admin (admin.remote) authorizes by.
+func adminTLSConfig() (*tls.Config, error) {
+	cfg := &tls.Config{MinVersion: tls.VersionTLS12}
+	if path := os.Getenv(caddyAdminCAEnv); path != "" {
+		pem, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", caddyAdminCAEnv, err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("%s: no PEM certificates in %s", caddyAdminCAEnv, path)
+		}
+		cfg.RootCAs = pool
+	}
+	certPath, keyPath := os.Getenv(caddyAdminCertEnv), os.Getenv(caddyAdminKeyEnv)
+	if (certPath == "") != (keyPath == "") {
+		return nil, fmt.Errorf("%s and %s must be set together", caddyAdminCertEnv, caddyAdminKeyEnv)
+	}
+	if certPath != "" {
+		cert, err := tls.LoadX509KeyPair(certPath, keyPath)
+		if err != nil {
+			return nil, fmt.Errorf("admin client certificate: %w", err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	return cfg, nil
+}
+
+// headerTransport adds one header, such as Authorization, to every admin
+// request.
+type headerTransport struct {
+	base        http.RoundTripper
+	name, value string
+}
+
+func (t headerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.Header.Set(t.name, t.value)
+	return t.base.RoundTrip(req)
+}
+
+func parseAdminEndpoint(raw string) (AdminEndpoint, error) {
+	raw = strings.TrimSpace(raw)
+	if path, ok := strings.CutPrefix(raw, "unix/"); ok {
+		// Caddy accepts a "|0660" permission suffix on socket addresses.
+		path, _, _ = strings.Cut(path, "|")
+		if !filepath.IsAbs(path) {
+			return AdminEndpoint{}, fmt.Errorf("socket path %q must be absolute (unix//path/to/admin.sock)", path)
+		}
+		// Caddy skips its Host check on sockets, so any host will do.
+		return AdminEndpoint{Listen: raw, Base: "http://localhost", Socket: path}, nil
+	}
+	scheme, hostport := "http", raw
+	if u, err := url.Parse(raw); err == nil && u.Host != "" {
+		if u.Scheme != "http" && u.Scheme != "https" {
+			return AdminEndpoint{}, fmt.Errorf("unsupported scheme %q (want http://, https://, or unix//)", u.Scheme)
+		}
+		scheme, hostport = u.Scheme, u.Host
+	}
+	host, port, err := net.SplitHostPort(hostport)
+	if err != nil || port == "" {
+		return AdminEndpoint{}, fmt.Errorf("%q is not host:port, http(s)://host:port, or unix//path", raw)
+	}
+	if host == "" {
+		host = "127.0.0.1"
+	}
+	remote := scheme == "https" || !isLoopbackHost(host)
+	return AdminEndpoint{Listen: hostport, Base: scheme + "://" + net.JoinHostPort(host, port), Remote: remote}, nil
+}
+
+func isLoopbackHost(host string) bool {
+	if strings.EqualFold(host, "localhost") {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && (ip.IsLoopback() || ip.IsUnspecified())
+}
+
+// AdminURL is the URL of path on the Caddy admin API.
+func AdminURL(path string) string {
+	if strings.HasPrefix(path, "/") {
+		return CaddyAdmin.Base + path
+	}
+	return CaddyAdmin.Base + "/" + path
+}
+
+// AdminHealthy reports whether the Caddy admin API answers.
+func AdminHealthy() bool {
+	res, err := APIClient().Get(AdminURL("/config/"))
+	if err != nil {
+		return false
+	}
+	defer res.Body.Close()
+	return res.StatusCode < 500
+}
+
+// AdminGet sends a GET for path to the Caddy admin API.
+func AdminGet(path string) (*http.Response, error) {
+	return APIClient().Get(AdminURL(path))
+}
+
+func adminDoJSON(method, path string, payload any) (*http.Response, error) {
+	b, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest(method, AdminURL(path), bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return APIClient().Do(req)
+}
+
+// adminDoIfMatch sends payload (none when nil) with an If-Match header, so
+// Caddy only applies the change while the config at etag's path is
+// unchanged. An empty etag sends the request unconditionally.
+func adminDoIfMatch(method, path string, payload any, etag string) (*http.Response, error) {
+	var body io.Reader
+	if payload != nil {
+		b, err := json.Marshal(payload)
+		if err != nil {
+			return nil, err
+		}
+		body = bytes.NewReader(b)
+	}
+	req, err := http.NewRequest(method, AdminURL(path), body)
+	if err != nil {
+		return nil, err
+	}
+	if payload != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if etag != "" {
+		req.Header.Set("If-Match", etag)
+	}
+	return APIClient().Do(req)
+}
+
+// FetchCaddyConfig returns Caddy's whole config; nil when it has none.
+func FetchCaddyConfig() (any, error) {
+	res, err := AdminGet("/config/")
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode >= 300 {
+		return nil, fmt.Errorf("caddy admin query failed: %s", AdminReadBody(res))
+	}
+	var cfg any
+	if err := json.NewDecoder(res.Body).Decode(&cfg); err != nil {
+		return nil, fmt.Errorf("caddy admin query failed: %w", err)
+	}
+	return cfg, nil
+}
+
+// AdminReadBody returns an admin API error response's body, trimmed.
+func AdminReadBody(res *http.Response) string {
+	b, _ := io.ReadAll(res.Body)
+	return strings.TrimSpace(string(b))
+}
+
+// adminSetJSON creates or replaces the value at a config path. PUT creates
+// missing parent objects but refuses existing keys, so fall back to PATCH.
+func adminSetJSON(path string, value any) error {
+	res, err := adminDoJSON(http.MethodPut, path, value)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode < 300 {
+		return nil
+	}
+	if res.StatusCode != http.StatusConflict {
+		return fmt.Errorf("caddy config update failed: %s", AdminReadBody(res))
+	}
+	patchRes, err := adminDoJSON(http.MethodPatch, path, value)
+	if err != nil {
+		return err
+	}
+	defer patchRes.Body.Close()
+	if patchRes.StatusCode >= 300 {
+		return fmt.Errorf("caddy config update failed: %s", AdminReadBody(patchRes))
+	}
+	return nil
+}
@@ -0,0 +1,26 @@
+package devwrap
+
+// BasicAuthAccount is a user allowed through a route's basic_auth. Only the
+// bcrypt hash is stored in state.
+type BasicAuthAccount struct {
+	Username string `json:"username"`
+	Hash     string `json:"hash"`
+}
+
+// basicAuthHandler guards a route with Caddy's http_basic provider.
+func basicAuthHandler(name string, accounts []BasicAuthAccount) map[string]any {
+	list := make([]map[string]any, 0, len(accounts))
+	for _, a := range accounts {
+		list = append(list, map[string]any{"username": a.Username, "password": a.Hash})
+	}
+	return map[string]any{
+		"handler": "authentication",
+		"providers": map[string]any{
+			"http_basic": map[string]any{
+				"hash":     map[string]any{"algorithm": "bcrypt"},
+				"accounts": list,
+				"realm":    "devwrap " + name,
+			},
+		},
+	}
+}
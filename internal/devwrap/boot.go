@@ -0,0 +1,14 @@
+package devwrap
+
+// RestoreSpec is how to start a --detach --restore app again: the devwrap
+// arguments it was started with and the directory it was started from.
+type RestoreSpec struct {
+	Args []string `json:"args"`
+	Dir  string   `json:"dir"`
+}
+
+// PendingRestore is a --restore app waiting to be started after a reboot.
+type PendingRestore struct {
+	Name string      `json:"name"`
+	Spec RestoreSpec `json:"spec"`
+}
@@ -0,0 +1,119 @@
+package devwrap
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"os"
+	"path/filepath"
+)
+
+const (
+	DevwrapCAID    = "devwrap"
+	caDirName      = "ca"
+	caRootCertFile = "root.crt"
+	caRootKeyFile  = "root.key"
+)
+
+func OwnCADir() (string, error) {
+	dir, err := StateDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, caDirName), nil
+}
+
+func OwnCAPaths() (string, string, error) {
+	dir, err := OwnCADir()
+	if err != nil {
+		return "", "", err
+	}
+	return filepath.Join(dir, caRootCertFile), filepath.Join(dir, caRootKeyFile), nil
+}
+
+// OwnCAEnabled reports whether a devwrap-owned root exists in the state dir.
+// Once it does, devwrap points Caddy's internal issuer at it instead of
+// Caddy's own "local" authority.
+func OwnCAEnabled() bool {
+	certPath, keyPath, err := OwnCAPaths()
+	if err != nil {
+		return false
+	}
+	if _, err := os.Stat(certPath); err != nil {
+		return false
+	}
+	_, err = os.Stat(keyPath)
+	return err == nil
+}
+
+// OwnCAApplied reports whether Caddy issues from the devwrap root. A
+// remote Caddy can't read the root's pem_file paths on this machine, so it
+// keeps issuing from its own "local" authority.
+func OwnCAApplied() bool {
+	return OwnCAEnabled() && !CaddyAdmin.Remote
+}
+
+// InternalIssuer is the issuer config used for every devwrap TLS subject.
+func InternalIssuer() map[string]any {
+	issuer := map[string]any{"module": "internal"}
+	if OwnCAApplied() {
+		issuer["ca"] = DevwrapCAID
+	}
+	return issuer
+}
+
+func OwnCAConfig() (map[string]any, error) {
+	certPath, keyPath, err := OwnCAPaths()
+	if err != nil {
+		return nil, err
+	}
+	return map[string]any{
+		"name":          "devwrap Local Authority",
+		"install_trust": false,
+		"root": map[string]any{
+			"certificate": certPath,
+			"private_key": keyPath,
+			"format":      "pem_file",
+		},
+	}, nil
+}
+
+const ownCAConfigPath = "/config/apps/pki/certificate_authorities/" + DevwrapCAID
+
+// syncOwnCA registers the devwrap root as a Caddy PKI authority so the
+// internal issuer can reference it, recording the change in undo. It is a
+// no-op without an applied own CA or when Caddy already has it.
+func syncOwnCA(undo *applyUndo) error {
+	if !OwnCAApplied() {
+		return nil
+	}
+	cfg, err := OwnCAConfig()
+	if err != nil {
+		return err
+	}
+	current, _, err := fetchConfigObject(ownCAConfigPath)
+	if err != nil {
+		return err
+	}
+	if SameJSON(current, cfg) {
+		return nil
+	}
+	undo.recordObject(ownCAConfigPath, current)
+	return adminSetJSON(ownCAConfigPath, cfg)
+}
+
+func LoadOwnCARoot() (*x509.Certificate, error) {
+	certPath, _, err := OwnCAPaths()
+	if err != nil {
+		return nil, err
+	}
+	b, err := os.ReadFile(certPath)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, errors.New("failed to decode devwrap root certificate PEM")
+	}
+	return x509.ParseCertificate(block.Bytes)
+}
@@ -0,0 +1,86 @@
+package devwrap
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ChaosOptions degrades an app's route on purpose: every request waits
+// Latency, and ErrorRate of them (0 to 1) get ErrorStatus instead of
+// reaching the app.
+type ChaosOptions struct {
+	Latency     time.Duration `json:"latency,omitempty"`
+	ErrorRate   float64       `json:"error_rate,omitempty"`
+	ErrorStatus int           `json:"error_status,omitempty"`
+}
+
+// chaosErrorDigits is how many hex digits of the request UUID pick the
+// failing requests, giving error rates a resolution of 1/4096.
+const chaosErrorDigits = 3
+
+// chaosHandlers returns the handlers that go right ahead of the reverse
+// proxy: the delay, then a subroute answering the failing share of
+// requests itself. Caddy's per-request UUID is random, so matching its
+// leading hex digits below a threshold selects that share of requests.
+func chaosHandlers(c *ChaosOptions) []map[string]any {
+	if c == nil {
+		return nil
+	}
+	var handlers []map[string]any
+	if c.Latency > 0 {
+		handlers = append(handlers, map[string]any{"handler": "devwrap_delay", "duration": c.Latency.String()})
+	}
+	if c.ErrorRate > 0 {
+		handlers = append(handlers, map[string]any{
+			"handler": "subroute",
+			"routes": []map[string]any{{
+				"match": []map[string]any{{"vars_regexp": map[string]any{
+					"{http.request.uuid}": map[string]any{"pattern": chaosErrorPattern(c.ErrorRate)},
+				}}},
+				"handle": []map[string]any{{
+					"handler":     "static_response",
+					"status_code": c.ErrorStatus,
+					"body":        fmt.Sprintf("devwrap chaos: injected %d\n", c.ErrorStatus),
+				}},
+			}},
+		})
+	}
+	return handlers
+}
+
+// chaosErrorPattern matches a UUID whose first chaosErrorDigits hex digits
+// are below rate's share of all values.
+func chaosErrorPattern(rate float64) string {
+	space := 1 << (4 * chaosErrorDigits)
+	n := min(space, max(1, int(math.Round(rate*float64(space)))))
+	return "^(?:" + hexBelow(n, chaosErrorDigits) + ")"
+}
+
+// hexBelow is a regex alternation matching width lowercase hex digits whose
+// value is below n (1 <= n <= 16^width).
+func hexBelow(n, width int) string {
+	const digits = "0123456789abcdef"
+	if width == 0 {
+		return ""
+	}
+	step := 1 << (4 * (width - 1))
+	hi, rest := n/step, n%step
+	var alts []string
+	if hi > 0 {
+		class := "[" + digits[:hi] + "]"
+		if hi == 1 {
+			class = digits[:1]
+		}
+		if width > 1 {
+			class += "[0-9a-f]{" + strconv.Itoa(width-1) + "}"
+		}
+		alts = append(alts, class)
+	}
+	if rest > 0 {
+		alts = append(alts, digits[hi:hi+1]+"(?:"+hexBelow(rest, width-1)+")")
+	}
+	return strings.Join(alts, "|")
+}
@@ -0,0 +1,67 @@
+package devwrap
+
+import (
+	"net/http"
+	"time"
+)
+
+var adminHTTPClient = &http.Client{Timeout: 4 * time.Second}
+
+// Lease is what a registered app is reachable at.
+type Lease struct {
+	Name      string `json:"name"`
+	Host      string `json:"host"`
+	Path      string `json:"path,omitempty"`
+	Port      int    `json:"port"`
+	HTTPURL   string `json:"http_url"`
+	HTTPSURL  string `json:"https_url"`
+	Trusted   bool   `json:"trusted"`
+	ExpiresAt string `json:"expires_at,omitempty"`
+	// LANURLs are set for --lan apps: the https URLs on this machine's LAN IPs.
+	LANURLs []string `json:"lan_urls,omitempty"`
+}
+
+// ProxyStatus is the proxy's state and its registered apps.
+type ProxyStatus struct {
+	Running     bool   `json:"running"`
+	CaddySource string `json:"caddy_source"`
+	Root        bool   `json:"root"`
+	HTTPPort    int    `json:"http_port"`
+	HTTPSPort   int    `json:"https_port"`
+	Trusted     bool   `json:"trusted"`
+	PID         int    `json:"pid"`
+	// ManagedBy is "systemd" when the managed proxy runs as the user unit
+	// from `devwrap proxy install`.
+	ManagedBy string      `json:"managed_by,omitempty"`
+	Paused    bool        `json:"paused"`
+	Apps      []App       `json:"apps"`
+	History   []ExitedApp `json:"history,omitempty"`
+}
+
+func APIClient() *http.Client {
+	return adminHTTPClient
+}
+
+// LeaseRequest asks for an app to be registered and routed.
+type LeaseRequest struct {
+	Name     string        `json:"name"`
+	Host     string        `json:"host,omitempty"`
+	Path     string        `json:"path,omitempty"`
+	Project  string        `json:"project,omitempty"`
+	PID      int           `json:"pid"`
+	Instance bool          `json:"instance,omitempty"`
+	TTL      time.Duration `json:"ttl,omitempty"`
+	Route    RouteOptions  `json:"route"`
+	Profiles []string      `json:"profiles,omitempty"`
+	LogFile  string        `json:"log_file,omitempty"`
+	// Port (or Upstream) and Unowned register a route to a process devwrap
+	// does not run (`devwrap add`); PID is 0 and the lease is never pruned
+	// as dead. Port without Unowned is `devwrap --port`: the app's command
+	// will listen there, so the port must also be free.
+	Port     int          `json:"port,omitempty"`
+	Upstream string       `json:"upstream,omitempty"`
+	Unowned  bool         `json:"unowned,omitempty"`
+	Restore  *RestoreSpec `json:"restore,omitempty"`
+	// PortStrategy is how to allocate a port when Port is 0.
+	PortStrategy string `json:"port_strategy,omitempty"`
+}
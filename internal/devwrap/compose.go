@@ -0,0 +1,27 @@
+package devwrap
+
+import (
+	"os/exec"
+)
+
+// ComposeService records a docker compose service started by `devwrap up`
+// so `devwrap down` can tear it down later.
+type ComposeService struct {
+	Name      string   `json:"name"`
+	Service   string   `json:"service"`
+	Dir       string   `json:"dir"`
+	File      string   `json:"file,omitempty"`
+	Profiles  []string `json:"profiles,omitempty"`
+	StartedAt string   `json:"started_at"`
+}
+
+func (c ComposeService) Command(args ...string) *exec.Cmd {
+	full := []string{"compose"}
+	if c.File != "" {
+		full = append(full, "-f", c.File)
+	}
+	full = append(full, args...)
+	cmd := exec.Command("docker", full...)
+	cmd.Dir = c.Dir
+	return cmd
+}
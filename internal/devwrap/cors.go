@@ -0,0 +1,72 @@
+package devwrap
+
+import (
+	"net/http"
+	"net/url"
+)
+
+// Preflight answers allow the common methods and echo the requested headers.
+const (
+	CORSAllowMethods = "GET, POST, PUT, PATCH, DELETE, OPTIONS"
+	CORSMaxAge       = "600"
+)
+
+// corsHandler answers preflight requests itself and adds CORS headers to
+// the app's responses. The request's Origin is echoed back (rather than
+// "*") so credentialed fetches work too; only listed origins match when
+// --cors names some.
+func corsHandler(opts RouteOptions) map[string]any {
+	if len(opts.CORS) == 0 {
+		return nil
+	}
+	origins := CORSOrigins(opts.CORS)
+	response := map[string][]string{
+		"Access-Control-Allow-Origin":      {"{http.request.header.Origin}"},
+		"Access-Control-Allow-Credentials": {"true"},
+		"Vary":                             {"Origin"},
+	}
+	preflight := map[string][]string{
+		"Access-Control-Allow-Methods": {CORSAllowMethods},
+		"Access-Control-Allow-Headers": {"{http.request.header.Access-Control-Request-Headers}"},
+		"Access-Control-Max-Age":       {CORSMaxAge},
+	}
+	for name, values := range response {
+		preflight[name] = values
+	}
+	// --set-header takes precedence. Its own handler runs after this one
+	// on proxied responses, but preflights stop here and need the values.
+	for name, value := range opts.ResponseHeaders {
+		delete(response, name)
+		preflight[name] = []string{value}
+	}
+	return map[string]any{
+		"handler": "subroute",
+		"routes": []map[string]any{
+			{
+				"match": []map[string]any{{
+					"method": []string{http.MethodOptions},
+					"header": map[string][]string{"Origin": origins, "Access-Control-Request-Method": {"*"}},
+				}},
+				"handle":   []map[string]any{{"handler": "static_response", "status_code": http.StatusNoContent, "headers": preflight}},
+				"terminal": true,
+			},
+			{
+				"match":  []map[string]any{{"header": map[string][]string{"Origin": origins}}},
+				"handle": []map[string]any{{"handler": "headers", "response": map[string]any{"set": response, "deferred": true}}},
+			},
+		},
+	}
+}
+
+// CORSOrigins returns the Origin header values --cors origins match. Origin
+// headers never carry a trailing slash; "*" matches any value.
+func CORSOrigins(cors []string) []string {
+	origins := make([]string, 0, len(cors))
+	for _, o := range cors {
+		if u, err := url.Parse(o); err == nil && u.Host != "" {
+			o = u.Scheme + "://" + u.Host
+		}
+		origins = append(origins, o)
+	}
+	return origins
+}
@@ -0,0 +1,263 @@
+package devwrap
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+)
+
+// App is one registered app, as kept in state.
+type App struct {
+	Name string `json:"name"`
+	Host string `json:"host"`
+	Path string `json:"path,omitempty"`
+	Port int    `json:"port"`
+	PID  int    `json:"pid"`
+	// PIDStart is the owning process's start time, so a recycled PID does
+	// not keep the route alive; HeartbeatAt is the owner's last check-in.
+	PIDStart    uint64         `json:"pid_start,omitempty"`
+	HeartbeatAt string         `json:"heartbeat_at,omitempty"`
+	StartedAt   string         `json:"started_at"`
+	ExpiresAt   string         `json:"expires_at,omitempty"`
+	Overrides   []FileOverride `json:"overrides,omitempty"`
+	// Project is the --project namespace; Name is then "<name>.<project>".
+	Project  string   `json:"project,omitempty"`
+	Profiles []string `json:"profiles,omitempty"`
+	LogFile  string   `json:"log_file,omitempty"`
+	Paused   bool     `json:"paused,omitempty"`
+	// Starting is set from the lease until the app's port first accepts
+	// connections; meanwhile its route holds requests instead of failing.
+	Starting bool   `json:"starting,omitempty"`
+	Unowned  bool   `json:"unowned,omitempty"`
+	Upstream string `json:"upstream,omitempty"`
+	// Restore is set for --detach --restore apps: how to start them again
+	// after a reboot.
+	Restore *RestoreSpec `json:"restore,omitempty"`
+	// Recorder is set while `devwrap record` captures the app's traffic.
+	Recorder *RecorderHop `json:"recorder,omitempty"`
+	// Chaos is set by `devwrap chaos` to slow down or fail requests.
+	Chaos *ChaosOptions `json:"chaos,omitempty"`
+	RouteOptions
+}
+
+// RouteOptions holds per-app settings that shape the generated Caddy route.
+// It is embedded in App so the fields stay flat in state.json.
+type RouteOptions struct {
+	RouteOrder  string        `json:"route_order,omitempty"`
+	StripPrefix string        `json:"strip_prefix,omitempty"`
+	Rewrites    []PathRewrite `json:"rewrites,omitempty"`
+	SPA         bool          `json:"spa,omitempty"`
+	Stream      bool          `json:"stream,omitempty"`
+	Timeouts    RouteTimeouts `json:"timeouts,omitzero"`
+	MaxBodySize int64         `json:"max_body_size,omitempty"`
+	// Headers set on responses to the browser and on requests to the app.
+	ResponseHeaders map[string]string  `json:"response_headers,omitempty"`
+	RequestHeaders  map[string]string  `json:"request_headers,omitempty"`
+	BasicAuth       []BasicAuthAccount `json:"basic_auth,omitempty"`
+	// CORS lists the allowed origins, or "*" for any.
+	CORS []string `json:"cors,omitempty"`
+	// ACMEDNS names the DNS provider issuing a public certificate for the
+	// host via ACME DNS-01 instead of the internal issuer.
+	ACMEDNS string `json:"acme_dns,omitempty"`
+	// RedirectHTTPS answers the app's plain-HTTP route with a redirect to
+	// HTTPS; HSTS adds a Strict-Transport-Security header.
+	RedirectHTTPS bool `json:"redirect_https,omitempty"`
+	HSTS          bool `json:"hsts,omitempty"`
+	// LAN also matches the route on this machine's LAN IPs, with those IPs
+	// as certificate subjects, so other devices can reach the app.
+	LAN bool `json:"lan,omitempty"`
+	// ClientCA is the PEM file client certificates must chain to.
+	ClientCA string `json:"client_ca,omitempty"`
+	// UpstreamTLS dials the app over HTTPS; UpstreamInsecure skips verifying
+	// its (usually self-signed) certificate.
+	UpstreamTLS      bool `json:"upstream_tls,omitempty"`
+	UpstreamInsecure bool `json:"upstream_insecure,omitempty"`
+	// Trace wraps the route in Caddy's tracing handler, which exports a span
+	// per request and passes the trace context on to the app.
+	Trace bool `json:"trace,omitempty"`
+	// DialHost is the loopback host the route dials the app's port on:
+	// 127.0.0.1 when empty, ::1, or localhost.
+	DialHost string `json:"dial_host,omitempty"`
+}
+
+// RouteTimeouts tunes how long proxied connections may live. Read and Write
+// bound each upstream read/write; StreamTimeout caps WebSocket and other
+// upgraded streams; StreamCloseDelay keeps them open across Caddy config
+// reloads (devwrap reloads whenever any app starts or stops). Zero keeps
+// Caddy's default.
+type RouteTimeouts struct {
+	Read             time.Duration `json:"read,omitempty" yaml:"read"`
+	Write            time.Duration `json:"write,omitempty" yaml:"write"`
+	StreamTimeout    time.Duration `json:"stream,omitempty" yaml:"stream"`
+	StreamCloseDelay time.Duration `json:"stream_close_delay,omitempty" yaml:"stream_close_delay"`
+}
+
+func (t RouteTimeouts) Validate() error {
+	if t.Read < 0 || t.Write < 0 || t.StreamTimeout < 0 || t.StreamCloseDelay < 0 {
+		return errors.New("timeouts must be positive")
+	}
+	return nil
+}
+
+// PathRewrite is a regex substitution applied to the request path before it
+// is proxied upstream.
+type PathRewrite struct {
+	Find    string `json:"find"`
+	Replace string `json:"replace"`
+}
+
+// FileOverride serves a local file for one request path of an app instead of
+// proxying it.
+type FileOverride struct {
+	Path string `json:"path"`
+	File string `json:"file"`
+}
+
+func (a App) expired(now time.Time) bool {
+	if a.ExpiresAt == "" {
+		return false
+	}
+	t, err := time.Parse(time.RFC3339, a.ExpiresAt)
+	return err == nil && !now.Before(t)
+}
+
+// DialAddress is where the app's route proxies to: an explicit upstream
+// (a VM, LAN host, or container) or the app's port on this machine, which
+// a remote Caddy reaches at $DEVWRAP_UPSTREAM_HOST. While `devwrap record`
+// runs, the route goes through its hop instead.
+func (a App) DialAddress() string {
+	if a.Recorder != nil && ProcessAlive(a.Recorder.PID) {
+		return a.Recorder.Dial
+	}
+	return a.UpstreamAddress()
+}
+
+// UpstreamAddress is where the app itself is reached, skipping any
+// recorder hop.
+func (a App) UpstreamAddress() string {
+	if a.Upstream != "" {
+		return a.Upstream
+	}
+	host := AppDialHost
+	// A remote Caddy reaches the app through $DEVWRAP_UPSTREAM_HOST, never
+	// through this machine's loopback.
+	if a.DialHost != "" && AppDialHost == LoopbackDialHost {
+		host = a.DialHost
+	}
+	return net.JoinHostPort(host, strconv.Itoa(a.Port))
+}
+
+func (a App) HTTPSURL(httpsPort int) string {
+	if httpsPort == 443 {
+		return "https://" + a.Host + a.Path
+	}
+	return "https://" + a.Host + ":" + strconv.Itoa(httpsPort) + a.Path
+}
+
+// DaemonState is everything devwrap keeps in its state store.
+type DaemonState struct {
+	Version     int              `json:"version"`
+	CaddySource string           `json:"caddy_source"`
+	Root        bool             `json:"root"`
+	HTTPPort    int              `json:"http_port"`
+	HTTPSPort   int              `json:"https_port"`
+	Paused      bool             `json:"paused,omitempty"`
+	Apps        map[string]App   `json:"apps"`
+	History     []ExitedApp      `json:"history,omitempty"`
+	Compose     []ComposeService `json:"compose,omitempty"`
+	// Ports remembers the port last allocated to each app name, so the app
+	// gets it back on its next run.
+	Ports map[string]int `json:"ports,omitempty"`
+	// BootID is the boot the registered PIDs belong to; Restore queues
+	// --restore apps dropped by the first command after a reboot.
+	BootID  string           `json:"boot_id,omitempty"`
+	Restore []PendingRestore `json:"restore,omitempty"`
+}
+
+// ExitedApp is a history entry for an app that is no longer registered.
+// ExitCode is nil when devwrap did not observe the exit (the owning process
+// vanished, or the route was removed by hand).
+type ExitedApp struct {
+	Name     string   `json:"name"`
+	Host     string   `json:"host"`
+	Path     string   `json:"path,omitempty"`
+	Project  string   `json:"project,omitempty"`
+	Profiles []string `json:"profiles,omitempty"`
+	PID      int      `json:"pid"`
+	Reason   string   `json:"reason"`
+	ExitCode *int     `json:"exit_code,omitempty"`
+	ExitedAt string   `json:"exited_at"`
+}
+
+const appHistoryLimit = 20
+
+// RecordExit prepends app to the bounded exit history, newest first.
+func (s *DaemonState) RecordExit(app App, reason string, exitCode *int) {
+	entry := ExitedApp{
+		Name:     app.Name,
+		Host:     app.Host,
+		Path:     app.Path,
+		Project:  app.Project,
+		Profiles: app.Profiles,
+		PID:      app.PID,
+		Reason:   reason,
+		ExitCode: exitCode,
+		ExitedAt: time.Now().UTC().Format(time.RFC3339),
+	}
+	s.History = append([]ExitedApp{entry}, s.History...)
+	if len(s.History) > appHistoryLimit {
+		s.History = s.History[:appHistoryLimit]
+	}
+}
+
+// ActiveRootCert returns the root devwrap certificates chain to: the
+// devwrap-owned root when present, otherwise Caddy's local authority.
+func ActiveRootCert() (*x509.Certificate, error) {
+	if OwnCAApplied() {
+		return LoadOwnCARoot()
+	}
+	return rootCertFromAdmin("local")
+}
+
+func IsCertTrusted() bool {
+	cert, err := ActiveRootCert()
+	if err != nil {
+		return false
+	}
+	chains, err := cert.Verify(x509.VerifyOptions{})
+	return err == nil && len(chains) > 0
+}
+
+func rootCertFromAdmin(caID string) (*x509.Certificate, error) {
+	if caID == "" {
+		caID = "local"
+	}
+	res, err := AdminGet("/pki/ca/" + caID)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode >= 300 {
+		return nil, fmt.Errorf("admin API returned %d", res.StatusCode)
+	}
+	var payload struct {
+		RootCert string `json:"root_certificate"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&payload); err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode([]byte(payload.RootCert))
+	if block == nil {
+		return nil, errors.New("failed to decode root certificate PEM")
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	return cert, nil
+}
@@ -0,0 +1,121 @@
+package devwrap
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"sort"
+	"time"
+)
+
+// Lifecycle event types written to <state>/events.ndjson.
+const (
+	eventAppRegistered = "app_registered"
+	eventAppReady      = "app_ready"
+	eventAppReleased   = "app_released"
+)
+
+// maxEventsLogSize caps the event log; past it the log moves to
+// events.ndjson.1 and a new one starts, which followers pick up.
+const maxEventsLogSize = 1 << 20
+
+// Event is one line of the lifecycle feed `devwrap events` streams.
+type Event struct {
+	Time        string `json:"time"`
+	Type        string `json:"type"`
+	App         string `json:"app,omitempty"`
+	Host        string `json:"host,omitempty"`
+	Path        string `json:"path,omitempty"`
+	PID         int    `json:"pid,omitempty"`
+	Reason      string `json:"reason,omitempty"`
+	ExitCode    *int   `json:"exit_code,omitempty"`
+	HTTPPort    int    `json:"http_port,omitempty"`
+	HTTPSPort   int    `json:"https_port,omitempty"`
+	Trusted     *bool  `json:"trusted,omitempty"`
+	Fingerprint string `json:"fingerprint,omitempty"`
+}
+
+// RecordEvents appends events to the event log. It is best effort: a
+// missing state dir or a full disk must not fail the state change itself.
+func RecordEvents(events ...Event) {
+	if len(events) == 0 {
+		return
+	}
+	path, err := EventsPath()
+	if err != nil {
+		return
+	}
+	if info, err := os.Stat(path); err == nil && info.Size() > maxEventsLogSize {
+		_ = os.Rename(path, path+".1")
+	}
+	var buf bytes.Buffer
+	now := time.Now().UTC().Format(time.RFC3339Nano)
+	for _, ev := range events {
+		if ev.Time == "" {
+			ev.Time = now
+		}
+		b, err := json.Marshal(ev)
+		if err != nil {
+			continue
+		}
+		buf.Write(b)
+		buf.WriteByte('\n')
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	ChownToInvoker(path)
+	// One write per batch keeps concurrent appenders from interleaving lines.
+	_, _ = f.Write(buf.Bytes())
+}
+
+// stateEvents derives app lifecycle events from a state transition, so
+// every path that saves state reports registrations, readiness, and
+// releases without emitting them itself.
+func stateEvents(prev, next DaemonState) []Event {
+	var events []Event
+	names := make([]string, 0, len(prev.Apps)+len(next.Apps))
+	for name := range prev.Apps {
+		names = append(names, name)
+	}
+	for name := range next.Apps {
+		if _, ok := prev.Apps[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		old, hadOld := prev.Apps[name]
+		app, hasNew := next.Apps[name]
+		replaced := hadOld && hasNew && old.PID != app.PID
+		if hadOld && (!hasNew || replaced) {
+			ev := appEvent(eventAppReleased, old)
+			ev.Reason = "removed"
+			for _, exited := range next.History {
+				if exited.Name == old.Name && exited.PID == old.PID {
+					ev.Reason = exited.Reason
+					ev.ExitCode = exited.ExitCode
+					break
+				}
+			}
+			events = append(events, ev)
+		}
+		if !hasNew {
+			continue
+		}
+		registered := !hadOld || replaced
+		if registered {
+			events = append(events, appEvent(eventAppRegistered, app))
+		}
+		if !app.Starting && (registered || old.Starting) {
+			events = append(events, appEvent(eventAppReady, app))
+		}
+	}
+	return events
+}
+
+func appEvent(typ string, app App) Event {
+	return Event{Type: typ, App: app.Name, Host: app.Host, Path: app.Path, PID: app.PID}
+}
@@ -0,0 +1,108 @@
+package devwrap
+
+import (
+	"errors"
+	"path"
+	"strings"
+)
+
+// ValidateName checks an app, project, or profile name: lowercase letters,
+// numbers, and dashes, not starting or ending with a dash.
+func ValidateName(name string) error {
+	if name == "" {
+		return errors.New("app name cannot be empty")
+	}
+	for _, r := range name {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') || r == '-' {
+			continue
+		}
+		return errors.New("app name must use lowercase letters, numbers, or dashes")
+	}
+	if name[0] == '-' || name[len(name)-1] == '-' {
+		return errors.New("app name cannot start or end with a dash")
+	}
+	return nil
+}
+
+func HostForApp(name, customHost string) (string, error) {
+	if customHost == "" {
+		return name + ".localhost", nil
+	}
+	host, err := NormalizeHost(customHost)
+	if err != nil {
+		return "", err
+	}
+	return host, nil
+}
+
+func NormalizeHost(raw string) (string, error) {
+	host := strings.ToLower(strings.TrimSpace(raw))
+	if host == "" {
+		return "", errors.New("host cannot be empty")
+	}
+	if strings.Contains(host, "://") {
+		return "", errors.New("host must be a hostname without scheme")
+	}
+	if strings.Contains(host, "/") {
+		return "", errors.New("host must not include a path")
+	}
+	if strings.Contains(host, ":") {
+		return "", errors.New("host must not include a port")
+	}
+	if strings.HasPrefix(host, ".") || strings.HasSuffix(host, ".") || strings.Contains(host, "..") {
+		return "", errors.New("host format is invalid")
+	}
+	labels := strings.Split(host, ".")
+	for i, label := range labels {
+		if label == "" {
+			return "", errors.New("host format is invalid")
+		}
+		if label == "*" {
+			if i != 0 || len(labels) < 2 {
+				return "", errors.New("wildcard '*' is only allowed as the whole first label (e.g. *.tenant.localhost)")
+			}
+			continue
+		}
+		if label[0] == '-' || label[len(label)-1] == '-' {
+			return "", errors.New("host labels cannot start or end with '-'")
+		}
+		for _, r := range label {
+			if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') || r == '-' {
+				continue
+			}
+			return "", errors.New("host can use lowercase letters, numbers, dots, and dashes")
+		}
+	}
+	return host, nil
+}
+
+// NormalizeMountPath cleans a site mount path: "/" (or empty) means the host
+// root and is stored as "", anything else is "/seg[/seg...]" without a
+// trailing slash.
+func NormalizeMountPath(raw string) (string, error) {
+	p := strings.TrimSpace(raw)
+	if p == "" || p == "/" {
+		return "", nil
+	}
+	if !strings.HasPrefix(p, "/") {
+		return "", errors.New("mount path must start with /")
+	}
+	if strings.ContainsAny(p, "*?#") {
+		return "", errors.New("mount path must be a plain path prefix")
+	}
+	p = path.Clean(p)
+	if p == "/" {
+		return "", nil
+	}
+	return p, nil
+}
+
+// DefaultPathSite is the site apps started with --path share when no host
+// is given, i.e. https://dev.localhost/<path>.
+const DefaultPathSite = "dev"
+
+// isWildcardHost reports whether host matches any single label in its first
+// position, e.g. *.tenant.localhost.
+func isWildcardHost(host string) bool {
+	return strings.HasPrefix(host, "*.")
+}
@@ -0,0 +1,55 @@
+package devwrap
+
+import (
+	"net"
+	"sort"
+	"strconv"
+)
+
+// LANAddresses lists this machine's non-loopback unicast IPs on interfaces
+// that are up, for --lan routes and their certificate subjects. Link-local
+// addresses are skipped; phones on the same network reach the others.
+func LANAddresses() []string {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil
+	}
+	var out []string
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagLoopback != 0 {
+			continue
+		}
+		addrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, addr := range addrs {
+			ipNet, ok := addr.(*net.IPNet)
+			if !ok || !ipNet.IP.IsGlobalUnicast() {
+				continue
+			}
+			out = append(out, ipNet.IP.String())
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+// lanURLs are the https URLs a --lan app answers on from other devices.
+func lanURLs(app App, httpsPort int) []string {
+	if !app.LAN {
+		return nil
+	}
+	var urls []string
+	for _, ip := range LANAddresses() {
+		hostPort := net.JoinHostPort(ip, strconv.Itoa(httpsPort))
+		if httpsPort == 443 {
+			hostPort = ip
+			if net.ParseIP(ip).To4() == nil {
+				hostPort = "[" + ip + "]"
+			}
+		}
+		urls = append(urls, "https://"+hostPort+app.Path)
+	}
+	return urls
+}
@@ -0,0 +1,149 @@
+package devwrap
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// leaseHeartbeatTTL is how long a running app's lease survives without a
+// heartbeat from its devwrap wrapper, which checks in every
+// routeCheckInterval.
+const leaseHeartbeatTTL = 30 * time.Second
+
+// pidReused reports whether app.PID now belongs to a different process
+// than the one that took the lease. Leases from older versions carry no
+// start time and are trusted.
+func pidReused(app App) bool {
+	if app.PIDStart == 0 {
+		return false
+	}
+	start, ok := processStartTime(app.PID)
+	return ok && start != app.PIDStart
+}
+
+// heartbeatStale reports whether the owning wrapper has stopped checking
+// in, e.g. because it hangs. Leases without a heartbeat are trusted.
+func heartbeatStale(app App, now time.Time) bool {
+	t, ok := lastHeartbeat(app)
+	return ok && now.Sub(t) > leaseHeartbeatTTL
+}
+
+// lastHeartbeat is when app's wrapper last checked in: the mtime of its
+// heartbeat file, or the lease's heartbeat_at when that is newer (before
+// the first check-in, or after a restore).
+func lastHeartbeat(app App) (time.Time, bool) {
+	if app.HeartbeatAt == "" {
+		return time.Time{}, false
+	}
+	t, err := time.Parse(time.RFC3339, app.HeartbeatAt)
+	if err != nil {
+		return time.Time{}, false
+	}
+	if path, err := heartbeatPath(app.Name, app.PID); err == nil {
+		if info, err := os.Stat(path); err == nil && info.ModTime().After(t) {
+			t = info.ModTime()
+		}
+	}
+	return t, true
+}
+
+// heartbeatPath returns <state>/heartbeats/<name>.<pid>. Check-ins touch
+// it instead of saving state, so they take no lock and leave the state
+// backups alone.
+func heartbeatPath(name string, pid int) (string, error) {
+	dir, err := StateDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, heartbeatsDir, name+"."+strconv.Itoa(pid)), nil
+}
+
+func touchHeartbeat(name string, pid int) error {
+	path, err := heartbeatPath(name, pid)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	if err := os.Chtimes(path, now, now); !os.IsNotExist(err) {
+		return err
+	}
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return err
+	}
+	ChownToInvoker(dir)
+	if err := os.WriteFile(path, nil, 0o600); err != nil {
+		return err
+	}
+	ChownToInvoker(path)
+	return nil
+}
+
+// removeHeartbeat deletes the heartbeat file of the lease name held by pid.
+func removeHeartbeat(name string, pid int) {
+	if path, err := heartbeatPath(name, pid); err == nil {
+		_ = os.Remove(path)
+	}
+}
+
+// HeartbeatLease refreshes the lease of name held by pid and remembers it
+// in last. A lease pruned as stale while this wrapper was frozen (a
+// suspended laptop, Ctrl-Z) is restored from last, unless another app has
+// taken its host and path since; restored reports that.
+func HeartbeatLease(name string, pid int, last *App) (restored bool, err error) {
+	state, err := LoadLocalState()
+	if err != nil {
+		return false, err
+	}
+	if app, ok := state.Apps[name]; ok {
+		if app.PID != pid {
+			return false, fmt.Errorf("app %q is now owned by pid %d", name, app.PID)
+		}
+		*last = app
+		return false, touchHeartbeat(name, pid)
+	}
+	err = WithStateLock(func(tx *StateTx) error {
+		state, err := tx.Load()
+		if err != nil {
+			return err
+		}
+		if app, ok := state.Apps[name]; ok {
+			if app.PID != pid {
+				return fmt.Errorf("app %q is now owned by pid %d", name, app.PID)
+			}
+			*last = app
+			return touchHeartbeat(name, pid)
+		}
+		if last.Name == "" || !prunedAsStale(state.History, name, pid) {
+			return fmt.Errorf("lease for %q is gone; its route is no longer served", name)
+		}
+		for other, app := range state.Apps {
+			if strings.EqualFold(app.Host, last.Host) && app.Path == last.Path {
+				return fmt.Errorf("lease for %q went stale and app %q took its route", name, other)
+			}
+		}
+		app := *last
+		app.HeartbeatAt = time.Now().UTC().Format(time.RFC3339)
+		state.Apps[name] = app
+		if _, _, err := ApplyRoutesViaAdmin(state); err != nil {
+			return err
+		}
+		*last = app
+		restored = true
+		return tx.Save(state)
+	})
+	return restored, err
+}
+
+func prunedAsStale(history []ExitedApp, name string, pid int) bool {
+	for _, exited := range history {
+		if exited.Name == name {
+			return exited.PID == pid && exited.Reason == "stale"
+		}
+	}
+	return false
+}
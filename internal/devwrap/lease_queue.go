@@ -0,0 +1,168 @@
+package devwrap
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// leaseResult is what the process that registered a queued lease request
+// leaves for its requester.
+type leaseResult struct {
+	Lease *Lease `json:"lease,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+// staleLeaseQueueEntry is how long a result nobody collected (its
+// requester died) or a half-written request stays in the queue.
+const staleLeaseQueueEntry = time.Minute
+
+// RequestLeaseDirect queues req and registers it. Whichever process gets
+// the state lock first registers every queued request in one pass and
+// applies routes once, so apps started together (`devwrap up`, monorepo
+// scripts) cost one route sync per batch instead of one each; the others
+// find their result waiting when they get the lock.
+func RequestLeaseDirect(req LeaseRequest) (Lease, error) {
+	dir, err := leaseQueuePath()
+	if err != nil {
+		return Lease{}, err
+	}
+	b, err := json.Marshal(req)
+	if err != nil {
+		return Lease{}, err
+	}
+	// Zero-padded nanoseconds sort queued requests by arrival.
+	id := fmt.Sprintf("%020d-%d", time.Now().UnixNano(), os.Getpid())
+	reqPath := filepath.Join(dir, id+".req")
+	resPath := filepath.Join(dir, id+".res")
+	if err := writeQueueFile(reqPath, b); err != nil {
+		return Lease{}, err
+	}
+	defer os.Remove(reqPath)
+	defer os.Remove(resPath)
+
+	var res leaseResult
+	err = WithStateLock(func(tx *StateTx) error {
+		if _, err := os.Stat(resPath); os.IsNotExist(err) {
+			if err := processLeaseQueue(tx, dir); err != nil {
+				return err
+			}
+		}
+		b, err := os.ReadFile(resPath)
+		if err != nil {
+			return fmt.Errorf("lease request was not processed: %w", err)
+		}
+		return json.Unmarshal(b, &res)
+	})
+	if err != nil {
+		return Lease{}, err
+	}
+	if res.Error != "" {
+		return Lease{}, errors.New(res.Error)
+	}
+	if res.Lease == nil {
+		return Lease{}, errors.New("lease request was not processed")
+	}
+	return *res.Lease, nil
+}
+
+// processLeaseQueue registers every queued request under the held state
+// lock, applies routes and saves state once, and writes each request's
+// result. A request that conflicts fails alone; a failed route sync fails
+// the whole batch and saves nothing.
+func processLeaseQueue(tx *StateTx, dir string) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	var ids []string
+	for _, e := range entries {
+		name := e.Name()
+		if id, ok := strings.CutSuffix(name, ".req"); ok {
+			ids = append(ids, id)
+			continue
+		}
+		if info, err := e.Info(); err == nil && time.Since(info.ModTime()) > staleLeaseQueueEntry {
+			_ = os.Remove(filepath.Join(dir, name))
+		}
+	}
+	sort.Strings(ids)
+
+	state, err := tx.Load()
+	if err != nil {
+		return err
+	}
+	PruneDeadApps(&state)
+	results := make(map[string]leaseResult, len(ids))
+	granted := map[string]App{}
+	for _, id := range ids {
+		b, err := os.ReadFile(filepath.Join(dir, id+".req"))
+		if err != nil {
+			continue
+		}
+		var req LeaseRequest
+		if err := json.Unmarshal(b, &req); err != nil {
+			results[id] = leaseResult{Error: fmt.Sprintf("invalid lease request: %v", err)}
+			continue
+		}
+		if !req.Unowned && !ProcessAlive(req.PID) {
+			// The requester gave up or died; nobody would run the app.
+			_ = os.Remove(filepath.Join(dir, id+".req"))
+			continue
+		}
+		app, err := LeaseInState(&state, req)
+		if err != nil {
+			results[id] = leaseResult{Error: err.Error()}
+			continue
+		}
+		granted[id] = app
+	}
+
+	if len(granted) > 0 {
+		httpPort, httpsPort, err := ApplyRoutesViaAdmin(state)
+		if err == nil {
+			state.Version = 1
+			state.CaddySource = "unmanaged"
+			state.HTTPPort = httpPort
+			state.HTTPSPort = httpsPort
+			state.Root = httpPort == 80 && httpsPort == 443
+			err = tx.Save(state)
+		}
+		for id, app := range granted {
+			if err != nil {
+				results[id] = leaseResult{Error: err.Error()}
+				continue
+			}
+			lease := LeaseFromAppAndPorts(app, httpPort, httpsPort)
+			results[id] = leaseResult{Lease: &lease}
+		}
+	}
+
+	for id, res := range results {
+		b, err := json.Marshal(res)
+		if err != nil {
+			return err
+		}
+		if err := writeQueueFile(filepath.Join(dir, id+".res"), b); err != nil {
+			return err
+		}
+		_ = os.Remove(filepath.Join(dir, id+".req"))
+	}
+	return nil
+}
+
+// writeQueueFile writes b to path atomically, so the queue never holds a
+// half-written request or result.
+func writeQueueFile(path string, b []byte) error {
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, b, 0o600); err != nil {
+		return err
+	}
+	ChownToInvoker(tmp)
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,633 @@
+package devwrap
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"maps"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// LoadLocalState reads the last committed state, filling in defaults when
+// nothing was saved yet. Callers that save afterwards load through the
+// StateTx that WithStateLock hands them instead.
+func LoadLocalState() (DaemonState, error) {
+	store, err := ActiveStateStore()
+	if err != nil {
+		return defaultState(), err
+	}
+	return loadState(store)
+}
+
+func defaultState() DaemonState {
+	return DaemonState{
+		Version:     1,
+		CaddySource: "unmanaged",
+		HTTPPort:    80,
+		HTTPSPort:   443,
+		Apps:        map[string]App{},
+	}
+}
+
+// loadState decodes state from src over the defaults.
+func loadState(src interface{ Load(*DaemonState) error }) (DaemonState, error) {
+	state := defaultState()
+	if err := src.Load(&state); err != nil {
+		return state, err
+	}
+	if state.Apps == nil {
+		state.Apps = map[string]App{}
+	}
+	if state.CaddySource == "" || state.CaddySource == "existing" {
+		state.CaddySource = "unmanaged"
+	}
+	if state.CaddySource == "spawned" {
+		state.CaddySource = "managed"
+	}
+	return state, nil
+}
+
+// StateTx is the state as the holder of the state lock sees it. It is
+// only valid inside the WithStateLock callback it was passed to, on that
+// goroutine.
+type StateTx struct {
+	locked LockedState
+	// apps is the app set as last loaded or saved, which Save diffs
+	// against to derive events; seen is false until either happens.
+	apps map[string]App
+	seen bool
+}
+
+// Load reads state under the lock, including what this holder saved.
+func (tx *StateTx) Load() (DaemonState, error) {
+	state, err := loadState(tx.locked)
+	if err != nil {
+		return state, err
+	}
+	tx.apps, tx.seen = maps.Clone(state.Apps), true
+	return state, nil
+}
+
+// Save writes state and records the lifecycle events the change implies.
+func (tx *StateTx) Save(state DaemonState) error {
+	if state.BootID == "" {
+		state.BootID = BootID()
+	}
+	if !tx.seen {
+		// Nothing loaded yet: read what this save replaces.
+		prev, _ := loadState(tx.locked)
+		tx.apps = prev.Apps
+	}
+	if err := tx.locked.Save(state); err != nil {
+		return err
+	}
+	RecordEvents(stateEvents(DaemonState{Apps: tx.apps}, state)...)
+	tx.apps, tx.seen = maps.Clone(state.Apps), true
+	return nil
+}
+
+// LocalStatusFromFiles reports the proxy's state and its live apps,
+// pruning dead ones on the way.
+func LocalStatusFromFiles() (ProxyStatus, error) {
+	var out ProxyStatus
+	err := WithStateLock(func(tx *StateTx) error {
+		info, err := InspectExternalCaddy()
+		if err != nil {
+			return err
+		}
+		state, err := tx.Load()
+		if err != nil {
+			return err
+		}
+		if len(PruneDeadApps(&state)) > 0 {
+			_, _, _ = ApplyRoutesViaAdmin(state)
+			_ = tx.Save(state)
+		}
+		apps := make([]App, 0, len(state.Apps))
+		for _, app := range state.Apps {
+			if t, ok := lastHeartbeat(app); ok {
+				app.HeartbeatAt = t.UTC().Format(time.RFC3339)
+			}
+			apps = append(apps, app)
+		}
+		sort.Slice(apps, func(i, j int) bool { return apps[i].Name < apps[j].Name })
+		source := "unmanaged"
+		pid := 0
+		managedBy := ""
+		if info.Managed {
+			source = "managed"
+			if p, err := ReadDaemonPID(); err == nil && ProcessAlive(p) {
+				pid = p
+			}
+			if SystemdServiceActive() {
+				managedBy = "systemd"
+			}
+		}
+		out = ProxyStatus{
+			Running:     true,
+			CaddySource: source,
+			Root:        info.HTTPPort == 80 && info.HTTPSPort == 443,
+			HTTPPort:    info.HTTPPort,
+			HTTPSPort:   info.HTTPSPort,
+			Trusted:     IsCertTrusted(),
+			PID:         pid,
+			ManagedBy:   managedBy,
+			Paused:      state.Paused,
+			Apps:        apps,
+			History:     state.History,
+		}
+		return nil
+	})
+	if err != nil {
+		return ProxyStatus{}, err
+	}
+	return out, nil
+}
+
+// LeaseInState registers req in state, as one entry of a lease batch. It
+// leaves state unchanged when the request is refused.
+func LeaseInState(state *DaemonState, req LeaseRequest) (App, error) {
+	name := req.Name
+	appHost, err := HostForApp(name, req.Host)
+	if err != nil {
+		return App{}, err
+	}
+	if running, ok := state.Apps[name]; ok && running.PID != req.PID {
+		if running.Unowned {
+			return App{}, fmt.Errorf("app %q was registered with `devwrap add`; run `devwrap rm %s` first", name, name)
+		}
+		if !req.Instance {
+			return App{}, fmt.Errorf("app %q is already running (pid %d); stop it first or pass --instance to start another copy", name, running.PID)
+		}
+		name, appHost, err = nextInstanceName(state.Apps, name, appHost)
+		if err != nil {
+			return App{}, err
+		}
+	}
+	for appName, app := range state.Apps {
+		if appName != name && strings.EqualFold(app.Host, appHost) && app.Path == req.Path {
+			if req.Path != "" {
+				return App{}, fmt.Errorf("path %s on host %q is already used by app %q", req.Path, appHost, appName)
+			}
+			return App{}, fmt.Errorf("host %q is already used by app %q", appHost, appName)
+		}
+	}
+
+	if req.Route.LAN {
+		for appName, app := range state.Apps {
+			if appName != name && app.LAN && app.Path == req.Path {
+				return App{}, fmt.Errorf("app %q already serves the LAN addresses; stop it or use a different --path", appName)
+			}
+		}
+	}
+
+	if req.Port > 0 {
+		for appName, app := range state.Apps {
+			if appName != name && app.Port == req.Port {
+				return App{}, fmt.Errorf("port %d is already used by app %q", req.Port, appName)
+			}
+		}
+		if !req.Unowned && !portFree(req.Port) {
+			return App{}, fmt.Errorf("port %d is already in use by another process", req.Port)
+		}
+	}
+
+	now := time.Now().UTC()
+	expiresAt := ""
+	if req.TTL > 0 {
+		expiresAt = now.Add(req.TTL).Format(time.RFC3339)
+	}
+	app, ok := state.Apps[name]
+	if ok {
+		app.Host = appHost
+		app.Path = req.Path
+		app.PID = req.PID
+		app.StartedAt = now.Format(time.RFC3339)
+		app.ExpiresAt = expiresAt
+		app.RouteOptions = req.Route
+		app.Project = req.Project
+		app.Profiles = req.Profiles
+		app.LogFile = req.LogFile
+		app.Paused = false
+		app.Starting = !req.Unowned
+		app.Unowned = req.Unowned
+		app.Upstream = req.Upstream
+		app.Restore = req.Restore
+		if req.Port > 0 || req.Upstream != "" {
+			app.Port = req.Port
+		}
+	} else {
+		port := req.Port
+		if port == 0 && req.Upstream == "" {
+			if port, err = allocatePortFromApps(*state, name, req.PortStrategy); err != nil {
+				return App{}, err
+			}
+			// A fallback doesn't replace the remembered port, which the app
+			// gets back once it is free again.
+			if _, ok := state.Ports[name]; !ok && req.PortStrategy != PortStrategyHash {
+				if state.Ports == nil {
+					state.Ports = map[string]int{}
+				}
+				state.Ports[name] = port
+			}
+		}
+		app = App{
+			Name:         name,
+			Host:         appHost,
+			Path:         req.Path,
+			Port:         port,
+			PID:          req.PID,
+			StartedAt:    now.Format(time.RFC3339),
+			ExpiresAt:    expiresAt,
+			Project:      req.Project,
+			Profiles:     req.Profiles,
+			LogFile:      req.LogFile,
+			Starting:     !req.Unowned,
+			Unowned:      req.Unowned,
+			Upstream:     req.Upstream,
+			Restore:      req.Restore,
+			RouteOptions: req.Route,
+		}
+	}
+	app.PIDStart, app.HeartbeatAt = 0, ""
+	if !req.Unowned {
+		app.PIDStart, _ = processStartTime(req.PID)
+		app.HeartbeatAt = now.Format(time.RFC3339)
+	}
+	state.Apps[name] = app
+	return app, nil
+}
+
+// nextInstanceName picks the first free "<name>-N" slot for a concurrent copy
+// of an app, suffixing the first host label the same way (api-2.localhost).
+func nextInstanceName(apps map[string]App, name, host string) (string, string, error) {
+	for n := 2; n <= 99; n++ {
+		// Like the host, a project app suffixes its first label: web-2.shop.
+		candidate := instanceHost(name, n)
+		if _, taken := apps[candidate]; taken {
+			continue
+		}
+		candidateHost := instanceHost(host, n)
+		hostTaken := false
+		for _, app := range apps {
+			if strings.EqualFold(app.Host, candidateHost) && app.Path == "" {
+				hostTaken = true
+				break
+			}
+		}
+		if !hostTaken {
+			return candidate, candidateHost, nil
+		}
+	}
+	return "", "", fmt.Errorf("too many running instances of %q", name)
+}
+
+func instanceHost(host string, n int) string {
+	if rest, ok := strings.CutPrefix(host, "*."); ok {
+		// Suffix the first fixed label: *.tenant.localhost -> *.tenant-2.localhost.
+		return "*." + instanceHost(rest, n)
+	}
+	suffix := "-" + strconv.Itoa(n)
+	if i := strings.IndexByte(host, '.'); i > 0 {
+		return host[:i] + suffix + host[i:]
+	}
+	return host + suffix
+}
+
+// ReleaseLeaseDirect drops name's lease if pid still holds it and
+// re-applies routes.
+func ReleaseLeaseDirect(name string, pid int, exitCode *int) {
+	if pid > 0 {
+		defer removeHeartbeat(name, pid)
+	}
+	_ = WithStateLock(func(tx *StateTx) error {
+		state, err := tx.Load()
+		if err != nil {
+			return err
+		}
+		app, ok := state.Apps[name]
+		if !ok {
+			return nil
+		}
+		if pid > 0 && app.PID != pid {
+			return nil
+		}
+		state.RecordExit(app, "exited", exitCode)
+		delete(state.Apps, name)
+		if _, _, err := ApplyRoutesViaAdmin(state); err != nil {
+			return err
+		}
+		return tx.Save(state)
+	})
+}
+
+// RemoveDirect drops the app called name, whoever owns it, and
+// re-applies routes.
+func RemoveDirect(name string) error {
+	return WithStateLock(func(tx *StateTx) error {
+		state, err := tx.Load()
+		if err != nil {
+			return err
+		}
+		app, ok := state.Apps[name]
+		if !ok {
+			return nil
+		}
+		state.RecordExit(app, "removed", nil)
+		delete(state.Apps, name)
+		if _, _, err := ApplyRoutesViaAdmin(state); err != nil {
+			return err
+		}
+		return tx.Save(state)
+	})
+}
+
+// RemoveProjectDirect removes the routes of every app in project and
+// returns their names.
+func RemoveProjectDirect(project string) ([]string, error) {
+	removed := []string{}
+	err := WithStateLock(func(tx *StateTx) error {
+		state, err := tx.Load()
+		if err != nil {
+			return err
+		}
+		for name, app := range state.Apps {
+			if app.Project != project {
+				continue
+			}
+			state.RecordExit(app, "removed", nil)
+			delete(state.Apps, name)
+			removed = append(removed, name)
+		}
+		if len(removed) == 0 {
+			return nil
+		}
+		sort.Strings(removed)
+		if _, _, err := ApplyRoutesViaAdmin(state); err != nil {
+			return err
+		}
+		return tx.Save(state)
+	})
+	return removed, err
+}
+
+// SetPausedDirect pauses or resumes every route, or only the named app's
+// route when name is set.
+func SetPausedDirect(name string, paused bool) error {
+	return WithStateLock(func(tx *StateTx) error {
+		state, err := tx.Load()
+		if err != nil {
+			return err
+		}
+		PruneDeadApps(&state)
+		if name == "" {
+			state.Paused = paused
+		} else {
+			app, ok := state.Apps[name]
+			if !ok {
+				return fmt.Errorf("app %q is not registered", name)
+			}
+			app.Paused = paused
+			state.Apps[name] = app
+		}
+		if _, _, err := ApplyRoutesViaAdmin(state); err != nil {
+			return err
+		}
+		return tx.Save(state)
+	})
+}
+
+// ReapplyAllDirect pushes routes, TLS policy, and CA config for every live app.
+func ReapplyAllDirect() error {
+	return WithStateLock(func(tx *StateTx) error {
+		state, err := tx.Load()
+		if err != nil {
+			return err
+		}
+		PruneDeadApps(&state)
+		if _, _, err := ApplyRoutesViaAdmin(state); err != nil {
+			return err
+		}
+		return tx.Save(state)
+	})
+}
+
+// PruneDeadAppsDirect drops dead apps and their routes, touching Caddy and
+// state only when something was pruned.
+func PruneDeadAppsDirect() ([]string, error) {
+	var pruned []string
+	err := WithStateLock(func(tx *StateTx) error {
+		state, err := tx.Load()
+		if err != nil {
+			return err
+		}
+		if pruned = PruneDeadApps(&state); len(pruned) == 0 {
+			return nil
+		}
+		if _, _, err := ApplyRoutesViaAdmin(state); err != nil {
+			return err
+		}
+		return tx.Save(state)
+	})
+	return pruned, err
+}
+
+// UpdateAppDirect applies fn to a live tracked app, re-applies routes, and
+// saves state.
+func UpdateAppDirect(name string, fn func(app *App) error) (App, error) {
+	var out App
+	err := WithStateLock(func(tx *StateTx) error {
+		state, err := tx.Load()
+		if err != nil {
+			return err
+		}
+		PruneDeadApps(&state)
+		app, ok := state.Apps[name]
+		if !ok {
+			return fmt.Errorf("app %q is not registered", name)
+		}
+		if err := fn(&app); err != nil {
+			return err
+		}
+		state.Apps[name] = app
+		if _, _, err := ApplyRoutesViaAdmin(state); err != nil {
+			return err
+		}
+		out = app
+		return tx.Save(state)
+	})
+	return out, err
+}
+
+// AppLive reports whether a tracked app should keep its route: its owning
+// process is alive (or devwrap does not own one) and its TTL (if any) has not
+// elapsed.
+func AppLive(app App) bool {
+	now := time.Now()
+	if app.expired(now) {
+		return false
+	}
+	if app.Unowned {
+		return true
+	}
+	return ProcessAlive(app.PID) && !pidReused(app) && !heartbeatStale(app, now)
+}
+
+// PruneDeadApps drops apps that are no longer live, recording each in the
+// exit history, and returns the pruned names in sorted order.
+func PruneDeadApps(state *DaemonState) []string {
+	var pruned []string
+	for name, app := range state.Apps {
+		if AppLive(app) {
+			continue
+		}
+		reason := "vanished"
+		switch now := time.Now(); {
+		case app.expired(now):
+			reason = "expired"
+		case !app.Unowned && ProcessAlive(app.PID) && !pidReused(app) && heartbeatStale(app, now):
+			reason = "stale"
+		}
+		state.RecordExit(app, reason, nil)
+		delete(state.Apps, name)
+		removeHeartbeat(app.Name, app.PID)
+		pruned = append(pruned, name)
+	}
+	sort.Strings(pruned)
+	return pruned
+}
+
+const (
+	appPortMin = 11000
+	appPortMax = 19999
+)
+
+// PortStrategyEnv selects how app ports are allocated when --port-strategy
+// is not given.
+const PortStrategyEnv = "DEVWRAP_PORT_STRATEGY"
+
+// Port strategies: sequential hands out the first free port and remembers
+// it per name; hash derives the port from the name, so every machine
+// agrees on it.
+const (
+	PortStrategySequential = "sequential"
+	PortStrategyHash       = "hash"
+)
+
+// ResolvePortStrategy applies $DEVWRAP_PORT_STRATEGY to an unset flag and
+// validates the result.
+func ResolvePortStrategy(flag string) (string, error) {
+	s := flag
+	if s == "" {
+		s = os.Getenv(PortStrategyEnv)
+	}
+	switch s {
+	case "", PortStrategySequential:
+		return PortStrategySequential, nil
+	case PortStrategyHash:
+		return s, nil
+	}
+	return "", fmt.Errorf("invalid port strategy %q: want %s or %s", s, PortStrategySequential, PortStrategyHash)
+}
+
+// hashPort is name's home port in the allocation range (FNV-1a).
+func hashPort(name string) int {
+	h := fnv.New32a()
+	h.Write([]byte(name))
+	return appPortMin + int(h.Sum32()%(appPortMax-appPortMin+1))
+}
+
+// allocatePortFromApps picks a port for name. With the hash strategy it
+// probes upward from hashPort(name), wrapping around the range. Otherwise
+// it returns the port name had last time if that is still free, else the
+// first free port in the range that no other app remembers, else any free
+// port in the range.
+func allocatePortFromApps(state DaemonState, name, strategy string) (int, error) {
+	used := make(map[int]struct{}, len(state.Apps))
+	for _, app := range state.Apps {
+		used[app.Port] = struct{}{}
+	}
+	if strategy == PortStrategyHash {
+		home := hashPort(name)
+		for i := 0; i <= appPortMax-appPortMin; i++ {
+			port := appPortMin + (home-appPortMin+i)%(appPortMax-appPortMin+1)
+			if _, ok := used[port]; !ok && portFree(port) {
+				return port, nil
+			}
+		}
+		return 0, fmt.Errorf("no free ports in range %d-%d", appPortMin, appPortMax)
+	}
+	if port, ok := state.Ports[name]; ok {
+		if _, taken := used[port]; !taken && portFree(port) {
+			return port, nil
+		}
+	}
+	remembered := make(map[int]struct{}, len(state.Ports))
+	for other, port := range state.Ports {
+		if other != name {
+			remembered[port] = struct{}{}
+		}
+	}
+	for _, skip := range []map[int]struct{}{remembered, nil} {
+		for port := appPortMin; port <= appPortMax; port++ {
+			if _, ok := used[port]; ok {
+				continue
+			}
+			if _, ok := skip[port]; ok {
+				continue
+			}
+			if portFree(port) {
+				return port, nil
+			}
+		}
+	}
+	return 0, fmt.Errorf("no free ports in range %d-%d", appPortMin, appPortMax)
+}
+
+// portFree reports whether nothing listens on port on 127.0.0.1 or ::1, so
+// an app that binds either stack gets a port of its own. Without IPv6 only
+// 127.0.0.1 counts.
+func portFree(port int) bool {
+	ln, err := net.Listen("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(port)))
+	if err != nil {
+		return false
+	}
+	_ = ln.Close()
+	ln, err = net.Listen("tcp", net.JoinHostPort("::1", strconv.Itoa(port)))
+	if err != nil {
+		return !errors.Is(err, syscall.EADDRINUSE)
+	}
+	_ = ln.Close()
+	return true
+}
+
+// LeaseFromAppAndPorts builds the URLs app is served at.
+func LeaseFromAppAndPorts(app App, httpPort, httpsPort int) Lease {
+	httpURL := "http://" + app.Host
+	httpsURL := "https://" + app.Host
+	if httpPort != 80 {
+		httpURL += ":" + strconv.Itoa(httpPort)
+	}
+	if httpsPort != 443 {
+		httpsURL += ":" + strconv.Itoa(httpsPort)
+	}
+	httpURL += app.Path
+	httpsURL += app.Path
+	return Lease{
+		Name:      app.Name,
+		Host:      app.Host,
+		Path:      app.Path,
+		Port:      app.Port,
+		HTTPURL:   httpURL,
+		HTTPSURL:  httpsURL,
+		Trusted:   IsCertTrusted(),
+		ExpiresAt: app.ExpiresAt,
+		LANURLs:   lanURLs(app, httpsPort),
+	}
+}
@@ -0,0 +1,116 @@
+package devwrap
+
+import (
+	"net"
+	"strconv"
+	"testing"
+)
+
+func TestHashPort(t *testing.T) {
+	// Pinned: every machine must keep deriving the same port for a name.
+	tests := []struct {
+		name string
+		want int
+	}{
+		{"api", 13567},
+		{"web", 15825},
+		{"api.shop", 15631},
+	}
+	for _, tt := range tests {
+		if got := hashPort(tt.name); got != tt.want {
+			t.Errorf("hashPort(%q) = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestAllocatePortFromApps(t *testing.T) {
+	home := hashPort("api")
+	tests := []struct {
+		name     string
+		strategy string
+		apps     map[string]int
+		ports    map[string]int
+		listen   []int
+		want     int
+	}{
+		{
+			name:     "sequential starts at the bottom of the range",
+			strategy: PortStrategySequential,
+			want:     appPortMin,
+		},
+		{
+			name:     "sequential skips ports apps hold",
+			strategy: PortStrategySequential,
+			apps:     map[string]int{"web": appPortMin, "docs": appPortMin + 1},
+			want:     appPortMin + 2,
+		},
+		{
+			name:     "sequential skips ports something else listens on",
+			strategy: PortStrategySequential,
+			listen:   []int{appPortMin},
+			want:     appPortMin + 1,
+		},
+		{
+			name:     "sequential reuses the port the name had",
+			strategy: PortStrategySequential,
+			ports:    map[string]int{"api": appPortMin + 5},
+			want:     appPortMin + 5,
+		},
+		{
+			name:     "sequential skips ports other names remember",
+			strategy: PortStrategySequential,
+			ports:    map[string]int{"web": appPortMin, "docs": appPortMin + 1},
+			want:     appPortMin + 2,
+		},
+		{
+			name:     "sequential gives up a remembered port another app holds",
+			strategy: PortStrategySequential,
+			apps:     map[string]int{"web": appPortMin + 5},
+			ports:    map[string]int{"api": appPortMin + 5},
+			want:     appPortMin,
+		},
+		{
+			name:     "hash uses the name's home port",
+			strategy: PortStrategyHash,
+			want:     home,
+		},
+		{
+			name:     "hash ignores remembered ports",
+			strategy: PortStrategyHash,
+			ports:    map[string]int{"api": appPortMin},
+			want:     home,
+		},
+		{
+			name:     "hash probes upward past a held port",
+			strategy: PortStrategyHash,
+			apps:     map[string]int{"web": home},
+			listen:   []int{home + 1},
+			want:     home + 2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, port := range tt.listen {
+				ln, err := net.Listen("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(port)))
+				if err != nil {
+					t.Skipf("port %d is in use: %v", port, err)
+				}
+				defer ln.Close()
+			}
+			if !portFree(tt.want) {
+				t.Skipf("port %d is in use", tt.want)
+			}
+			state := DaemonState{Apps: map[string]App{}, Ports: tt.ports}
+			for name, port := range tt.apps {
+				state.Apps[name] = App{Name: name, Port: port}
+			}
+			got, err := allocatePortFromApps(state, "api", tt.strategy)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("got port %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,84 @@
+package devwrap
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// devwrapMTLSPolicyPrefix prefixes the @id of the TLS connection policy
+// devwrap adds per host for --require-client-cert.
+const devwrapMTLSPolicyPrefix = "devwrap-mtls-"
+
+// clientAuthPolicies builds one connection policy per host whose apps
+// require client certificates. Client auth happens in the TLS handshake,
+// before any path is known, so it covers every app sharing that host.
+func clientAuthPolicies(apps map[string]App) []map[string]any {
+	caFiles := map[string][]string{}
+	for _, app := range apps {
+		if app.ClientCA == "" {
+			continue
+		}
+		host := strings.ToLower(app.Host)
+		if !slices.Contains(caFiles[host], app.ClientCA) {
+			caFiles[host] = append(caFiles[host], app.ClientCA)
+		}
+	}
+	hosts := make([]string, 0, len(caFiles))
+	for host := range caFiles {
+		hosts = append(hosts, host)
+	}
+	sort.Strings(hosts)
+	policies := make([]map[string]any, 0, len(hosts))
+	for _, host := range hosts {
+		files := caFiles[host]
+		sort.Strings(files)
+		policies = append(policies, map[string]any{
+			"@id":   devwrapMTLSPolicyPrefix + host,
+			"match": map[string]any{"sni": []string{host}},
+			"client_authentication": map[string]any{
+				"ca":   map[string]any{"provider": "file", "pem_files": files},
+				"mode": "require_and_verify",
+			},
+		})
+	}
+	return policies
+}
+
+// syncClientAuthPolicies puts devwrap's client-auth connection policies
+// ahead of the HTTPS server's own, replacing earlier devwrap ones by @id.
+func syncClientAuthPolicies(serverName string, server map[string]any, apps map[string]App, undo *applyUndo) error {
+	if !isTLSServer(server) {
+		return nil
+	}
+	existing, _ := server["tls_connection_policies"].([]any)
+	out := make([]any, 0, len(existing)+len(apps))
+	for _, p := range clientAuthPolicies(apps) {
+		out = append(out, p)
+	}
+	changed := len(out) > 0
+	for _, policyAny := range existing {
+		if policy, ok := policyAny.(map[string]any); ok {
+			if id, _ := policy["@id"].(string); strings.HasPrefix(id, devwrapMTLSPolicyPrefix) {
+				changed = true
+				continue
+			}
+		}
+		out = append(out, policyAny)
+	}
+	if !changed || SameJSON(existing, out) {
+		return nil
+	}
+	path := "/config/apps/http/servers/" + serverName + "/tls_connection_policies"
+	undo.record(path, existing)
+	res, err := adminDoJSON("PATCH", path, out)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode >= 300 {
+		return fmt.Errorf("caddy TLS connection policy update failed: %s", AdminReadBody(res))
+	}
+	return nil
+}
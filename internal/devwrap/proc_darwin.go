@@ -0,0 +1,127 @@
+package devwrap
+
+import (
+	"bufio"
+	"bytes"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// processStartTime returns pid's start time in microseconds since the
+// epoch, from kinfo_proc. A recycled PID has a different one.
+func processStartTime(pid int) (uint64, bool) {
+	kp, err := unix.SysctlKinfoProc("kern.proc.pid", pid)
+	if err != nil || int(kp.Proc.P_pid) != pid {
+		return 0, false
+	}
+	tv := kp.Proc.P_starttime
+	return uint64(tv.Sec)*1e6 + uint64(tv.Usec), true
+}
+
+// BootID identifies the running boot by the kernel's boot time.
+func BootID() string {
+	tv, err := unix.SysctlTimeval("kern.boottime")
+	if err != nil {
+		return ""
+	}
+	return strconv.FormatInt(tv.Sec, 10) + "." + strconv.FormatInt(int64(tv.Usec), 10)
+}
+
+// ReadProcesses samples every process. The process table comes from the
+// kern.proc.all sysctl; CPU time and RSS are not part of kinfo_proc and
+// would need libproc (cgo), so they are read from ps(1).
+func ReadProcesses() (map[int]ProcInfo, error) {
+	kprocs, err := unix.SysctlKinfoProcSlice("kern.proc.all")
+	if err != nil {
+		return nil, err
+	}
+	procs := make(map[int]ProcInfo, len(kprocs))
+	for _, kp := range kprocs {
+		pid := int(kp.Proc.P_pid)
+		procs[pid] = ProcInfo{
+			PID:     pid,
+			PPID:    int(kp.Eproc.Ppid),
+			Command: unix.ByteSliceToString(kp.Proc.P_comm[:]),
+		}
+	}
+
+	out, err := exec.Command("ps", "-axo", "pid=,rss=,time=,comm=").Output()
+	if err != nil {
+		return procs, nil
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 4 {
+			continue
+		}
+		pid, err := strconv.Atoi(fields[0])
+		if err != nil {
+			continue
+		}
+		p, ok := procs[pid]
+		if !ok {
+			continue
+		}
+		rss, _ := strconv.ParseInt(fields[1], 10, 64)
+		p.RSS = rss * 1024
+		p.CPU = parsePsTime(fields[2])
+		if comm := filepath.Base(strings.Join(fields[3:], " ")); comm != "" {
+			p.Command = comm
+		}
+		procs[pid] = p
+	}
+	return procs, nil
+}
+
+// parsePsTime parses ps's cumulative CPU time, "[[dd-]hh:]mm:ss.cc".
+func parsePsTime(s string) time.Duration {
+	var days int64
+	if d, rest, ok := strings.Cut(s, "-"); ok {
+		days, _ = strconv.ParseInt(d, 10, 64)
+		s = rest
+	}
+	var total float64
+	for _, part := range strings.Split(s, ":") {
+		v, _ := strconv.ParseFloat(part, 64)
+		total = total*60 + v
+	}
+	return time.Duration(days)*24*time.Hour + time.Duration(total*float64(time.Second))
+}
+
+// ListeningPorts returns the TCP ports pids listen on, from lsof(8).
+func ListeningPorts(pids []int) []int {
+	if len(pids) == 0 {
+		return nil
+	}
+	list := make([]string, len(pids))
+	for i, pid := range pids {
+		list[i] = strconv.Itoa(pid)
+	}
+	out, _ := exec.Command("lsof", "-nP", "-a", "-p", strings.Join(list, ","), "-iTCP", "-sTCP:LISTEN", "-Fn").Output()
+	seen := map[int]bool{}
+	var ports []int
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		// Name lines look like n*:3000, n127.0.0.1:3000, or n[::1]:3000.
+		line, ok := strings.CutPrefix(scanner.Text(), "n")
+		if !ok {
+			continue
+		}
+		i := strings.LastIndexByte(line, ':')
+		if i < 0 {
+			continue
+		}
+		port, err := strconv.Atoi(line[i+1:])
+		if err == nil && !seen[port] {
+			seen[port] = true
+			ports = append(ports, port)
+		}
+	}
+	return ports
+}
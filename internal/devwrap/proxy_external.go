@@ -0,0 +1,812 @@
+package devwrap
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const caddyAdminListen = "127.0.0.1:2019"
+
+const devwrapInternalTLSPolicyID = "devwrap-internal-policy"
+
+// ExternalCaddyInfo is what InspectExternalCaddy finds about the running
+// Caddy.
+type ExternalCaddyInfo struct {
+	Available bool
+	HTTPPort  int
+	HTTPSPort int
+	Managed   bool
+}
+
+// InspectExternalCaddy reads how the running Caddy is set up.
+func InspectExternalCaddy() (ExternalCaddyInfo, error) {
+	servers, err := FetchExternalServers()
+	if err != nil {
+		return ExternalCaddyInfo{}, err
+	}
+	httpPort, httpsPort, _, _, err := ParseExternalServers(servers)
+	if err != nil {
+		return ExternalCaddyInfo{}, err
+	}
+	_, managed := servers["devwrap-http"]
+	return ExternalCaddyInfo{Available: true, HTTPPort: httpPort, HTTPSPort: httpsPort, Managed: managed}, nil
+}
+
+// ApplyRoutesViaAdmin brings Caddy's routes, TLS policies, and CA in line
+// with state as one transaction. When it wrote anything it checks the result
+// the way `proxy verify` does, and when any step fails it puts back the
+// lists it changed, so a failed apply never leaves routes updated without
+// their TLS policy. Only devwrap's entries are restored (see applyUndo).
+func ApplyRoutesViaAdmin(state DaemonState) (int, int, error) {
+	var undo applyUndo
+	httpPort, httpsPort, err := applyDevwrapConfig(state, &undo)
+	if err == nil && len(undo.steps) > 0 {
+		err = verifyAppliedConfig(state)
+	}
+	if err != nil {
+		if rollbackErr := undo.rollback(); rollbackErr != nil {
+			return 0, 0, fmt.Errorf("%w; rolling devwrap's caddy changes back also failed: %v (run `devwrap proxy reload` once caddy is healthy)", err, rollbackErr)
+		}
+		return 0, 0, fmt.Errorf("%w (devwrap's caddy changes rolled back)", err)
+	}
+	return httpPort, httpsPort, nil
+}
+
+// verifyAppliedConfig fails when Caddy doesn't hold what was just applied.
+func verifyAppliedConfig(state DaemonState) error {
+	problems, err := VerifyProxyState(state)
+	if err != nil {
+		return err
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("caddy config does not match after applying: %s", problems[0].Detail)
+	}
+	return nil
+}
+
+// applyDevwrapConfig writes state's routes to both servers, then the
+// client-auth policies, CA, and TLS policy, recording each list it changes
+// in undo.
+func applyDevwrapConfig(state DaemonState, undo *applyUndo) (int, int, error) {
+	apps := state.Apps
+	servers, err := FetchExternalServers()
+	if err != nil {
+		return 0, 0, err
+	}
+	httpPort, httpsPort, httpName, httpsName, err := ParseExternalServers(servers)
+	if err != nil {
+		return 0, 0, err
+	}
+	if err := snapshotExternalConfig(servers); err != nil {
+		return 0, 0, err
+	}
+
+	devwrapRoutes := MakeDevwrapRoutes(state, 0)
+	httpRoutesWanted := devwrapRoutes
+	if httpName != httpsName {
+		httpRoutesWanted = MakeDevwrapRoutes(state, httpsPort)
+	}
+
+	orders := make(map[string]string, len(apps))
+	for _, app := range apps {
+		orders["devwrap-"+app.Name] = app.RouteOrder
+	}
+
+	if err := syncServerRoutes(httpName, httpRoutesWanted, orders, undo); err != nil {
+		return 0, 0, err
+	}
+
+	if httpsName != "" {
+		if err := syncServerRoutes(httpsName, devwrapRoutes, orders, undo); err != nil {
+			return 0, 0, err
+		}
+		if err := syncClientAuthPolicies(httpsName, servers[httpsName], apps, undo); err != nil {
+			return 0, 0, err
+		}
+	}
+
+	if err := syncOwnCA(undo); err != nil {
+		return 0, 0, err
+	}
+	if err := syncDevwrapInternalTLSPolicy(apps, undo); err != nil {
+		return 0, 0, err
+	}
+
+	return httpPort, httpsPort, nil
+}
+
+func syncDevwrapInternalTLSPolicy(apps map[string]App, undo *applyUndo) error {
+	subjectSets := map[string]map[string]struct{}{}
+	for _, app := range apps {
+		id, subject := tlsPolicyForApp(app)
+		if subjectSets[id] == nil {
+			subjectSets[id] = map[string]struct{}{}
+		}
+		subjectSets[id][subject] = struct{}{}
+		if app.LAN {
+			// The internal issuer signs IP SANs; ACME can't.
+			if subjectSets[devwrapInternalTLSPolicyID] == nil {
+				subjectSets[devwrapInternalTLSPolicyID] = map[string]struct{}{}
+			}
+			for _, ip := range LANAddresses() {
+				subjectSets[devwrapInternalTLSPolicyID][ip] = struct{}{}
+			}
+		}
+	}
+	subjects := make(map[string][]string, len(subjectSets))
+	for id, set := range subjectSets {
+		list := make([]string, 0, len(set))
+		for subject := range set {
+			list = append(list, subject)
+		}
+		sort.Strings(list)
+		subjects[id] = list
+	}
+
+	policies, found, err := fetchTLSAutomationPolicies()
+	if err != nil {
+		return err
+	}
+
+	merged := mergeDevwrapInternalTLSPolicy(policies, subjects)
+	if found {
+		if SameJSON(policies, merged) {
+			return nil
+		}
+		undo.record(tlsAutomationPoliciesPath, policies)
+		return putTLSAutomationPolicies(merged)
+	}
+	if len(subjects) == 0 {
+		return nil
+	}
+	undo.record(tlsAutomationPoliciesPath, nil)
+	return createTLSAppWithPolicies(merged)
+}
+
+func tlsSubjectForHost(host string) string {
+	h := strings.ToLower(strings.TrimSpace(host))
+	if isWildcardHost(h) {
+		return h
+	}
+	if i := strings.IndexByte(h, '.'); i > 0 && i < len(h)-1 {
+		return "*." + h[i+1:]
+	}
+	return h
+}
+
+const tlsAutomationPoliciesPath = "/config/apps/tls/automation/policies"
+
+func fetchTLSAutomationPolicies() ([]any, bool, error) {
+	res, err := AdminGet(tlsAutomationPoliciesPath)
+	if err != nil {
+		return nil, false, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode == http.StatusNotFound {
+		return nil, false, nil
+	}
+	if res.StatusCode >= 300 {
+		return nil, false, fmt.Errorf("caddy TLS policy query failed: %s", AdminReadBody(res))
+	}
+	var policies []any
+	if err := json.NewDecoder(res.Body).Decode(&policies); err != nil {
+		return nil, false, err
+	}
+	return policies, true, nil
+}
+
+// mergeDevwrapInternalTLSPolicy replaces devwrap's TLS policies (keyed by
+// @id) in front of the existing ones: per-provider ACME policies first, as
+// their subjects are exact hosts, then the internal-issuer policy.
+func mergeDevwrapInternalTLSPolicy(existing []any, subjects map[string][]string) []any {
+	out := make([]any, 0, len(existing)+len(subjects))
+	ids := make([]string, 0, len(subjects))
+	for id := range subjects {
+		if id != devwrapInternalTLSPolicyID {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		out = append(out, acmeDNSPolicy(strings.TrimPrefix(id, devwrapACMETLSPolicyPrefix), subjects[id]))
+	}
+	if hosts := subjects[devwrapInternalTLSPolicyID]; len(hosts) > 0 {
+		out = append(out, map[string]any{
+			"@id":      devwrapInternalTLSPolicyID,
+			"subjects": hosts,
+			"issuers":  []map[string]any{InternalIssuer()},
+		})
+	}
+	for _, policyAny := range existing {
+		policy, ok := policyAny.(map[string]any)
+		if !ok {
+			out = append(out, policyAny)
+			continue
+		}
+		id, _ := policy["@id"].(string)
+		if isDevwrapTLSPolicyID(id) {
+			continue
+		}
+		out = append(out, policyAny)
+	}
+	return out
+}
+
+func putTLSAutomationPolicies(policies []any) error {
+	res, err := adminDoJSON(http.MethodPatch, tlsAutomationPoliciesPath, policies)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode >= 300 {
+		return fmt.Errorf("caddy TLS policy update failed: %s", AdminReadBody(res))
+	}
+	return nil
+}
+
+func createTLSAppWithPolicies(policies []any) error {
+	res, err := adminDoJSON(http.MethodPut, "/config/apps/tls", map[string]any{
+		"automation": map[string]any{"policies": policies},
+	})
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode >= 300 {
+		return fmt.Errorf("caddy TLS app create failed: %s", AdminReadBody(res))
+	}
+	return nil
+}
+
+// MakeDevwrapRoutes builds one route per app. Apps mounted under a path on a
+// shared host (a "site") are ordered longest path first so "/api" is matched
+// before the "/" member, and wildcard hosts come after specific ones. For the
+// plain-HTTP server, redirectPort is the HTTPS port --redirect-https apps
+// redirect to; it is 0 for the HTTPS server.
+func MakeDevwrapRoutes(state DaemonState, redirectPort int) []map[string]any {
+	apps := RouteOrderedApps(state.Apps)
+	routes := make([]map[string]any, 0, len(apps))
+	for _, app := range apps {
+		handle := appHandlers(app)
+		if state.Paused || app.Paused {
+			handle = pausedHandlers(app.Name)
+		} else if app.RedirectHTTPS && redirectPort > 0 {
+			handle = httpsRedirectHandlers(redirectPort)
+		}
+		hosts := []string{app.Host}
+		if app.LAN {
+			hosts = append(hosts, LANAddresses()...)
+		}
+		match := map[string]any{"host": hosts}
+		if app.Path != "" {
+			match["path"] = []string{app.Path, app.Path + "/*"}
+		}
+		routes = append(routes, map[string]any{
+			"@id":    "devwrap-" + app.Name,
+			"match":  []map[string]any{match},
+			"handle": handle,
+		})
+	}
+	return routes
+}
+
+// RouteOrderedApps sorts apps the way their routes are matched: by host,
+// longest mount path first, wildcard hosts last.
+func RouteOrderedApps(byName map[string]App) []App {
+	apps := make([]App, 0, len(byName))
+	for _, app := range byName {
+		apps = append(apps, app)
+	}
+	sort.Slice(apps, func(i, j int) bool {
+		a, b := apps[i], apps[j]
+		// Wildcard hosts go last so a specific host under them still wins.
+		if wa, wb := isWildcardHost(a.Host), isWildcardHost(b.Host); wa != wb {
+			return wb
+		}
+		if a.Host != b.Host {
+			return a.Host < b.Host
+		}
+		if len(a.Path) != len(b.Path) {
+			return len(a.Path) > len(b.Path)
+		}
+		return a.Name < b.Name
+	})
+	return apps
+}
+
+// appHandlers builds the handler chain for an app's route: path rewrites and
+// the reverse proxy to its upstream, preceded by any per-path file overrides.
+func appHandlers(app App) []map[string]any {
+	// CORS comes first so preflights (which never carry credentials) are
+	// answered before basic auth. Basic auth guards the whole route, file
+	// overrides included.
+	var handlers []map[string]any
+	if app.Trace {
+		// First, so requests CORS or basic auth answer are traced too.
+		handlers = append(handlers, map[string]any{"handler": "tracing", "span": app.Name})
+	}
+	if cors := corsHandler(app.RouteOptions); cors != nil {
+		handlers = append(handlers, cors)
+	}
+	if len(app.BasicAuth) > 0 {
+		handlers = append(handlers, basicAuthHandler(app.Name, app.BasicAuth))
+	}
+	var proxy []map[string]any
+	if app.MaxBodySize > 0 {
+		// Oversized uploads get 413 from the proxy before reaching the app.
+		proxy = append(proxy, map[string]any{"handler": "request_body", "max_size": app.MaxBodySize})
+	}
+	if h := headersHandler(app.RouteOptions); h != nil {
+		proxy = append(proxy, h)
+	}
+	if app.SPA {
+		proxy = append(proxy, spaFallbackHandler(app.Path))
+	}
+	if rewrite := rewriteHandler(app.RouteOptions); rewrite != nil {
+		proxy = append(proxy, rewrite)
+	}
+	proxy = append(proxy, chaosHandlers(app.Chaos)...)
+	reverseProxy := map[string]any{
+		"handler":   "reverse_proxy",
+		"upstreams": []map[string]any{{"dial": app.DialAddress()}},
+	}
+	if app.Starting {
+		// Retry dialing while the app boots so the first request waits for
+		// it instead of getting a 502.
+		reverseProxy["load_balancing"] = map[string]any{
+			"try_duration": readyHoldTimeout.String(),
+			"try_interval": ReadyPollInterval.String(),
+		}
+	}
+	if app.Stream {
+		// Flush every write so SSE and chunked streams aren't held back.
+		reverseProxy["flush_interval"] = -1
+	}
+	if app.Timeouts.StreamTimeout > 0 {
+		reverseProxy["stream_timeout"] = app.Timeouts.StreamTimeout.String()
+	}
+	if app.Timeouts.StreamCloseDelay > 0 {
+		reverseProxy["stream_close_delay"] = app.Timeouts.StreamCloseDelay.String()
+	}
+	if transport := upstreamTransport(app.RouteOptions); transport != nil {
+		reverseProxy["transport"] = transport
+	}
+	proxy = append(proxy, reverseProxy)
+	proxy = []map[string]any{startingPageHandler(app, proxy)}
+	if len(app.Overrides) == 0 {
+		return append(handlers, proxy...)
+	}
+	routes := make([]map[string]any, 0, len(app.Overrides)+1)
+	for _, o := range app.Overrides {
+		routes = append(routes, map[string]any{
+			"match": []map[string]any{{"path": []string{o.Path}}},
+			"handle": []map[string]any{
+				{"handler": "rewrite", "uri": "/" + filepath.Base(o.File)},
+				{"handler": "file_server", "root": filepath.Dir(o.File)},
+			},
+			"terminal": true,
+		})
+	}
+	routes = append(routes, map[string]any{"handle": proxy})
+	return append(handlers, map[string]any{"handler": "subroute", "routes": routes})
+}
+
+// upstreamTransport returns the reverse_proxy http transport for TLS
+// upstreams and read/write timeouts, or nil for Caddy's default.
+func upstreamTransport(opts RouteOptions) map[string]any {
+	if !opts.UpstreamTLS && opts.Timeouts.Read == 0 && opts.Timeouts.Write == 0 {
+		return nil
+	}
+	transport := map[string]any{"protocol": "http"}
+	if opts.UpstreamTLS {
+		tls := map[string]any{}
+		if opts.UpstreamInsecure {
+			tls["insecure_skip_verify"] = true
+		}
+		transport["tls"] = tls
+	}
+	if opts.Timeouts.Read > 0 {
+		transport["read_timeout"] = opts.Timeouts.Read.String()
+	}
+	if opts.Timeouts.Write > 0 {
+		transport["write_timeout"] = opts.Timeouts.Write.String()
+	}
+	return transport
+}
+
+func rewriteHandler(opts RouteOptions) map[string]any {
+	if opts.StripPrefix == "" && len(opts.Rewrites) == 0 {
+		return nil
+	}
+	h := map[string]any{"handler": "rewrite"}
+	if opts.StripPrefix != "" {
+		h["strip_path_prefix"] = opts.StripPrefix
+	}
+	if len(opts.Rewrites) > 0 {
+		rules := make([]map[string]any, 0, len(opts.Rewrites))
+		for _, r := range opts.Rewrites {
+			rules = append(rules, map[string]any{"find": r.Find, "replace": r.Replace})
+		}
+		h["path_regexp"] = rules
+	}
+	return h
+}
+
+// headersHandler sets --set-header values on responses and
+// --set-request-header values on requests to the app. Response headers are
+// deferred so they replace whatever the app sends.
+func headersHandler(opts RouteOptions) map[string]any {
+	response := headerValues(opts.ResponseHeaders)
+	if _, set := response[HSTSHeader]; opts.HSTS && !set {
+		// Browsers ignore it on plain HTTP, so one handler serves both servers.
+		response[HSTSHeader] = []string{HSTSValue}
+	}
+	if len(response) == 0 && len(opts.RequestHeaders) == 0 {
+		return nil
+	}
+	h := map[string]any{"handler": "headers"}
+	if len(opts.RequestHeaders) > 0 {
+		h["request"] = map[string]any{"set": headerValues(opts.RequestHeaders)}
+	}
+	if len(response) > 0 {
+		h["response"] = map[string]any{"set": response, "deferred": true}
+	}
+	return h
+}
+
+// --hsts sends a production-like Strict-Transport-Security header. No
+// includeSubDomains: that would pin every *.localhost app to HTTPS.
+const (
+	HSTSHeader = "Strict-Transport-Security"
+	HSTSValue  = "max-age=31536000"
+)
+
+func headerValues(headers map[string]string) map[string][]string {
+	out := make(map[string][]string, len(headers))
+	for name, value := range headers {
+		out[name] = []string{value}
+	}
+	return out
+}
+
+// spaFallbackHandler rewrites browser navigations to client-side routes
+// (GET/HEAD asking for HTML, no file extension) to the app's root, the way
+// vite and CRA dev servers answer them. It runs before the app's own
+// rewrites so --strip-prefix still applies.
+func spaFallbackHandler(mount string) map[string]any {
+	return map[string]any{
+		"handler": "subroute",
+		"routes": []map[string]any{{
+			"match": []map[string]any{{
+				"method": []string{"GET", "HEAD"},
+				"header": map[string][]string{"Accept": {"*text/html*"}},
+				"not":    []map[string]any{{"path": []string{"*.*"}}},
+			}},
+			"handle": []map[string]any{{"handler": "rewrite", "uri": mount + "/"}},
+		}},
+	}
+}
+
+// httpsRedirectHandlers permanently redirects plain-HTTP requests to the
+// same host and URI on the HTTPS port.
+func httpsRedirectHandlers(httpsPort int) []map[string]any {
+	location := "https://{http.request.host}{http.request.uri}"
+	if httpsPort != 443 {
+		location = "https://{http.request.host}:" + strconv.Itoa(httpsPort) + "{http.request.uri}"
+	}
+	return []map[string]any{{
+		"handler":     "static_response",
+		"status_code": http.StatusPermanentRedirect,
+		"headers":     map[string][]string{"Location": {location}},
+	}}
+}
+
+// pausedHandlers answers every request with 503 while keeping the route (and
+// its @id) in place so resuming is just another apply.
+func pausedHandlers(name string) []map[string]any {
+	return []map[string]any{{
+		"handler":     "static_response",
+		"status_code": 503,
+		"headers": map[string][]string{
+			"Content-Type": {"text/plain; charset=utf-8"},
+			"Retry-After":  {"60"},
+		},
+		"body": "devwrap: " + name + " is paused\n",
+	}}
+}
+
+// mergeExternalRoutes replaces devwrap-* routes in a server's route list.
+// orders maps a devwrap route @id to its placement: "" or "last" appends after
+// unmanaged routes, "first" prepends ahead of them (so catch-alls can't shadow
+// it), and "after:<@id>" inserts right after that route.
+func mergeExternalRoutes(existing []any, devwrapRoutes []map[string]any, orders map[string]string) ([]any, error) {
+	var first, last []any
+	after := map[string][]any{}
+	for _, route := range devwrapRoutes {
+		id, _ := route["@id"].(string)
+		order := orders[id]
+		switch {
+		case order == "first":
+			first = append(first, route)
+		case strings.HasPrefix(order, "after:"):
+			target := strings.TrimPrefix(order, "after:")
+			after[target] = append(after[target], route)
+		default:
+			last = append(last, route)
+		}
+	}
+
+	out := make([]any, 0, len(existing)+len(devwrapRoutes))
+	out = append(out, first...)
+	for _, route := range existing {
+		routeMap, ok := route.(map[string]any)
+		if !ok {
+			out = append(out, route)
+			continue
+		}
+		id, _ := routeMap["@id"].(string)
+		if strings.HasPrefix(id, "devwrap-") {
+			continue
+		}
+		out = append(out, route)
+		if id != "" {
+			out = append(out, after[id]...)
+			delete(after, id)
+		}
+	}
+	targets := make([]string, 0, len(after))
+	for target := range after {
+		targets = append(targets, target)
+	}
+	sort.Strings(targets)
+	for _, target := range targets {
+		out = append(out, after[target]...)
+	}
+	out = append(out, last...)
+	return out, nil
+}
+
+func FetchExternalServers() (map[string]map[string]any, error) {
+	res, err := AdminGet("/config/apps/http/servers")
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode >= 300 {
+		b, _ := io.ReadAll(res.Body)
+		return nil, fmt.Errorf("caddy admin query failed: %s", strings.TrimSpace(string(b)))
+	}
+	var raw map[string]any
+	if err := json.NewDecoder(res.Body).Decode(&raw); err != nil {
+		return nil, err
+	}
+	servers := make(map[string]map[string]any, len(raw))
+	for k, v := range raw {
+		m, ok := v.(map[string]any)
+		if !ok {
+			continue
+		}
+		servers[k] = m
+	}
+	if len(servers) == 0 {
+		return nil, errors.New("caddy has no HTTP servers configured")
+	}
+	return servers, nil
+}
+
+func ParseExternalServers(servers map[string]map[string]any) (int, int, string, string, error) {
+	if server, ok := servers["devwrap-http"]; ok {
+		httpPort := firstListenPort(server)
+		if httpPort == 0 {
+			httpPort = 80
+		}
+		httpsPort := 443
+		httpsName := ""
+		if httpsServer, ok := servers["devwrap-https"]; ok {
+			httpsName = "devwrap-https"
+			if p := firstListenPort(httpsServer); p > 0 {
+				httpsPort = p
+			}
+		}
+		if httpsName == "" {
+			httpsPort = httpPort
+		}
+		return httpPort, httpsPort, "devwrap-http", httpsName, nil
+	}
+
+	httpName := ""
+	httpsName := ""
+	httpPort := 80
+	httpsPort := 443
+
+	names := make([]string, 0, len(servers))
+	for name := range servers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		server := servers[name]
+		port := firstListenPort(server)
+		if isTLSServer(server) || hasListenPort(server, 443) {
+			if httpsName == "" {
+				httpsName = name
+				if port > 0 {
+					httpsPort = port
+				}
+			}
+			continue
+		}
+		if httpName == "" {
+			httpName = name
+			if port > 0 {
+				httpPort = port
+			}
+		}
+	}
+
+	if httpName == "" && httpsName == "" {
+		return 0, 0, "", "", errors.New("unable to determine caddy server ports")
+	}
+	if httpName == "" {
+		httpName = httpsName
+	}
+	if httpsName == "" {
+		httpsName = httpName
+	}
+	return httpPort, httpsPort, httpName, httpsName, nil
+}
+
+func isTLSServer(server map[string]any) bool {
+	v, ok := server["tls_connection_policies"]
+	if !ok {
+		return false
+	}
+	arr, ok := v.([]any)
+	return ok && len(arr) > 0
+}
+
+func firstListenPort(server map[string]any) int {
+	listenAny, ok := server["listen"]
+	if !ok {
+		return 0
+	}
+	listen, ok := listenAny.([]any)
+	if !ok || len(listen) == 0 {
+		return 0
+	}
+	s, ok := listen[0].(string)
+	if !ok {
+		return 0
+	}
+	if strings.HasPrefix(s, ":") {
+		n, _ := strconv.Atoi(strings.TrimPrefix(s, ":"))
+		return n
+	}
+	parts := strings.Split(s, ":")
+	if len(parts) == 0 {
+		return 0
+	}
+	n, _ := strconv.Atoi(parts[len(parts)-1])
+	return n
+}
+
+func hasListenPort(server map[string]any, want int) bool {
+	listenAny, ok := server["listen"]
+	if !ok {
+		return false
+	}
+	listen, ok := listenAny.([]any)
+	if !ok {
+		return false
+	}
+	for _, item := range listen {
+		s, ok := item.(string)
+		if !ok {
+			continue
+		}
+		if parseListenPort(s) == want {
+			return true
+		}
+	}
+	return false
+}
+
+func parseListenPort(s string) int {
+	if strings.HasPrefix(s, ":") {
+		n, _ := strconv.Atoi(strings.TrimPrefix(s, ":"))
+		return n
+	}
+	parts := strings.Split(s, ":")
+	if len(parts) == 0 {
+		return 0
+	}
+	n, _ := strconv.Atoi(parts[len(parts)-1])
+	return n
+}
+
+// routeSyncAttempts bounds how often syncServerRoutes starts over when
+// another client changes a server's routes under it.
+const routeSyncAttempts = 3
+
+// errRoutesChanged means a server's routes changed between devwrap reading
+// and writing them.
+var errRoutesChanged = errors.New("caddy routes changed concurrently")
+
+// syncServerRoutes replaces devwrap's routes on serverName without
+// clobbering routes another tool changed meanwhile: the write carries the
+// ETag of the routes it was merged from, and when Caddy rejects it the
+// routes are read and merged again.
+func syncServerRoutes(serverName string, devwrapRoutes []map[string]any, orders map[string]string, undo *applyUndo) error {
+	for attempt := 0; attempt < routeSyncAttempts; attempt++ {
+		existing, etag, err := fetchServerRoutes(serverName)
+		if err != nil {
+			return err
+		}
+		routes, err := mergeExternalRoutes(existing, devwrapRoutes, orders)
+		if err != nil {
+			return err
+		}
+		written, err := writeServerRoutes(serverName, existing, routes, etag)
+		if written {
+			undo.record(serverRoutesPath(serverName), existing)
+		}
+		if !errors.Is(err, errRoutesChanged) {
+			return err
+		}
+	}
+	return fmt.Errorf("caddy routes on server %s kept changing while devwrap updated them; try again", serverName)
+}
+
+func serverRoutesPath(serverName string) string {
+	return "/config/apps/http/servers/" + serverName + "/routes"
+}
+
+// fetchServerRoutes returns a server's routes and their ETag. A server
+// without routes has no ETag.
+func fetchServerRoutes(serverName string) ([]any, string, error) {
+	res, err := AdminGet(serverRoutesPath(serverName))
+	if err != nil {
+		return nil, "", err
+	}
+	defer res.Body.Close()
+	if res.StatusCode >= 300 {
+		return nil, "", nil
+	}
+	var routes []any
+	if err := json.NewDecoder(res.Body).Decode(&routes); err != nil {
+		return nil, "", fmt.Errorf("caddy admin query failed: %w", err)
+	}
+	return routes, res.Header.Get("Etag"), nil
+}
+
+// putExternalRoutes writes a server's routes. With an etag, Caddy only
+// accepts the write while the routes are unchanged; errRoutesChanged
+// reports that it refused. A server without routes (no etag) gets them
+// created.
+func putExternalRoutes(serverName string, routes []any, etag string) error {
+	path := serverRoutesPath(serverName)
+	method := http.MethodPatch
+	if etag == "" {
+		method = http.MethodPut
+	}
+	res, err := adminDoIfMatch(method, path, routes, etag)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode == http.StatusPreconditionFailed {
+		return errRoutesChanged
+	}
+	if res.StatusCode >= 300 {
+		return fmt.Errorf("caddy routes update failed: %s", AdminReadBody(res))
+	}
+	return nil
+}
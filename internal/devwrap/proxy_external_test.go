@@ -0,0 +1,92 @@
+package devwrap
+
+import (
+	"slices"
+	"testing"
+)
+
+func routeIDs(routes []any) []string {
+	ids := make([]string, 0, len(routes))
+	for _, r := range routes {
+		m, _ := r.(map[string]any)
+		id, _ := m["@id"].(string)
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+func TestMergeExternalRoutes(t *testing.T) {
+	existing := []any{
+		map[string]any{"@id": "auth"},
+		map[string]any{"@id": "devwrap-stale"},
+		map[string]any{"match": "no id"},
+		map[string]any{"@id": "catch-all"},
+	}
+	tests := []struct {
+		name   string
+		apps   []string
+		orders map[string]string
+		want   []string
+	}{
+		{
+			name: "default appends after unmanaged routes",
+			apps: []string{"devwrap-api", "devwrap-web"},
+			want: []string{"auth", "", "catch-all", "devwrap-api", "devwrap-web"},
+		},
+		{
+			name:   "last is the default",
+			apps:   []string{"devwrap-api"},
+			orders: map[string]string{"devwrap-api": "last"},
+			want:   []string{"auth", "", "catch-all", "devwrap-api"},
+		},
+		{
+			name:   "first goes ahead of unmanaged routes",
+			apps:   []string{"devwrap-api", "devwrap-web"},
+			orders: map[string]string{"devwrap-web": "first"},
+			want:   []string{"devwrap-web", "auth", "", "catch-all", "devwrap-api"},
+		},
+		{
+			name:   "after places right behind its target",
+			apps:   []string{"devwrap-api", "devwrap-web"},
+			orders: map[string]string{"devwrap-api": "after:auth"},
+			want:   []string{"auth", "devwrap-api", "", "catch-all", "devwrap-web"},
+		},
+		{
+			name:   "after keeps app order for a shared target",
+			apps:   []string{"devwrap-api", "devwrap-web"},
+			orders: map[string]string{"devwrap-api": "after:auth", "devwrap-web": "after:auth"},
+			want:   []string{"auth", "devwrap-api", "devwrap-web", "", "catch-all"},
+		},
+		{
+			name:   "after a missing target goes before last routes",
+			apps:   []string{"devwrap-api", "devwrap-web", "devwrap-docs"},
+			orders: map[string]string{"devwrap-web": "after:zeta", "devwrap-docs": "after:alpha"},
+			want:   []string{"auth", "", "catch-all", "devwrap-docs", "devwrap-web", "devwrap-api"},
+		},
+		{
+			name:   "after another devwrap route is not honored",
+			apps:   []string{"devwrap-api"},
+			orders: map[string]string{"devwrap-api": "after:devwrap-stale"},
+			want:   []string{"auth", "", "catch-all", "devwrap-api"},
+		},
+		{
+			name: "no apps drops stale devwrap routes",
+			want: []string{"auth", "", "catch-all"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var apps []map[string]any
+			for _, id := range tt.apps {
+				apps = append(apps, map[string]any{"@id": id})
+			}
+			got, err := mergeExternalRoutes(existing, apps, tt.orders)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ids := routeIDs(got); !slices.Equal(ids, tt.want) {
+				t.Errorf("got %q, want %q", ids, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,89 @@
+package devwrap
+
+import (
+	"sort"
+	"time"
+)
+
+// ProcInfo is one process as read from the OS process table. CPU is the
+// cumulative user+system time; RSS is in bytes.
+type ProcInfo struct {
+	PID     int
+	PPID    int
+	Command string
+	CPU     time.Duration
+	RSS     int64
+}
+
+// ProcessSample is one process of an app's tree in `devwrap ps` output.
+type ProcessSample struct {
+	PID        int     `json:"pid"`
+	PPID       int     `json:"ppid"`
+	Command    string  `json:"command"`
+	Depth      int     `json:"depth"`
+	CPUPercent float64 `json:"cpu_percent"`
+	RSSBytes   int64   `json:"rss_bytes"`
+}
+
+// AppResources is an app's lease plus a resource sample of the process tree
+// rooted at its devwrap process.
+type AppResources struct {
+	Name          string          `json:"name"`
+	Host          string          `json:"host"`
+	Port          int             `json:"port"`
+	PID           int             `json:"pid"`
+	UptimeSeconds int64           `json:"uptime_seconds"`
+	CPUPercent    float64         `json:"cpu_percent"`
+	RSSBytes      int64           `json:"rss_bytes"`
+	Processes     []ProcessSample `json:"processes"`
+}
+
+// SampleResources measures app's process tree between two snapshots taken
+// interval apart.
+func (a App) SampleResources(before, after map[int]ProcInfo, interval time.Duration) AppResources {
+	res := AppResources{Name: a.Name, Host: a.Host, Port: a.Port, PID: a.PID, Processes: []ProcessSample{}}
+	if started, err := time.Parse(time.RFC3339, a.StartedAt); err == nil {
+		res.UptimeSeconds = int64(time.Since(started).Seconds())
+	}
+	for _, node := range ProcessTree(after, a.PID) {
+		p := after[node.PID]
+		sample := ProcessSample{PID: p.PID, PPID: p.PPID, Command: p.Command, Depth: node.depth, RSSBytes: p.RSS}
+		if prev, ok := before[p.PID]; ok && p.CPU >= prev.CPU {
+			sample.CPUPercent = float64(p.CPU-prev.CPU) / float64(interval) * 100
+		}
+		res.CPUPercent += sample.CPUPercent
+		res.RSSBytes += sample.RSSBytes
+		res.Processes = append(res.Processes, sample)
+	}
+	return res
+}
+
+type TreeNode struct {
+	PID   int
+	depth int
+}
+
+// ProcessTree lists root and its descendants depth-first, children by pid.
+func ProcessTree(procs map[int]ProcInfo, root int) []TreeNode {
+	if _, ok := procs[root]; !ok {
+		return nil
+	}
+	children := map[int][]int{}
+	for pid, p := range procs {
+		if pid != p.PPID {
+			children[p.PPID] = append(children[p.PPID], pid)
+		}
+	}
+	var out []TreeNode
+	var walk func(pid, depth int)
+	walk = func(pid, depth int) {
+		out = append(out, TreeNode{PID: pid, depth: depth})
+		kids := children[pid]
+		sort.Ints(kids)
+		for _, kid := range kids {
+			walk(kid, depth+1)
+		}
+	}
+	walk(root, 0)
+	return out
+}
@@ -0,0 +1,13 @@
+package devwrap
+
+import (
+	"time"
+)
+
+const (
+	ReadyPollInterval = 250 * time.Millisecond
+	// readyHoldTimeout bounds how long one request waits on a route whose
+	// app has not accepted a connection yet; after that it gets the
+	// starting page.
+	readyHoldTimeout = 30 * time.Second
+)
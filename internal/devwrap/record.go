@@ -0,0 +1,8 @@
+package devwrap
+
+// RecorderHop is the local proxy `devwrap record` puts between Caddy and an
+// app; PID is the recording process, so a crashed recorder is ignored.
+type RecorderHop struct {
+	PID  int    `json:"pid"`
+	Dial string `json:"dial"`
+}
@@ -0,0 +1,170 @@
+package devwrap
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+)
+
+// errConfigChanged means another Admin API client changed a config list
+// since devwrap read it, so devwrap won't write its old copy back.
+var errConfigChanged = errors.New("changed by another caddy admin client")
+
+// applyUndo records each config list an apply wrote, as it was read before
+// the write, so a failed apply can put devwrap's entries back.
+type applyUndo struct {
+	steps []undoStep
+}
+
+type undoStep struct {
+	path   string
+	before []any
+	// object marks a step for a config object devwrap owns whole (its CA)
+	// rather than a shared list; beforeObject is nil when it was absent.
+	object       bool
+	beforeObject map[string]any
+}
+
+func (u *applyUndo) record(path string, before []any) {
+	u.steps = append(u.steps, undoStep{path: path, before: before})
+}
+
+func (u *applyUndo) recordObject(path string, before map[string]any) {
+	u.steps = append(u.steps, undoStep{path: path, object: true, beforeObject: before})
+}
+
+// rollback restores the recorded lists, last write first. A list only goes
+// back when nothing but devwrap's entries changed in it, and the write
+// carries the ETag it was checked at; otherwise the list is left as it is
+// and reported.
+func (u *applyUndo) rollback() error {
+	var errs []error
+	for i := len(u.steps) - 1; i >= 0; i-- {
+		if err := u.steps[i].restore(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (s undoStep) restore() error {
+	if s.object {
+		return s.restoreObject()
+	}
+	current, etag, err := fetchConfigList(s.path)
+	if err != nil {
+		return err
+	}
+	before, err := normalizeRoutes(s.before)
+	if err != nil {
+		return err
+	}
+	if before == nil {
+		before = []any{}
+	}
+	if current == nil {
+		current = []any{}
+	}
+	if reflect.DeepEqual(current, before) {
+		return nil
+	}
+	if etag == "" || !reflect.DeepEqual(foreignEntries(current), foreignEntries(before)) {
+		return fmt.Errorf("not restoring %s: %w", s.path, errConfigChanged)
+	}
+	res, err := adminDoIfMatch(http.MethodPatch, s.path, before, etag)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode == http.StatusPreconditionFailed {
+		return fmt.Errorf("not restoring %s: %w", s.path, errConfigChanged)
+	}
+	if res.StatusCode >= 300 {
+		return fmt.Errorf("restoring %s failed: %s", s.path, AdminReadBody(res))
+	}
+	return nil
+}
+
+// restoreObject puts back an object devwrap owns, or deletes it when it
+// didn't exist, unless another client changed it since it was read.
+func (s undoStep) restoreObject() error {
+	current, etag, err := fetchConfigObject(s.path)
+	if err != nil {
+		return err
+	}
+	if SameJSON(current, s.beforeObject) {
+		return nil
+	}
+	method, payload := http.MethodPatch, any(s.beforeObject)
+	if s.beforeObject == nil {
+		method, payload = http.MethodDelete, nil
+	}
+	res, err := adminDoIfMatch(method, s.path, payload, etag)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode == http.StatusPreconditionFailed {
+		return fmt.Errorf("not restoring %s: %w", s.path, errConfigChanged)
+	}
+	if res.StatusCode >= 300 {
+		return fmt.Errorf("restoring %s failed: %s", s.path, AdminReadBody(res))
+	}
+	return nil
+}
+
+// foreignEntries drops devwrap's entries from list. Its routes and TLS
+// policies all carry a devwrap- @id.
+func foreignEntries(list []any) []any {
+	out := make([]any, 0, len(list))
+	for _, v := range list {
+		if DevwrapRouteID(v) == "" {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+// fetchConfigList returns the list at an admin config path and its ETag;
+// a missing path is an empty list without one.
+func fetchConfigList(path string) ([]any, string, error) {
+	res, err := AdminGet(path)
+	if err != nil {
+		return nil, "", err
+	}
+	defer res.Body.Close()
+	if res.StatusCode == http.StatusNotFound {
+		return nil, "", nil
+	}
+	if res.StatusCode >= 300 {
+		return nil, "", fmt.Errorf("caddy admin query failed: %s", AdminReadBody(res))
+	}
+	var list []any
+	if err := json.NewDecoder(res.Body).Decode(&list); err != nil {
+		return nil, "", fmt.Errorf("caddy admin query failed: %w", err)
+	}
+	return list, res.Header.Get("Etag"), nil
+}
+
+// fetchConfigObject returns the object at an admin config path and its
+// ETag; a missing path is nil without one.
+func fetchConfigObject(path string) (map[string]any, string, error) {
+	res, err := AdminGet(path)
+	if err != nil {
+		return nil, "", err
+	}
+	defer res.Body.Close()
+	if res.StatusCode == http.StatusNotFound {
+		return nil, "", nil
+	}
+	if res.StatusCode >= 300 {
+		return nil, "", fmt.Errorf("caddy admin query failed: %s", AdminReadBody(res))
+	}
+	var obj map[string]any
+	if err := json.NewDecoder(res.Body).Decode(&obj); err != nil {
+		return nil, "", fmt.Errorf("caddy admin query failed: %w", err)
+	}
+	return obj, res.Header.Get("Etag"), nil
+}
@@ -0,0 +1,141 @@
+package devwrap
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// routeOp is a change to a single entry of a server's route list.
+type routeOp struct {
+	Method string // PUT inserts at Index, PATCH replaces it, DELETE removes it
+	Index  int
+	Route  any
+}
+
+// writeServerRoutes moves a server's routes from existing to routes. When
+// one app registered, changed, or left, only that route is written, so the
+// request carries one route instead of every app's and never rewrites
+// routes devwrap doesn't own. Anything else replaces the whole list; an
+// unchanged list isn't written at all. It reports whether Caddy took a
+// write.
+//
+// A narrower write does not avoid a reload: Caddy's admin API reloads the
+// whole config on any change under /config, however deep the path. Only
+// skipping unchanged lists saves one.
+//
+// The single-route request addresses the route by its index under the
+// server rather than by /id/devwrap-<name>: the plain-HTTP and HTTPS servers
+// carry the same @id, so the /id lookup can resolve to either. The ETag of
+// the list the index was taken from guards against it having shifted.
+func writeServerRoutes(serverName string, existing, routes []any, etag string) (bool, error) {
+	normalized, err := normalizeRoutes(routes)
+	if err != nil {
+		return false, err
+	}
+	if reflect.DeepEqual(existing, normalized) {
+		return false, nil
+	}
+	op, ok := singleRouteChange(existing, normalized)
+	if !ok || etag == "" {
+		err := putExternalRoutes(serverName, routes, etag)
+		return err == nil, err
+	}
+	path := serverRoutesPath(serverName) + "/" + strconv.Itoa(op.Index)
+	res, err := adminDoIfMatch(op.Method, path, op.Route, etag)
+	if err != nil {
+		return false, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode == http.StatusPreconditionFailed {
+		return false, errRoutesChanged
+	}
+	if res.StatusCode >= 300 {
+		return false, fmt.Errorf("caddy route update failed: %s", AdminReadBody(res))
+	}
+	return true, nil
+}
+
+// normalizeRoutes round-trips routes through JSON so they compare equal to
+// the same routes read back from Caddy.
+func normalizeRoutes(routes []any) ([]any, error) {
+	b, err := json.Marshal(routes)
+	if err != nil {
+		return nil, err
+	}
+	var out []any
+	if err := json.Unmarshal(b, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// singleRouteChange finds the one devwrap route that differs between
+// existing and routes, with everything around it in the same order.
+func singleRouteChange(existing, routes []any) (routeOp, bool) {
+	i := 0
+	for i < len(existing) && i < len(routes) && reflect.DeepEqual(existing[i], routes[i]) {
+		i++
+	}
+	switch len(routes) - len(existing) {
+	case 0:
+		if i < len(routes) && DevwrapRouteID(existing[i]) != "" &&
+			DevwrapRouteID(existing[i]) == DevwrapRouteID(routes[i]) &&
+			reflect.DeepEqual(existing[i+1:], routes[i+1:]) {
+			return routeOp{Method: http.MethodPatch, Index: i, Route: routes[i]}, true
+		}
+	case 1:
+		if DevwrapRouteID(routes[i]) != "" && reflect.DeepEqual(existing[i:], routes[i+1:]) {
+			return routeOp{Method: http.MethodPut, Index: i, Route: routes[i]}, true
+		}
+	case -1:
+		if DevwrapRouteID(existing[i]) != "" && reflect.DeepEqual(existing[i+1:], routes[i:]) {
+			return routeOp{Method: http.MethodDelete, Index: i}, true
+		}
+	}
+	return routeOp{}, false
+}
+
+// DevwrapRouteID returns route's @id when devwrap owns it.
+func DevwrapRouteID(route any) string {
+	m, _ := route.(map[string]any)
+	id, _ := m["@id"].(string)
+	if !strings.HasPrefix(id, "devwrap-") {
+		return ""
+	}
+	return id
+}
+
+// AppRoutePresent reports whether both servers devwrap writes to still
+// have the route of the app called name. It reads each server's routes
+// rather than asking for /id/devwrap-<name>: both servers carry that @id
+// and Caddy indexes only one of them, so the lookup keeps answering while
+// the other server has lost the route.
+func AppRoutePresent(name string) (bool, error) {
+	servers, err := FetchExternalServers()
+	if err != nil {
+		return false, err
+	}
+	_, _, httpName, httpsName, err := ParseExternalServers(servers)
+	if err != nil {
+		return false, err
+	}
+	id := "devwrap-" + name
+	for _, server := range []string{httpName, httpsName} {
+		if server == "" {
+			continue
+		}
+		routes, _, err := fetchServerRoutes(server)
+		if err != nil {
+			return false, err
+		}
+		if !slices.ContainsFunc(routes, func(r any) bool { return DevwrapRouteID(r) == id }) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
@@ -0,0 +1,114 @@
+package devwrap
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func testRoute(id string, upstream string) map[string]any {
+	return map[string]any{"@id": id, "upstream": upstream}
+}
+
+func TestSingleRouteChange(t *testing.T) {
+	user := testRoute("user-site", "a")
+	api := testRoute("devwrap-api", "localhost:4100")
+	apiMoved := testRoute("devwrap-api", "localhost:4200")
+	web := testRoute("devwrap-web", "localhost:3000")
+	tests := []struct {
+		name     string
+		existing []any
+		routes   []any
+		want     routeOp
+		ok       bool
+	}{
+		{
+			name:     "unchanged",
+			existing: []any{user, api},
+			routes:   []any{user, api},
+		},
+		{
+			name:     "changed in place",
+			existing: []any{user, api, web},
+			routes:   []any{user, apiMoved, web},
+			want:     routeOp{Method: http.MethodPatch, Index: 1, Route: apiMoved},
+			ok:       true,
+		},
+		{
+			name:     "added at the end",
+			existing: []any{user, api},
+			routes:   []any{user, api, web},
+			want:     routeOp{Method: http.MethodPut, Index: 2, Route: web},
+			ok:       true,
+		},
+		{
+			name:     "added in the middle",
+			existing: []any{user, web},
+			routes:   []any{user, api, web},
+			want:     routeOp{Method: http.MethodPut, Index: 1, Route: api},
+			ok:       true,
+		},
+		{
+			name:     "added to an empty list",
+			existing: []any{},
+			routes:   []any{api},
+			want:     routeOp{Method: http.MethodPut, Index: 0, Route: api},
+			ok:       true,
+		},
+		{
+			name:     "removed",
+			existing: []any{user, api, web},
+			routes:   []any{user, web},
+			want:     routeOp{Method: http.MethodDelete, Index: 1},
+			ok:       true,
+		},
+		{
+			name:     "removed the last one",
+			existing: []any{api},
+			routes:   []any{},
+			want:     routeOp{Method: http.MethodDelete, Index: 0},
+			ok:       true,
+		},
+		{
+			name:     "unowned route changed",
+			existing: []any{user, api},
+			routes:   []any{testRoute("user-site", "b"), api},
+		},
+		{
+			name:     "unowned route removed",
+			existing: []any{user, api},
+			routes:   []any{api},
+		},
+		{
+			name:     "route replaced by another app",
+			existing: []any{user, api},
+			routes:   []any{user, web},
+		},
+		{
+			name:     "two routes changed",
+			existing: []any{api, web},
+			routes:   []any{apiMoved, testRoute("devwrap-web", "localhost:3001")},
+		},
+		{
+			name:     "reordered",
+			existing: []any{api, web},
+			routes:   []any{web, api},
+		},
+		{
+			name:     "two routes added",
+			existing: []any{user},
+			routes:   []any{user, api, web},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := singleRouteChange(tt.existing, tt.routes)
+			if ok != tt.ok {
+				t.Fatalf("ok = %v, want %v", ok, tt.ok)
+			}
+			if ok && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
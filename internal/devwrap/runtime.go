@@ -0,0 +1,334 @@
+package devwrap
+
+import (
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"syscall"
+
+	"github.com/gofrs/flock"
+)
+
+const (
+	StateFile          = "state.json"
+	StateDB            = "state.db"
+	pidFile            = "daemon.pid"
+	LogFile            = "daemon.log"
+	lockFile           = "state.lock"
+	eventsFile         = "events.ndjson"
+	leaseQueue         = "lease-queue"
+	heartbeatsDir      = "heartbeats"
+	ProxyDownNotified  = "proxy-down-notified"
+	originalConfigFile = "caddy-original.json"
+)
+
+// StateDirEnv names one directory holding all of devwrap's state and
+// runtime files (also set by --state-dir), so CI jobs, tests, and separate
+// instances don't share state.json and its lock.
+const StateDirEnv = "DEVWRAP_STATE_DIR"
+
+// StateDir holds durable data (state.json, logs) under XDG_STATE_HOME, or
+// in $DEVWRAP_STATE_DIR when set.
+func StateDir() (string, error) {
+	if dir := os.Getenv(StateDirEnv); dir != "" {
+		dir, err := filepath.Abs(dir)
+		if err != nil {
+			return "", err
+		}
+		if err := os.MkdirAll(dir, 0o700); err != nil {
+			return "", err
+		}
+		stateRepairOnce.Do(func() { repairRuntimeFiles(dir, StateDirFiles) })
+		return dir, nil
+	}
+	base := os.Getenv("XDG_STATE_HOME")
+	if base == "" {
+		home, err := runtimeHomeDir()
+		if err != nil {
+			return "", err
+		}
+		base = filepath.Join(home, ".local", "state")
+	}
+	dir := filepath.Join(base, "devwrap")
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return "", err
+	}
+	stateRepairOnce.Do(func() { repairRuntimeFiles(dir, StateDirFiles) })
+	return dir, nil
+}
+
+// RuntimeDir holds per-session files (pid, logs) under XDG_RUNTIME_DIR, which
+// is a tmpfs cleared on reboot. Without XDG_RUNTIME_DIR (macOS, some
+// containers) or with DEVWRAP_STATE_DIR it is the state dir.
+func RuntimeDir() (string, error) {
+	if os.Getenv(StateDirEnv) != "" {
+		return StateDir()
+	}
+	base := os.Getenv("XDG_RUNTIME_DIR")
+	if base == "" {
+		if uid, _, ok := sudoInvoker(); ok {
+			if candidate := filepath.Join("/run/user", strconv.Itoa(uid)); isDir(candidate) {
+				base = candidate
+			}
+		}
+	}
+	if base == "" {
+		return StateDir()
+	}
+	dir := filepath.Join(base, "devwrap")
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return "", err
+	}
+	runtimeRepairOnce.Do(func() {
+		repairRuntimeFiles(dir, RuntimeDirFiles)
+		migrateRuntimeFiles(dir)
+	})
+	return dir, nil
+}
+
+var (
+	stateRepairOnce   sync.Once
+	runtimeRepairOnce sync.Once
+	StateDirFiles     = []string{StateFile, StateDB, LogFile, lockFile, eventsFile}
+	RuntimeDirFiles   = []string{pidFile, ProxyDownNotified}
+)
+
+// migrateRuntimeFiles moves a pid file written by older versions from the
+// state dir into the runtime dir, moves their event log the other way, and
+// drops their runtime-dir lock file if nobody holds it.
+func migrateRuntimeFiles(dir string) {
+	oldDir, err := StateDir()
+	if err != nil || oldDir == dir {
+		return
+	}
+	oldPID := filepath.Join(oldDir, pidFile)
+	if b, err := os.ReadFile(oldPID); err == nil {
+		newPID := filepath.Join(dir, pidFile)
+		if _, err := os.Stat(newPID); os.IsNotExist(err) {
+			if err := os.WriteFile(newPID, b, 0o600); err == nil {
+				ChownToInvoker(newPID)
+			}
+		}
+		_ = os.Remove(oldPID)
+	}
+	oldEvents := filepath.Join(dir, eventsFile)
+	if _, err := os.Stat(filepath.Join(oldDir, eventsFile)); os.IsNotExist(err) {
+		_ = os.Rename(oldEvents, filepath.Join(oldDir, eventsFile))
+	}
+	oldLock := filepath.Join(dir, lockFile)
+	if _, err := os.Stat(oldLock); err == nil {
+		l := flock.New(oldLock)
+		if ok, err := l.TryLock(); err == nil && ok {
+			_ = os.Remove(oldLock)
+			_ = l.Unlock()
+		}
+	}
+}
+
+func isDir(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.IsDir()
+}
+
+// repairRuntimeFiles tightens permissions on a devwrap directory and hands
+// files left behind by a privileged run back to the invoking user. Under sudo
+// it chowns to SUDO_UID; otherwise it rewrites foreign-owned files it can
+// still read so later writes don't fail with EACCES.
+func repairRuntimeFiles(dir string, names []string) {
+	if uid, gid, ok := sudoInvoker(); ok {
+		_ = os.Chown(dir, uid, gid)
+		for _, name := range names {
+			_ = os.Lchown(filepath.Join(dir, name), uid, gid)
+		}
+		return
+	}
+	if info, err := os.Stat(dir); err == nil && FileOwner(info) == os.Geteuid() && info.Mode().Perm() != 0o700 {
+		_ = os.Chmod(dir, 0o700)
+	}
+	for _, name := range names {
+		path := filepath.Join(dir, name)
+		info, err := os.Lstat(path)
+		if err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+		if FileOwner(info) == os.Geteuid() {
+			if info.Mode().Perm()&0o077 != 0 {
+				_ = os.Chmod(path, 0o600)
+			}
+			continue
+		}
+		b, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		tmp := path + ".repair"
+		if err := os.WriteFile(tmp, b, 0o600); err != nil {
+			continue
+		}
+		if err := os.Rename(tmp, path); err != nil {
+			_ = os.Remove(tmp)
+		}
+	}
+}
+
+func FileOwner(info os.FileInfo) int {
+	if st, ok := info.Sys().(*syscall.Stat_t); ok {
+		return int(st.Uid)
+	}
+	return -1
+}
+
+// sudoInvoker returns the uid/gid of the user who invoked sudo, when running
+// as root under sudo.
+func sudoInvoker() (int, int, bool) {
+	if os.Geteuid() != 0 {
+		return 0, 0, false
+	}
+	uid, err := strconv.Atoi(os.Getenv("SUDO_UID"))
+	if err != nil || uid == 0 {
+		return 0, 0, false
+	}
+	gid, err := strconv.Atoi(os.Getenv("SUDO_GID"))
+	if err != nil {
+		gid = uid
+	}
+	return uid, gid, true
+}
+
+// ChownToInvoker keeps files written by a sudo run owned by the real user.
+func ChownToInvoker(path string) {
+	if uid, gid, ok := sudoInvoker(); ok {
+		_ = os.Lchown(path, uid, gid)
+	}
+}
+
+func runtimeHomeDir() (string, error) {
+	if os.Geteuid() == 0 {
+		sudoUser := os.Getenv("SUDO_USER")
+		if sudoUser != "" {
+			u, err := user.Lookup(sudoUser)
+			if err == nil && u.HomeDir != "" {
+				return u.HomeDir, nil
+			}
+		}
+	}
+	return os.UserHomeDir()
+}
+
+func PidPath() (string, error) {
+	dir, err := RuntimeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, pidFile), nil
+}
+
+// StatePath is state.json in the state dir.
+func StatePath() (string, error) {
+	dir, err := StateDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, StateFile), nil
+}
+
+// StateDBPath is state.db, the SQLite backend's file, in the state dir.
+func StateDBPath() (string, error) {
+	dir, err := StateDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, StateDB), nil
+}
+
+// EventsPath returns <state>/events.ndjson, the lifecycle event log.
+func EventsPath() (string, error) {
+	dir, err := StateDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, eventsFile), nil
+}
+
+// leaseQueuePath returns <state>/lease-queue, where lease requests wait
+// to be registered in a batch, creating it.
+func leaseQueuePath() (string, error) {
+	dir, err := StateDir()
+	if err != nil {
+		return "", err
+	}
+	dir = filepath.Join(dir, leaseQueue)
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return "", err
+	}
+	ChownToInvoker(dir)
+	return dir, nil
+}
+
+// StateLockPath is the state lock, next to the state it guards. It must
+// not depend on XDG_RUNTIME_DIR or sudo: every process that resolves the
+// same state file has to agree on its lock.
+func StateLockPath() (string, error) {
+	dir, err := StateDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, lockFile), nil
+}
+
+// WithStateLock runs fn holding the state lock, which serializes
+// read-modify-write cycles across processes. fn loads and saves through
+// tx; other goroutines keep reading the last committed state.
+func WithStateLock(fn func(tx *StateTx) error) error {
+	for {
+		store, err := ActiveStateStore()
+		if err != nil {
+			return err
+		}
+		locked, unlock, err := store.Lock()
+		if err != nil {
+			return err
+		}
+		// A migration that finished while we waited leaves this lock
+		// guarding the old backend; take the new one's instead.
+		if stateStoreMoved(store) {
+			unlock()
+			continue
+		}
+		defer unlock()
+		defer recordLockHolder()()
+		return fn(&StateTx{locked: locked})
+	}
+}
+
+// CheckSystemCaddyReachable reports whether a Caddy admin API answers.
+func CheckSystemCaddyReachable() bool {
+	return AdminHealthy()
+}
+
+func ReadDaemonPID() (int, error) {
+	path, err := PidPath()
+	if err != nil {
+		return 0, err
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	p, err := strconv.Atoi(string(b))
+	if err != nil {
+		return 0, err
+	}
+	return p, nil
+}
+
+// ProcessAlive reports whether pid is running.
+func ProcessAlive(pid int) bool {
+	if pid <= 0 {
+		return false
+	}
+	err := syscall.Kill(pid, 0)
+	return err == nil
+}
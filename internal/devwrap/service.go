@@ -0,0 +1,39 @@
+package devwrap
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+)
+
+// SystemdUnitName is the systemd user unit `devwrap proxy install` writes.
+const SystemdUnitName = "devwrap.service"
+
+// SystemdUnitPath returns $XDG_CONFIG_HOME/systemd/user/devwrap.service.
+func SystemdUnitPath() (string, error) {
+	base := os.Getenv("XDG_CONFIG_HOME")
+	if base == "" {
+		home, err := runtimeHomeDir()
+		if err != nil {
+			return "", err
+		}
+		base = filepath.Join(home, ".config")
+	}
+	return filepath.Join(base, "systemd", "user", SystemdUnitName), nil
+}
+
+func SystemdServiceInstalled() bool {
+	path, err := SystemdUnitPath()
+	if err != nil {
+		return false
+	}
+	_, err = os.Stat(path)
+	return err == nil
+}
+
+func SystemdServiceActive() bool {
+	if !SystemdServiceInstalled() {
+		return false
+	}
+	return exec.Command("systemctl", "--user", "is-active", "--quiet", SystemdUnitName).Run() == nil
+}
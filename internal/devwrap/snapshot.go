@@ -0,0 +1,78 @@
+package devwrap
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// OriginalConfigPath is where devwrap keeps an unmanaged Caddy's config as
+// it was before devwrap first changed it.
+func OriginalConfigPath() (string, error) {
+	dir, err := StateDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, originalConfigFile), nil
+}
+
+// snapshotExternalConfig saves an unmanaged Caddy's config once, before
+// devwrap's first change to it. Anything devwrap added in earlier runs is
+// stripped, so the snapshot is the user's own config.
+func snapshotExternalConfig(servers map[string]map[string]any) error {
+	if _, managed := servers["devwrap-http"]; managed {
+		return nil
+	}
+	path, err := OriginalConfigPath()
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(path); err == nil {
+		return nil
+	}
+	cfg, err := FetchCaddyConfig()
+	if err != nil {
+		return fmt.Errorf("caddy config snapshot failed: %w", err)
+	}
+	b, err := json.MarshalIndent(stripDevwrapConfig(cfg), "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, b, 0o600); err != nil {
+		return err
+	}
+	ChownToInvoker(tmp)
+	return os.Rename(tmp, path)
+}
+
+// stripDevwrapConfig drops every array element whose @id starts with
+// "devwrap-" (routes, TLS automation and connection policies) and the
+// devwrap PKI authority.
+func stripDevwrapConfig(v any) any {
+	switch v := v.(type) {
+	case map[string]any:
+		for k, child := range v {
+			v[k] = stripDevwrapConfig(child)
+		}
+		if cas, ok := v["certificate_authorities"].(map[string]any); ok {
+			delete(cas, DevwrapCAID)
+		}
+		return v
+	case []any:
+		out := v[:0]
+		for _, child := range v {
+			if m, ok := child.(map[string]any); ok {
+				if id, _ := m["@id"].(string); strings.HasPrefix(id, "devwrap-") {
+					continue
+				}
+			}
+			out = append(out, stripDevwrapConfig(child))
+		}
+		return out
+	default:
+		return v
+	}
+}
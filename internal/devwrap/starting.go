@@ -0,0 +1,52 @@
+package devwrap
+
+import (
+	_ "embed"
+	"html/template"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+//go:embed assets/starting.html
+var startingPageHTML string
+
+var startingPage = template.Must(template.New("starting").Parse(startingPageHTML))
+
+// startingPageRefresh is how often (seconds) the starting page reloads.
+const startingPageRefresh = 2
+
+// startingPageHandler wraps an app's proxy chain in a subroute whose error
+// routes turn the 502 reverse_proxy returns while the upstream refuses
+// connections (still booting, or crashed) into a self-refreshing page.
+// Only browser navigations get the page; API clients keep the bare 502.
+func startingPageHandler(app App, proxy []map[string]any) map[string]any {
+	var body strings.Builder
+	_ = startingPage.Execute(&body, map[string]any{
+		"Name":     app.Name,
+		"Upstream": app.DialAddress(),
+		"Refresh":  startingPageRefresh,
+	})
+	return map[string]any{
+		"handler": "subroute",
+		"routes":  []map[string]any{{"handle": proxy}},
+		"errors": map[string]any{
+			"routes": []map[string]any{{
+				"match": []map[string]any{{
+					"expression": "{http.error.status_code} == 502",
+					"header":     map[string][]string{"Accept": {"*text/html*"}},
+				}},
+				"handle": []map[string]any{{
+					"handler":     "static_response",
+					"status_code": http.StatusBadGateway,
+					"headers": map[string][]string{
+						"Content-Type":  {"text/html; charset=utf-8"},
+						"Cache-Control": {"no-store"},
+						"Refresh":       {strconv.Itoa(startingPageRefresh)},
+					},
+					"body": body.String(),
+				}},
+			}},
+		},
+	}
+}
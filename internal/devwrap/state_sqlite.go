@@ -0,0 +1,251 @@
+package devwrap
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"sync"
+
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// SQLiteStateStore keeps state in state.db, a SQLite database in WAL mode:
+// one row per app plus one row for everything else. Reads never wait for
+// a writer, and a save only rewrites the rows that changed, in a single
+// transaction, so a crash mid-save leaves the previous state intact.
+type SQLiteStateStore struct {
+	path string
+	info os.FileInfo
+	db   *sql.DB
+
+	// held serializes Lock within this process.
+	held sync.Mutex
+}
+
+// sqliteLocked is the connection holding a SQLiteStateStore's write
+// transaction. Only the goroutine that took the lock uses it, so other
+// readers never see a save in progress.
+type sqliteLocked struct {
+	conn *sql.Conn
+}
+
+const stateSchema = `
+CREATE TABLE IF NOT EXISTS meta (key TEXT PRIMARY KEY, value TEXT NOT NULL);
+CREATE TABLE IF NOT EXISTS apps (name TEXT PRIMARY KEY, app TEXT NOT NULL);
+`
+
+// OpenSQLiteStateStore opens (creating if needed) the database at path.
+func OpenSQLiteStateStore(path string) (*SQLiteStateStore, error) {
+	dsn := "file:" + (&url.URL{Path: path}).EscapedPath() +
+		"?_pragma=journal_mode(WAL)&_pragma=synchronous(FULL)" +
+		fmt.Sprintf("&_pragma=busy_timeout(%d)", stateLockTimeout.Milliseconds())
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := db.Exec(stateSchema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("open %s: %w", path, err)
+	}
+	ChownToInvoker(path)
+	info, err := os.Stat(path)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &SQLiteStateStore{path: path, info: info, db: db}, nil
+}
+
+// moved reports whether path no longer names the database this store has
+// open, which is what `devwrap state migrate` leaves behind.
+func (s *SQLiteStateStore) moved() bool {
+	info, err := os.Stat(s.path)
+	return err != nil || !os.SameFile(info, s.info)
+}
+
+// Lock starts an IMMEDIATE transaction, which SQLite grants to one writer
+// at a time across processes; the returned LockedState loads and saves
+// inside it until unlock commits.
+func (s *SQLiteStateStore) Lock() (LockedState, func(), error) {
+	s.held.Lock()
+	ctx := context.Background()
+	conn, err := s.db.Conn(ctx)
+	if err != nil {
+		s.held.Unlock()
+		return nil, nil, err
+	}
+	if _, err := conn.ExecContext(ctx, "BEGIN IMMEDIATE"); err != nil {
+		conn.Close()
+		s.held.Unlock()
+		if sqliteBusy(err) {
+			lockPath, _ := StateLockPath()
+			return nil, nil, stateLockTimeoutError(lockPath)
+		}
+		return nil, nil, fmt.Errorf("acquire state lock: %w", err)
+	}
+	return sqliteLocked{conn: conn}, func() {
+		if _, err := conn.ExecContext(ctx, "COMMIT"); err != nil {
+			fmt.Fprintf(os.Stderr, "devwrap: commit state: %v\n", err)
+			_, _ = conn.ExecContext(ctx, "ROLLBACK")
+		}
+		conn.Close()
+		s.held.Unlock()
+	}, nil
+}
+
+// sqliteBusy reports whether err is SQLite giving up on a lock after the
+// busy timeout.
+func sqliteBusy(err error) bool {
+	var serr *sqlite.Error
+	return errors.As(err, &serr) && serr.Code()&0xff == sqlite3.SQLITE_BUSY
+}
+
+// stateQuerier is what Load and Save need from either the locked
+// connection or a transaction of their own.
+type stateQuerier interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+}
+
+func (s *SQLiteStateStore) Load(state *DaemonState) error {
+	ctx := context.Background()
+	// A read transaction sees one snapshot of meta and apps, and in WAL
+	// mode doesn't wait for a writer or see its uncommitted rows.
+	tx, err := s.db.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	return loadSQLiteState(ctx, tx, state)
+}
+
+func loadSQLiteState(ctx context.Context, q stateQuerier, state *DaemonState) error {
+	rows, err := q.QueryContext(ctx, "SELECT value FROM meta WHERE key = 'state'")
+	if err != nil {
+		return err
+	}
+	var meta []byte
+	for rows.Next() {
+		if err := rows.Scan(&meta); err != nil {
+			rows.Close()
+			return err
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if meta == nil {
+		return nil
+	}
+	decoded := *state
+	if err := json.Unmarshal(meta, &decoded); err != nil {
+		return fmt.Errorf("state.db: %w", err)
+	}
+	apps, err := loadSQLiteApps(ctx, q)
+	if err != nil {
+		return err
+	}
+	decoded.Apps = make(map[string]App, len(apps))
+	for name, raw := range apps {
+		var app App
+		if err := json.Unmarshal([]byte(raw), &app); err != nil {
+			return fmt.Errorf("state.db: app %s: %w", name, err)
+		}
+		decoded.Apps[name] = app
+	}
+	*state = decoded
+	return nil
+}
+
+// loadSQLiteApps returns each app row's JSON by name.
+func loadSQLiteApps(ctx context.Context, q stateQuerier) (map[string]string, error) {
+	rows, err := q.QueryContext(ctx, "SELECT name, app FROM apps")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	apps := map[string]string{}
+	for rows.Next() {
+		var name, app string
+		if err := rows.Scan(&name, &app); err != nil {
+			return nil, err
+		}
+		apps[name] = app
+	}
+	return apps, rows.Err()
+}
+
+func (l sqliteLocked) Load(state *DaemonState) error {
+	return loadSQLiteState(context.Background(), l.conn, state)
+}
+
+func (l sqliteLocked) Save(state DaemonState) error {
+	ctx := context.Background()
+	conn := l.conn
+	// The savepoint keeps a failed save from being committed by unlock
+	// half done.
+	if _, err := conn.ExecContext(ctx, "SAVEPOINT save_state"); err != nil {
+		return err
+	}
+	if err := saveSQLiteState(ctx, conn, state); err != nil {
+		_, _ = conn.ExecContext(ctx, "ROLLBACK TO save_state")
+		_, _ = conn.ExecContext(ctx, "RELEASE save_state")
+		return err
+	}
+	_, err := conn.ExecContext(ctx, "RELEASE save_state")
+	return err
+}
+
+// saveSQLiteState writes only the rows that differ from what is stored.
+func saveSQLiteState(ctx context.Context, q stateQuerier, state DaemonState) error {
+	apps := state.Apps
+	state.Apps = nil
+	meta, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	if _, err := q.ExecContext(ctx,
+		"INSERT INTO meta (key, value) VALUES ('state', ?) ON CONFLICT (key) DO UPDATE SET value = excluded.value WHERE value <> excluded.value",
+		string(meta)); err != nil {
+		return err
+	}
+	stored, err := loadSQLiteApps(ctx, q)
+	if err != nil {
+		return err
+	}
+	for name, app := range apps {
+		b, err := json.Marshal(app)
+		if err != nil {
+			return err
+		}
+		if stored[name] == string(b) {
+			continue
+		}
+		if _, err := q.ExecContext(ctx,
+			"INSERT INTO apps (name, app) VALUES (?, ?) ON CONFLICT (name) DO UPDATE SET app = excluded.app",
+			name, string(b)); err != nil {
+			return err
+		}
+	}
+	for name := range stored {
+		if _, ok := apps[name]; ok {
+			continue
+		}
+		if _, err := q.ExecContext(ctx, "DELETE FROM apps WHERE name = ?", name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close checkpoints the WAL back into state.db and closes it.
+func (s *SQLiteStateStore) Close() error {
+	_, _ = s.db.Exec("PRAGMA wal_checkpoint(TRUNCATE)")
+	return s.db.Close()
+}
@@ -0,0 +1,309 @@
+package devwrap
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofrs/flock"
+)
+
+// StateStore persists DaemonState for LoadLocalState and WithStateLock.
+// Callers only see those two, so a backend with its own concurrency
+// control (row-level writes, a WAL database) can replace the JSON file and
+// its coarse lock without touching them.
+type StateStore interface {
+	// Lock serializes read-modify-write cycles across processes. The
+	// returned LockedState belongs to the goroutine that took the lock and
+	// is void once unlock runs.
+	Lock() (locked LockedState, unlock func(), err error)
+	// Load decodes the last committed state over the defaults in state,
+	// leaving them alone when nothing was saved yet. It does not wait for
+	// the lock.
+	Load(state *DaemonState) error
+}
+
+// LockedState loads and saves state for the holder of a StateStore's
+// lock. Its Load sees the holder's own saves.
+type LockedState interface {
+	Load(state *DaemonState) error
+	Save(state DaemonState) error
+}
+
+var (
+	sqliteStoreMu sync.Mutex
+	sqliteStore   *SQLiteStateStore
+)
+
+// ActiveStateStore picks the backend: state.db when it exists (see
+// `devwrap state migrate`), state.json otherwise.
+func ActiveStateStore() (StateStore, error) {
+	path, err := StateDBPath()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := os.Stat(path); err != nil {
+		if os.IsNotExist(err) {
+			return FileStateStore{}, nil
+		}
+		return nil, err
+	}
+	sqliteStoreMu.Lock()
+	defer sqliteStoreMu.Unlock()
+	if sqliteStore != nil && sqliteStore.path == path && !sqliteStore.moved() {
+		return sqliteStore, nil
+	}
+	store, err := OpenSQLiteStateStore(path)
+	if err != nil {
+		return nil, err
+	}
+	sqliteStore = store
+	return store, nil
+}
+
+// CloseStateStore closes the SQLite store ActiveStateStore has open, if
+// any, checkpointing its WAL into state.db.
+func CloseStateStore() error {
+	sqliteStoreMu.Lock()
+	defer sqliteStoreMu.Unlock()
+	if sqliteStore == nil {
+		return nil
+	}
+	err := sqliteStore.Close()
+	sqliteStore = nil
+	return err
+}
+
+// stateStoreMoved reports whether a migration switched backends since
+// store was picked, so a lock taken on it no longer guards the state.
+func stateStoreMoved(store StateStore) bool {
+	switch s := store.(type) {
+	case *SQLiteStateStore:
+		return s.moved()
+	default:
+		path, err := StateDBPath()
+		if err != nil {
+			return false
+		}
+		_, err = os.Stat(path)
+		return err == nil
+	}
+}
+
+// stateBackups is how many previous versions of state.json are kept as
+// state.json.1 (newest) through state.json.<n>.
+const stateBackups = 3
+
+// FileStateStore keeps state in state.json, replaced atomically on save,
+// guarded by an flock on state.lock next to it. Saves are only made under
+// that lock, so it is its own LockedState.
+type FileStateStore struct{}
+
+// reportedStateRecovery keeps a process from repeating the recovery notice
+// on every load until the next save replaces the corrupt file.
+var reportedStateRecovery bool
+
+// stateLockTimeout bounds the wait for the state lock. Every command takes
+// it only for a short read-modify-write, so a longer wait means the holder
+// is hung.
+const stateLockTimeout = 15 * time.Second
+
+// lockHolder is written into state.lock by the process holding it, so a
+// command that times out can say who to blame.
+type lockHolder struct {
+	PID     int    `json:"pid"`
+	Since   string `json:"since"`
+	Command string `json:"command,omitempty"`
+}
+
+// recordLockHolder writes this process into state.lock and returns the
+// func that clears it again on unlock.
+func recordLockHolder() func() {
+	path, err := StateLockPath()
+	if err != nil {
+		return func() {}
+	}
+	holder, _ := json.Marshal(lockHolder{
+		PID:     os.Getpid(),
+		Since:   time.Now().UTC().Format(time.RFC3339),
+		Command: strings.Join(os.Args, " "),
+	})
+	_ = os.WriteFile(path, holder, 0o600)
+	ChownToInvoker(path)
+	return func() { _ = os.Truncate(path, 0) }
+}
+
+func (FileStateStore) Lock() (LockedState, func(), error) {
+	path, err := StateLockPath()
+	if err != nil {
+		return nil, nil, err
+	}
+	fileLock := flock.New(path)
+	ctx, cancel := context.WithTimeout(context.Background(), stateLockTimeout)
+	defer cancel()
+	locked, err := fileLock.TryLockContext(ctx, 50*time.Millisecond)
+	if !locked {
+		_ = fileLock.Close()
+		if err != nil && !errors.Is(err, context.DeadlineExceeded) {
+			return nil, nil, fmt.Errorf("acquire state lock: %w", err)
+		}
+		return nil, nil, stateLockTimeoutError(path)
+	}
+	ChownToInvoker(path)
+	return FileStateStore{}, func() { _ = fileLock.Unlock() }, nil
+}
+
+// stateLockTimeoutError names the process holding the lock at path.
+func stateLockTimeoutError(path string) error {
+	msg := fmt.Sprintf("timed out after %s waiting for the state lock (%s)", stateLockTimeout, path)
+	var holder lockHolder
+	b, err := os.ReadFile(path)
+	if err != nil || json.Unmarshal(b, &holder) != nil || holder.PID <= 0 {
+		return errors.New(msg + "; the holder did not record itself (an older devwrap?)")
+	}
+	since := holder.Since
+	if t, err := time.Parse(time.RFC3339, holder.Since); err == nil {
+		since = fmt.Sprintf("%s (%s ago)", t.Local().Format(time.DateTime), time.Since(t).Round(time.Second))
+	}
+	msg += fmt.Sprintf("; held by pid %d since %s", holder.PID, since)
+	if holder.Command != "" {
+		msg += fmt.Sprintf(": %s", holder.Command)
+	}
+	if !ProcessAlive(holder.PID) {
+		return errors.New(msg + "; that process is gone, so a process it started may still hold the lock")
+	}
+	return fmt.Errorf("%s; if it is hung, stop it with `kill %d`", msg, holder.PID)
+}
+
+func (FileStateStore) Load(state *DaemonState) error {
+	path, err := StatePath()
+	if err != nil {
+		return err
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	parseErr := decodeState(b, state)
+	if parseErr == nil {
+		return nil
+	}
+	// Never fall back to an empty registry: that silently drops every
+	// route. Use the newest backup that parses, or refuse.
+	for i := 1; i <= stateBackups; i++ {
+		backup := path + "." + strconv.Itoa(i)
+		b, err := os.ReadFile(backup)
+		if err != nil {
+			continue
+		}
+		recovered := *state
+		if decodeState(b, &recovered) != nil {
+			continue
+		}
+		*state = recovered
+		if !reportedStateRecovery {
+			reportedStateRecovery = true
+			fmt.Fprintf(os.Stderr, "devwrap: %s is corrupt (%v); recovered from %s\n", path, parseErr, backup)
+			_ = copyFile(path, path+".corrupt")
+		}
+		return nil
+	}
+	return fmt.Errorf("%s is corrupt (%v) and no backup is readable; move it aside to start with empty state", path, parseErr)
+}
+
+// decodeState decodes a state file over the defaults in state, leaving
+// state untouched when b does not parse.
+func decodeState(b []byte, state *DaemonState) error {
+	decoded := *state
+	if err := json.Unmarshal(b, &decoded); err != nil {
+		return err
+	}
+	*state = decoded
+	return nil
+}
+
+func (FileStateStore) Save(state DaemonState) error {
+	path, err := StatePath()
+	if err != nil {
+		return err
+	}
+	b, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := writeFileSync(tmp, b); err != nil {
+		return err
+	}
+	ChownToInvoker(tmp)
+	rotateStateBackups(path)
+	if err := os.Rename(tmp, path); err != nil {
+		return err
+	}
+	SyncDir(filepath.Dir(path))
+	return nil
+}
+
+// rotateStateBackups shifts state.json.<n> up by one and hard-links the
+// current state.json as state.json.1, so state.json itself is never
+// missing while it is being replaced.
+func rotateStateBackups(path string) {
+	if _, err := os.Stat(path); err != nil {
+		return
+	}
+	for i := stateBackups - 1; i >= 1; i-- {
+		_ = os.Rename(path+"."+strconv.Itoa(i), path+"."+strconv.Itoa(i+1))
+	}
+	_ = os.Remove(path + ".1")
+	if err := os.Link(path, path+".1"); err != nil {
+		_ = copyFile(path, path+".1")
+	}
+}
+
+// writeFileSync writes b to path and fsyncs it before returning, so a
+// crash right after the rename cannot leave an empty state file.
+func writeFileSync(path string, b []byte) error {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// SyncDir fsyncs a directory so a rename in it is durable. Best effort.
+func SyncDir(dir string) {
+	if d, err := os.Open(dir); err == nil {
+		_ = d.Sync()
+		d.Close()
+	}
+}
+
+func copyFile(src, dst string) error {
+	b, err := os.ReadFile(src)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(dst, b, 0o600); err != nil {
+		return err
+	}
+	ChownToInvoker(dst)
+	return nil
+}
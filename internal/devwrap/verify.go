@@ -0,0 +1,134 @@
+package devwrap
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+type DriftProblem struct {
+	Kind   string `json:"kind"`
+	App    string `json:"app,omitempty"`
+	Server string `json:"server,omitempty"`
+	Detail string `json:"detail"`
+}
+
+// VerifyProxyState cross-checks live apps in state.json against the routes and
+// TLS policy currently loaded in Caddy.
+func VerifyProxyState(state DaemonState) ([]DriftProblem, error) {
+	servers, err := FetchExternalServers()
+	if err != nil {
+		return nil, err
+	}
+	_, httpsPort, httpName, httpsName, err := ParseExternalServers(servers)
+	if err != nil {
+		return nil, err
+	}
+
+	serverNames := []string{httpName}
+	if httpsName != "" && httpsName != httpName {
+		serverNames = append(serverNames, httpsName)
+	}
+
+	var problems []DriftProblem
+	for _, serverName := range serverNames {
+		// The plain-HTTP server carries --redirect-https redirects instead.
+		redirectPort := 0
+		if serverName != httpsName {
+			redirectPort = httpsPort
+		}
+		desired := map[string]map[string]any{}
+		for _, route := range MakeDevwrapRoutes(state, redirectPort) {
+			id, _ := route["@id"].(string)
+			desired[id] = route
+		}
+		routes, _ := servers[serverName]["routes"].([]any)
+		seen := map[string]int{}
+		for _, routeAny := range routes {
+			route, ok := routeAny.(map[string]any)
+			if !ok {
+				continue
+			}
+			id, _ := route["@id"].(string)
+			if !strings.HasPrefix(id, "devwrap-") {
+				continue
+			}
+			seen[id]++
+			want, ok := desired[id]
+			if !ok {
+				problems = append(problems, DriftProblem{Kind: "orphaned_route", Server: serverName, Detail: fmt.Sprintf("route %s has no live app in state", id)})
+				continue
+			}
+			if !SameJSON(route, want) {
+				problems = append(problems, DriftProblem{Kind: "route_mismatch", App: strings.TrimPrefix(id, "devwrap-"), Server: serverName, Detail: fmt.Sprintf("route %s differs from desired config", id)})
+			}
+		}
+		ids := make([]string, 0, len(desired))
+		for id := range desired {
+			ids = append(ids, id)
+		}
+		sort.Strings(ids)
+		for _, id := range ids {
+			switch n := seen[id]; {
+			case n == 0:
+				problems = append(problems, DriftProblem{Kind: "missing_route", App: strings.TrimPrefix(id, "devwrap-"), Server: serverName, Detail: fmt.Sprintf("route %s is missing", id)})
+			case n > 1:
+				problems = append(problems, DriftProblem{Kind: "duplicate_route", App: strings.TrimPrefix(id, "devwrap-"), Server: serverName, Detail: fmt.Sprintf("route %s appears %d times", id, n)})
+			}
+		}
+	}
+
+	policies, _, err := fetchTLSAutomationPolicies()
+	if err != nil {
+		return nil, err
+	}
+	subjects := map[string]struct{}{}
+	for _, policyAny := range policies {
+		policy, ok := policyAny.(map[string]any)
+		if !ok {
+			continue
+		}
+		id, _ := policy["@id"].(string)
+		if !isDevwrapTLSPolicyID(id) {
+			continue
+		}
+		list, _ := policy["subjects"].([]any)
+		for _, s := range list {
+			if str, ok := s.(string); ok {
+				subjects[id+" "+str] = struct{}{}
+			}
+		}
+	}
+	names := make([]string, 0, len(state.Apps))
+	for name := range state.Apps {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		id, subject := tlsPolicyForApp(state.Apps[name])
+		if _, ok := subjects[id+" "+subject]; !ok {
+			problems = append(problems, DriftProblem{Kind: "missing_tls_subject", App: name, Detail: fmt.Sprintf("TLS policy %s does not cover %s", id, subject)})
+		}
+	}
+	return problems, nil
+}
+
+// SameJSON compares a live Caddy value with a locally built one by round-
+// tripping both through JSON, so []map[string]any and []any compare equal.
+func SameJSON(a, b any) bool {
+	var na, nb any
+	ab, err := json.Marshal(a)
+	if err != nil {
+		return false
+	}
+	bb, err := json.Marshal(b)
+	if err != nil {
+		return false
+	}
+	if json.Unmarshal(ab, &na) != nil || json.Unmarshal(bb, &nb) != nil {
+		return false
+	}
+	return reflect.DeepEqual(na, nb)
+}
@@ -0,0 +1,153 @@
+// Package devwrap registers routes with devwrap from other Go programs.
+//
+// A Client works on the same state files and Caddy config as the CLI, so
+// routes it adds are listed, paused, and removed like any other app:
+//
+//	c, err := devwrap.NewClient()
+//	if err != nil {
+//		return err
+//	}
+//	lease, err := c.Acquire(devwrap.AcquireRequest{Name: "api", Port: 8080})
+//	if err != nil {
+//		return err
+//	}
+//	defer c.Release(lease.Name)
+//	fmt.Println(lease.HTTPSURL) // https://api.localhost
+//
+// Routes added this way are not tied to the calling process: they stay
+// until Release, `devwrap rm`, or `devwrap down`. The proxy must already
+// be running (`devwrap proxy start`).
+package devwrap
+
+import (
+	"errors"
+	"fmt"
+
+	internal "devwrap/internal/devwrap"
+)
+
+// Lease is where a registered app is reachable.
+type Lease = internal.Lease
+
+// App is one registered app, as `devwrap ls` reports it.
+type App = internal.App
+
+// ExitedApp is an app that has left the registry, with its exit status.
+type ExitedApp = internal.ExitedApp
+
+// ProxyStatus is the proxy's state and its registered apps.
+type ProxyStatus = internal.ProxyStatus
+
+// RouteOptions holds the per-app settings `devwrap add` takes as flags.
+type RouteOptions = internal.RouteOptions
+
+// Client registers routes with the running proxy.
+type Client struct{}
+
+// NewClient returns a Client for the proxy in the current environment.
+// DEVWRAP_STATE_DIR and DEVWRAP_CADDY_ADMIN (with its credentials and
+// DEVWRAP_UPSTREAM_HOST) apply as for the CLI; an invalid admin setting
+// is an error.
+func NewClient() (*Client, error) {
+	if err := internal.ConfigureAdminEndpoint(); err != nil {
+		return nil, err
+	}
+	return &Client{}, nil
+}
+
+// AcquireRequest describes a route to a server that is already listening.
+// Set exactly one of Port and Upstream.
+type AcquireRequest struct {
+	Name string
+	// Port is the local port the server listens on.
+	Port int
+	// Upstream is a host:port to proxy to instead of a local port.
+	Upstream string
+	// Host defaults to <name>.localhost (<name>.<project>.localhost), or
+	// dev.localhost when Path is set.
+	Host string
+	// Path serves the app under this path prefix on Host.
+	Path     string
+	Project  string
+	Profiles []string
+	Route    RouteOptions
+}
+
+// Acquire registers a route like `devwrap add` and returns where the app
+// is reachable. Name is qualified as "<name>.<project>" when Project is set.
+func (c *Client) Acquire(req AcquireRequest) (Lease, error) {
+	if err := internal.ValidateName(req.Name); err != nil {
+		return Lease{}, err
+	}
+	name := req.Name
+	if req.Project != "" {
+		if err := internal.ValidateName(req.Project); err != nil {
+			return Lease{}, fmt.Errorf("invalid project %q: %w", req.Project, err)
+		}
+		name += "." + req.Project
+	}
+	for _, p := range req.Profiles {
+		if err := internal.ValidateName(p); err != nil {
+			return Lease{}, fmt.Errorf("invalid profile %q: %w", p, err)
+		}
+	}
+	switch {
+	case req.Port != 0 && req.Upstream != "":
+		return Lease{}, errors.New("set only one of Port and Upstream")
+	case req.Upstream == "" && (req.Port <= 0 || req.Port > 65535):
+		return Lease{}, errors.New("port must be between 1 and 65535 (or set Upstream)")
+	}
+	mount, err := internal.NormalizeMountPath(req.Path)
+	if err != nil {
+		return Lease{}, err
+	}
+	customHost := req.Host
+	if mount != "" && customHost == "" {
+		customHost = internal.DefaultPathSite + ".localhost"
+	}
+	host, err := internal.HostForApp(name, customHost)
+	if err != nil {
+		return Lease{}, err
+	}
+	if !internal.CheckSystemCaddyReachable() {
+		return Lease{}, errors.New("proxy is not running")
+	}
+	return internal.RequestLeaseDirect(internal.LeaseRequest{
+		Name:     name,
+		Host:     host,
+		Path:     mount,
+		Project:  req.Project,
+		Port:     req.Port,
+		Upstream: req.Upstream,
+		Unowned:  true,
+		Profiles: req.Profiles,
+		Route:    req.Route,
+	})
+}
+
+// Release removes the named app's route, like `devwrap rm`. Releasing an
+// app that is not registered is not an error.
+func (c *Client) Release(name string) error {
+	if !internal.CheckSystemCaddyReachable() {
+		return errors.New("proxy is not running")
+	}
+	return internal.RemoveDirect(name)
+}
+
+// List returns the registered apps, sorted by name.
+func (c *Client) List() ([]App, error) {
+	s, err := c.Status()
+	if err != nil {
+		return nil, err
+	}
+	return s.Apps, nil
+}
+
+// Status reports the proxy and its apps, like `devwrap proxy status`.
+// Running is false, with no apps, when the proxy is not running.
+func (c *Client) Status() (ProxyStatus, error) {
+	if !internal.CheckSystemCaddyReachable() {
+		return ProxyStatus{Apps: []App{}}, nil
+	}
+	return internal.LocalStatusFromFiles()
+}